@@ -0,0 +1,144 @@
+package db
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+	"github.com/spaghetti-lover/go-db/internal/storage/index/bptree_disk"
+	"github.com/spaghetti-lover/go-db/pkg/kv"
+)
+
+var ErrIndexExists = errors.New("index already exists")
+
+// BulkInsert adds many rows to a table at once. Into an empty store, rows
+// and their index entries are sorted and handed to the KV store as a
+// single bulk load, which is much cheaper than one Insert per row for
+// large imports; otherwise they are written as one batch.
+// Nothing is written if any row conflicts with an existing one. Hash
+// indexes are not sorted, so their postings are added row by row after
+// the load.
+func (db *DB) BulkInsert(tdef *TableDef, recs []*Record) error {
+	entries := make([]disk.KeyVal, 0, len(recs)*(1+len(tdef.Indexes)))
+	seen := make(map[string]struct{}, len(recs))
+
+	for _, rec := range recs {
+		if err := reorderRecord(tdef, rec); err != nil {
+			return err
+		}
+
+		pkVals := rec.Vals[:tdef.PKeyN]
+		key := encodeKey(tdef.Prefix, pkVals)
+		if _, dup := seen[string(key)]; dup {
+			return ErrConflict
+		}
+		if _, ok := db.KV.Get(key); ok {
+			return ErrConflict
+		}
+		seen[string(key)] = struct{}{}
+
+		entries = append(entries, disk.KeyVal{Key: key, Val: encodeValue(rec.Vals[tdef.PKeyN:])})
+		for i := range tdef.Indexes {
+			if tdef.Indexes[i].Kind == IndexHash {
+				continue
+			}
+			entries = append(entries, disk.KeyVal{Key: encodeIndexKey(&tdef.Indexes[i], rec, pkVals)})
+		}
+	}
+
+	if err := db.bulkLoad(entries); err != nil {
+		return err
+	}
+
+	for i := range tdef.Indexes {
+		idx := &tdef.Indexes[i]
+		if idx.Kind != IndexHash {
+			continue
+		}
+		for _, rec := range recs {
+			if err := db.addPosting(idx, rec, encodeKey(tdef.Prefix, rec.Vals[:tdef.PKeyN])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// BuildIndex adds idx to the table and fills it from the existing rows
+// with one batch
+func (db *DB) BuildIndex(tdef *TableDef, idx IndexDef) error {
+	for _, existing := range tdef.Indexes {
+		if existing.Name == idx.Name || existing.Prefix == idx.Prefix {
+			return ErrIndexExists
+		}
+	}
+
+	var entries []disk.KeyVal
+	var postErr error
+	err := db.Scan(tdef.Name, nil, nil, func(rec *Record) bool {
+		if idx.Kind == IndexHash {
+			postErr = db.addPosting(&idx, rec, encodeKey(tdef.Prefix, rec.Vals[:tdef.PKeyN]))
+			return postErr == nil
+		}
+		entries = append(entries, disk.KeyVal{Key: encodeIndexKey(&idx, rec, rec.Vals[:tdef.PKeyN])})
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if postErr != nil {
+		return postErr
+	}
+
+	if err := db.bulkLoad(entries); err != nil {
+		return err
+	}
+
+	tdef.Indexes = append(tdef.Indexes, idx)
+	return nil
+}
+
+// bulkLoad writes entries in one bulk load when the store is empty.
+// A bulk load rebuilds the whole tree, so into a store that already
+// holds data, or one with no key order, the entries are written as one
+// batch instead.
+func (db *DB) bulkLoad(entries []disk.KeyVal) error {
+	compare := db.KV.KeyOrder()
+	empty, err := db.empty()
+	if err != nil {
+		return err
+	}
+	if compare == nil || !empty {
+		return db.writeBatch(entries)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return compare(entries[i].Key, entries[j].Key) < 0
+	})
+	return db.KV.BulkLoad(bptree_disk.NewSliceIterator(entries), bptree_disk.BulkOptions{})
+}
+
+func (db *DB) empty() (bool, error) {
+	it := db.KV.NewIterator(false)
+	defer it.Close()
+	it.Seek(nil)
+	return !it.Valid(), it.Err()
+}
+
+// writeBatch applies entries atomically if the engine can, one Set at a
+// time otherwise
+func (db *DB) writeBatch(entries []disk.KeyVal) error {
+	if bw, ok := db.KV.Engine.(kv.BatchWriter); ok {
+		ops := make([]kv.BatchOp, len(entries))
+		for i, e := range entries {
+			ops[i] = kv.BatchOp{Key: e.Key, Value: e.Val}
+		}
+		return bw.WriteBatch(ops, kv.DurabilityDefault)
+	}
+	for _, e := range entries {
+		if err := db.KV.Set(e.Key, e.Val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
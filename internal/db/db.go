@@ -14,6 +14,9 @@ var (
 type DB struct {
 	KV        kv.KV
 	TableDefs map[string]*TableDef
+
+	// HashKV holds the hash indexes, usually in a "hash" engine
+	HashKV *kv.KV
 }
 
 // Reorder record columns to match table definition
@@ -95,6 +98,12 @@ func (db *DB) insertByDef(tdef *TableDef, rec *Record) error {
 	// Insert secondary indexes
 	pkVals := rec.Vals[:tdef.PKeyN]
 	for _, idx := range tdef.Indexes {
+		if idx.Kind == IndexHash {
+			if err := db.addPosting(&idx, rec, key); err != nil {
+				return err
+			}
+			continue
+		}
 		idxVals := extractIndexedValues(&idx, rec)
 		// Index key = index prefix + indexed cols + primary key
 		idxKey := encodeKey(idx.Prefix, append(idxVals, pkVals...))
@@ -116,9 +125,14 @@ func (db *DB) updateByDef(tdef *TableDef, rec *Record) error {
 	key := encodeKey(tdef.Prefix, rec.Vals[:tdef.PKeyN])
 
 	// Check existence
-	if _, ok := db.KV.Get(key); !ok {
+	old, ok := db.KV.Get(key)
+	if !ok {
 		return ErrNotFound
 	}
+	oldRec, err := decodeRecord(tdef, key, old)
+	if err != nil {
+		return err
+	}
 
 	// Encode value
 	val := encodeValue(rec.Vals[tdef.PKeyN:])
@@ -126,6 +140,18 @@ func (db *DB) updateByDef(tdef *TableDef, rec *Record) error {
 	// Update secondary indexes
 	pkVals := rec.Vals[:tdef.PKeyN]
 	for _, idx := range tdef.Indexes {
+		if idx.Kind == IndexHash {
+			if !indexValuesChanged(&idx, oldRec, rec) {
+				continue
+			}
+			if err := db.removePosting(&idx, oldRec, key); err != nil {
+				return err
+			}
+			if err := db.addPosting(&idx, rec, key); err != nil {
+				return err
+			}
+			continue
+		}
 		idxKey := encodeIndexKey(&idx, rec, pkVals)
 		if err := db.KV.Set(idxKey, []byte{}); err != nil {
 			return err
@@ -158,6 +184,12 @@ func (db *DB) deleteByDef(tdef *TableDef, rec *Record) error {
 	// delete secondary indexes
 	pkVals := rec.Vals[:tdef.PKeyN]
 	for _, idx := range tdef.Indexes {
+		if idx.Kind == IndexHash {
+			if err := db.removePosting(&idx, oldRec, key); err != nil {
+				return err
+			}
+			continue
+		}
 		idxVals := extractIndexedValues(&idx, oldRec)
 		idxKey := encodeKey(idx.Prefix, append(idxVals, pkVals...))
 		_, _ = db.KV.Del(idxKey)
@@ -210,6 +242,7 @@ func (db *DB) Scan(table string, startRec, endRec *Record, fn func(rec *Record)
 	if err != nil {
 		return err
 	}
+	defer scanner.Close()
 
 	for scanner.Valid() {
 		ok, err := scanner.Deref()
@@ -222,10 +255,24 @@ func (db *DB) Scan(table string, startRec, endRec *Record, fn func(rec *Record)
 		scanner.Next()
 	}
 
-	return nil
+	return scanner.Err()
 }
 
 func (db *DB) NewScanner(tdef *TableDef, indexDef *IndexDef, startRec, endRec *Record) (*Scanner, error) {
+	return db.newScanner(tdef, indexDef, startRec, endRec, false)
+}
+
+// NewReverseScanner is NewScanner returning the rows from endRec down to
+// startRec, largest key first
+func (db *DB) NewReverseScanner(tdef *TableDef, indexDef *IndexDef, startRec, endRec *Record) (*Scanner, error) {
+	return db.newScanner(tdef, indexDef, startRec, endRec, true)
+}
+
+func (db *DB) newScanner(tdef *TableDef, indexDef *IndexDef, startRec, endRec *Record, desc bool) (*Scanner, error) {
+	if indexDef != nil && indexDef.Kind == IndexHash {
+		return nil, ErrHashIndexScan
+	}
+
 	var startKey, endKey []byte
 	if indexDef == nil {
 		// Primary scan
@@ -254,13 +301,23 @@ func (db *DB) NewScanner(tdef *TableDef, indexDef *IndexDef, startRec, endRec *R
 		endKey = encodeKey(indexDef.Prefix, append(idxValsEnd, pkMax...))
 	}
 
-	engine, ok := db.KV.Engine.(*kv.BPTreeEngine)
-	if !ok {
-		return nil, errors.New("engine does not support range scan")
+	iter := db.KV.NewIterator(desc)
+
+	switch {
+	case !desc:
+		iter.Seek(startKey)
+	case endKey != nil:
+		iter.Seek(endKey)
+	case startKey[0] < 0xFF:
+		// Last key below the next prefix; skip that prefix itself if present
+		iter.Seek([]byte{startKey[0] + 1})
+		if iter.Valid() && iter.Key()[0] != startKey[0] {
+			iter.Next()
+		}
+	default:
+		iter.Seek(nil)
 	}
 
-	iter := engine.Tree.SeekGE(startKey)
-
 	return &Scanner{
 		iter:     iter,
 		db:       db,
@@ -268,5 +325,6 @@ func (db *DB) NewScanner(tdef *TableDef, indexDef *IndexDef, startRec, endRec *R
 		indexDef: indexDef,
 		startKey: startKey,
 		endKey:   endKey,
+		desc:     desc,
 	}, nil
 }
@@ -1,9 +1,13 @@
 package db
 
 import (
+	"fmt"
 	"os"
 	"testing"
 
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+	"github.com/spaghetti-lover/go-db/internal/storage/index/bptree_disk"
+	"github.com/spaghetti-lover/go-db/internal/storage/lsm"
 	"github.com/spaghetti-lover/go-db/pkg/kv"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -16,8 +20,7 @@ func setupTestDB(t *testing.T) *DB {
 
 	tree, err := kv.NewBPTreeEngine(fileName)
 	require.NoError(t, err)
-	kvStore := kv.NewKV(tree)
-	db := &DB{KV: *kvStore, TableDefs: map[string]*TableDef{}}
+	db := &DB{KV: kv.KV{Engine: tree}, TableDefs: map[string]*TableDef{}}
 	tdef := &TableDef{
 		Name:   "People",
 		Cols:   []string{"id", "name", "age"},
@@ -172,3 +175,413 @@ func TestDelete(t *testing.T) {
 	assert.NoError(t, err)
 	assert.False(t, found, "Deleted record should not be found")
 }
+
+func person(id int64, name string, age int64) *Record {
+	return &Record{
+		Cols: []string{"id", "name", "age"},
+		Vals: []Value{NewInt64Value(id), NewBytesValue([]byte(name)), NewInt64Value(age)},
+	}
+}
+
+func TestBulkInsert(t *testing.T) {
+	db := setupTestDB(t)
+	tdef := db.TableDefs["People"]
+	tdef.Indexes = []IndexDef{{Name: "idx_name", Cols: []string{"name"}, Prefix: 2}}
+
+	require.NoError(t, db.Insert(tdef, person(0, "Zero", 1)))
+
+	// Rows out of order are sorted before loading
+	var recs []*Record
+	for i := int64(1000); i >= 1; i-- {
+		recs = append(recs, person(i, fmt.Sprintf("name-%04d", i), i%90))
+	}
+	require.NoError(t, db.BulkInsert(tdef, recs))
+
+	var ids []int64
+	err := db.Scan("People", nil, nil, func(r *Record) bool {
+		ids = append(ids, r.Vals[0].I64)
+		return true
+	})
+	require.NoError(t, err)
+	require.Len(t, ids, 1001)
+	for i, id := range ids {
+		assert.Equal(t, int64(i), id)
+	}
+
+	// Index entries were loaded too
+	key := &Record{Cols: []string{"name"}, Vals: []Value{NewBytesValue([]byte("name-0500"))}}
+	scanner, err := db.NewScanner(tdef, &tdef.Indexes[0], key, key)
+	require.NoError(t, err)
+	require.True(t, scanner.Valid())
+	rec, err := scanner.Deref()
+	require.NoError(t, err)
+	assert.Equal(t, int64(500), rec.Vals[0].I64)
+}
+
+func TestBulkInsert_Twice(t *testing.T) {
+	db := setupTestDB(t)
+	tdef := db.TableDefs["People"]
+
+	// The first batch is bulk loaded, the second is written into the
+	// existing tree
+	var first, second []*Record
+	for i := int64(1); i <= 500; i++ {
+		first = append(first, person(2*i, "even", 1))
+		second = append(second, person(2*i-1, "odd", 1))
+	}
+	require.NoError(t, db.BulkInsert(tdef, first))
+	require.NoError(t, db.BulkInsert(tdef, second))
+
+	var ids []int64
+	require.NoError(t, db.Scan("People", nil, nil, func(r *Record) bool {
+		ids = append(ids, r.Vals[0].I64)
+		return true
+	}))
+	require.Len(t, ids, 1000)
+	for i, id := range ids {
+		assert.Equal(t, int64(i+1), id)
+	}
+}
+
+func TestBulkInsert_Comparator(t *testing.T) {
+	fileName := "test_db_reverse.db"
+	os.Remove(fileName)
+	t.Cleanup(func() { os.Remove(fileName) })
+
+	tree, err := kv.NewBPTreeEngineWithOptions(fileName, bptree_disk.Options{Comparator: disk.REVERSE_BYTEWISE})
+	require.NoError(t, err)
+	defer tree.Close()
+	tdef := &TableDef{
+		Name:   "People",
+		Cols:   []string{"id", "name", "age"},
+		Types:  []ValueType{ValueInt64, ValueBytes, ValueInt64},
+		PKeyN:  1,
+		Prefix: 1,
+	}
+	db := &DB{KV: kv.KV{Engine: tree}, TableDefs: map[string]*TableDef{"People": tdef}}
+
+	// Entries are sorted in the tree's order, not bytes.Compare
+	var recs []*Record
+	for i := int64(1); i <= 300; i++ {
+		recs = append(recs, person(i, "name", i))
+	}
+	require.NoError(t, db.BulkInsert(tdef, recs))
+	report, err := tree.Tree.Verify()
+	require.NoError(t, err)
+	require.Empty(t, report.Violations)
+
+	for i := int64(1); i <= 300; i++ {
+		rec := person(i, "", 0)
+		require.NoError(t, db.Get(tdef, rec))
+		assert.Equal(t, i, rec.Vals[2].I64)
+	}
+}
+
+func TestBulkInsert_Conflict(t *testing.T) {
+	db := setupTestDB(t)
+	tdef := db.TableDefs["People"]
+
+	require.NoError(t, db.Insert(tdef, person(1, "Alice", 30)))
+
+	err := db.BulkInsert(tdef, []*Record{person(2, "Bob", 25), person(1, "Again", 1)})
+	assert.ErrorIs(t, err, ErrConflict)
+
+	err = db.BulkInsert(tdef, []*Record{person(3, "Bob", 25), person(3, "Bob", 25)})
+	assert.ErrorIs(t, err, ErrConflict)
+
+	// Nothing from the failed batches was written
+	var n int
+	require.NoError(t, db.Scan("People", nil, nil, func(r *Record) bool { n++; return true }))
+	assert.Equal(t, 1, n)
+}
+
+func TestBuildIndex(t *testing.T) {
+	db := setupTestDB(t)
+	tdef := db.TableDefs["People"]
+
+	for i := int64(1); i <= 300; i++ {
+		require.NoError(t, db.Insert(tdef, person(i, fmt.Sprintf("name-%04d", 301-i), 20)))
+	}
+
+	idx := IndexDef{Name: "idx_name", Cols: []string{"name"}, Prefix: 2}
+	require.NoError(t, db.BuildIndex(tdef, idx))
+	assert.ErrorIs(t, db.BuildIndex(tdef, idx), ErrIndexExists)
+
+	// The index returns rows in name order
+	start := &Record{Cols: []string{"name"}, Vals: []Value{NewBytesValue([]byte("name-0001"))}}
+	end := &Record{Cols: []string{"name"}, Vals: []Value{NewBytesValue([]byte("name-0300"))}}
+	scanner, err := db.NewScanner(tdef, &tdef.Indexes[0], start, end)
+	require.NoError(t, err)
+
+	var ids []int64
+	for scanner.Valid() {
+		rec, err := scanner.Deref()
+		require.NoError(t, err)
+		ids = append(ids, rec.Vals[0].I64)
+		scanner.Next()
+	}
+	require.Len(t, ids, 300)
+	assert.Equal(t, int64(300), ids[0])
+	assert.Equal(t, int64(1), ids[299])
+}
+
+func TestReverseScanner(t *testing.T) {
+	db := setupTestDB(t)
+	tdef := db.TableDefs["People"]
+	tdef.Indexes = []IndexDef{{Name: "idx_age", Cols: []string{"age"}, Prefix: 2}}
+
+	for i := int64(1); i <= 200; i++ {
+		require.NoError(t, db.Insert(tdef, person(i, fmt.Sprintf("name-%04d", i), i%10)))
+	}
+
+	collect := func(scanner *Scanner) []int64 {
+		var ids []int64
+		for scanner.Valid() {
+			rec, err := scanner.Deref()
+			require.NoError(t, err)
+			ids = append(ids, rec.Vals[0].I64)
+			scanner.Next()
+		}
+		return ids
+	}
+
+	// Whole table, largest key first
+	scanner, err := db.NewReverseScanner(tdef, nil, nil, nil)
+	require.NoError(t, err)
+	ids := collect(scanner)
+	require.Len(t, ids, 200)
+	assert.Equal(t, int64(200), ids[0])
+	assert.Equal(t, int64(1), ids[199])
+
+	// Bounded range
+	scanner, err = db.NewReverseScanner(tdef, nil, person(50, "", 0), person(55, "", 0))
+	require.NoError(t, err)
+	assert.Equal(t, []int64{55, 54, 53, 52, 51, 50}, collect(scanner))
+
+	// Secondary index, descending
+	age := &Record{Cols: []string{"age"}, Vals: []Value{NewInt64Value(3)}}
+	scanner, err = db.NewReverseScanner(tdef, &tdef.Indexes[0], age, age)
+	require.NoError(t, err)
+	ids = collect(scanner)
+	require.Len(t, ids, 20)
+	assert.Equal(t, int64(193), ids[0])
+	assert.Equal(t, int64(3), ids[19])
+}
+
+func TestScanner_Engines(t *testing.T) {
+	dir := t.TempDir()
+	lsmEngine, err := kv.NewLSMEngine(dir+"/lsm", lsm.Options{MemtableSize: 4 << 10})
+	require.NoError(t, err)
+	walEngine, err := kv.NewWALBPTreeEngine(dir+"/wal.db", dir+"/wal.db.wal")
+	require.NoError(t, err)
+
+	engines := map[string]kv.KVEngine{"lsm": lsmEngine, "ram": kv.NewRAMEngine(4), "wal-bptree": walEngine}
+	for name, engine := range engines {
+		t.Run(name, func(t *testing.T) {
+			defer engine.Close()
+			testScanner(t, &DB{KV: kv.KV{Engine: engine}, TableDefs: map[string]*TableDef{}})
+		})
+	}
+}
+
+func testScanner(t *testing.T, db *DB) {
+	tdef := &TableDef{
+		Name:    "People",
+		Cols:    []string{"id", "name", "age"},
+		Types:   []ValueType{ValueInt64, ValueBytes, ValueInt64},
+		PKeyN:   1,
+		Prefix:  1,
+		Indexes: []IndexDef{{Name: "idx_age", Cols: []string{"age"}, Prefix: 2}},
+	}
+	db.TableDefs["People"] = tdef
+
+	for i := int64(1); i <= 200; i++ {
+		require.NoError(t, db.Insert(tdef, person(i, fmt.Sprintf("name-%04d", i), i%10)))
+	}
+	require.NoError(t, db.Delete(tdef, person(100, "", 0)))
+
+	var ids []int64
+	require.NoError(t, db.Scan("People", person(98, "", 0), person(102, "", 0), func(rec *Record) bool {
+		ids = append(ids, rec.Vals[0].I64)
+		return true
+	}))
+	assert.Equal(t, []int64{98, 99, 101, 102}, ids)
+
+	age := &Record{Cols: []string{"age"}, Vals: []Value{NewInt64Value(3)}}
+	scanner, err := db.NewReverseScanner(tdef, &tdef.Indexes[0], age, age)
+	require.NoError(t, err)
+	defer scanner.Close()
+	ids = nil
+	for ; scanner.Valid(); scanner.Next() {
+		rec, err := scanner.Deref()
+		require.NoError(t, err)
+		ids = append(ids, rec.Vals[0].I64)
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, ids, 20)
+	assert.Equal(t, int64(193), ids[0])
+	assert.Equal(t, int64(3), ids[19])
+}
+
+func TestTruncate(t *testing.T) {
+	db := setupTestDB(t)
+	tdef := db.TableDefs["People"]
+	tdef.Indexes = []IndexDef{{Name: "idx_name", Cols: []string{"name"}, Prefix: 3}}
+
+	// A second table that must survive
+	other := &TableDef{
+		Name:   "Pets",
+		Cols:   []string{"id", "name"},
+		Types:  []ValueType{ValueInt64, ValueBytes},
+		PKeyN:  1,
+		Prefix: 2,
+	}
+	db.TableDefs["Pets"] = other
+
+	for i := int64(1); i <= 500; i++ {
+		require.NoError(t, db.Insert(tdef, person(i, fmt.Sprintf("name-%04d", i), 20)))
+	}
+	pet := &Record{Cols: []string{"id", "name"}, Vals: []Value{NewInt64Value(1), NewBytesValue([]byte("Rex"))}}
+	require.NoError(t, db.Insert(other, pet))
+
+	require.NoError(t, db.Truncate(tdef))
+
+	var n int
+	require.NoError(t, db.Scan("People", nil, nil, func(r *Record) bool { n++; return true }))
+	assert.Equal(t, 0, n)
+	require.NoError(t, db.KV.Scan([]byte{3}, []byte{4}, func(key, val []byte) bool { n++; return true }))
+	assert.Equal(t, 0, n, "index entries left behind")
+
+	require.NoError(t, db.Scan("Pets", nil, nil, func(r *Record) bool { n++; return true }))
+	assert.Equal(t, 1, n)
+
+	require.NoError(t, db.DropTable("Pets"))
+	assert.Nil(t, db.TableDefs["Pets"])
+	assert.Error(t, db.DropTable("Pets"))
+}
+
+func TestTableStats(t *testing.T) {
+	db := setupTestDB(t)
+	tdef := db.TableDefs["People"]
+	tdef.Indexes = []IndexDef{{Name: "idx_name", Cols: []string{"name"}, Prefix: 3}}
+
+	for i := int64(1); i <= 50; i++ {
+		require.NoError(t, db.Insert(tdef, person(i, fmt.Sprintf("name-%04d", i), 20)))
+	}
+
+	stats, err := db.TableStats(tdef)
+	require.NoError(t, err)
+	assert.Equal(t, "People", stats.Table.Name)
+	assert.Equal(t, int64(50), stats.Table.Rows)
+	assert.True(t, stats.Table.Exact)
+	assert.Greater(t, stats.Table.Bytes, int64(50*len("name-0000")))
+
+	require.Len(t, stats.Indexes, 1)
+	assert.Equal(t, "idx_name", stats.Indexes[0].Name)
+	assert.Equal(t, int64(50), stats.Indexes[0].Rows)
+
+	// The whole engine holds both
+	engine, err := db.KV.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), engine.Keys)
+}
+
+func TestHashIndex(t *testing.T) {
+	db := setupTestDB(t)
+	hash, err := kv.NewHashEngine(t.TempDir() + "/idx.hash")
+	require.NoError(t, err)
+	defer hash.Close()
+	db.HashKV = &kv.KV{Engine: hash}
+
+	tdef := db.TableDefs["People"]
+	tdef.Indexes = []IndexDef{{Name: "idx_age", Cols: []string{"age"}, Prefix: 2, Kind: IndexHash}}
+	idx := &tdef.Indexes[0]
+
+	for i := int64(1); i <= 100; i++ {
+		require.NoError(t, db.Insert(tdef, person(i, fmt.Sprintf("name-%04d", i), i%10)))
+	}
+	require.NoError(t, db.Delete(tdef, person(13, "", 0)))
+	require.NoError(t, db.Update(tdef, person(23, "moved", 4)))
+
+	lookup := func(age int64) []int64 {
+		recs, err := db.Lookup(tdef, idx, &Record{Cols: []string{"age"}, Vals: []Value{NewInt64Value(age)}})
+		require.NoError(t, err)
+		var ids []int64
+		for _, rec := range recs {
+			ids = append(ids, rec.Vals[0].I64)
+		}
+		return ids
+	}
+	assert.Equal(t, []int64{3, 33, 43, 53, 63, 73, 83, 93}, lookup(3))
+	assert.ElementsMatch(t, []int64{4, 14, 23, 24, 34, 44, 54, 64, 74, 84, 94}, lookup(4))
+	assert.Empty(t, lookup(42))
+
+	age := &Record{Cols: []string{"age"}, Vals: []Value{NewInt64Value(3)}}
+	_, err = db.NewScanner(tdef, idx, age, age)
+	assert.ErrorIs(t, err, ErrHashIndexScan)
+
+	// A hash index built over existing rows
+	require.NoError(t, db.BuildIndex(tdef, IndexDef{Name: "idx_name", Cols: []string{"name"}, Prefix: 3, Kind: IndexHash}))
+	recs, err := db.Lookup(tdef, &tdef.Indexes[1], &Record{Cols: []string{"name"}, Vals: []Value{NewBytesValue([]byte("moved"))}})
+	require.NoError(t, err)
+	require.Len(t, recs, 1)
+	assert.Equal(t, int64(23), recs[0].Vals[0].I64)
+
+	stats, err := db.TableStats(tdef)
+	require.NoError(t, err)
+	// A head per value and an entry per row
+	assert.Equal(t, int64(10+99), stats.Indexes[0].Rows)
+	assert.Equal(t, int64(99+99), stats.Indexes[1].Rows)
+
+	require.NoError(t, db.Truncate(tdef))
+	assert.Empty(t, lookup(3))
+}
+
+func TestHashIndex_Postings(t *testing.T) {
+	db := setupTestDB(t)
+	hash, err := kv.NewHashEngine(t.TempDir() + "/idx.hash")
+	require.NoError(t, err)
+	defer hash.Close()
+	db.HashKV = &kv.KV{Engine: hash}
+
+	tdef := db.TableDefs["People"]
+	tdef.Indexes = []IndexDef{{Name: "idx_age", Cols: []string{"age"}, Prefix: 2, Kind: IndexHash}}
+	idx := &tdef.Indexes[0]
+	lookup := func(age int64) []int64 {
+		recs, err := db.Lookup(tdef, idx, &Record{Cols: []string{"age"}, Vals: []Value{NewInt64Value(age)}})
+		require.NoError(t, err)
+		var ids []int64
+		for _, rec := range recs {
+			ids = append(ids, rec.Vals[0].I64)
+		}
+		return ids
+	}
+
+	for i := int64(1); i <= 6; i++ {
+		require.NoError(t, db.Insert(tdef, person(i, fmt.Sprintf("name-%d", i), 30)))
+	}
+	// Unlinking the first, a middle and the last row keeps the rest in order
+	require.NoError(t, db.Delete(tdef, person(1, "", 0)))
+	require.NoError(t, db.Delete(tdef, person(4, "", 0)))
+	require.NoError(t, db.Delete(tdef, person(6, "", 0)))
+	assert.Equal(t, []int64{2, 3, 5}, lookup(30))
+	require.NoError(t, db.Insert(tdef, person(7, "name-7", 30)))
+	assert.Equal(t, []int64{2, 3, 5, 7}, lookup(30))
+
+	// An update moves the row's entry to its new values
+	require.NoError(t, db.Update(tdef, person(3, "name-3", 31)))
+	assert.Equal(t, []int64{2, 5, 7}, lookup(30))
+	assert.Equal(t, []int64{3}, lookup(31))
+	pk := encodeKey(tdef.Prefix, []Value{NewInt64Value(3)})
+	old := encodePostingKey(idx, &Record{Cols: []string{"age"}, Vals: []Value{NewInt64Value(30)}})
+	_, ok := db.HashKV.Get(postingEntryKey(old, pk))
+	assert.False(t, ok)
+
+	// The last row gone, the head goes too
+	for _, id := range []int64{2, 5, 7} {
+		require.NoError(t, db.Delete(tdef, person(id, "", 0)))
+	}
+	assert.Empty(t, lookup(30))
+	_, ok = db.HashKV.Get(postingHeadKey(old))
+	assert.False(t, ok)
+}
@@ -0,0 +1,217 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNoHashKV      = errors.New("hash index needs DB.HashKV")
+	ErrHashIndexScan = errors.New("hash index does not support range scans; use Lookup")
+)
+
+// A hash index keeps the primary keys of the rows sharing the indexed
+// values in a doubly linked list, in insertion order, so adding or
+// removing one costs a few point writes however many rows share the
+// values. Under | index prefix | indexed columns | the head key holds the
+// first and the last primary key, and the entry key of each row, followed
+// by its primary key, holds the previous and the next one. A link is
+// stored as its length, a uvarint, then its bytes; an empty link ends the
+// list.
+
+const (
+	POSTING_HEAD  byte = 0
+	POSTING_ENTRY byte = 1
+)
+
+func encodePostingKey(idx *IndexDef, rec *Record) []byte {
+	return encodeKey(idx.Prefix, extractIndexedValues(idx, rec))
+}
+
+func postingHeadKey(prefix []byte) []byte {
+	return append(append([]byte{}, prefix...), POSTING_HEAD)
+}
+
+func postingEntryKey(prefix, pk []byte) []byte {
+	return append(append(append([]byte{}, prefix...), POSTING_ENTRY), pk...)
+}
+
+func encodeLinks(a, b []byte) []byte {
+	raw := binary.AppendUvarint(nil, uint64(len(a)))
+	raw = append(raw, a...)
+	raw = binary.AppendUvarint(raw, uint64(len(b)))
+	return append(raw, b...)
+}
+
+func decodeLinks(raw []byte) ([]byte, []byte, error) {
+	var links [2][]byte
+	for i := range links {
+		n, size := binary.Uvarint(raw)
+		if size <= 0 || uint64(len(raw)-size) < n {
+			return nil, nil, fmt.Errorf("corrupted hash index posting")
+		}
+		links[i] = raw[size : size+int(n)]
+		raw = raw[size+int(n):]
+	}
+	if len(raw) > 0 {
+		return nil, nil, fmt.Errorf("corrupted hash index posting")
+	}
+	return links[0], links[1], nil
+}
+
+// getLinks reads the two links stored under key; a missing key has none
+func (db *DB) getLinks(key []byte) ([]byte, []byte, bool, error) {
+	raw, ok := db.HashKV.Get(key)
+	if !ok {
+		return nil, nil, false, nil
+	}
+	a, b, err := decodeLinks(raw)
+	return a, b, true, err
+}
+
+// setLink changes the previous (next false) or the next link of the entry
+// of pk
+func (db *DB) setLink(prefix, pk, link []byte, next bool) error {
+	key := postingEntryKey(prefix, pk)
+	prev, nxt, ok, err := db.getLinks(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("corrupted hash index posting: missing entry")
+	}
+	if next {
+		nxt = link
+	} else {
+		prev = link
+	}
+	return db.HashKV.Set(key, encodeLinks(prev, nxt))
+}
+
+// addPosting records that the row with primary key pk has the indexed
+// values of rec, at the end of their list
+func (db *DB) addPosting(idx *IndexDef, rec *Record, pk []byte) error {
+	if db.HashKV == nil {
+		return ErrNoHashKV
+	}
+
+	prefix := encodePostingKey(idx, rec)
+	if _, ok := db.HashKV.Get(postingEntryKey(prefix, pk)); ok {
+		return nil
+	}
+	first, last, _, err := db.getLinks(postingHeadKey(prefix))
+	if err != nil {
+		return err
+	}
+
+	if err := db.HashKV.Set(postingEntryKey(prefix, pk), encodeLinks(last, nil)); err != nil {
+		return err
+	}
+	if len(last) > 0 {
+		if err := db.setLink(prefix, last, pk, true); err != nil {
+			return err
+		}
+	} else {
+		first = pk
+	}
+	return db.HashKV.Set(postingHeadKey(prefix), encodeLinks(first, pk))
+}
+
+// removePosting unlinks pk from the list of the values of rec
+func (db *DB) removePosting(idx *IndexDef, rec *Record, pk []byte) error {
+	if db.HashKV == nil {
+		return ErrNoHashKV
+	}
+
+	prefix := encodePostingKey(idx, rec)
+	prev, next, ok, err := db.getLinks(postingEntryKey(prefix, pk))
+	if err != nil || !ok {
+		return err
+	}
+	first, last, _, err := db.getLinks(postingHeadKey(prefix))
+	if err != nil {
+		return err
+	}
+
+	if len(prev) > 0 {
+		err = db.setLink(prefix, prev, next, true)
+	} else {
+		first = next
+	}
+	if err == nil && len(next) > 0 {
+		err = db.setLink(prefix, next, prev, false)
+	} else if err == nil {
+		last = prev
+	}
+	if err == nil && len(first) == 0 {
+		_, err = db.HashKV.Del(postingHeadKey(prefix))
+	} else if err == nil {
+		err = db.HashKV.Set(postingHeadKey(prefix), encodeLinks(first, last))
+	}
+	if err != nil {
+		return err
+	}
+	_, err = db.HashKV.Del(postingEntryKey(prefix, pk))
+	return err
+}
+
+// Lookup returns the rows whose indexed columns equal those of rec. A
+// hash index reads one posting list; a B+tree index is scanned over the
+// matching entries.
+func (db *DB) Lookup(tdef *TableDef, idx *IndexDef, rec *Record) ([]*Record, error) {
+	if idx.Kind != IndexHash {
+		scanner, err := db.NewScanner(tdef, idx, rec, rec)
+		if err != nil {
+			return nil, err
+		}
+		defer scanner.Close()
+
+		var recs []*Record
+		for ; scanner.Valid(); scanner.Next() {
+			row, err := scanner.Deref()
+			if err != nil {
+				return nil, err
+			}
+			recs = append(recs, row)
+		}
+		return recs, scanner.Err()
+	}
+
+	if db.HashKV == nil {
+		return nil, ErrNoHashKV
+	}
+	prefix := encodePostingKey(idx, rec)
+	pk, _, _, err := db.getLinks(postingHeadKey(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	var recs []*Record
+	for len(pk) > 0 {
+		_, next, ok, err := db.getLinks(postingEntryKey(prefix, pk))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("corrupted hash index posting: missing entry")
+		}
+		val, found := db.KV.Get(pk)
+		key := pk
+		pk = next
+		if !found {
+			continue
+		}
+
+		row, err := decodeRecord(tdef, key, val)
+		if err != nil {
+			return nil, err
+		}
+		// A failed update may leave the entry for the old values behind
+		if indexValuesChanged(idx, rec, row) {
+			continue
+		}
+		recs = append(recs, row)
+	}
+	return recs, nil
+}
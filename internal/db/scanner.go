@@ -3,16 +3,17 @@ package db
 import (
 	"bytes"
 
-	"github.com/spaghetti-lover/go-db/internal/storage/index/bptree_disk"
+	"github.com/spaghetti-lover/go-db/pkg/kv"
 )
 
 type Scanner struct {
-	iter     *bptree_disk.BIter
+	iter     kv.Iterator // reversed for a descending scanner
 	db       *DB
 	tableDef *TableDef
 	indexDef *IndexDef // nil = primary scan
 	startKey []byte    // prefix for validation
 	endKey   []byte    // nil = no upper bound
+	desc     bool      // walk from endKey down to startKey
 }
 
 // Valid returns true if the iterators is valid and not past endKey
@@ -20,30 +21,41 @@ func (s *Scanner) Valid() bool {
 	if !s.iter.Valid() {
 		return false
 	}
-	kv := s.iter.Deref()
-	key := kv.GetRightAlignedKey()
+	key := s.iter.Key()
 	// Check that key starts with the correct prefix
 	if len(key) == 0 || len(s.startKey) == 0 || key[0] != s.startKey[0] {
 		return false
 	}
+	if s.desc {
+		return bytes.Compare(key, s.startKey) >= 0
+	}
 	if s.endKey == nil {
 		return true
 	}
 	return bytes.Compare(key, s.endKey) <= 0
 }
 
-// Next advances the iterator
+// Next advances the iterator, backwards for a descending scanner
 func (s *Scanner) Next() {
 	s.iter.Next()
 }
 
+// Err returns the error that stopped the scan, if any
+func (s *Scanner) Err() error {
+	return s.iter.Err()
+}
+
+// Close releases the iterator
+func (s *Scanner) Close() error {
+	return s.iter.Close()
+}
+
 // Deref returns the current record
 func (s *Scanner) Deref() (*Record, error) {
 	if s.indexDef == nil {
 		// Primary scan: decode directly
-		kv := s.iter.Deref()
-		key := kv.GetRightAlignedKey()
-		val := kv.GetRightAlignedValue()
+		key := s.iter.Key()
+		val := s.iter.Value()
 		rec, err := decodeRecord(s.tableDef, key, val)
 		if err != nil {
 			return nil, err
@@ -51,8 +63,7 @@ func (s *Scanner) Deref() (*Record, error) {
 		return rec, nil
 	}
 	// Secondary index scan: extract PK from index key, fetch value from primary
-	kv := s.iter.Deref()
-	idxKey := kv.GetRightAlignedKey()
+	idxKey := s.iter.Key()
 	pk := extractPrimaryKeyFromIndexKey(idxKey, s.tableDef)
 	val, ok := s.db.KV.Get(pk)
 	if !ok {
@@ -0,0 +1,54 @@
+package db
+
+import "github.com/spaghetti-lover/go-db/pkg/kv"
+
+// PrefixStats is the estimated size of the keys under one prefix
+type PrefixStats struct {
+	Name   string
+	Prefix uint8
+	Rows   int64
+	Bytes  int64 // keys plus values
+	Exact  bool  // counted rather than estimated
+}
+
+// TableStats is the estimated size of a table and each of its indexes
+type TableStats struct {
+	Table   PrefixStats
+	Indexes []PrefixStats
+}
+
+// TableStats estimates row counts and bytes of the table and its indexes.
+// Every table and index owns the keys under its prefix, so each one is a
+// single range estimate of the KV engine holding it.
+func (db *DB) TableStats(tdef *TableDef) (*TableStats, error) {
+	table, err := db.prefixStats(&db.KV, tdef.Name, tdef.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &TableStats{Table: table}
+	for _, idx := range tdef.Indexes {
+		store := &db.KV
+		if idx.Kind == IndexHash {
+			if db.HashKV == nil {
+				return nil, ErrNoHashKV
+			}
+			store = db.HashKV
+		}
+		s, err := db.prefixStats(store, idx.Name, idx.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		stats.Indexes = append(stats.Indexes, s)
+	}
+	return stats, nil
+}
+
+func (db *DB) prefixStats(store *kv.KV, name string, prefix uint8) (PrefixStats, error) {
+	start, end := prefixRange(prefix)
+	est, err := store.EstimateRange(start, end)
+	if err != nil {
+		return PrefixStats{}, err
+	}
+	return PrefixStats{Name: name, Prefix: prefix, Rows: est.Keys, Bytes: est.Bytes, Exact: est.Exact}, nil
+}
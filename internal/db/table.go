@@ -1,10 +1,22 @@
 package db
 
+// IndexKind selects how an index is stored
+type IndexKind uint8
+
+const (
+	// IndexBTree keeps one ordered entry per row in the table's KV store
+	IndexBTree IndexKind = iota
+	// IndexHash keeps the primary keys of the rows sharing the indexed
+	// values in a linked list in DB.HashKV. It serves equality lookups only.
+	IndexHash
+)
+
 // Index definition
 type IndexDef struct {
 	Name   string
 	Cols   []string
 	Prefix uint8
+	Kind   IndexKind
 }
 
 // Table definition (schema)
@@ -0,0 +1,54 @@
+package db
+
+import "errors"
+
+// prefixRange returns the key range [start, end) holding every key that
+// starts with prefix
+func prefixRange(prefix uint8) ([]byte, []byte) {
+	if prefix == 0xFF {
+		return []byte{prefix}, nil
+	}
+	return []byte{prefix}, []byte{prefix + 1}
+}
+
+// Truncate removes every row of the table and its index entries. Each key
+// prefix is dropped with one range delete instead of row by row.
+func (db *DB) Truncate(tdef *TableDef) error {
+	prefixes := []uint8{tdef.Prefix}
+	for _, idx := range tdef.Indexes {
+		if idx.Kind == IndexHash {
+			if db.HashKV == nil {
+				return ErrNoHashKV
+			}
+			start, end := prefixRange(idx.Prefix)
+			if _, err := db.HashKV.DeleteRange(start, end); err != nil {
+				return err
+			}
+			continue
+		}
+		prefixes = append(prefixes, idx.Prefix)
+	}
+
+	for _, prefix := range prefixes {
+		start, end := prefixRange(prefix)
+		if _, err := db.KV.DeleteRange(start, end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DropTable removes the table's rows and index entries and forgets its
+// definition
+func (db *DB) DropTable(table string) error {
+	tdef := db.TableDefs[table]
+	if tdef == nil {
+		return errors.New("unknown table: " + table)
+	}
+
+	if err := db.Truncate(tdef); err != nil {
+		return err
+	}
+	delete(db.TableDefs, table)
+	return nil
+}
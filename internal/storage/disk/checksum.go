@@ -0,0 +1,52 @@
+package disk
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrPageCorrupt is returned when a page read from disk does not match
+// its checksum
+type ErrPageCorrupt struct {
+	PageID   uint64
+	Stored   uint32
+	Computed uint32
+}
+
+func (e *ErrPageCorrupt) Error() string {
+	return fmt.Sprintf("page %d is corrupt: checksum %08x, computed %08x", e.PageID, e.Stored, e.Computed)
+}
+
+// PageChecksum returns the CRC32C of a page, skipping the checksum field
+func PageChecksum(buf []byte) uint32 {
+	crc := crc32.Update(0, castagnoli, buf[:PAGE_CHECKSUM_OFFSET])
+	return crc32.Update(crc, castagnoli, buf[PAGE_CHECKSUM_OFFSET+4:])
+}
+
+// SetPageChecksum stores the checksum of buf in its header
+func SetPageChecksum(buf []byte) {
+	binary.BigEndian.PutUint32(buf[PAGE_CHECKSUM_OFFSET:], PageChecksum(buf))
+}
+
+// VerifyPageChecksum checks buf against the checksum in its header.
+// An all-zero page has never been written and is accepted.
+func VerifyPageChecksum(pageID uint64, buf []byte) error {
+	stored := binary.BigEndian.Uint32(buf[PAGE_CHECKSUM_OFFSET:])
+	computed := PageChecksum(buf)
+	if stored == computed || isZeroPage(buf) {
+		return nil
+	}
+	return &ErrPageCorrupt{PageID: pageID, Stored: stored, Computed: computed}
+}
+
+func isZeroPage(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
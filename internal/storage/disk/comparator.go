@@ -0,0 +1,121 @@
+package disk
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// COMPARATOR_NAME_SIZE is the room the file header has for the name
+const COMPARATOR_NAME_SIZE = 32
+
+// Names of the built-in comparators. BYTEWISE is the default.
+const (
+	BYTEWISE         = "bytewise"
+	REVERSE_BYTEWISE = "reverse-bytewise"
+	CASE_INSENSITIVE = "case-insensitive"
+)
+
+var (
+	ErrUnknownComparator  = errors.New("unknown comparator")
+	ErrComparatorExists   = errors.New("comparator already registered")
+	ErrComparatorMismatch = errors.New("comparator does not match the file")
+)
+
+// Comparator orders keys: negative when a < b, zero when they are the same
+// key, positive when a > b. Its name is recorded in the file header, so a
+// file is always reopened with the order it was built with.
+type Comparator struct {
+	Name    string
+	Compare func(a, b []byte) int
+}
+
+var (
+	Bytewise = &Comparator{Name: BYTEWISE, Compare: bytes.Compare}
+
+	// ReverseBytewise orders keys from largest to smallest, for descending
+	// columns and newest-first timestamps
+	ReverseBytewise = &Comparator{Name: REVERSE_BYTEWISE, Compare: func(a, b []byte) int {
+		return bytes.Compare(b, a)
+	}}
+
+	// CaseInsensitive compares ASCII letters without regard to case; keys
+	// that differ only in case are the same key
+	CaseInsensitive = &Comparator{Name: CASE_INSENSITIVE, Compare: compareFold}
+)
+
+var comparators = struct {
+	sync.RWMutex
+	byName map[string]*Comparator
+}{byName: map[string]*Comparator{
+	BYTEWISE:         Bytewise,
+	REVERSE_BYTEWISE: ReverseBytewise,
+	CASE_INSENSITIVE: CaseInsensitive,
+}}
+
+// RegisterComparator makes c available to files that name it. It must be
+// registered before such a file is opened.
+func RegisterComparator(c *Comparator) error {
+	if c.Name == "" || len(c.Name) > COMPARATOR_NAME_SIZE {
+		return fmt.Errorf("comparator name must be 1 to %d bytes: %q", COMPARATOR_NAME_SIZE, c.Name)
+	}
+
+	comparators.Lock()
+	defer comparators.Unlock()
+	if _, ok := comparators.byName[c.Name]; ok {
+		return fmt.Errorf("%w: %s", ErrComparatorExists, c.Name)
+	}
+	comparators.byName[c.Name] = c
+	return nil
+}
+
+// LookupComparator returns the comparator registered under name. An empty
+// name is BYTEWISE.
+func LookupComparator(name string) (*Comparator, error) {
+	if name == "" {
+		return Bytewise, nil
+	}
+
+	comparators.RLock()
+	defer comparators.RUnlock()
+	c, ok := comparators.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownComparator, name)
+	}
+	return c, nil
+}
+
+// compareWith compares with c, or bytewise when c is nil
+func compareWith(c *Comparator, a, b []byte) int {
+	if c == nil {
+		return bytes.Compare(a, b)
+	}
+	return c.Compare(a, b)
+}
+
+func compareFold(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		x, y := lower(a[i]), lower(b[i])
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
+func lower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
@@ -0,0 +1,58 @@
+package disk
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComparators(t *testing.T) {
+	assert.Negative(t, Bytewise.Compare([]byte("a"), []byte("b")))
+	assert.Positive(t, ReverseBytewise.Compare([]byte("a"), []byte("b")))
+	assert.Zero(t, ReverseBytewise.Compare([]byte("a"), []byte("a")))
+
+	assert.Zero(t, CaseInsensitive.Compare([]byte("Hello"), []byte("hELLO")))
+	assert.Negative(t, CaseInsensitive.Compare([]byte("apple"), []byte("Banana")))
+	assert.Negative(t, CaseInsensitive.Compare([]byte("ab"), []byte("ABC")))
+
+	for _, name := range []string{BYTEWISE, REVERSE_BYTEWISE, CASE_INSENSITIVE} {
+		c, err := LookupComparator(name)
+		require.NoError(t, err)
+		assert.Equal(t, name, c.Name)
+	}
+	c, err := LookupComparator("")
+	require.NoError(t, err)
+	assert.Equal(t, Bytewise, c)
+}
+
+func TestRegisterComparator(t *testing.T) {
+	_, err := LookupComparator("test-length-first")
+	assert.ErrorIs(t, err, ErrUnknownComparator)
+
+	lengthFirst := &Comparator{Name: "test-length-first", Compare: func(a, b []byte) int {
+		if len(a) != len(b) {
+			return len(a) - len(b)
+		}
+		return bytes.Compare(a, b)
+	}}
+	require.NoError(t, RegisterComparator(lengthFirst))
+	assert.ErrorIs(t, RegisterComparator(lengthFirst), ErrComparatorExists)
+	assert.Error(t, RegisterComparator(&Comparator{Name: string(make([]byte, COMPARATOR_NAME_SIZE+1))}))
+
+	c, err := LookupComparator("test-length-first")
+	require.NoError(t, err)
+	assert.Equal(t, lengthFirst, c)
+
+	// Pages search in the order of their comparator
+	leaf := NewLeafPage()
+	leaf.Cmp = lengthFirst
+	for _, k := range []string{"ccc", "a", "bb"} {
+		kv := NewKeyValFromBytes([]byte(k), nil)
+		leaf.InsertKV(&kv)
+	}
+	assert.Equal(t, []byte("a"), leaf.KVs[0].Key)
+	assert.Equal(t, []byte("ccc"), leaf.KVs[2].Key)
+	assert.Equal(t, 2, leaf.LowerBound(NewKeyEntryFromBytes([]byte("zz"))))
+}
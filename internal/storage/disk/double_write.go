@@ -0,0 +1,130 @@
+package disk
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+// A checkpoint overwrites pages in place, so a crash in the middle of it
+// could leave some pages new and some old, or a page half written, under
+// either meta page. The images are therefore first written to a
+// double-write file next to the data file and synced; only then do they
+// go in place. Opening the file puts back the images of a whole
+// double-write file, which finishes the checkpoint. A torn one is dropped:
+// nothing was written in place yet.
+//
+// Layout: magic(4) | block size(4) | count(4) | (page ID(8) | page)* | crc(4),
+// the CRC32C covering everything before it.
+
+const (
+	DOUBLE_WRITE_SUFFIX        = ".dwb"
+	DOUBLE_WRITE_MAGIC  uint32 = 0x44574231 // "DWB1"
+	DOUBLE_WRITE_HEADER        = 4 + 4 + 4
+)
+
+// writeDoubleWrite writes checksummed images to the double-write file of
+// p and syncs it
+func (p *Pager) writeDoubleWrite(images []PageImage) error {
+	buf := make([]byte, DOUBLE_WRITE_HEADER, DOUBLE_WRITE_HEADER+len(images)*(8+p.blockSize)+4)
+	binary.BigEndian.PutUint32(buf, DOUBLE_WRITE_MAGIC)
+	binary.BigEndian.PutUint32(buf[4:], uint32(p.blockSize))
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(images)))
+	for _, img := range images {
+		SetPageChecksum(img.Buf)
+		buf = binary.BigEndian.AppendUint64(buf, img.PageID)
+		buf = append(buf, img.Buf...)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, crc32.Checksum(buf, castagnoli))
+
+	path := p.file.Name() + DOUBLE_WRITE_SUFFIX
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// removeDoubleWrite deletes the double-write file of p once its images
+// are durable in place
+func (p *Pager) removeDoubleWrite() error {
+	return removeDoubleWrite(p.file.Name() + DOUBLE_WRITE_SUFFIX)
+}
+
+// RecoverDoubleWrite finishes a checkpoint cut short by a crash: if file
+// has a whole double-write file, its images are written in place and
+// synced. The double-write file is removed either way. It reports whether
+// images were put back.
+func RecoverDoubleWrite(file *os.File) (bool, error) {
+	path := file.Name() + DOUBLE_WRITE_SUFFIX
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	blockSize, pages, ok := decodeDoubleWrite(data)
+	if !ok {
+		return false, removeDoubleWrite(path)
+	}
+	for off := 0; off < len(pages); off += 8 + blockSize {
+		pid := binary.BigEndian.Uint64(pages[off:])
+		if _, err := file.WriteAt(pages[off+8:off+8+blockSize], int64(BlockOffset(pid, blockSize))); err != nil {
+			return false, err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return false, err
+	}
+	return true, removeDoubleWrite(path)
+}
+
+// decodeDoubleWrite checks a double-write file and returns its block size
+// and its page records
+func decodeDoubleWrite(data []byte) (int, []byte, bool) {
+	if len(data) < DOUBLE_WRITE_HEADER+4 || binary.BigEndian.Uint32(data) != DOUBLE_WRITE_MAGIC {
+		return 0, nil, false
+	}
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.Checksum(body, castagnoli) != sum {
+		return 0, nil, false
+	}
+	blockSize := int(binary.BigEndian.Uint32(data[4:]))
+	count := int(binary.BigEndian.Uint32(data[8:]))
+	pages := body[DOUBLE_WRITE_HEADER:]
+	if ValidBlockSize(blockSize) != nil || len(pages) != count*(8+blockSize) {
+		return 0, nil, false
+	}
+	return blockSize, pages, true
+}
+
+func removeDoubleWrite(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir makes the files created or removed in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
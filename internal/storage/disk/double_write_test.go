@@ -0,0 +1,115 @@
+package disk
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openNoStealPager(t *testing.T, path string) *Pager {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	require.NoError(t, err)
+	_, err = RecoverDoubleWrite(file)
+	require.NoError(t, err)
+	pager, err := NewPagerWithOptions(file, NewFileAllocator(), PagerOptions{Capacity: 64, NoSteal: true})
+	require.NoError(t, err)
+	return pager
+}
+
+// fillPage returns a page whose body is fill
+func fillPage(fill byte) []byte {
+	buf := make([]byte, BLOCK_SIZE)
+	copy(buf[PAGE_HEADER_SIZE:], bytes.Repeat([]byte{fill}, BLOCK_SIZE-PAGE_HEADER_SIZE))
+	return buf
+}
+
+func TestMetaCheckpoint_Torn(t *testing.T) {
+	errCrash := errors.New("crash")
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 40; i++ {
+		path := filepath.Join(t.TempDir(), "torn.db")
+		pager := openNoStealPager(t, path)
+		meta := NewMetaPage(BLOCK_SIZE)
+
+		var pids []uint64
+		for j := 0; j < 20; j++ {
+			pid, _, err := pager.NewPage()
+			require.NoError(t, err)
+			require.NoError(t, pager.WritePage(pid, fillPage('a')))
+			require.NoError(t, pager.UnpinPage(pid, true))
+			pids = append(pids, pid)
+		}
+		ckpt, err := BeginCheckpoint(pager, 0, meta, 1)
+		require.NoError(t, err)
+		require.NoError(t, ckpt.Write())
+
+		// The next checkpoint changes every page and adds some, and
+		// crashes after a random number of its writes in place
+		for _, pid := range pids {
+			require.NoError(t, pager.WritePage(pid, fillPage('b')))
+		}
+		for j := 0; j < 10; j++ {
+			pid, _, err := pager.NewPage()
+			require.NoError(t, err)
+			require.NoError(t, pager.WritePage(pid, fillPage('b')))
+			require.NoError(t, pager.UnpinPage(pid, true))
+			pids = append(pids, pid)
+		}
+		ckpt, err = BeginCheckpoint(pager, 0, meta, 2)
+		require.NoError(t, err)
+
+		writes := len(pids) + 1
+		crashAt := rng.Intn(writes + 1)
+		tornDoubleWrite := i%4 == 0
+		if tornDoubleWrite {
+			crashAt = 0
+		}
+		n := 0
+		pager.beforeWrite = func() error {
+			if n == crashAt {
+				return errCrash
+			}
+			n++
+			return nil
+		}
+		err = ckpt.Write()
+		if crashAt < writes {
+			require.ErrorIs(t, err, errCrash)
+		} else {
+			require.NoError(t, err)
+		}
+		pager.file.Close()
+
+		// A crash while the double-write file was written leaves it torn
+		want, lsn := byte('b'), uint64(2)
+		if tornDoubleWrite {
+			dwb := path + DOUBLE_WRITE_SUFFIX
+			info, err := os.Stat(dwb)
+			require.NoError(t, err)
+			require.NoError(t, os.Truncate(dwb, rng.Int63n(info.Size())))
+			want, lsn = 'a', 1
+			pids = pids[:20]
+		}
+
+		pager = openNoStealPager(t, path)
+		_, err = os.Stat(path + DOUBLE_WRITE_SUFFIX)
+		assert.True(t, os.IsNotExist(err))
+		got, err := LoadMeta(pager, 0)
+		require.NoError(t, err)
+		assert.Equal(t, lsn, got.CheckpointLSN, "crash after %d writes", crashAt)
+		for _, pid := range pids {
+			buf, err := pager.FetchPage(pid)
+			require.NoError(t, err, "crash after %d writes", crashAt)
+			assert.Equal(t, want, buf[BLOCK_SIZE-1], "page %d, crash after %d writes", pid, crashAt)
+			require.NoError(t, pager.UnpinPage(pid, false))
+		}
+		pager.file.Close()
+	}
+}
@@ -1,12 +1,18 @@
 package disk
 
+import "sync"
+
+// BLOCK_SIZE is the default page size for new files
 const BLOCK_SIZE = 4096
 
 // FileAllocator manages allocation of fixed-size blocks.
 // It works purely with block IDs, not byte offsets.
 type FileAllocator struct {
-	nextBlockID uint64   // Next never-used block ID
-	freeList    []uint64 // Reusable block IDs
+	mu          sync.Mutex
+	nextBlockID uint64              // Next never-used block ID
+	freeList    []uint64            // Reusable block IDs
+	reserved    map[uint64]struct{} // Free blocks holding the on-disk free list
+	dirty       bool                // Changed since the last Reserve
 }
 
 // NewFileAllocator creates a fresh allocator (empty file case)
@@ -14,15 +20,26 @@ func NewFileAllocator() *FileAllocator {
 	return &FileAllocator{
 		nextBlockID: 1,
 		freeList:    make([]uint64, 0),
+		reserved:    make(map[uint64]struct{}),
 	}
 }
 
 // Allocate returns a block ID that can be written to.
+// Reserved blocks are skipped: overwriting them would break the free list
+// that is currently durable on disk.
 func (a *FileAllocator) Allocate() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.dirty = true
+
 	// Reuse from free list if possible
-	if n := len(a.freeList); n > 0 {
-		id := a.freeList[n-1] // LIFO is cache-friendly
-		a.freeList = a.freeList[:n-1]
+	for i := len(a.freeList) - 1; i >= 0; i-- { // LIFO is cache-friendly
+		id := a.freeList[i]
+		if _, ok := a.reserved[id]; ok {
+			continue
+		}
+		a.freeList = append(a.freeList[:i], a.freeList[i+1:]...)
 		return id
 	}
 
@@ -33,71 +50,69 @@ func (a *FileAllocator) Allocate() uint64 {
 
 // Free releases a block ID for reuse.
 func (a *FileAllocator) Free(blockID uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.dirty = true
 	a.freeList = append(a.freeList, blockID)
 }
 
+// Extend hands out a never-used block ID and records it as free.
+// It is used when the free list needs a page to store itself in.
+func (a *FileAllocator) Extend() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	id := a.nextBlockID
+	a.nextBlockID++
+	a.freeList = append(a.freeList, id)
+	a.dirty = true
+	return id
+}
+
+// Snapshot returns a copy of the allocator state and whether it changed
+// since the last call to Reserve.
+func (a *FileAllocator) Snapshot() (nextBlockID uint64, freeList []uint64, dirty bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.nextBlockID, append([]uint64(nil), a.freeList...), a.dirty
+}
+
+// IsReserved reports whether blockID holds part of the durable free list
+func (a *FileAllocator) IsReserved(blockID uint64) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	_, ok := a.reserved[blockID]
+	return ok
+}
+
+// Reserve records which free blocks now hold the durable free list and
+// marks the allocator state as persisted.
+func (a *FileAllocator) Reserve(blockIDs []uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.reserved = make(map[uint64]struct{}, len(blockIDs))
+	for _, id := range blockIDs {
+		a.reserved[id] = struct{}{}
+	}
+	a.dirty = false
+}
+
+// Load restores allocator state read back from disk
+func (a *FileAllocator) Load(nextBlockID uint64, freeList []uint64, reserved []uint64) {
+	a.mu.Lock()
+	a.nextBlockID = nextBlockID
+	a.freeList = append([]uint64(nil), freeList...)
+	a.mu.Unlock()
+
+	a.Reserve(reserved)
+}
+
 // BlockOffset converts a block ID to byte offset in file.
 // Pager layer should use this.
-func BlockOffset(blockID uint64) uint64 {
-	return blockID * BLOCK_SIZE
+func BlockOffset(blockID uint64, blockSize int) uint64 {
+	return blockID * uint64(blockSize)
 }
-
-// // Persist allocator state to disk (MetaPage, header, etc.)
-// func (a *FileAllocator) WriteToFile(file *os.File) error {
-// 	buf := new(bytes.Buffer)
-// 	// - serialize nextBlockID
-// 	if err := binary.Write(buf, binary.BigEndian, a.nextBlockID); err != nil {
-// 		return err
-// 	}
-// 	// - serialize freeList length + entries
-// 	freeLen := uint64(len(a.freeList))
-// 	if err := binary.Write(buf, binary.BigEndian, freeLen); err != nil {
-// 		return err
-// 	}
-// 	for _, id := range a.freeList {
-// 		if err := binary.Write(buf, binary.BigEndian, id); err != nil {
-// 			return err
-// 		}
-// 	}
-// 	// - write to meta page (usually page 0)
-// 	if _, err := file.Seek(0, io.SeekStart); err != nil {
-// 		return err
-// 	}
-
-// 	data := buf.Bytes()
-// 	if len(data) > BLOCK_SIZE {
-// 		return io.ErrShortBuffer
-// 	}
-// 	padding := make([]byte, BLOCK_SIZE-len(data))
-// 	if _, err := file.Write(append(data, padding...)); err != nil {
-// 		return err
-// 	}
-// 	return nil
-// }
-
-// // Load allocator state from disk
-// func LoadFileAllocator(file *os.File) (*FileAllocator, error) {
-// 	buf := make([]byte, BLOCK_SIZE)
-// 	if _, err := file.ReadAt(buf, 0); err != nil {
-// 		return nil, err
-// 	}
-// 	r := bytes.NewReader(buf)
-// 	var nextBlockID uint64
-// 	if err := binary.Read(r, binary.BigEndian, &nextBlockID); err != nil {
-// 		return nil, err
-// 	}
-// 	var freeLen uint64
-// 	if err := binary.Read(r, binary.BigEndian, &freeLen); err != nil {
-// 		return nil, err
-// 	}
-// 	freeList := make([]uint64, freeLen)
-// 	for i := uint64(0); i < freeLen; i++ {
-// 		if err := binary.Read(r, binary.BigEndian, &freeList[i]); err != nil {
-// 			return nil, err
-// 		}
-// 	}
-// 	return &FileAllocator{
-// 		nextBlockID: nextBlockID,
-// 		freeList:    freeList,
-// 	}, nil
-// }
@@ -0,0 +1,56 @@
+package disk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileAllocator_AllocateAndFree(t *testing.T) {
+	a := NewFileAllocator()
+
+	assert.Equal(t, uint64(1), a.Allocate())
+	assert.Equal(t, uint64(2), a.Allocate())
+	assert.Equal(t, uint64(3), a.Allocate())
+
+	a.Free(2)
+	a.Free(3)
+
+	// LIFO reuse
+	assert.Equal(t, uint64(3), a.Allocate())
+	assert.Equal(t, uint64(2), a.Allocate())
+	assert.Equal(t, uint64(4), a.Allocate())
+}
+
+func TestFileAllocator_SkipsReserved(t *testing.T) {
+	a := NewFileAllocator()
+	a.Load(10, []uint64{4, 5, 6}, []uint64{6})
+
+	_, _, dirty := a.Snapshot()
+	assert.False(t, dirty)
+
+	assert.Equal(t, uint64(5), a.Allocate())
+	assert.Equal(t, uint64(4), a.Allocate())
+	assert.Equal(t, uint64(10), a.Allocate())
+
+	next, free, dirty := a.Snapshot()
+	assert.Equal(t, uint64(11), next)
+	assert.Equal(t, []uint64{6}, free)
+	assert.True(t, dirty)
+
+	// Once a new chain is durable the old block is usable again
+	a.Reserve(nil)
+	assert.Equal(t, uint64(6), a.Allocate())
+}
+
+func TestFileAllocator_Extend(t *testing.T) {
+	a := NewFileAllocator()
+	a.Allocate()
+
+	id := a.Extend()
+	assert.Equal(t, uint64(2), id)
+
+	next, free, _ := a.Snapshot()
+	assert.Equal(t, uint64(3), next)
+	assert.Equal(t, []uint64{2}, free)
+}
@@ -0,0 +1,164 @@
+package disk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// FORMAT_VERSION is bumped whenever the on-disk layout changes.
+// Version 2 added the comparator name, version 3 the page LSN and the
+// checkpoint LSN.
+const FORMAT_VERSION uint16 = 3
+
+// Supported page sizes. BLOCK_SIZE is the default.
+const (
+	MIN_BLOCK_SIZE = 4096
+	MAX_BLOCK_SIZE = 32768
+)
+
+// Feature flags recorded in the file header. A file that uses a feature
+// this build does not know about is refused.
+const (
+	FeatureChecksums uint64 = 1 << iota
+	FeatureOverflowPages
+	FeatureHashIndex // the file holds a hash index instead of a B+tree
+)
+
+const SUPPORTED_FEATURES = FeatureChecksums | FeatureOverflowPages | FeatureHashIndex
+
+// magic(4) | version(2) | block size(4) | max key size(2) | features(8) |
+// comparator name(COMPARATOR_NAME_SIZE, zero padded)
+const FILE_HEADER_SIZE = 4 + 2 + 4 + 2 + 8 + COMPARATOR_NAME_SIZE
+
+var (
+	ErrNotDatabaseFile     = errors.New("not a database file")
+	ErrUnsupportedVersion  = errors.New("unsupported format version")
+	ErrInvalidBlockSize    = fmt.Errorf("block size must be a power of two between %d and %d", MIN_BLOCK_SIZE, MAX_BLOCK_SIZE)
+	ErrUnsupportedFeatures = errors.New("unsupported feature flags")
+	ErrWrongIndexType      = errors.New("file holds a different index type")
+)
+
+// FileHeader describes a database file. It sits at the start of the meta
+// page, right after the page header, so it can be read before the page
+// size is known.
+type FileHeader struct {
+	Magic         uint32
+	FormatVersion uint16
+	BlockSize     uint32
+	MaxKeySize    uint16
+	Features      uint64
+	Comparator    string // name of the key order
+}
+
+// NewFileHeader returns the header for a new file with the given page size
+func NewFileHeader(blockSize int) FileHeader {
+	return FileHeader{
+		Magic:         META_MAGIC,
+		FormatVersion: FORMAT_VERSION,
+		BlockSize:     uint32(blockSize),
+		MaxKeySize:    MAX_KEY_SIZE,
+		Features:      FeatureChecksums | FeatureOverflowPages,
+		Comparator:    BYTEWISE,
+	}
+}
+
+// ValidBlockSize reports whether n can be used as a page size
+func ValidBlockSize(n int) error {
+	if n < MIN_BLOCK_SIZE || n > MAX_BLOCK_SIZE || n&(n-1) != 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidBlockSize, n)
+	}
+	return nil
+}
+
+// Validate checks that this build can open a file with this header
+func (h *FileHeader) Validate() error {
+	if h.Magic != META_MAGIC {
+		return fmt.Errorf("%w: bad magic %08x", ErrNotDatabaseFile, h.Magic)
+	}
+	if h.FormatVersion != FORMAT_VERSION {
+		return fmt.Errorf("%w: file has version %d, expected %d", ErrUnsupportedVersion, h.FormatVersion, FORMAT_VERSION)
+	}
+	if err := ValidBlockSize(int(h.BlockSize)); err != nil {
+		return err
+	}
+	if h.MaxKeySize != MAX_KEY_SIZE {
+		return fmt.Errorf("%w: max key size %d, expected %d", ErrUnsupportedFeatures, h.MaxKeySize, MAX_KEY_SIZE)
+	}
+	if unknown := h.Features &^ SUPPORTED_FEATURES; unknown != 0 {
+		return fmt.Errorf("%w: %x", ErrUnsupportedFeatures, unknown)
+	}
+	if _, err := LookupComparator(h.Comparator); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (h *FileHeader) WriteToBuffer(buf *bytes.Buffer) error {
+	fields := []any{h.Magic, h.FormatVersion, h.BlockSize, h.MaxKeySize, h.Features}
+	for _, f := range fields {
+		if err := binary.Write(buf, binary.BigEndian, f); err != nil {
+			return err
+		}
+	}
+
+	if len(h.Comparator) > COMPARATOR_NAME_SIZE {
+		return fmt.Errorf("comparator name %q exceeds %d bytes", h.Comparator, COMPARATOR_NAME_SIZE)
+	}
+	var name [COMPARATOR_NAME_SIZE]byte
+	copy(name[:], h.Comparator)
+	_, err := buf.Write(name[:])
+	return err
+}
+
+func (h *FileHeader) ReadFromBuffer(buf *bytes.Buffer) error {
+	fields := []any{&h.Magic, &h.FormatVersion, &h.BlockSize, &h.MaxKeySize, &h.Features}
+	for _, f := range fields {
+		if err := binary.Read(buf, binary.BigEndian, f); err != nil {
+			return err
+		}
+	}
+
+	name := buf.Next(COMPARATOR_NAME_SIZE)
+	if len(name) < COMPARATOR_NAME_SIZE {
+		return io.ErrUnexpectedEOF
+	}
+	h.Comparator = string(bytes.TrimRight(name, "\x00"))
+	return nil
+}
+
+// ReadFileHeader reads and validates the header of an existing file
+// without going through a Pager. It returns nil, nil when the file holds
+// no header yet (empty, or the first page was never written).
+func ReadFileHeader(r io.ReaderAt) (*FileHeader, error) {
+	raw := make([]byte, PAGE_HEADER_SIZE+FILE_HEADER_SIZE)
+	n, err := r.ReadAt(raw, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if isZeroPage(raw[:n]) {
+		return nil, nil
+	}
+	if n < len(raw) {
+		return nil, fmt.Errorf("%w: file is too short", ErrNotDatabaseFile)
+	}
+
+	h := &FileHeader{}
+	if err := h.ReadFromBuffer(bytes.NewBuffer(raw[PAGE_HEADER_SIZE:])); err != nil {
+		return nil, err
+	}
+	if err := h.Validate(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// pageSize returns n, or BLOCK_SIZE for pages built without a size
+func pageSize(n int) int {
+	if n == 0 {
+		return BLOCK_SIZE
+	}
+	return n
+}
@@ -0,0 +1,94 @@
+package disk
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidBlockSize(t *testing.T) {
+	for _, n := range []int{4096, 8192, 16384, 32768} {
+		assert.NoError(t, ValidBlockSize(n))
+	}
+	for _, n := range []int{0, 512, 2048, 6000, 65536} {
+		assert.ErrorIs(t, ValidBlockSize(n), ErrInvalidBlockSize)
+	}
+}
+
+func TestFileHeader_Validate(t *testing.T) {
+	h := NewFileHeader(8192)
+	assert.NoError(t, h.Validate())
+
+	bad := h
+	bad.Magic = 0x12345678
+	assert.ErrorIs(t, bad.Validate(), ErrNotDatabaseFile)
+
+	bad = h
+	bad.FormatVersion = FORMAT_VERSION + 1
+	assert.ErrorIs(t, bad.Validate(), ErrUnsupportedVersion)
+
+	bad = h
+	bad.BlockSize = 1000
+	assert.ErrorIs(t, bad.Validate(), ErrInvalidBlockSize)
+
+	bad = h
+	bad.Features |= 1 << 63
+	assert.ErrorIs(t, bad.Validate(), ErrUnsupportedFeatures)
+
+	bad = h
+	bad.Comparator = "no-such-order"
+	assert.ErrorIs(t, bad.Validate(), ErrUnknownComparator)
+}
+
+func TestFileHeader_Comparator(t *testing.T) {
+	h := NewFileHeader(BLOCK_SIZE)
+	assert.Equal(t, BYTEWISE, h.Comparator)
+
+	h.Comparator = REVERSE_BYTEWISE
+	buf := new(bytes.Buffer)
+	require.NoError(t, h.WriteToBuffer(buf))
+	assert.Equal(t, FILE_HEADER_SIZE, buf.Len())
+
+	var read FileHeader
+	require.NoError(t, read.ReadFromBuffer(buf))
+	assert.Equal(t, h, read)
+
+	h.Comparator = string(make([]byte, COMPARATOR_NAME_SIZE+1))
+	assert.Error(t, h.WriteToBuffer(new(bytes.Buffer)))
+}
+
+func TestReadFileHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "header.db")
+
+	// Empty file: no header yet
+	require.NoError(t, os.WriteFile(path, nil, 0666))
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	h, err := ReadFileHeader(file)
+	file.Close()
+	require.NoError(t, err)
+	assert.Nil(t, h)
+
+	// A meta page written by the tree
+	buf := new(bytes.Buffer)
+	require.NoError(t, NewMetaPage(16384).WriteToBuffer(buf))
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0666))
+	file, err = os.Open(path)
+	require.NoError(t, err)
+	h, err = ReadFileHeader(file)
+	file.Close()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(16384), h.BlockSize)
+
+	// Some other file
+	require.NoError(t, os.WriteFile(path, bytes.Repeat([]byte("not a db"), 100), 0666))
+	file, err = os.Open(path)
+	require.NoError(t, err)
+	_, err = ReadFileHeader(file)
+	file.Close()
+	assert.ErrorIs(t, err, ErrNotDatabaseFile)
+}
@@ -0,0 +1,69 @@
+package disk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// FreeListMaxIDs is the number of IDs one free list page holds:
+// header | nids(2) | ids...
+func FreeListMaxIDs(blockSize int) int {
+	return (blockSize - PAGE_HEADER_SIZE - 2) / 8
+}
+
+// FreeListPage stores free block IDs that do not fit in the meta page.
+// The page itself is a free block; Header.NextPagePointer links the chain.
+type FreeListPage struct {
+	Header    PageHeader
+	IDs       []uint64
+	BlockSize int // encoded page size; 0 means BLOCK_SIZE
+}
+
+func NewFreeListPage() *FreeListPage {
+	return &FreeListPage{
+		Header: PageHeader{
+			PageType:        PageTypeFreeList,
+			NextPagePointer: 0,
+		},
+	}
+}
+
+func (p *FreeListPage) WriteToBuffer(buf *bytes.Buffer) error {
+	maxIDs := FreeListMaxIDs(pageSize(p.BlockSize))
+	if len(p.IDs) > maxIDs {
+		return fmt.Errorf("free list page: %d ids exceed %d", len(p.IDs), maxIDs)
+	}
+
+	if err := p.Header.WriteToBuffer(buf); err != nil {
+		return err
+	}
+
+	if err := binary.Write(buf, binary.BigEndian, uint16(len(p.IDs))); err != nil {
+		return err
+	}
+
+	return binary.Write(buf, binary.BigEndian, p.IDs)
+}
+
+func (p *FreeListPage) ReadFromBuffer(buf *bytes.Buffer) error {
+	if err := p.Header.ReadFromBuffer(buf); err != nil {
+		return err
+	}
+
+	if p.Header.PageType != PageTypeFreeList {
+		return fmt.Errorf("free list page: unexpected page type %d", p.Header.PageType)
+	}
+
+	var n uint16
+	if err := binary.Read(buf, binary.BigEndian, &n); err != nil {
+		return err
+	}
+
+	if maxIDs := FreeListMaxIDs(pageSize(p.BlockSize)); int(n) > maxIDs {
+		return fmt.Errorf("free list page: %d ids exceed %d", n, maxIDs)
+	}
+
+	p.IDs = make([]uint64, n)
+	return binary.Read(buf, binary.BigEndian, p.IDs)
+}
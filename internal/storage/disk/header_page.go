@@ -10,9 +10,24 @@ import (
 // 2: Leaf Page
 // ...: not support
 
+// PAGE_HEADER_SIZE is the encoded size of PageHeader
+const PAGE_HEADER_SIZE = 1 + 8 + 4 + 8
+
+// PAGE_CHECKSUM_OFFSET is where the checksum sits inside the header
+const PAGE_CHECKSUM_OFFSET = 1 + 8
+
+// PAGE_LSN_OFFSET is where the page LSN sits inside the header
+const PAGE_LSN_OFFSET = 1 + 8 + 4
+
 type PageHeader struct {
 	PageType        uint8
 	NextPagePointer uint64
+	// Checksum is the CRC32C of the page, filled in by the Pager when the
+	// page is written to disk
+	Checksum uint32
+	// LSN is the log sequence number of the last logged change to the
+	// page, stamped by the Pager when the page is dirtied
+	LSN uint64
 }
 
 // Input: {page_type = 1, next = 1024}. Output: buffer = [ 1 0 0 0 0 0 0 255 255 ]
@@ -30,6 +45,14 @@ func (h *PageHeader) WriteToBuffer(buf *bytes.Buffer) error {
 		return err
 	}
 
+	if err := binary.Write(buf, binary.BigEndian, h.Checksum); err != nil {
+		return err
+	}
+
+	if err := binary.Write(buf, binary.BigEndian, h.LSN); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -43,5 +66,13 @@ func (h *PageHeader) ReadFromBuffer(buf *bytes.Buffer) error {
 
 	}
 
+	if err := binary.Read(buf, binary.BigEndian, &h.Checksum); err != nil {
+		return err
+	}
+
+	if err := binary.Read(buf, binary.BigEndian, &h.LSN); err != nil {
+		return err
+	}
+
 	return nil
 }
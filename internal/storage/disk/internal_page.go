@@ -3,45 +3,97 @@ package disk
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"sort"
 )
 
-const (
-	MAX_INTERNAL_KEYS     = 4
-	MAX_INTERNAL_CHILDREN = MAX_INTERNAL_KEYS + 1
-)
+// header | nkeys(2) | cell start(2) | child0(8)
+const INTERNAL_HEADER_SIZE = PAGE_HEADER_SIZE + 2 + 2 + 8
 
-// [header | u8 u8 | k0 k1 k2 ... | 0 0 0 0 0 0 ... ]
+// Keys[i] separates Children[i] | Children[i+1]
+// [header | n start c0 | s0 s1 s2 ... | free | (c1 k0) (c2 k1) (c3 k2) ... ]
 type InternalPage struct {
-	Header   PageHeader
-	NKeys    uint16
-	Keys     [MAX_INTERNAL_KEYS]KeyEntry
-	Children [MAX_INTERNAL_CHILDREN]uint64
+	Header    PageHeader
+	Keys      []KeyEntry
+	Children  []uint64
+	BlockSize int         // encoded page size; 0 means BLOCK_SIZE
+	Cmp       *Comparator // key order; nil means bytewise
+}
+
+func NewInternalPage() *InternalPage {
+	return &InternalPage{
+		Header: PageHeader{
+			PageType:        PageTypeInternal,
+			NextPagePointer: 0,
+		},
+		Children: []uint64{0},
+	}
+}
+
+func (p *InternalPage) blockSize() int {
+	return pageSize(p.BlockSize)
+}
+
+// NKeys returns the number of separator keys
+func (p *InternalPage) NKeys() int {
+	return len(p.Keys)
+}
+
+// Size returns the number of bytes the page occupies when encoded
+func (p *InternalPage) Size() int {
+	size := INTERNAL_HEADER_SIZE
+	for i := range p.Keys {
+		size += p.Keys[i].CellSize()
+	}
+	return size
 }
 
+// WriteToBuffer encodes the full page of BlockSize bytes
 func (p *InternalPage) WriteToBuffer(buffer *bytes.Buffer) error {
-	if err := p.Header.WriteToBuffer(buffer); err != nil {
-		return err
+	if len(p.Children) != len(p.Keys)+1 {
+		return fmt.Errorf("internal page: %d keys but %d children", len(p.Keys), len(p.Children))
+	}
+	if p.Size() > p.blockSize() {
+		return ErrPageOverflow
 	}
 
-	if err := binary.Write(buffer, binary.BigEndian, p.NKeys); err != nil {
+	if err := p.Header.WriteToBuffer(buffer); err != nil {
 		return err
 	}
 
-	for i := 0; i < int(p.NKeys); i += 1 {
-		if err := p.Keys[i].writeToBuffer(buffer); err != nil {
-			return err
-		}
+	body := make([]byte, p.blockSize()-PAGE_HEADER_SIZE)
+
+	// cells are packed at the end of the page in slot order
+	cellStart := p.blockSize()
+	for i := range p.Keys {
+		cellStart -= p.Keys[i].CellSize() - SLOT_SIZE
 	}
 
-	for i := 0; i <= int(p.NKeys); i += 1 {
-		if err := binary.Write(buffer, binary.BigEndian, p.Children[i]); err != nil {
-			return err
-		}
+	binary.BigEndian.PutUint16(body[0:], uint16(len(p.Keys)))
+	binary.BigEndian.PutUint16(body[2:], uint16(cellStart))
+	binary.BigEndian.PutUint64(body[4:], p.Children[0])
+
+	slot := INTERNAL_HEADER_SIZE
+	off := cellStart
+	for i := range p.Keys {
+		key := p.Keys[i].Key
+		binary.BigEndian.PutUint16(body[slot-PAGE_HEADER_SIZE:], uint16(off))
+
+		cell := body[off-PAGE_HEADER_SIZE:]
+		binary.BigEndian.PutUint64(cell[0:], p.Children[i+1])
+		binary.BigEndian.PutUint16(cell[8:], uint16(len(key)))
+		copy(cell[10:], key)
+
+		slot += SLOT_SIZE
+		off += p.Keys[i].CellSize() - SLOT_SIZE
 	}
 
-	return nil
+	_, err := buffer.Write(body)
+	return err
 }
 
+// ReadFromBuffer decodes a page. When isReadHeader is false the header has
+// already been consumed from buffer. Keys are copied out.
 func (p *InternalPage) ReadFromBuffer(buffer *bytes.Buffer, isReadHeader bool) error {
 	if isReadHeader {
 		if err := p.Header.ReadFromBuffer(buffer); err != nil {
@@ -49,116 +101,88 @@ func (p *InternalPage) ReadFromBuffer(buffer *bytes.Buffer, isReadHeader bool) e
 		}
 	}
 
-	if err := binary.Read(buffer, binary.BigEndian, &p.NKeys); err != nil {
-		return err
+	body := buffer.Bytes()
+	if len(body) < INTERNAL_HEADER_SIZE-PAGE_HEADER_SIZE {
+		return fmt.Errorf("internal page: short buffer")
 	}
+	p.BlockSize = PAGE_HEADER_SIZE + len(body)
 
-	for i := 0; i < int(p.NKeys); i += 1 {
-		if err := p.Keys[i].readFromBuffer(buffer); err != nil {
-			return err
+	n := int(binary.BigEndian.Uint16(body[0:]))
+	p.Keys = make([]KeyEntry, n)
+	p.Children = make([]uint64, n+1)
+	p.Children[0] = binary.BigEndian.Uint64(body[4:])
+
+	slot := INTERNAL_HEADER_SIZE
+	for i := 0; i < n; i++ {
+		if slot+SLOT_SIZE-PAGE_HEADER_SIZE > len(body) {
+			return fmt.Errorf("internal page: slot %d out of range", i)
+		}
+		off := int(binary.BigEndian.Uint16(body[slot-PAGE_HEADER_SIZE:])) - PAGE_HEADER_SIZE
+		if off < 0 || off+10 > len(body) {
+			return fmt.Errorf("internal page: cell %d out of range", i)
 		}
-	}
 
-	for i := 0; i <= int(p.NKeys); i += 1 {
-		if err := binary.Read(buffer, binary.BigEndian, &p.Children[i]); err != nil {
-			return err
+		klen := int(binary.BigEndian.Uint16(body[off+8:]))
+		if off+10+klen > len(body) {
+			return fmt.Errorf("internal page: cell %d out of range", i)
 		}
+
+		p.Children[i+1] = binary.BigEndian.Uint64(body[off:])
+		p.Keys[i] = *NewKeyEntryFromBytes(body[off+10 : off+10+klen])
+		slot += SLOT_SIZE
 	}
 
+	buffer.Next(len(body))
 	return nil
 }
 
-func NewInternalPage() *InternalPage {
-
-	var new_keys [MAX_INTERNAL_KEYS]KeyEntry
-	var new_children [MAX_INTERNAL_CHILDREN]uint64
-
-	return &InternalPage{
-		NKeys:    0,
-		Keys:     new_keys,
-		Children: new_children,
-		Header: PageHeader{
-			PageType:        PageTypeInternal,
-			NextPagePointer: 0,
-		},
-	}
-}
-
 // Find last position so that the key <= find_key
 func (n *InternalPage) FindLastLE(key *KeyEntry) int {
-	pos := -1
-	for i := 0; i < int(n.NKeys); i++ {
-		if n.Keys[i].Compare(key) > 0 {
-			break
-		}
-		pos = i
-	}
-	return pos
+	return sort.Search(len(n.Keys), func(i int) bool {
+		return compareWith(n.Cmp, n.Keys[i].Key, key.Key) > 0
+	}) - 1
 }
 
-// Insert a key-children pair into the Internal Node
+// Insert a key-children pair into the Internal Node.
+// The page may overflow; callers split it afterwards.
 func (n *InternalPage) InsertKV(key *KeyEntry, rightChild uint64) {
-	pos := n.FindLastLE(key)
-
-	// shift keys
-	for i := int(n.NKeys); i > pos+1; i-- {
-		n.Keys[i] = n.Keys[i-1]
-	}
+	pos := n.FindLastLE(key) + 1
 
-	// shift children
-	for i := int(n.NKeys) + 1; i > pos+2; i-- {
-		n.Children[i] = n.Children[i-1]
-	}
+	n.Keys = append(n.Keys, KeyEntry{})
+	copy(n.Keys[pos+1:], n.Keys[pos:])
+	n.Keys[pos] = *key
 
-	n.Keys[pos+1] = *key
-	n.Children[pos+2] = rightChild
-	n.NKeys++
+	n.Children = append(n.Children, 0)
+	copy(n.Children[pos+2:], n.Children[pos+1:])
+	n.Children[pos+1] = rightChild
 }
 
-// func (node *InternalPage) DelKVAtPos(pos int) {
-// 	for i := pos; i < int(node.NKeys)-1; i++ {
-// 		node.Keys[i] = node.Keys[i+1]
-// 	}
-
-// 	for i := pos + 1; i < int(node.NKeys); i++ {
-// 		node.Children[i] = node.Children[i+1]
-// 	}
-
-// 	node.Keys[node.NKeys-1] = KeyEntry{}
-// 	node.Children[node.NKeys] = 0
-// 	node.NKeys -= 1
-// }
+// DelKVAtPos removes Keys[pos] and the child to its right
+func (n *InternalPage) DelKVAtPos(pos int) {
+	n.Keys = append(n.Keys[:pos], n.Keys[pos+1:]...)
+	n.Children = append(n.Children[:pos+1], n.Children[pos+2:]...)
+}
 
-// Split a node into 2 equal part
+// Split a node into 2 parts of roughly equal byte size.
+// The middle key moves up to the parent and is kept in neither half.
 func (n *InternalPage) Split() (*InternalPage, *KeyEntry) {
-	var newKeys [MAX_INTERNAL_KEYS]KeyEntry
-	var newChildren [MAX_INTERNAL_CHILDREN]uint64
-
-	mid := n.NKeys / 2
-
-	middleKey := n.Keys[mid]
-
-	// Move keys[mid..] → new node
-	for i := mid; i < n.NKeys; i++ {
-		newKeys[i-mid] = n.Keys[i]
-		n.Keys[i] = KeyEntry{}
+	sizes := make([]int, len(n.Keys))
+	for i := range n.Keys {
+		sizes[i] = n.Keys[i].CellSize()
 	}
+	mid := min(splitPoint(sizes), len(n.Keys)-1)
 
-	// Move children[mid..]
-	for i := mid; i <= n.NKeys; i++ {
-		newChildren[i-mid] = n.Children[i]
-		n.Children[i] = 0
-	}
+	middleKey := n.Keys[mid]
 
-	newNode := InternalPage{
-		Header:   PageHeader{PageType: 1},
-		NKeys:    n.NKeys - mid,
-		Keys:     newKeys,
-		Children: newChildren,
-	}
+	newNode := NewInternalPage()
+	newNode.BlockSize = n.BlockSize
+	newNode.Cmp = n.Cmp
+	newNode.Keys = append([]KeyEntry{}, n.Keys[mid+1:]...)
+	newNode.Children = append([]uint64{}, n.Children[mid+1:]...)
 
-	n.NKeys = mid
-	return &newNode, &middleKey
+	n.Keys = n.Keys[:mid:mid]
+	n.Children = n.Children[: mid+1 : mid+1]
+	return newNode, &middleKey
 }
 
 func (p *InternalPage) IsLeaf() bool {
@@ -166,13 +190,21 @@ func (p *InternalPage) IsLeaf() bool {
 }
 
 func (p *InternalPage) IsOverflow() bool {
-	return p.NKeys > MAX_INTERNAL_KEYS
+	return p.Size() > p.blockSize()
+}
+
+func (p *InternalPage) IsUnderflow() bool {
+	return p.Size() < MinFill(p.blockSize())
 }
 
-func (p *InternalPage) MinKeys() uint16 {
-	return MAX_INTERNAL_KEYS / 2
+// CanReplaceKey reports whether Keys[i] can be replaced by key without
+// the page overflowing
+func (p *InternalPage) CanReplaceKey(i int, key *KeyEntry) bool {
+	return p.Size()-p.Keys[i].CellSize()+key.CellSize() <= p.blockSize()
 }
 
-func (p *InternalPage) CanBorrow() bool {
-	return p.NKeys > p.MinKeys()
+// CanLend reports whether the key at i can rotate to a sibling without
+// this page dropping below MinFill
+func (p *InternalPage) CanLend(i int) bool {
+	return len(p.Keys) > 1 && p.Size()-p.Keys[i].CellSize() >= MinFill(p.blockSize())
 }
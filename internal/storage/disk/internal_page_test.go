@@ -15,13 +15,13 @@ func TestInternalPage(t *testing.T) {
 	node.InsertKV(key_3, c)
 
 	// [3]
-	assert.Equal(t, uint16(1), node.NKeys, "nkey should be 1")
+	assert.Equal(t, 1, node.NKeys(), "nkey should be 1")
 	assert.Equal(t, 0, node.Keys[0].Compare(key_3), "key[0] should be 3")
 	key_10 := NewKeyEntryFromInt(10)
 	node.InsertKV(key_10, c)
 
 	// [3, 10]
-	assert.Equal(t, uint16(2), node.NKeys, "nkey should be 2")
+	assert.Equal(t, 2, node.NKeys(), "nkey should be 2")
 	assert.Equal(t, 0, node.Keys[0].Compare(key_3), "key[0] should be 3")
 	assert.Equal(t, 0, node.Keys[1].Compare(key_10), "key[1] should be 10")
 
@@ -29,27 +29,26 @@ func TestInternalPage(t *testing.T) {
 	node.InsertKV(key_5, c)
 
 	// [3, 5, 10]
-	assert.Equal(t, uint16(3), node.NKeys, "nkey should be 3")
+	assert.Equal(t, 3, node.NKeys(), "nkey should be 3")
 	assert.Equal(t, 0, node.Keys[0].Compare(key_3), "key[0] should be 3")
 	assert.Equal(t, 0, node.Keys[1].Compare(key_5), "key[1] should be 5")
 	assert.Equal(t, 0, node.Keys[2].Compare(key_10), "key[2] should be 10")
 	key_12 := NewKeyEntryFromInt(12)
 	node.InsertKV(key_12, c)
 
-	assert.Equal(t, uint16(4), node.NKeys, "nkey should be 4")
+	assert.Equal(t, 4, node.NKeys(), "nkey should be 4")
 
 	// [3, 5, 10, 12]
 	newNode, middleKey := node.Split()
 
-	// [3, 5] [10, 12]
-	assert.Equal(t, uint16(2), node.NKeys, "node nkey should be 2 after split")
-	assert.Equal(t, uint16(2), newNode.NKeys, "newNode nkey should be 2 after split")
+	// [3, 5] 10 [12]
+	assert.Equal(t, 2, node.NKeys(), "node nkey should be 2 after split")
+	assert.Equal(t, 1, newNode.NKeys(), "newNode nkey should be 1 after split")
 
 	assert.Equal(t, 0, node.Keys[0].Compare(key_3), "node key[0] should be 3")
 	assert.Equal(t, 0, node.Keys[1].Compare(key_5), "node key[1] should be 5")
 
-	assert.Equal(t, 0, newNode.Keys[0].Compare(key_10), "newNode key[0] should be 10")
-	assert.Equal(t, 0, newNode.Keys[1].Compare(key_12), "newNode key[1] should be 12")
+	assert.Equal(t, 0, newNode.Keys[0].Compare(key_12), "newNode key[0] should be 12")
 
 	assert.Equal(t, 0, middleKey.Compare(key_10), "middle key should be 10")
 
@@ -61,7 +60,7 @@ func TestInternalPage(t *testing.T) {
 	err = clonedNode.ReadFromBuffer(buf, true)
 	require.NoError(t, err, "readFromBuffer should not error")
 
-	assert.Equal(t, uint16(2), clonedNode.NKeys, "clonedNode nkey should be 2")
+	assert.Equal(t, 2, clonedNode.NKeys(), "clonedNode nkey should be 2")
 	assert.Equal(t, 0, clonedNode.Keys[0].Compare(key_3), "clonedNode key[0] should be 3")
 	assert.Equal(t, 0, clonedNode.Keys[1].Compare(key_5), "clonedNode key[1] should be 5")
 }
@@ -69,7 +68,7 @@ func TestInternalPage(t *testing.T) {
 func TestNewIPage(t *testing.T) {
 	node := NewInternalPage()
 
-	assert.Equal(t, uint16(0), node.NKeys, "new page should have 0 keys")
+	assert.Equal(t, 0, node.NKeys(), "new page should have 0 keys")
 	assert.Equal(t, uint8(1), node.Header.PageType, "page type should be 1")
 	assert.Equal(t, uint64(0), node.Header.NextPagePointer, "next page pointer should be 0")
 }
@@ -84,20 +83,6 @@ func TestKeyEntry_Compare(t *testing.T) {
 	assert.Equal(t, 0, key3.Compare(key3_dup), "3 should equal 3")
 }
 
-func TestKeyEntry_Serialization(t *testing.T) {
-	key := NewKeyEntryFromInt(12345)
-	buf := new(bytes.Buffer)
-
-	err := key.writeToBuffer(buf)
-	require.NoError(t, err, "writeToBuffer should not error")
-
-	var readKey KeyEntry
-	err = readKey.readFromBuffer(buf)
-	require.NoError(t, err, "readFromBuffer should not error")
-
-	assert.Equal(t, 0, key.Compare(&readKey), "serialized key should match original")
-}
-
 func TestInternalPage_InsertKV_AfterChildSplit(t *testing.T) {
 	node := NewInternalPage()
 
@@ -108,7 +93,7 @@ func TestInternalPage_InsertKV_AfterChildSplit(t *testing.T) {
 
 	node.InsertKV(key, rightChild)
 
-	assert.Equal(t, uint16(1), node.NKeys)
+	assert.Equal(t, 1, node.NKeys())
 
 	assert.Equal(t, 0, node.Keys[0].Compare(key))
 	assert.Equal(t, uint64(10), node.Children[0])
@@ -129,7 +114,7 @@ func TestInternalPage_InsertKV_MultipleSplitsAscending(t *testing.T) {
 	node.InsertKV(k2, 30)
 	node.InsertKV(k3, 40)
 
-	assert.Equal(t, uint16(3), node.NKeys)
+	assert.Equal(t, 3, node.NKeys())
 
 	assert.Equal(t, 0, node.Keys[0].Compare(k1))
 	assert.Equal(t, 0, node.Keys[1].Compare(k2))
@@ -154,7 +139,7 @@ func TestInternalPage_InsertKV_Middle(t *testing.T) {
 	node.InsertKV(k30, 40)
 	node.InsertKV(k20, 30)
 
-	assert.Equal(t, uint16(3), node.NKeys)
+	assert.Equal(t, 3, node.NKeys())
 
 	assert.Equal(t, 0, node.Keys[0].Compare(k10))
 	assert.Equal(t, 0, node.Keys[1].Compare(k20))
@@ -174,29 +159,69 @@ func TestInternalPage_Split(t *testing.T) {
 	k3 := NewKeyEntryFromInt(3)
 	k4 := NewKeyEntryFromInt(4)
 
-	node.NKeys = 4
-	node.Keys[0] = *k1
-	node.Keys[1] = *k2
-	node.Keys[2] = *k3
-	node.Keys[3] = *k4
+	node.Keys = []KeyEntry{*k1, *k2, *k3, *k4}
+	node.Children = []uint64{10, 20, 30, 40, 50}
 
-	node.Children[0] = 10
-	node.Children[1] = 20
-	node.Children[2] = 30
-	node.Children[3] = 40
-	node.Children[4] = 50
-
-	right, _ := node.Split()
+	right, middleKey := node.Split()
 
-	assert.Equal(t, uint16(2), node.NKeys)
-	assert.Equal(t, uint16(2), right.NKeys)
+	// left: [1 2], up: 3, right: [4]
+	assert.Equal(t, 2, node.NKeys())
+	assert.Equal(t, 1, right.NKeys())
+	assert.Equal(t, 0, middleKey.Compare(k3))
 
 	assert.Equal(t, 0, node.Keys[0].Compare(k1))
 	assert.Equal(t, 0, node.Keys[1].Compare(k2))
+	assert.Equal(t, 0, right.Keys[0].Compare(k4))
 
-	assert.Equal(t, 0, right.Keys[0].Compare(k3))
-	assert.Equal(t, 0, right.Keys[1].Compare(k4))
+	assert.Equal(t, []uint64{10, 20, 30}, node.Children)
+	assert.Equal(t, []uint64{40, 50}, right.Children)
+}
 
-	assert.Equal(t, uint64(10), node.Children[0])
-	assert.Equal(t, uint64(30), right.Children[0])
+func TestInternalPage_SplitBySize(t *testing.T) {
+	node := NewInternalPage()
+
+	// One large key followed by many small ones
+	node.Keys = []KeyEntry{*NewKeyEntryFromBytes(bytes.Repeat([]byte{1}, MAX_KEY_SIZE))}
+	node.Children = []uint64{1, 2}
+	for i := 0; i < 10; i++ {
+		node.InsertKV(NewKeyEntryFromBytes([]byte{2, byte(i)}), uint64(i+3))
+	}
+
+	right, middleKey := node.Split()
+
+	// The large key alone outweighs the rest, so it stays left on its own
+	assert.Equal(t, 1, node.NKeys())
+	assert.Equal(t, 0, middleKey.Compare(NewKeyEntryFromBytes([]byte{2, 0})))
+	assert.Equal(t, 9, right.NKeys())
+	assert.Equal(t, right.NKeys()+1, len(right.Children))
+}
+
+func TestInternalPage_Overflow(t *testing.T) {
+	node := NewInternalPage()
+
+	i := 0
+	for !node.IsOverflow() {
+		node.InsertKV(NewKeyEntryFromInt(int64(i)), uint64(i+1))
+		i++
+	}
+
+	err := node.WriteToBuffer(new(bytes.Buffer))
+	assert.ErrorIs(t, err, ErrPageOverflow)
+
+	right, _ := node.Split()
+	assert.False(t, node.IsOverflow())
+	assert.False(t, right.IsOverflow())
+}
+
+func TestInternalPage_DelKVAtPos(t *testing.T) {
+	node := NewInternalPage()
+	node.Children[0] = 10
+	node.InsertKV(NewKeyEntryFromInt(1), 20)
+	node.InsertKV(NewKeyEntryFromInt(2), 30)
+
+	node.DelKVAtPos(0)
+
+	assert.Equal(t, 1, node.NKeys())
+	assert.Equal(t, 0, node.Keys[0].Compare(NewKeyEntryFromInt(2)))
+	assert.Equal(t, []uint64{10, 30}, node.Children)
 }
@@ -8,11 +8,22 @@ import (
 
 func TestKeyEntry(t *testing.T) {
 	keyEntry1 := NewKeyEntryFromInt(8)
-	assert.Equal(t, keyEntry1.KeyLen, uint16(8))
-	assert.Equal(t, keyEntry1.Key, [64]uint8{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 8})
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0, 8}, keyEntry1.Key)
 
 	input := []byte{0, 0, 0, 0, 255, 255, 1, 2}
 	keyEntry2 := NewKeyEntryFromBytes(input)
-	assert.Equal(t, keyEntry2.KeyLen, uint16(len(input)))
-	assert.Equal(t, keyEntry2.Key, [64]uint8 {0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,255,255,1,2})
-}
\ No newline at end of file
+	assert.Equal(t, input, keyEntry2.Key)
+
+	// The entry owns its bytes
+	input[7] = 9
+	assert.Equal(t, byte(2), keyEntry2.Key[7])
+}
+
+func TestKeyEntry_CompareVariableLength(t *testing.T) {
+	short := NewKeyEntryFromBytes([]byte{1})
+	long := NewKeyEntryFromBytes([]byte{1, 0})
+
+	assert.Equal(t, -1, short.Compare(long))
+	assert.Equal(t, 1, long.Compare(short))
+	assert.Equal(t, 1, NewKeyEntryFromBytes([]byte{2}).Compare(long))
+}
@@ -4,133 +4,93 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math"
 )
 
-const MAX_KEY_SIZE = 64
-const MAX_VAL_SIZE = 64
+// MAX_KEY_SIZE bounds keys so that internal pages always hold several
+// separators; a leaf cell (key + value) may use at most MaxLeafCellSize.
+const MAX_KEY_SIZE = 256
 
-var ErrKeyNotFound = fmt.Errorf("key not found")
+// OVERFLOW_REF_SIZE is the in-leaf size of a spilled value:
+// [ValSize u32][first overflow page u64]
+const OVERFLOW_REF_SIZE = 4 + 8
 
-// 3: [1, 7, 255]
-// 0: [0 0 0 0 0 0 1 7 255]
-type KeyVal struct {
-	KeyLen uint16
-	ValLen uint16
-	Key    [MAX_KEY_SIZE]uint8 // BigEndian storage
-	Val    [MAX_VAL_SIZE]uint8 // BigEndian storage
-}
+// overflowFlag marks the ValLen of a cell whose value is in overflow pages
+const overflowFlag = 0x8000
 
-func (kv *KeyVal) GetRightAlignedKey() []byte {
-	return kv.Key[MAX_KEY_SIZE-int(kv.KeyLen):]
-}
+var (
+	ErrKeyNotFound    = fmt.Errorf("key not found")
+	ErrKeyTooLarge    = fmt.Errorf("key exceeds %d bytes", MAX_KEY_SIZE)
+	ErrKeyValTooLarge = fmt.Errorf("key and value do not fit in a leaf cell")
+	ErrValueTooLarge  = fmt.Errorf("value exceeds %d bytes", math.MaxUint32)
+)
 
-func (kv *KeyVal) GetRightAlignedValue() []byte {
-	return kv.Val[MAX_VAL_SIZE-int(kv.ValLen):]
+type KeyVal struct {
+	Key []byte
+	Val []byte
+
+	// Overflow is the first page of the chain holding the value when it
+	// is too large for the leaf; Val is then empty and ValSize is the full
+	// value length. 0 means the value is inline.
+	Overflow uint64
+	ValSize  uint32
 }
 
 // Value returns the value bytes (for backward compatibility)
 func (kv *KeyVal) Value() []byte {
-	return kv.GetRightAlignedValue()
-}
-
-// RightAlignCopy copies src to the end of dst slice
-func RightAlignCopy(dst []byte, src []byte) {
-	// For example:
-	// dst := make([]byte, 8)
-	// src := []byte{0x00, 0x00, 0x01, 0x2C}
-	// RightAlignCopy(dst, src) = [0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x2C]
-	copy(dst[len(dst)-len(src):], src)
+	return kv.Val
 }
 
 // From int64 key/value (BigEndian, sortable)
 func NewKeyValFromInt(k, v int64) KeyVal {
-	var key [MAX_KEY_SIZE]uint8
-	var val [MAX_VAL_SIZE]uint8
-
-	var keyBuf [8]byte
-	var valBuf [8]byte
+	key := make([]byte, 8)
+	val := make([]byte, 8)
 
-	binary.BigEndian.PutUint64(keyBuf[:], uint64(k))
-	binary.BigEndian.PutUint64(valBuf[:], uint64(v))
-
-	RightAlignCopy(key[:], keyBuf[:])
-	RightAlignCopy(val[:], valBuf[:])
+	binary.BigEndian.PutUint64(key, uint64(k))
+	binary.BigEndian.PutUint64(val, uint64(v))
 
 	return KeyVal{
-		KeyLen: 8,
-		ValLen: 8,
-		Key:    key,
-		Val:    val,
+		Key: key,
+		Val: val,
 	}
 }
 
-// From raw bytes
+// From raw bytes. The slices are copied.
 func NewKeyValFromBytes(k, v []byte) KeyVal {
-	var key [MAX_KEY_SIZE]uint8
-	var val [MAX_VAL_SIZE]uint8
-
-	RightAlignCopy(key[:], k)
-	RightAlignCopy(val[:], v)
-
 	return KeyVal{
-		KeyLen: uint16(len(k)),
-		ValLen: uint16(len(v)),
-		Key:    key,
-		Val:    val,
+		Key: append([]byte{}, k...),
+		Val: append([]byte{}, v...),
 	}
 }
 
-// Layout:
-// [KeyLen][ValLen][Key bytes][Val bytes]
-func (kv *KeyVal) writeToBuffer(buf *bytes.Buffer) error {
-	if err := binary.Write(buf, binary.BigEndian, kv.KeyLen); err != nil {
-		return err
-	}
-	if err := binary.Write(buf, binary.BigEndian, kv.ValLen); err != nil {
-		return err
+// CellSize is the number of page bytes the pair takes in a leaf,
+// including its slot.
+// Layout: [KeyLen][ValLen][Key bytes][Val bytes]
+func (kv *KeyVal) CellSize() int {
+	if kv.HasOverflow() {
+		return SLOT_SIZE + 2 + 2 + len(kv.Key) + OVERFLOW_REF_SIZE
 	}
+	return SLOT_SIZE + 2 + 2 + len(kv.Key) + len(kv.Val)
+}
 
-	buf.Write(kv.Key[MAX_KEY_SIZE-int(kv.KeyLen):])
-	buf.Write(kv.Val[MAX_VAL_SIZE-int(kv.ValLen):])
-	return nil
+// HasOverflow reports whether the value lives in overflow pages
+func (kv *KeyVal) HasOverflow() bool {
+	return kv.Overflow != 0
 }
 
-func (kv *KeyVal) readFromBuffer(buf *bytes.Buffer) error {
-	if err := binary.Read(buf, binary.BigEndian, &kv.KeyLen); err != nil {
-		return err
+// Validate reports whether the pair can be stored in a leaf page of
+// blockSize bytes
+func (kv *KeyVal) Validate(blockSize int) error {
+	if len(kv.Key) > MAX_KEY_SIZE {
+		return ErrKeyTooLarge
 	}
-	if err := binary.Read(buf, binary.BigEndian, &kv.ValLen); err != nil {
-		return err
+	if kv.CellSize() > MaxLeafCellSize(blockSize) {
+		return ErrKeyValTooLarge
 	}
-
-	// zero old data
-	kv.Key = [MAX_KEY_SIZE]uint8{}
-	kv.Val = [MAX_VAL_SIZE]uint8{}
-
-	buf.Read(kv.Key[MAX_KEY_SIZE-int(kv.KeyLen):])
-	buf.Read(kv.Val[MAX_VAL_SIZE-int(kv.ValLen):])
 	return nil
 }
 
 // Lexicographical compare (BigEndian sortable)
 func (kv *KeyVal) Compare(other *KeyVal) int {
-	kSlice := kv.Key[MAX_KEY_SIZE-int(kv.KeyLen):]
-	oSlice := other.Key[MAX_KEY_SIZE-int(other.KeyLen):]
-	minLen := len(kSlice)
-	if len(oSlice) < minLen {
-		minLen = len(oSlice)
-	}
-	for i := 0; i < minLen; i++ {
-		if kSlice[i] < oSlice[i] {
-			return -1
-		} else if kSlice[i] > oSlice[i] {
-			return 1
-		}
-	}
-	if len(kSlice) < len(oSlice) {
-		return -1
-	} else if len(kSlice) > len(oSlice) {
-		return 1
-	}
-	return 0
+	return bytes.Compare(kv.Key, other.Key)
 }
@@ -3,14 +3,18 @@ package disk
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"sort"
 )
 
-const LEAF_MAX_KV = (BLOCK_SIZE - 16 - 2) / (2 + 2 + MAX_KEY_SIZE + MAX_VAL_SIZE)
+// header | nkv(2) | cell start(2)
+const LEAF_HEADER_SIZE = PAGE_HEADER_SIZE + 2 + 2
 
 type LeafPage struct {
-	Header PageHeader
-	NKV    uint16
-	KVs    [LEAF_MAX_KV]KeyVal
+	Header    PageHeader
+	KVs       []KeyVal
+	BlockSize int         // encoded page size; 0 means BLOCK_SIZE
+	Cmp       *Comparator // key order; nil means bytewise
 }
 
 func NewLeafPage() *LeafPage {
@@ -19,28 +23,72 @@ func NewLeafPage() *LeafPage {
 			PageType:        PageTypeLeaf,
 			NextPagePointer: 0,
 		},
-		NKV: 0,
 	}
 }
 
+func (p *LeafPage) blockSize() int {
+	return pageSize(p.BlockSize)
+}
+
+// Size returns the number of bytes the page occupies when encoded
+func (p *LeafPage) Size() int {
+	size := LEAF_HEADER_SIZE
+	for i := range p.KVs {
+		size += p.KVs[i].CellSize()
+	}
+	return size
+}
+
+// WriteToBuffer encodes the full page of BlockSize bytes
 func (p *LeafPage) WriteToBuffer(buf *bytes.Buffer) error {
+	if p.Size() > p.blockSize() {
+		return ErrPageOverflow
+	}
+
 	if err := p.Header.WriteToBuffer(buf); err != nil {
 		return err
 	}
 
-	if err := binary.Write(buf, binary.BigEndian, p.NKV); err != nil {
-		return err
+	body := make([]byte, p.blockSize()-PAGE_HEADER_SIZE)
+
+	// cells are packed at the end of the page in slot order
+	cellStart := p.blockSize()
+	for i := range p.KVs {
+		cellStart -= p.KVs[i].CellSize() - SLOT_SIZE
 	}
 
-	for i := 0; i < int(p.NKV); i++ {
-		if err := p.KVs[i].writeToBuffer(buf); err != nil {
-			return err
+	binary.BigEndian.PutUint16(body[0:], uint16(len(p.KVs)))
+	binary.BigEndian.PutUint16(body[2:], uint16(cellStart))
+
+	slot := LEAF_HEADER_SIZE
+	off := cellStart
+	for i := range p.KVs {
+		kv := &p.KVs[i]
+		binary.BigEndian.PutUint16(body[slot-PAGE_HEADER_SIZE:], uint16(off))
+
+		cell := body[off-PAGE_HEADER_SIZE:]
+		binary.BigEndian.PutUint16(cell[0:], uint16(len(kv.Key)))
+		copy(cell[4:], kv.Key)
+		if kv.HasOverflow() {
+			ref := cell[4+len(kv.Key):]
+			binary.BigEndian.PutUint16(cell[2:], OVERFLOW_REF_SIZE|overflowFlag)
+			binary.BigEndian.PutUint32(ref[0:], kv.ValSize)
+			binary.BigEndian.PutUint64(ref[4:], kv.Overflow)
+		} else {
+			binary.BigEndian.PutUint16(cell[2:], uint16(len(kv.Val)))
+			copy(cell[4+len(kv.Key):], kv.Val)
 		}
+
+		slot += SLOT_SIZE
+		off += kv.CellSize() - SLOT_SIZE
 	}
 
-	return nil
+	_, err := buf.Write(body)
+	return err
 }
 
+// ReadFromBuffer decodes a page. When readHeader is false the header has
+// already been consumed from buf. Keys and values are copied out.
 func (p *LeafPage) ReadFromBuffer(buf *bytes.Buffer, readHeader bool) error {
 	if readHeader {
 		if err := p.Header.ReadFromBuffer(buf); err != nil {
@@ -48,72 +96,90 @@ func (p *LeafPage) ReadFromBuffer(buf *bytes.Buffer, readHeader bool) error {
 		}
 	}
 
-	if err := binary.Read(buf, binary.BigEndian, &p.NKV); err != nil {
-		return err
+	body := buf.Bytes()
+	if len(body) < LEAF_HEADER_SIZE-PAGE_HEADER_SIZE {
+		return fmt.Errorf("leaf page: short buffer")
 	}
+	p.BlockSize = PAGE_HEADER_SIZE + len(body)
 
-	for i := 0; i < int(p.NKV); i++ {
-		if err := p.KVs[i].readFromBuffer(buf); err != nil {
-			return err
+	n := int(binary.BigEndian.Uint16(body[0:]))
+	p.KVs = make([]KeyVal, n)
+
+	slot := LEAF_HEADER_SIZE
+	for i := 0; i < n; i++ {
+		if slot+SLOT_SIZE-PAGE_HEADER_SIZE > len(body) {
+			return fmt.Errorf("leaf page: slot %d out of range", i)
+		}
+		off := int(binary.BigEndian.Uint16(body[slot-PAGE_HEADER_SIZE:])) - PAGE_HEADER_SIZE
+		if off < 0 || off+4 > len(body) {
+			return fmt.Errorf("leaf page: cell %d out of range", i)
+		}
+
+		klen := int(binary.BigEndian.Uint16(body[off:]))
+		vlen := int(binary.BigEndian.Uint16(body[off+2:]))
+		spilled := vlen&overflowFlag != 0
+		vlen &^= overflowFlag
+		if off+4+klen+vlen > len(body) || (spilled && vlen != OVERFLOW_REF_SIZE) {
+			return fmt.Errorf("leaf page: cell %d out of range", i)
+		}
+
+		cell := body[off+4:]
+		if spilled {
+			ref := cell[klen:]
+			p.KVs[i] = KeyVal{
+				Key:      append([]byte{}, cell[:klen]...),
+				ValSize:  binary.BigEndian.Uint32(ref[0:]),
+				Overflow: binary.BigEndian.Uint64(ref[4:]),
+			}
+		} else {
+			p.KVs[i] = NewKeyValFromBytes(cell[:klen], cell[klen:klen+vlen])
 		}
+		slot += SLOT_SIZE
 	}
 
+	buf.Next(len(body))
 	return nil
 }
 
 // Find last position so that the key <= find_key
 func (p *LeafPage) FindLastLE(kv *KeyVal) int {
-	pos := -1
-	for i := 0; i < int(p.NKV); i++ {
-		if p.KVs[i].Compare(kv) > 0 {
-			break
-		}
-		pos = i
-	}
-	return pos
+	return sort.Search(len(p.KVs), func(i int) bool {
+		return compareWith(p.Cmp, p.KVs[i].Key, kv.Key) > 0
+	}) - 1
 }
 
+// LowerBound returns the first position whose key >= key
 func (p *LeafPage) LowerBound(key *KeyEntry) int {
-	l, r := 0, int(p.NKV)
-	for l < r {
-		m := (l + r) / 2
-		entry := NewKeyEntryFromKeyVal(&p.KVs[m])
-		if entry.Compare(key) < 0 {
-			l = m + 1
-		} else {
-			r = m
-		}
-	}
-	return l
+	return sort.Search(len(p.KVs), func(i int) bool {
+		return compareWith(p.Cmp, p.KVs[i].Key, key.Key) >= 0
+	})
 }
 
-// Insert a key-children pair into the Leaf Node
-func (p *LeafPage) InsertKV(kv *KeyVal) bool {
-	if p.NKV >= LEAF_MAX_KV {
-		return false
-	}
+// Insert a key-value pair into the Leaf Node, keeping key order.
+// The page may overflow; callers split it afterwards.
+func (p *LeafPage) InsertKV(kv *KeyVal) {
 	pos := p.LowerBound(NewKeyEntryFromKeyVal(kv))
-
-	for i := int(p.NKV); i > pos; i-- {
-		p.KVs[i] = p.KVs[i-1]
-	}
+	p.KVs = append(p.KVs, KeyVal{})
+	copy(p.KVs[pos+1:], p.KVs[pos:])
 	p.KVs[pos] = *kv
-	p.NKV++
-	return true
 }
 
-// Split a node into 2 equal part
+// Split a node into 2 parts of roughly equal byte size
 func (p *LeafPage) Split() (*LeafPage, *KeyEntry) {
-	mid := int(p.NKV / 2)
+	sizes := make([]int, len(p.KVs))
+	for i := range p.KVs {
+		sizes[i] = p.KVs[i].CellSize()
+	}
+	mid := splitPoint(sizes)
+
 	newLeaf := NewLeafPage()
+	newLeaf.BlockSize = p.BlockSize
+	newLeaf.Cmp = p.Cmp
 	newLeaf.Header.NextPagePointer = p.Header.NextPagePointer
-	newLeaf.NKV = p.NKV - uint16(mid)
-	for i := 0; i < int(newLeaf.NKV); i++ {
-		newLeaf.KVs[i] = p.KVs[mid+i]
-		p.KVs[mid+i] = KeyVal{}
-	}
-	p.NKV = uint16(mid)
-	sep := NewKeyEntryFromKeyVal(&newLeaf.KVs[0])
+	newLeaf.KVs = append([]KeyVal{}, p.KVs[mid:]...)
+	p.KVs = p.KVs[:mid:mid]
+
+	sep := NewKeyEntryFromBytes(newLeaf.KVs[0].Key)
 	return newLeaf, sep
 }
 
@@ -122,33 +188,24 @@ func (p *LeafPage) IsLeaf() bool {
 }
 
 func (p *LeafPage) IsOverflow() bool {
-	return p.NKV > LEAF_MAX_KV
+	return p.Size() > p.blockSize()
 }
 
-func (p *LeafPage) DelKey(key *KeyEntry) bool {
-	pos := -1
-	for i := 0; i < int(p.NKV); i++ {
-		entry := NewKeyEntryFromKeyVal(&p.KVs[i])
-		if entry.Compare(key) == 0 {
-			pos = i
-			break
-		}
-	}
-	if pos == -1 {
-		return false
-	}
-	for i := pos; i < int(p.NKV)-1; i++ {
-		p.KVs[i] = p.KVs[i+1]
-	}
-	p.KVs[p.NKV-1] = KeyVal{}
-	p.NKV--
-	return true
+func (p *LeafPage) IsUnderflow() bool {
+	return p.Size() < MinFill(p.blockSize())
 }
 
-func (p *LeafPage) MinKeys() uint16 {
-	return LEAF_MAX_KV / 2
+// CanLend reports whether the entry at i can move to a sibling without
+// this page dropping below MinFill
+func (p *LeafPage) CanLend(i int) bool {
+	return len(p.KVs) > 1 && p.Size()-p.KVs[i].CellSize() >= MinFill(p.blockSize())
 }
 
-func (p *LeafPage) CanBorrow() bool {
-	return p.NKV > p.MinKeys()
+func (p *LeafPage) DelKey(key *KeyEntry) bool {
+	pos := p.LowerBound(key)
+	if pos == len(p.KVs) || compareWith(p.Cmp, p.KVs[pos].Key, key.Key) != 0 {
+		return false
+	}
+	p.KVs = append(p.KVs[:pos], p.KVs[pos+1:]...)
+	return true
 }
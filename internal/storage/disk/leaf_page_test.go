@@ -16,7 +16,7 @@ func kv(i int) *KeyVal {
 func TestNewLeafPage(t *testing.T) {
 	leaf := NewLeafPage()
 
-	assert.Equal(t, uint16(0), leaf.NKV)
+	assert.Equal(t, 0, len(leaf.KVs))
 	assert.Equal(t, PageTypeLeaf, int(leaf.Header.PageType))
 	assert.Equal(t, uint64(0), leaf.Header.NextPagePointer)
 }
@@ -28,7 +28,7 @@ func TestLeafPage_InsertKV_Ascending(t *testing.T) {
 	leaf.InsertKV(kv(3))
 	leaf.InsertKV(kv(5))
 
-	assert.Equal(t, uint16(3), leaf.NKV)
+	assert.Equal(t, 3, len(leaf.KVs))
 
 	assert.Equal(t, 0, leaf.KVs[0].Compare(kv(1)))
 	assert.Equal(t, 0, leaf.KVs[1].Compare(kv(3)))
@@ -42,7 +42,7 @@ func TestLeafPage_InsertKV_Middle(t *testing.T) {
 	leaf.InsertKV(kv(5))
 	leaf.InsertKV(kv(3))
 
-	assert.Equal(t, uint16(3), leaf.NKV)
+	assert.Equal(t, 3, len(leaf.KVs))
 
 	assert.Equal(t, 0, leaf.KVs[0].Compare(kv(1)))
 	assert.Equal(t, 0, leaf.KVs[1].Compare(kv(3)))
@@ -73,8 +73,8 @@ func TestLeafPage_Split(t *testing.T) {
 	right, promoteKey := leaf.Split()
 
 	// left: [1,2], right: [3,4]
-	assert.Equal(t, uint16(2), leaf.NKV)
-	assert.Equal(t, uint16(2), right.NKV)
+	assert.Equal(t, 2, len(leaf.KVs))
+	assert.Equal(t, 2, len(right.KVs))
 
 	assert.Equal(t, 0, leaf.KVs[0].Compare(kv(1)))
 	assert.Equal(t, 0, leaf.KVs[1].Compare(kv(2)))
@@ -100,7 +100,97 @@ func TestLeafPage_Serialization(t *testing.T) {
 	err = cloned.ReadFromBuffer(buf, true)
 	require.NoError(t, err)
 
-	assert.Equal(t, uint16(2), cloned.NKV)
+	assert.Equal(t, 2, len(cloned.KVs))
 	assert.Equal(t, 0, cloned.KVs[0].Compare(kv(10)))
 	assert.Equal(t, 0, cloned.KVs[1].Compare(kv(20)))
 }
+
+func TestLeafPage_VariableLength(t *testing.T) {
+	leaf := NewLeafPage()
+
+	big := NewKeyValFromBytes([]byte("big"), bytes.Repeat([]byte{7}, 500))
+	small := NewKeyValFromBytes([]byte("a"), []byte("x"))
+	leaf.InsertKV(&big)
+	leaf.InsertKV(&small)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, leaf.WriteToBuffer(buf))
+	assert.Equal(t, BLOCK_SIZE, buf.Len())
+
+	cloned := NewLeafPage()
+	require.NoError(t, cloned.ReadFromBuffer(buf, true))
+
+	require.Equal(t, 2, len(cloned.KVs))
+	assert.Equal(t, []byte("a"), cloned.KVs[0].Key)
+	assert.Equal(t, []byte("x"), cloned.KVs[0].Val)
+	assert.Equal(t, []byte("big"), cloned.KVs[1].Key)
+	assert.Equal(t, big.Val, cloned.KVs[1].Val)
+}
+
+func TestLeafPage_CapacityDependsOnBytes(t *testing.T) {
+	small := NewLeafPage()
+	for i := 0; !small.IsOverflow(); i++ {
+		small.InsertKV(kv(i))
+	}
+
+	large := NewLeafPage()
+	for i := 0; !large.IsOverflow(); i++ {
+		kv := NewKeyValFromBytes([]byte{byte(i)}, bytes.Repeat([]byte{1}, 200))
+		large.InsertKV(&kv)
+	}
+
+	assert.Greater(t, len(small.KVs), len(large.KVs))
+
+	// Splitting an overflowing page yields two pages that fit
+	right, sep := small.Split()
+	assert.False(t, small.IsOverflow())
+	assert.False(t, right.IsOverflow())
+	assert.Equal(t, right.KVs[0].Key, sep.Key)
+}
+
+func TestLeafPage_DelKey(t *testing.T) {
+	leaf := NewLeafPage()
+	leaf.InsertKV(kv(1))
+	leaf.InsertKV(kv(2))
+
+	assert.True(t, leaf.DelKey(NewKeyEntryFromKeyVal(kv(1))))
+	assert.False(t, leaf.DelKey(NewKeyEntryFromKeyVal(kv(1))))
+	assert.Equal(t, 1, len(leaf.KVs))
+}
+
+func TestKeyVal_Validate(t *testing.T) {
+	ok := NewKeyValFromBytes([]byte("k"), bytes.Repeat([]byte{1}, 100))
+	assert.NoError(t, ok.Validate(BLOCK_SIZE))
+
+	longKey := NewKeyValFromBytes(bytes.Repeat([]byte{1}, MAX_KEY_SIZE+1), nil)
+	assert.ErrorIs(t, longKey.Validate(BLOCK_SIZE), ErrKeyTooLarge)
+
+	longVal := NewKeyValFromBytes([]byte("k"), bytes.Repeat([]byte{1}, MaxLeafCellSize(BLOCK_SIZE)))
+	assert.ErrorIs(t, longVal.Validate(BLOCK_SIZE), ErrKeyValTooLarge)
+}
+
+func TestLeafPage_OverflowRef(t *testing.T) {
+	leaf := NewLeafPage()
+	inline := NewKeyValFromBytes([]byte("a"), []byte("small"))
+	spilled := KeyVal{Key: []byte("b"), Overflow: 42, ValSize: 100000}
+	leaf.InsertKV(&inline)
+	leaf.InsertKV(&spilled)
+
+	assert.Equal(t, SLOT_SIZE+4+1+OVERFLOW_REF_SIZE, spilled.CellSize())
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, leaf.WriteToBuffer(buf))
+
+	cloned := NewLeafPage()
+	require.NoError(t, cloned.ReadFromBuffer(buf, true))
+	require.Equal(t, 2, len(cloned.KVs))
+
+	assert.False(t, cloned.KVs[0].HasOverflow())
+	assert.Equal(t, []byte("small"), cloned.KVs[0].Val)
+
+	assert.True(t, cloned.KVs[1].HasOverflow())
+	assert.Equal(t, []byte("b"), cloned.KVs[1].Key)
+	assert.Equal(t, uint64(42), cloned.KVs[1].Overflow)
+	assert.Equal(t, uint32(100000), cloned.KVs[1].ValSize)
+	assert.Empty(t, cloned.KVs[1].Val)
+}
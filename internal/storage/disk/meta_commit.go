@@ -0,0 +1,237 @@
+package disk
+
+import (
+	"bytes"
+)
+
+// Files built on a MetaPage, the B+tree and the hash index, share how the
+// allocator state is stored: the meta page holds the high-water mark and
+// as many free IDs as fit, the rest go to a chain of FreeListPages.
+
+// pageWriter is a page that can be encoded
+type pageWriter interface {
+	WriteToBuffer(buf *bytes.Buffer) error
+}
+
+// WritePageTo encodes page into the frame of pid
+func WritePageTo(p *Pager, pid uint64, page pageWriter) error {
+	writer := bytes.NewBuffer(make([]byte, 0, p.BlockSize()))
+	if err := page.WriteToBuffer(writer); err != nil {
+		return err
+	}
+	return p.WritePage(pid, writer.Bytes())
+}
+
+// NewPageFrom allocates a page, encodes page into it and returns its ID
+func NewPageFrom(p *Pager, page pageWriter) (uint64, error) {
+	writer := bytes.NewBuffer(make([]byte, 0, p.BlockSize()))
+	if err := page.WriteToBuffer(writer); err != nil {
+		return 0, err
+	}
+
+	pid, _, err := p.NewPage()
+	if err != nil {
+		return 0, err
+	}
+	if err := p.WritePage(pid, writer.Bytes()); err != nil {
+		p.UnpinPage(pid, false)
+		return 0, err
+	}
+	return pid, p.UnpinPage(pid, true)
+}
+
+// LoadMeta reads the meta page at pid. A new file reads as a zero Magic.
+func LoadMeta(p *Pager, pid uint64) (*MetaPage, error) {
+	buf, err := p.FetchPage(pid)
+	if err != nil {
+		return nil, err
+	}
+	defer p.UnpinPage(pid, false)
+
+	meta := &MetaPage{}
+	if err := meta.ReadFromBuffer(bytes.NewBuffer(buf)); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// LoadAllocator restores the allocator of p from meta and the free-list
+// chain hanging off it
+func LoadAllocator(p *Pager, meta *MetaPage) error {
+	free := append([]uint64(nil), meta.FreeIDs...)
+	trunks := make([]uint64, 0)
+
+	for pid := meta.FreeListHead; pid != 0; {
+		buf, err := p.FetchPage(pid)
+		if err != nil {
+			return err
+		}
+
+		page := &FreeListPage{BlockSize: p.BlockSize()}
+		err = page.ReadFromBuffer(bytes.NewBuffer(buf))
+		p.UnpinPage(pid, false)
+		if err != nil {
+			return err
+		}
+
+		trunks = append(trunks, pid)
+		free = append(free, pid)
+		free = append(free, page.IDs...)
+		pid = page.Header.NextPagePointer
+	}
+
+	p.Allocator().Load(meta.NextBlockID, free, trunks)
+	return nil
+}
+
+// writeFreeList stores the free IDs that do not fit in the meta page in a
+// chain of FreeListPages. The chain lives in free blocks that are not part
+// of the chain currently on disk, so a crash before the meta page is
+// written leaves the old chain intact.
+func writeFreeList(p *Pager) (next uint64, inline []uint64, head uint64, trunks []uint64, err error) {
+	alloc := p.Allocator()
+	blockSize := p.BlockSize()
+
+	for {
+		next, free, _ := alloc.Snapshot()
+
+		maxInline := MetaMaxFreeIDs(blockSize)
+		perTrunk := FreeListMaxIDs(blockSize)
+
+		n := min(len(free), maxInline)
+		inline, rest := free[:n], free[n:]
+		if len(rest) == 0 {
+			return next, inline, 0, nil, nil
+		}
+
+		// Every trunk page accounts for itself plus the IDs it stores
+		k := (len(rest) + perTrunk) / (perTrunk + 1)
+
+		trunks = make([]uint64, 0, k)
+		others := make([]uint64, 0, len(rest))
+		for _, id := range rest {
+			if len(trunks) < k && !alloc.IsReserved(id) {
+				trunks = append(trunks, id)
+				continue
+			}
+			others = append(others, id)
+		}
+
+		// Not enough blocks outside the durable chain: grow the file
+		if len(trunks) < k {
+			alloc.Extend()
+			continue
+		}
+
+		for i, pid := range trunks {
+			page := NewFreeListPage()
+			page.BlockSize = blockSize
+			if i+1 < len(trunks) {
+				page.Header.NextPagePointer = trunks[i+1]
+			}
+
+			cnt := min(len(others), perTrunk)
+			page.IDs, others = others[:cnt], others[cnt:]
+
+			if err := WritePageTo(p, pid, page); err != nil {
+				return 0, nil, 0, nil, err
+			}
+		}
+
+		return next, inline, trunks[0], trunks, nil
+	}
+}
+
+// CommitMeta makes every page written so far durable. Data and free-list
+// pages are synced first; the meta page at pid, which holds the allocator
+// state along with whatever the file keeps there, is written last so the
+// two always agree on disk.
+func CommitMeta(p *Pager, pid uint64, meta *MetaPage) error {
+	next, inline, head, trunks, err := writeFreeList(p)
+	if err != nil {
+		return err
+	}
+
+	if err := p.Sync(); err != nil {
+		return err
+	}
+
+	meta.NextBlockID = next
+	meta.FreeIDs = inline
+	meta.FreeListHead = head
+	if err := WritePageTo(p, pid, meta); err != nil {
+		return err
+	}
+
+	if err := p.Sync(); err != nil {
+		return err
+	}
+
+	p.Allocator().Reserve(trunks)
+	return nil
+}
+
+// MetaCheckpoint is the state of a file captured by BeginCheckpoint,
+// ready to be written while the cache keeps changing
+type MetaCheckpoint struct {
+	p      *Pager
+	pages  []PageImage
+	meta   []PageImage
+	trunks []uint64
+}
+
+// BeginCheckpoint captures every dirty page along with the free list and
+// the meta page at pid, stamped with checkpoint LSN lsn. Nothing may
+// change pages while it runs; the file is not touched until Write.
+func BeginCheckpoint(p *Pager, pid uint64, meta *MetaPage, lsn uint64) (*MetaCheckpoint, error) {
+	next, inline, head, trunks, err := writeFreeList(p)
+	if err != nil {
+		return nil, err
+	}
+
+	meta.NextBlockID = next
+	meta.FreeIDs = inline
+	meta.FreeListHead = head
+	meta.CheckpointLSN = lsn
+	if err := WritePageTo(p, pid, meta); err != nil {
+		return nil, err
+	}
+
+	c := &MetaCheckpoint{p: p, trunks: trunks}
+	for _, img := range p.SnapshotDirty() {
+		if img.PageID == pid {
+			c.meta = append(c.meta, img)
+		} else {
+			c.pages = append(c.pages, img)
+		}
+	}
+	return c, nil
+}
+
+// Write makes the checkpoint durable: every image goes to the
+// double-write file first, then the pages are synced in place and the meta
+// page last, as in CommitMeta. A crash at any point leaves the file at this
+// checkpoint or the one before once RecoverDoubleWrite has run.
+func (c *MetaCheckpoint) Write() error {
+	if err := c.p.writeDoubleWrite(append(append([]PageImage{}, c.pages...), c.meta...)); err != nil {
+		return err
+	}
+	if err := c.p.WriteImages(c.pages); err != nil {
+		return err
+	}
+	if err := c.p.WriteImages(c.meta); err != nil {
+		return err
+	}
+	if err := c.p.removeDoubleWrite(); err != nil {
+		return err
+	}
+	c.p.Allocator().Reserve(c.trunks)
+	return nil
+}
+
+// Abort marks the captured pages dirty again after Write failed or was
+// never called, so that a later checkpoint writes them
+func (c *MetaCheckpoint) Abort() {
+	c.p.AbortImages(c.pages)
+	c.p.AbortImages(c.meta)
+}
@@ -3,43 +3,103 @@ package disk
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 )
 
 const (
 	PageTypeMeta     = 0
 	PageTypeInternal = 1
 	PageTypeLeaf     = 2
+	PageTypeFreeList = 3
+	PageTypeOverflow = 4
+	PageTypeHashDir  = 5
+	PageTypeBucket   = 6
 )
 
 const META_MAGIC uint32 = 0xDBDBDBDB
 
+// Fixed part: header | file header | root(8) | next(8) | free head(8) |
+// checkpoint LSN(8) | nfree(2)
+const META_FIXED_SIZE = PAGE_HEADER_SIZE + FILE_HEADER_SIZE + 8 + 8 + 8 + 8 + 2
+
+// MetaMaxFreeIDs is the number of free block IDs the meta page stores inline
+func MetaMaxFreeIDs(blockSize int) int {
+	return (blockSize - META_FIXED_SIZE) / 8
+}
+
+// MetaPage is page 0 of a tree file. It holds the file header, the root
+// pointer and the allocator state together, so a single page write keeps
+// them consistent.
 type MetaPage struct {
-	Header  PageHeader
-	Magic  uint32
-	RootPID uint64
+	Header       PageHeader
+	File         FileHeader
+	RootPID      uint64
+	NextBlockID  uint64   // allocator high-water mark
+	FreeListHead uint64   // first FreeListPage, 0 = none
+	FreeIDs      []uint64 // free block IDs stored inline
+
+	// CheckpointLSN is where redo starts for a file whose writes are
+	// logged: every change before it is in the file
+	CheckpointLSN uint64
 }
 
-func NewMetaPage() *MetaPage {
+func NewMetaPage(blockSize int) *MetaPage {
 	return &MetaPage{
 		Header: PageHeader{
 			PageType:        PageTypeMeta,
 			NextPagePointer: 0,
 		},
-		Magic:  META_MAGIC,
-		RootPID: 0,
+		File:        NewFileHeader(blockSize),
+		RootPID:     0,
+		NextBlockID: 1,
 	}
 }
 
 func (p *MetaPage) WriteToBuffer(buf *bytes.Buffer) error {
+	maxFree := MetaMaxFreeIDs(pageSize(int(p.File.BlockSize)))
+	if len(p.FreeIDs) > maxFree {
+		return fmt.Errorf("meta page: %d inline free ids exceed %d", len(p.FreeIDs), maxFree)
+	}
+
 	if err := p.Header.WriteToBuffer(buf); err != nil {
 		return err
 	}
-	return binary.Write(buf, binary.BigEndian, p.RootPID)
+
+	if err := p.File.WriteToBuffer(buf); err != nil {
+		return err
+	}
+
+	fields := []any{p.RootPID, p.NextBlockID, p.FreeListHead, p.CheckpointLSN, uint16(len(p.FreeIDs))}
+	for _, f := range fields {
+		if err := binary.Write(buf, binary.BigEndian, f); err != nil {
+			return err
+		}
+	}
+
+	return binary.Write(buf, binary.BigEndian, p.FreeIDs)
 }
 
 func (p *MetaPage) ReadFromBuffer(buf *bytes.Buffer) error {
 	if err := p.Header.ReadFromBuffer(buf); err != nil {
 		return err
 	}
-	return binary.Read(buf, binary.BigEndian, &p.RootPID)
+
+	if err := p.File.ReadFromBuffer(buf); err != nil {
+		return err
+	}
+
+	var nfree uint16
+	fields := []any{&p.RootPID, &p.NextBlockID, &p.FreeListHead, &p.CheckpointLSN, &nfree}
+	for _, f := range fields {
+		if err := binary.Read(buf, binary.BigEndian, f); err != nil {
+			return err
+		}
+	}
+
+	if maxFree := MetaMaxFreeIDs(pageSize(int(p.File.BlockSize))); int(nfree) > maxFree {
+		return fmt.Errorf("meta page: %d inline free ids exceed %d", nfree, maxFree)
+	}
+
+	p.FreeIDs = make([]uint64, nfree)
+	return binary.Read(buf, binary.BigEndian, p.FreeIDs)
 }
@@ -0,0 +1,97 @@
+package disk
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetaPage_Serialization(t *testing.T) {
+	meta := NewMetaPage(BLOCK_SIZE)
+	meta.RootPID = 7
+	meta.NextBlockID = 42
+	meta.FreeListHead = 9
+	meta.FreeIDs = []uint64{3, 5, 11}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, meta.WriteToBuffer(buf))
+	assert.LessOrEqual(t, buf.Len(), BLOCK_SIZE)
+
+	cloned := &MetaPage{}
+	require.NoError(t, cloned.ReadFromBuffer(buf))
+
+	assert.Equal(t, NewFileHeader(BLOCK_SIZE), cloned.File)
+	assert.Equal(t, uint64(7), cloned.RootPID)
+	assert.Equal(t, uint64(42), cloned.NextBlockID)
+	assert.Equal(t, uint64(9), cloned.FreeListHead)
+	assert.Equal(t, []uint64{3, 5, 11}, cloned.FreeIDs)
+}
+
+func TestMetaPage_FullInlineFreeListFitsInBlock(t *testing.T) {
+	meta := NewMetaPage(BLOCK_SIZE)
+	meta.FreeIDs = make([]uint64, MetaMaxFreeIDs(BLOCK_SIZE))
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, meta.WriteToBuffer(buf))
+	assert.LessOrEqual(t, buf.Len(), BLOCK_SIZE)
+
+	meta.FreeIDs = make([]uint64, MetaMaxFreeIDs(BLOCK_SIZE)+1)
+	assert.Error(t, meta.WriteToBuffer(new(bytes.Buffer)))
+}
+
+func TestMetaPage_LargeBlockSize(t *testing.T) {
+	meta := NewMetaPage(MAX_BLOCK_SIZE)
+	meta.FreeIDs = make([]uint64, MetaMaxFreeIDs(MAX_BLOCK_SIZE))
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, meta.WriteToBuffer(buf))
+	assert.LessOrEqual(t, buf.Len(), MAX_BLOCK_SIZE)
+	assert.Greater(t, len(meta.FreeIDs), MetaMaxFreeIDs(BLOCK_SIZE))
+
+	cloned := &MetaPage{}
+	require.NoError(t, cloned.ReadFromBuffer(buf))
+	assert.Equal(t, uint32(MAX_BLOCK_SIZE), cloned.File.BlockSize)
+	assert.Equal(t, len(meta.FreeIDs), len(cloned.FreeIDs))
+}
+
+func TestFreeListPage_Serialization(t *testing.T) {
+	page := NewFreeListPage()
+	page.Header.NextPagePointer = 12
+	page.IDs = make([]uint64, FreeListMaxIDs(BLOCK_SIZE))
+	for i := range page.IDs {
+		page.IDs[i] = uint64(i + 100)
+	}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, page.WriteToBuffer(buf))
+	assert.LessOrEqual(t, buf.Len(), BLOCK_SIZE)
+
+	cloned := &FreeListPage{}
+	require.NoError(t, cloned.ReadFromBuffer(buf))
+	assert.Equal(t, uint64(12), cloned.Header.NextPagePointer)
+	assert.Equal(t, page.IDs, cloned.IDs)
+
+	// Any other page type is rejected
+	leaf := new(bytes.Buffer)
+	require.NoError(t, NewLeafPage().WriteToBuffer(leaf))
+	assert.Error(t, (&FreeListPage{}).ReadFromBuffer(leaf))
+}
+
+func TestOverflowPage_Serialization(t *testing.T) {
+	data := bytes.Repeat([]byte{7}, OverflowPageCapacity(BLOCK_SIZE))
+	page := NewOverflowPage(data, 9)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, page.WriteToBuffer(buf))
+	assert.LessOrEqual(t, buf.Len(), BLOCK_SIZE)
+
+	var cloned OverflowPage
+	require.NoError(t, cloned.ReadFromBuffer(buf))
+	assert.Equal(t, uint64(9), cloned.Header.NextPagePointer)
+	assert.Equal(t, data, cloned.Data)
+
+	tooLarge := NewOverflowPage(append(data, 1), 0)
+	assert.Error(t, tooLarge.WriteToBuffer(new(bytes.Buffer)))
+}
@@ -0,0 +1,81 @@
+package disk
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// WriteOverflow stores value in a chain of overflow pages and returns the
+// first page ID. Pages are written back to front so each knows its successor.
+func WriteOverflow(p *Pager, value []byte) (uint64, error) {
+	capacity := OverflowPageCapacity(p.BlockSize())
+	n := (len(value) + capacity - 1) / capacity
+
+	next := uint64(0)
+	for i := n - 1; i >= 0; i-- {
+		start := i * capacity
+		end := min(start+capacity, len(value))
+
+		page := NewOverflowPage(value[start:end], next)
+		page.BlockSize = p.BlockSize()
+		pid, err := NewPageFrom(p, page)
+		if err != nil {
+			FreeOverflow(p, next)
+			return 0, err
+		}
+		next = pid
+	}
+	return next, nil
+}
+
+// ReadOverflow reassembles the size bytes stored in the chain at head
+func ReadOverflow(p *Pager, head uint64, size uint32) ([]byte, error) {
+	val := make([]byte, 0, size)
+
+	pid := head
+	for pid != 0 {
+		page, err := LoadOverflow(p, pid)
+		if err != nil {
+			return nil, err
+		}
+		val = append(val, page.Data...)
+		pid = page.Header.NextPagePointer
+
+		if len(val) > int(size) {
+			break
+		}
+	}
+
+	if len(val) != int(size) {
+		return nil, fmt.Errorf("overflow chain at page %d: got %d bytes, want %d", head, len(val), size)
+	}
+	return val, nil
+}
+
+// FreeOverflow returns every page of the chain starting at pid to the allocator
+func FreeOverflow(p *Pager, pid uint64) error {
+	for pid != 0 {
+		page, err := LoadOverflow(p, pid)
+		if err != nil {
+			return err
+		}
+		p.FreePage(pid)
+		pid = page.Header.NextPagePointer
+	}
+	return nil
+}
+
+// LoadOverflow reads the overflow page at pid
+func LoadOverflow(p *Pager, pid uint64) (*OverflowPage, error) {
+	buf, err := p.FetchPage(pid)
+	if err != nil {
+		return nil, err
+	}
+	defer p.UnpinPage(pid, false)
+
+	page := &OverflowPage{BlockSize: p.BlockSize()}
+	if err := page.ReadFromBuffer(bytes.NewBuffer(buf)); err != nil {
+		return nil, fmt.Errorf("page %d: %w", pid, err)
+	}
+	return page, nil
+}
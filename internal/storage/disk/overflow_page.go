@@ -0,0 +1,74 @@
+package disk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// header | len(2) | data...
+const OVERFLOW_HEADER_SIZE = PAGE_HEADER_SIZE + 2
+
+// OverflowPageCapacity is the number of value bytes one overflow page holds
+func OverflowPageCapacity(blockSize int) int {
+	return blockSize - OVERFLOW_HEADER_SIZE
+}
+
+// OverflowPage holds one chunk of a value too large to store in its leaf.
+// Header.NextPagePointer links the next chunk; 0 ends the chain.
+type OverflowPage struct {
+	Header    PageHeader
+	Data      []byte
+	BlockSize int // encoded page size; 0 means BLOCK_SIZE
+}
+
+func NewOverflowPage(data []byte, next uint64) *OverflowPage {
+	return &OverflowPage{
+		Header: PageHeader{
+			PageType:        PageTypeOverflow,
+			NextPagePointer: next,
+		},
+		Data: data,
+	}
+}
+
+func (p *OverflowPage) WriteToBuffer(buf *bytes.Buffer) error {
+	capacity := OverflowPageCapacity(pageSize(p.BlockSize))
+	if len(p.Data) > capacity {
+		return fmt.Errorf("overflow page: %d bytes exceed %d", len(p.Data), capacity)
+	}
+
+	if err := p.Header.WriteToBuffer(buf); err != nil {
+		return err
+	}
+
+	if err := binary.Write(buf, binary.BigEndian, uint16(len(p.Data))); err != nil {
+		return err
+	}
+
+	_, err := buf.Write(p.Data)
+	return err
+}
+
+// ReadFromBuffer decodes an overflow page. Data is copied out.
+func (p *OverflowPage) ReadFromBuffer(buf *bytes.Buffer) error {
+	if err := p.Header.ReadFromBuffer(buf); err != nil {
+		return err
+	}
+
+	if p.Header.PageType != PageTypeOverflow {
+		return fmt.Errorf("overflow page: unexpected page type %d", p.Header.PageType)
+	}
+
+	var n uint16
+	if err := binary.Read(buf, binary.BigEndian, &n); err != nil {
+		return err
+	}
+
+	if int(n) > OverflowPageCapacity(pageSize(p.BlockSize)) || int(n) > buf.Len() {
+		return fmt.Errorf("overflow page: length %d out of range", n)
+	}
+
+	p.Data = append([]byte{}, buf.Next(int(n))...)
+	return nil
+}
@@ -0,0 +1,278 @@
+package disk
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// Page views read leaf and internal pages straight from their encoded
+// bytes, usually a pinned pager frame, without decoding the whole page.
+// Keys and values returned by a view alias the buffer: they are only valid
+// while the page stays pinned and unchanged, and must be copied to be kept.
+// Views trust the page layout; pages read from disk have had their
+// checksum verified by the pager.
+
+// PageTypeOf returns the type byte of an encoded page
+func PageTypeOf(buf []byte) uint8 {
+	return buf[0]
+}
+
+// NextPageOf returns the NextPagePointer of an encoded page
+func NextPageOf(buf []byte) uint64 {
+	return binary.BigEndian.Uint64(buf[1:])
+}
+
+// PageLSNOf returns the page LSN of an encoded page
+func PageLSNOf(buf []byte) uint64 {
+	return binary.BigEndian.Uint64(buf[PAGE_LSN_OFFSET:])
+}
+
+// SetPageLSN stores lsn as the page LSN of an encoded page
+func SetPageLSN(buf []byte, lsn uint64) {
+	binary.BigEndian.PutUint64(buf[PAGE_LSN_OFFSET:], lsn)
+}
+
+// LeafView accesses an encoded leaf page in place
+type LeafView struct {
+	buf []byte
+	cmp *Comparator
+}
+
+// NewLeafView reads buf with keys ordered by cmp; nil means bytewise
+func NewLeafView(buf []byte, cmp *Comparator) LeafView {
+	return LeafView{buf: buf, cmp: cmp}
+}
+
+// NKeys returns the number of entries
+func (v LeafView) NKeys() int {
+	return int(binary.BigEndian.Uint16(v.buf[PAGE_HEADER_SIZE:]))
+}
+
+func (v LeafView) setNKeys(n int) {
+	binary.BigEndian.PutUint16(v.buf[PAGE_HEADER_SIZE:], uint16(n))
+}
+
+func (v LeafView) cellStart() int {
+	return int(binary.BigEndian.Uint16(v.buf[PAGE_HEADER_SIZE+2:]))
+}
+
+func (v LeafView) setCellStart(off int) {
+	binary.BigEndian.PutUint16(v.buf[PAGE_HEADER_SIZE+2:], uint16(off))
+}
+
+// Next returns the page ID of the next leaf
+func (v LeafView) Next() uint64 {
+	return NextPageOf(v.buf)
+}
+
+// LSN returns the page LSN
+func (v LeafView) LSN() uint64 {
+	return PageLSNOf(v.buf)
+}
+
+// SetNext changes the page ID of the next leaf
+func (v LeafView) SetNext(pid uint64) {
+	binary.BigEndian.PutUint64(v.buf[1:], pid)
+}
+
+func (v LeafView) slot(i int) int {
+	return LEAF_HEADER_SIZE + i*SLOT_SIZE
+}
+
+func (v LeafView) cellOffset(i int) int {
+	return int(binary.BigEndian.Uint16(v.buf[v.slot(i):]))
+}
+
+// cellLen is the size of the cell at off, without its slot
+func (v LeafView) cellLen(off int) int {
+	klen := int(binary.BigEndian.Uint16(v.buf[off:]))
+	vlen := int(binary.BigEndian.Uint16(v.buf[off+2:])) &^ overflowFlag
+	return 4 + klen + vlen
+}
+
+// Key returns the key of entry i
+func (v LeafView) Key(i int) []byte {
+	off := v.cellOffset(i)
+	klen := int(binary.BigEndian.Uint16(v.buf[off:]))
+	return v.buf[off+4 : off+4+klen]
+}
+
+// Cell returns entry i. Key and Val alias the page.
+func (v LeafView) Cell(i int) KeyVal {
+	off := v.cellOffset(i)
+	klen := int(binary.BigEndian.Uint16(v.buf[off:]))
+	vlen := int(binary.BigEndian.Uint16(v.buf[off+2:]))
+	cell := v.buf[off+4:]
+
+	if vlen&overflowFlag != 0 {
+		ref := cell[klen:]
+		return KeyVal{
+			Key:      cell[:klen:klen],
+			ValSize:  binary.BigEndian.Uint32(ref[0:]),
+			Overflow: binary.BigEndian.Uint64(ref[4:]),
+		}
+	}
+	return KeyVal{Key: cell[:klen:klen], Val: cell[klen : klen+vlen : klen+vlen]}
+}
+
+// LowerBound returns the first position whose key >= key
+func (v LeafView) LowerBound(key []byte) int {
+	return sort.Search(v.NKeys(), func(i int) bool {
+		return compareWith(v.cmp, v.Key(i), key) >= 0
+	})
+}
+
+// FindLastLE returns the last position whose key <= key, or -1
+func (v LeafView) FindLastLE(key []byte) int {
+	return sort.Search(v.NKeys(), func(i int) bool {
+		return compareWith(v.cmp, v.Key(i), key) > 0
+	}) - 1
+}
+
+// Size returns the bytes the entries use, as LeafPage.Size does. Space
+// left behind by deleted cells is not counted.
+func (v LeafView) Size() int {
+	size := LEAF_HEADER_SIZE
+	for i := 0; i < v.NKeys(); i++ {
+		size += SLOT_SIZE + v.cellLen(v.cellOffset(i))
+	}
+	return size
+}
+
+// Decode copies the page into a LeafPage
+func (v LeafView) Decode() *LeafPage {
+	page := NewLeafPage()
+	page.BlockSize = len(v.buf)
+	page.Cmp = v.cmp
+	page.Header.NextPagePointer = v.Next()
+	page.KVs = make([]KeyVal, v.NKeys())
+	for i := range page.KVs {
+		cell := v.Cell(i)
+		if cell.HasOverflow() {
+			cell.Key = append([]byte{}, cell.Key...)
+			page.KVs[i] = cell
+		} else {
+			page.KVs[i] = NewKeyValFromBytes(cell.Key, cell.Val)
+		}
+	}
+	return page
+}
+
+// Insert puts kv at position i and reports whether it fit. The page is
+// compacted first when deleted cells left the free space fragmented.
+// Nothing is changed when the entry does not fit.
+func (v LeafView) Insert(i int, kv *KeyVal) bool {
+	if v.Size()+kv.CellSize() > len(v.buf) {
+		return false
+	}
+
+	n := v.NKeys()
+	size := kv.CellSize() - SLOT_SIZE
+	if v.cellStart()-v.slot(n+1) < size {
+		v.compact()
+	}
+
+	off := v.cellStart() - size
+	cell := v.buf[off:]
+	binary.BigEndian.PutUint16(cell[0:], uint16(len(kv.Key)))
+	copy(cell[4:], kv.Key)
+	if kv.HasOverflow() {
+		ref := cell[4+len(kv.Key):]
+		binary.BigEndian.PutUint16(cell[2:], OVERFLOW_REF_SIZE|overflowFlag)
+		binary.BigEndian.PutUint32(ref[0:], kv.ValSize)
+		binary.BigEndian.PutUint64(ref[4:], kv.Overflow)
+	} else {
+		binary.BigEndian.PutUint16(cell[2:], uint16(len(kv.Val)))
+		copy(cell[4+len(kv.Key):], kv.Val)
+	}
+
+	copy(v.buf[v.slot(i+1):v.slot(n+1)], v.buf[v.slot(i):v.slot(n)])
+	binary.BigEndian.PutUint16(v.buf[v.slot(i):], uint16(off))
+	v.setCellStart(off)
+	v.setNKeys(n + 1)
+	return true
+}
+
+// Delete removes entry i. Its cell becomes free space, reclaimed by the
+// next compaction.
+func (v LeafView) Delete(i int) {
+	n := v.NKeys()
+	if off := v.cellOffset(i); off == v.cellStart() {
+		v.setCellStart(off + v.cellLen(off))
+	}
+	copy(v.buf[v.slot(i):], v.buf[v.slot(i+1):v.slot(n)])
+	v.setNKeys(n - 1)
+}
+
+// compact packs the cells at the end of the page in slot order
+func (v LeafView) compact() {
+	n := v.NKeys()
+	cells := make([]byte, 0, len(v.buf)-v.cellStart())
+	offs := make([]int, n)
+	for i := 0; i < n; i++ {
+		off := v.cellOffset(i)
+		offs[i] = len(cells)
+		cells = append(cells, v.buf[off:off+v.cellLen(off)]...)
+	}
+
+	start := len(v.buf) - len(cells)
+	copy(v.buf[start:], cells)
+	for i := 0; i < n; i++ {
+		binary.BigEndian.PutUint16(v.buf[v.slot(i):], uint16(start+offs[i]))
+	}
+	v.setCellStart(start)
+}
+
+// InternalView accesses an encoded internal page in place
+type InternalView struct {
+	buf []byte
+	cmp *Comparator
+}
+
+// NewInternalView reads buf with keys ordered by cmp; nil means bytewise
+func NewInternalView(buf []byte, cmp *Comparator) InternalView {
+	return InternalView{buf: buf, cmp: cmp}
+}
+
+// NKeys returns the number of separator keys
+func (v InternalView) NKeys() int {
+	return int(binary.BigEndian.Uint16(v.buf[PAGE_HEADER_SIZE:]))
+}
+
+func (v InternalView) cellOffset(i int) int {
+	return int(binary.BigEndian.Uint16(v.buf[INTERNAL_HEADER_SIZE+i*SLOT_SIZE:]))
+}
+
+// Key returns separator i
+func (v InternalView) Key(i int) []byte {
+	off := v.cellOffset(i)
+	klen := int(binary.BigEndian.Uint16(v.buf[off+8:]))
+	return v.buf[off+10 : off+10+klen : off+10+klen]
+}
+
+// Child returns the page ID of child i; separator i lies between child i
+// and child i+1
+func (v InternalView) Child(i int) uint64 {
+	if i == 0 {
+		return binary.BigEndian.Uint64(v.buf[PAGE_HEADER_SIZE+4:])
+	}
+	return binary.BigEndian.Uint64(v.buf[v.cellOffset(i-1):])
+}
+
+// FindLastLE returns the last separator <= key, or -1; the key belongs to
+// child FindLastLE(key)+1
+func (v InternalView) FindLastLE(key []byte) int {
+	return sort.Search(v.NKeys(), func(i int) bool {
+		return compareWith(v.cmp, v.Key(i), key) > 0
+	}) - 1
+}
+
+// Size returns the bytes the separators use, as InternalPage.Size does
+func (v InternalView) Size() int {
+	size := INTERNAL_HEADER_SIZE
+	for i := 0; i < v.NKeys(); i++ {
+		off := v.cellOffset(i)
+		size += SLOT_SIZE + 10 + int(binary.BigEndian.Uint16(v.buf[off+8:]))
+	}
+	return size
+}
@@ -0,0 +1,127 @@
+package disk
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encode returns the full encoded page
+func encode(t *testing.T, page Page) []byte {
+	buf := new(bytes.Buffer)
+	require.NoError(t, page.WriteToBuffer(buf))
+	return buf.Bytes()
+}
+
+// decodeLeaf decodes buf the way the tree does when it loads a page
+func decodeLeaf(t *testing.T, buf []byte) *LeafPage {
+	page := &LeafPage{}
+	require.NoError(t, page.ReadFromBuffer(bytes.NewBuffer(append([]byte{}, buf...)), true))
+	return page
+}
+
+func viewKey(i int) []byte {
+	return []byte(fmt.Sprintf("key-%03d", i))
+}
+
+func TestLeafView_Read(t *testing.T) {
+	leaf := NewLeafPage()
+	leaf.BlockSize = BLOCK_SIZE
+	leaf.Header.NextPagePointer = 42
+	for i := 0; i < 20; i += 2 {
+		leaf.InsertKV(&KeyVal{Key: viewKey(i), Val: bytes.Repeat([]byte{byte(i)}, i)})
+	}
+	leaf.InsertKV(&KeyVal{Key: viewKey(99), Overflow: 7, ValSize: 100000})
+
+	v := NewLeafView(encode(t, leaf), nil)
+	assert.Equal(t, len(leaf.KVs), v.NKeys())
+	assert.Equal(t, uint64(42), v.Next())
+	assert.Equal(t, leaf.Size(), v.Size())
+	assert.Equal(t, leaf, v.Decode())
+
+	for i := range leaf.KVs {
+		assert.Equal(t, leaf.KVs[i].Key, v.Key(i))
+		cell := v.Cell(i)
+		assert.Equal(t, leaf.KVs[i].Overflow, cell.Overflow)
+		if !cell.HasOverflow() {
+			assert.Equal(t, leaf.KVs[i].Val, cell.Val)
+		}
+	}
+	assert.Equal(t, uint32(100000), v.Cell(10).ValSize)
+
+	assert.Equal(t, 0, v.LowerBound(nil))
+	assert.Equal(t, 1, v.LowerBound(viewKey(1)))
+	assert.Equal(t, 1, v.LowerBound(viewKey(2)))
+	assert.Equal(t, 0, v.FindLastLE(viewKey(1)))
+	assert.Equal(t, 1, v.FindLastLE(viewKey(2)))
+	assert.Equal(t, -1, v.FindLastLE([]byte("a")))
+	assert.Equal(t, 11, v.LowerBound([]byte("z")))
+}
+
+func TestLeafView_InsertDelete(t *testing.T) {
+	buf := encode(t, NewLeafPage())
+	v := NewLeafView(buf, nil)
+	want := NewLeafPage()
+
+	// Fill the page in place, in a scrambled order
+	val := bytes.Repeat([]byte{1}, 100)
+	for i := 0; ; i = (i + 7) % 101 {
+		kv := KeyVal{Key: viewKey(i), Val: val}
+		if !v.Insert(v.LowerBound(kv.Key), &kv) {
+			break
+		}
+		want.InsertKV(&kv)
+	}
+	assert.Equal(t, want.KVs, decodeLeaf(t, buf).KVs)
+	assert.True(t, want.Size()+SLOT_SIZE+4+len(viewKey(0))+len(val) > BLOCK_SIZE)
+
+	// Holes left by deletes are reused after a compaction
+	for i := v.NKeys() - 1; i >= 0; i -= 3 {
+		v.Delete(i)
+		want.KVs = append(want.KVs[:i], want.KVs[i+1:]...)
+	}
+	assert.Equal(t, want.Size(), v.Size())
+
+	big := KeyVal{Key: []byte("big"), Val: bytes.Repeat([]byte{2}, 500)}
+	require.True(t, v.Insert(v.LowerBound(big.Key), &big))
+	want.InsertKV(&big)
+
+	got := decodeLeaf(t, buf)
+	assert.Equal(t, want.KVs, got.KVs)
+	assert.Equal(t, want.Size(), v.Size())
+
+	// The page still encodes the same way once decoded
+	assert.Equal(t, want.KVs, decodeLeaf(t, encode(t, got)).KVs)
+}
+
+func TestLeafView_SetNext(t *testing.T) {
+	buf := encode(t, NewLeafPage())
+	NewLeafView(buf, nil).SetNext(9)
+	assert.Equal(t, uint64(9), decodeLeaf(t, buf).Header.NextPagePointer)
+	assert.Equal(t, uint8(PageTypeLeaf), PageTypeOf(buf))
+}
+
+func TestInternalView(t *testing.T) {
+	internal := NewInternalPage()
+	internal.Children = []uint64{100}
+	for i := 1; i <= 50; i++ {
+		internal.InsertKV(NewKeyEntryFromBytes(viewKey(i*2)), uint64(100+i))
+	}
+
+	v := NewInternalView(encode(t, internal), nil)
+	require.Equal(t, internal.NKeys(), v.NKeys())
+	assert.Equal(t, internal.Size(), v.Size())
+	for i := range internal.Keys {
+		assert.Equal(t, internal.Keys[i].Key, v.Key(i))
+	}
+	for i, child := range internal.Children {
+		assert.Equal(t, child, v.Child(i))
+	}
+
+	for _, key := range [][]byte{nil, viewKey(1), viewKey(2), viewKey(51), viewKey(100), []byte("z")} {
+		assert.Equal(t, internal.FindLastLE(&KeyEntry{Key: key}), v.FindLastLE(key), "%s", key)
+	}
+}
@@ -2,79 +2,596 @@ package disk
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"os"
+	"sync"
 )
 
-// Pager manages page-level I/O and caching
+// DEFAULT_CACHE_PAGES is the number of frames a Pager keeps when the caller
+// does not choose a capacity.
+const DEFAULT_CACHE_PAGES = 256
+
+var (
+	ErrNoFreeFrame     = errors.New("buffer pool: all frames are pinned")
+	ErrNoCleanFrame    = errors.New("buffer pool: no clean frame to evict under no-steal")
+	ErrPageNotCached   = errors.New("page not in cache")
+	ErrPageNotPinned   = errors.New("page is not pinned")
+	ErrInvalidCapacity = errors.New("buffer pool capacity must be positive")
+	ErrPageFreed       = errors.New("page has been freed")
+)
+
+// PagerOptions configures a Pager
+type PagerOptions struct {
+	// Capacity is the number of cached pages; 0 means DEFAULT_CACHE_PAGES
+	Capacity int
+	// BlockSize is the page size of the file; 0 means BLOCK_SIZE
+	BlockSize int
+	// NoSteal keeps dirty pages in the cache until they are flushed on
+	// purpose: eviction only takes clean frames. Files whose changes are
+	// logged use it so the file changes only at checkpoints.
+	NoSteal bool
+}
+
+// PageImage is a copy of a page taken by SnapshotDirty
+type PageImage struct {
+	PageID uint64
+	Buf    []byte
+}
+
+// PagerStats counts cache activity since the pager was created
+type PagerStats struct {
+	Hits    uint64 `json:"hits"`    // FetchPage served from a frame
+	Misses  uint64 `json:"misses"`  // FetchPage read from disk
+	Flushes uint64 `json:"flushes"` // pages written back to disk
+}
+
+// frame is one slot of the buffer pool
+type frame struct {
+	pageID   uint64
+	buf      []byte
+	pinCount int
+	dirty    bool
+	ref      bool // CLOCK reference bit
+	used     bool
+	writing  bool // copied by SnapshotDirty, copy not yet on disk
+	freed    bool // freed while pinned; released at the last unpin
+}
+
+// Pager manages page-level I/O and caching.
+//
+// Pages live in a fixed number of frames. A page returned by NewPage or
+// FetchPage is pinned and will not be evicted until every pin is released
+// with UnpinPage. When a frame is needed, unpinned frames are chosen with
+// the CLOCK policy and dirty victims are written back before reuse.
+//
+// The Pager is safe for concurrent use. Callers that share pages between
+// goroutines modify them through WritePage or UpdatePage, which change the
+// frame under the pager lock, and coordinate readers and writers of a page with their own latches.
 type Pager struct {
+	mu        sync.Mutex
 	file      *os.File
 	allocator *FileAllocator
-	cache     map[uint64][]byte // pageID -> page buffer
+	frames    []frame
+	table     map[uint64]int // pageID -> frame index
+	hand      int            // CLOCK hand
+	blockSize int
+	scratch   []byte // checksummed copy of the frame being written
+	stats     PagerStats
+	noSteal   bool
+	lsn       uint64 // stamped on pages as they are dirtied
+
+	// beforeWrite runs before each page WriteImages writes; tests use it
+	// to fail a checkpoint part way
+	beforeWrite func() error
 }
 
-// NewPager creates a pager bound to a file
+// NewPager creates a pager bound to a file with DEFAULT_CACHE_PAGES frames
 func NewPager(file *os.File, allocator *FileAllocator) *Pager {
+	p, _ := NewPagerWithCapacity(file, allocator, DEFAULT_CACHE_PAGES)
+	return p
+}
+
+// NewPagerWithCapacity creates a pager that caches at most capacity pages
+func NewPagerWithCapacity(file *os.File, allocator *FileAllocator, capacity int) (*Pager, error) {
+	if capacity <= 0 {
+		return nil, ErrInvalidCapacity
+	}
+	return NewPagerWithOptions(file, allocator, PagerOptions{Capacity: capacity})
+}
+
+// NewPagerWithOptions creates a pager with a chosen cache capacity and page size
+func NewPagerWithOptions(file *os.File, allocator *FileAllocator, opts PagerOptions) (*Pager, error) {
+	capacity := opts.Capacity
+	if capacity == 0 {
+		capacity = DEFAULT_CACHE_PAGES
+	}
+	if capacity < 0 {
+		return nil, ErrInvalidCapacity
+	}
+
+	blockSize := pageSize(opts.BlockSize)
+	if err := ValidBlockSize(blockSize); err != nil {
+		return nil, err
+	}
+
 	return &Pager{
 		file:      file,
 		allocator: allocator,
-		cache:     make(map[uint64][]byte),
-	}
+		frames:    make([]frame, capacity),
+		table:     make(map[uint64]int, capacity),
+		blockSize: blockSize,
+		scratch:   make([]byte, blockSize),
+		noSteal:   opts.NoSteal,
+	}, nil
 }
 
-// NewPage allocates a new page and returns its ID and buffer
-func (p *Pager) NewPage() (pageID uint64, buf []byte) {
-	pageID = p.allocator.Allocate()
-	buf = make([]byte, BLOCK_SIZE)
+// Capacity returns the maximum number of cached pages
+func (p *Pager) Capacity() int {
+	return len(p.frames)
+}
 
-	p.cache[pageID] = buf
-	return pageID, buf
+// BlockSize returns the size of every page in the file
+func (p *Pager) BlockSize() int {
+	return p.blockSize
 }
 
-// FetchPage retrieves a page buffer by its ID
+// NewPage allocates a new page and returns its ID and a zeroed, pinned buffer.
+// The page is considered dirty until it is flushed.
+func (p *Pager) NewPage() (pageID uint64, buf []byte, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	idx, err := p.victim()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	pageID = p.allocator.Allocate()
+	f := p.install(idx, pageID)
+	clear(f.buf)
+	p.markDirty(f)
+	return pageID, f.buf, nil
+}
+
+// FetchPage retrieves and pins a page buffer by its ID.
+// Pages read from disk are checked against their checksum; a mismatch
+// returns *ErrPageCorrupt.
 func (p *Pager) FetchPage(pageID uint64) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.fetch(pageID)
+	if err != nil {
+		return nil, err
+	}
+	f.pinCount++
+	return f.buf, nil
+}
+
+// fetch returns the frame holding pageID, reading the page from disk on a
+// cache miss. The frame is not pinned.
+func (p *Pager) fetch(pageID uint64) (*frame, error) {
 	// Cache hit
-	if buf, ok := p.cache[pageID]; ok {
-		return buf, nil
+	if idx, ok := p.table[pageID]; ok {
+		f := &p.frames[idx]
+		if f.freed {
+			return nil, fmt.Errorf("%w: %d", ErrPageFreed, pageID)
+		}
+		f.ref = true
+		p.stats.Hits++
+		return f, nil
 	}
 
 	// Cache miss → read from disk
-	buf := make([]byte, BLOCK_SIZE)
-	offset := int64(BlockOffset(pageID))
+	p.stats.Misses++
+	idx, err := p.victim()
+	if err != nil {
+		return nil, err
+	}
+
+	f := &p.frames[idx]
+	if f.buf == nil {
+		f.buf = make([]byte, p.blockSize)
+	}
+	clear(f.buf)
+
+	offset := int64(BlockOffset(pageID, p.blockSize))
+	if _, err := p.file.ReadAt(f.buf, offset); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
 
-	_, err := p.file.ReadAt(buf, offset)
-	if err != nil && !errors.Is(err, io.EOF) {
+	if err := VerifyPageChecksum(pageID, f.buf); err != nil {
 		return nil, err
 	}
 
-	p.cache[pageID] = buf
-	return buf, nil
+	p.install(idx, pageID).pinCount = 0
+	return f, nil
+}
+
+// WritePage replaces the content of a page with data and marks it dirty.
+// A page that is not cached gets a frame without being read from disk.
+// The copy is made under the pager lock, so it never races with a flush.
+func (p *Pager) WritePage(pageID uint64, data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	idx, ok := p.table[pageID]
+	if !ok {
+		var err error
+		if idx, err = p.victim(); err != nil {
+			return err
+		}
+		p.install(idx, pageID).pinCount = 0
+	}
+
+	f := &p.frames[idx]
+	if f.freed {
+		return fmt.Errorf("%w: %d", ErrPageFreed, pageID)
+	}
+	clear(f.buf[copy(f.buf, data):])
+	p.markDirty(f)
+	f.ref = true
+	return nil
+}
+
+// UpdatePage changes a page in place: fn runs on the cached buffer under
+// the pager lock, reading the page first if it is not cached, and the page
+// is marked dirty when fn reports that it changed it. Holding the lock
+// keeps flushes from writing a half-changed page; fn should be short.
+func (p *Pager) UpdatePage(pageID uint64, fn func(buf []byte) bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := p.fetch(pageID)
+	if err != nil {
+		return err
+	}
+	if fn(f.buf) {
+		p.markDirty(f)
+	}
+	return nil
 }
 
-// FlushPage writes a page buffer back to disk
-// Not removing from cache for simplicity. Dirty handling can be added later.
+// UnpinPage releases one pin on a page. dirty records that the caller
+// modified the buffer and it must be written back before eviction. The
+// last pin on a freed page drops it and releases its ID.
+func (p *Pager) UnpinPage(pageID uint64, dirty bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	idx, ok := p.table[pageID]
+	if !ok {
+		return ErrPageNotCached
+	}
+
+	f := &p.frames[idx]
+	if f.pinCount == 0 {
+		return fmt.Errorf("%w: %d", ErrPageNotPinned, pageID)
+	}
+	f.pinCount--
+	if f.freed {
+		if f.pinCount == 0 {
+			p.release(idx)
+		}
+		return nil
+	}
+	if dirty {
+		p.markDirty(f)
+	}
+	return nil
+}
+
+// MarkDirty flags a cached page as modified without touching its pin count
+func (p *Pager) MarkDirty(pageID uint64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	idx, ok := p.table[pageID]
+	if !ok {
+		return ErrPageNotCached
+	}
+	if p.frames[idx].freed {
+		return fmt.Errorf("%w: %d", ErrPageFreed, pageID)
+	}
+	p.markDirty(&p.frames[idx])
+	return nil
+}
+
+// markDirty flags a frame as modified and stamps it with the current LSN
+func (p *Pager) markDirty(f *frame) {
+	f.dirty = true
+	SetPageLSN(f.buf, p.lsn)
+}
+
+// SetLSN sets the LSN stamped on pages dirtied from now on. The caller
+// sets it to the log record of the change it is about to make.
+func (p *Pager) SetLSN(lsn uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lsn = lsn
+}
+
+// DirtyPages returns the number of cached pages not yet written back
+func (p *Pager) DirtyPages() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := 0
+	for i := range p.frames {
+		if p.frames[i].used && p.frames[i].dirty {
+			n++
+		}
+	}
+	return n
+}
+
+// SnapshotDirty copies every dirty page and marks them clean. The copies
+// can be written with WriteImages while the cache keeps changing; until
+// then the frames are not evicted, as the file still has older versions.
+// The caller keeps pages from changing while the snapshot is taken.
+func (p *Pager) SnapshotDirty() []PageImage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var images []PageImage
+	for i := range p.frames {
+		f := &p.frames[i]
+		if f.used && f.dirty {
+			images = append(images, PageImage{PageID: f.pageID, Buf: append([]byte{}, f.buf...)})
+			f.dirty = false
+			f.writing = true
+		}
+	}
+	return images
+}
+
+// WriteImages writes checksummed page copies to the file and fsyncs it.
+// Their frames can be evicted again once it succeeds.
+func (p *Pager) WriteImages(images []PageImage) error {
+	for _, img := range images {
+		if p.beforeWrite != nil {
+			if err := p.beforeWrite(); err != nil {
+				return err
+			}
+		}
+		SetPageChecksum(img.Buf)
+		if _, err := p.file.WriteAt(img.Buf, int64(BlockOffset(img.PageID, p.blockSize))); err != nil {
+			return err
+		}
+	}
+	if err := p.file.Sync(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, img := range images {
+		if idx, ok := p.table[img.PageID]; ok {
+			p.frames[idx].writing = false
+		}
+	}
+	p.stats.Flushes += uint64(len(images))
+	return nil
+}
+
+// AbortImages gives up on writing images taken by SnapshotDirty: their
+// frames are dirty again, so the next snapshot copies them anew
+func (p *Pager) AbortImages(images []PageImage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, img := range images {
+		if idx, ok := p.table[img.PageID]; ok {
+			p.frames[idx].writing = false
+			p.frames[idx].dirty = true
+		}
+	}
+}
+
+// FlushPage stamps the page checksum, writes the buffer back to disk and
+// clears its dirty flag
 func (p *Pager) FlushPage(pageID uint64) error {
-	buf, ok := p.cache[pageID]
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	idx, ok := p.table[pageID]
 	if !ok {
-		return errors.New("page not in cache")
+		return ErrPageNotCached
 	}
+	return p.writeFrame(&p.frames[idx])
+}
+
+// FlushAll writes every dirty page back to disk
+func (p *Pager) FlushAll() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	offset := int64(BlockOffset(pageID))
-	_, err := p.file.WriteAt(buf, offset)
-	return err
+	return p.flushAllLocked()
 }
 
+// Sync writes every dirty page back and fsyncs the file. The fsync runs
+// without the pager lock so other goroutines keep using the cache.
 func (p *Pager) Sync() error {
+	p.mu.Lock()
+	err := p.flushAllLocked()
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
 	return p.file.Sync()
 }
 
-// FreePage releases a page ID and removes it from cache
+// FreePage releases a page ID and removes it from cache.
+// Any pending changes to the page are discarded. A pinned page stays in
+// its frame for the goroutines holding it, but can no longer be fetched or
+// written; its ID is released when the last pin is.
 func (p *Pager) FreePage(pageID uint64) {
-	delete(p.cache, pageID)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	idx, ok := p.table[pageID]
+	if !ok {
+		p.allocator.Free(pageID)
+		return
+	}
+	f := &p.frames[idx]
+	if f.freed {
+		return
+	}
+	if f.pinCount > 0 {
+		f.freed = true
+		f.dirty = false
+		return
+	}
+	p.release(idx)
+}
+
+// release empties a frame and frees the ID of its page
+func (p *Pager) release(idx int) {
+	pageID := p.frames[idx].pageID
+	p.frames[idx] = frame{buf: p.frames[idx].buf}
+	delete(p.table, pageID)
 	p.allocator.Free(pageID)
 }
 
-// Close closes the underlying file
+// Stats returns the cache counters
+func (p *Pager) Stats() PagerStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.stats
+}
+
+// FileSize returns the size of the file on disk. Pages allocated since the
+// last flush are not counted until they are written.
+func (p *Pager) FileSize() (int64, error) {
+	info, err := p.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Allocator returns the block allocator backing this pager
+func (p *Pager) Allocator() *FileAllocator {
+	return p.allocator
+}
+
+// Discard drops every cached page without writing it back, so the file
+// keeps what was last written to it
+func (p *Pager) Discard() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.discardLocked()
+}
+
+func (p *Pager) discardLocked() {
+	for i := range p.frames {
+		p.frames[i] = frame{buf: p.frames[i].buf}
+	}
+	clear(p.table)
+}
+
+// Close flushes dirty pages and closes the underlying file. A NoSteal
+// pager drops them instead: only checkpoints write its pages.
 func (p *Pager) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.noSteal {
+		p.discardLocked()
+		return p.file.Close()
+	}
+	if err := p.flushAllLocked(); err != nil {
+		p.file.Close()
+		return err
+	}
 	return p.file.Close()
 }
+
+func (p *Pager) flushAllLocked() error {
+	for i := range p.frames {
+		f := &p.frames[i]
+		if f.used && f.dirty {
+			if err := p.writeFrame(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeFrame writes a checksummed copy of the frame; the frame itself is
+// left untouched for goroutines reading it
+func (p *Pager) writeFrame(f *frame) error {
+	copy(p.scratch, f.buf)
+	SetPageChecksum(p.scratch)
+
+	offset := int64(BlockOffset(f.pageID, p.blockSize))
+	if _, err := p.file.WriteAt(p.scratch, offset); err != nil {
+		return err
+	}
+	f.dirty = false
+	p.stats.Flushes++
+	return nil
+}
+
+// install binds a free frame to pageID and pins it once
+func (p *Pager) install(idx int, pageID uint64) *frame {
+	f := &p.frames[idx]
+	if f.buf == nil {
+		f.buf = make([]byte, p.blockSize)
+	}
+	f.pageID = pageID
+	f.pinCount = 1
+	f.dirty = false
+	f.ref = true
+	f.used = true
+	p.table[pageID] = idx
+	return f
+}
+
+// victim picks a frame to (re)use with the CLOCK policy.
+// Dirty victims are written back before the frame is handed out. When
+// every unpinned frame is kept only because it is not on disk yet, it
+// returns ErrNoCleanFrame: a checkpoint frees them.
+func (p *Pager) victim() (int, error) {
+	n := len(p.frames)
+	unwritten := false
+
+	// Two sweeps: the first may only clear reference bits
+	for i := 0; i < 2*n; i++ {
+		idx := p.hand
+		p.hand = (p.hand + 1) % n
+
+		f := &p.frames[idx]
+		if !f.used {
+			return idx, nil
+		}
+		if f.pinCount > 0 {
+			continue
+		}
+		if f.ref {
+			f.ref = false
+			continue
+		}
+		if f.writing || (f.dirty && p.noSteal) {
+			unwritten = true
+			continue
+		}
+
+		if f.dirty {
+			if err := p.writeFrame(f); err != nil {
+				return 0, err
+			}
+		}
+		delete(p.table, f.pageID)
+		f.used = false
+		return idx, nil
+	}
+
+	if unwritten {
+		return 0, ErrNoCleanFrame
+	}
+	return 0, ErrNoFreeFrame
+}
@@ -0,0 +1,382 @@
+package disk
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupPager(t *testing.T, capacity int) *Pager {
+	file, err := os.OpenFile(filepath.Join(t.TempDir(), "pager.db"), os.O_RDWR|os.O_CREATE, 0666)
+	require.NoError(t, err)
+
+	pager, err := NewPagerWithCapacity(file, NewFileAllocator(), capacity)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		pager.Close()
+	})
+	return pager
+}
+
+func TestPager_InvalidCapacity(t *testing.T) {
+	_, err := NewPagerWithCapacity(nil, NewFileAllocator(), 0)
+	assert.ErrorIs(t, err, ErrInvalidCapacity)
+}
+
+func TestPager_EvictionWritesBackDirtyPages(t *testing.T) {
+	pager := setupPager(t, 2)
+
+	// Fill more pages than the pool can hold
+	pids := make([]uint64, 0)
+	for i := 0; i < 5; i++ {
+		pid, buf, err := pager.NewPage()
+		require.NoError(t, err)
+		buf[0] = byte(i + 1)
+		require.NoError(t, pager.UnpinPage(pid, true))
+		pids = append(pids, pid)
+	}
+
+	assert.LessOrEqual(t, len(pager.table), pager.Capacity())
+
+	// Evicted pages must come back from disk unchanged
+	for i, pid := range pids {
+		buf, err := pager.FetchPage(pid)
+		require.NoError(t, err)
+		assert.Equal(t, byte(i+1), buf[0])
+		require.NoError(t, pager.UnpinPage(pid, false))
+	}
+}
+
+func TestPager_PinnedPagesAreNotEvicted(t *testing.T) {
+	pager := setupPager(t, 2)
+
+	pid1, buf1, err := pager.NewPage()
+	require.NoError(t, err)
+	pid2, _, err := pager.NewPage()
+	require.NoError(t, err)
+
+	// Every frame is pinned
+	_, _, err = pager.NewPage()
+	assert.ErrorIs(t, err, ErrNoFreeFrame)
+
+	// Releasing one pin frees a frame; the pinned page stays resident
+	require.NoError(t, pager.UnpinPage(pid2, false))
+	pid3, _, err := pager.NewPage()
+	require.NoError(t, err)
+
+	buf1[0] = 42
+	again, err := pager.FetchPage(pid1)
+	require.NoError(t, err)
+	assert.Equal(t, byte(42), again[0])
+
+	require.NoError(t, pager.UnpinPage(pid1, true))
+	require.NoError(t, pager.UnpinPage(pid1, false))
+	require.NoError(t, pager.UnpinPage(pid3, false))
+}
+
+func TestPager_UnpinErrors(t *testing.T) {
+	pager := setupPager(t, 2)
+
+	assert.ErrorIs(t, pager.UnpinPage(7, false), ErrPageNotCached)
+
+	pid, _, err := pager.NewPage()
+	require.NoError(t, err)
+	require.NoError(t, pager.UnpinPage(pid, false))
+	assert.ErrorIs(t, pager.UnpinPage(pid, false), ErrPageNotPinned)
+}
+
+func TestPager_FreePinnedPage(t *testing.T) {
+	pager := setupPager(t, 4)
+
+	pid, buf, err := pager.NewPage()
+	require.NoError(t, err)
+	buf[PAGE_HEADER_SIZE] = 'x'
+	_, err = pager.FetchPage(pid)
+	require.NoError(t, err)
+
+	// The holders keep their buffer, but the page is gone for everyone else
+	pager.FreePage(pid)
+	assert.Equal(t, byte('x'), buf[PAGE_HEADER_SIZE])
+	_, err = pager.FetchPage(pid)
+	assert.ErrorIs(t, err, ErrPageFreed)
+	assert.ErrorIs(t, pager.WritePage(pid, fillPage('y')), ErrPageFreed)
+	assert.ErrorIs(t, pager.MarkDirty(pid), ErrPageFreed)
+	assert.Zero(t, pager.DirtyPages())
+
+	// Its ID is only handed out again once the last pin is released
+	other, _, err := pager.NewPage()
+	require.NoError(t, err)
+	assert.NotEqual(t, pid, other)
+	require.NoError(t, pager.UnpinPage(other, false))
+
+	require.NoError(t, pager.UnpinPage(pid, true))
+	require.NoError(t, pager.UnpinPage(pid, false))
+	assert.ErrorIs(t, pager.UnpinPage(pid, false), ErrPageNotCached)
+	reused, _, err := pager.NewPage()
+	require.NoError(t, err)
+	assert.Equal(t, pid, reused)
+}
+
+func TestPager_SyncPersistsDirtyPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pager.db")
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	require.NoError(t, err)
+
+	pager, err := NewPagerWithCapacity(file, NewFileAllocator(), 4)
+	require.NoError(t, err)
+
+	pid, buf, err := pager.NewPage()
+	require.NoError(t, err)
+	buf[PAGE_HEADER_SIZE] = 0xAB
+	require.NoError(t, pager.UnpinPage(pid, true))
+	require.NoError(t, pager.Sync())
+	require.NoError(t, pager.Close())
+
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0xAB), raw[BlockOffset(pid, BLOCK_SIZE)+PAGE_HEADER_SIZE])
+}
+
+func TestPager_FetchDetectsCorruptPage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pager.db")
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	require.NoError(t, err)
+
+	pager, err := NewPagerWithCapacity(file, NewFileAllocator(), 4)
+	require.NoError(t, err)
+
+	pid, buf, err := pager.NewPage()
+	require.NoError(t, err)
+	buf[PAGE_HEADER_SIZE] = 0xAB
+	require.NoError(t, pager.UnpinPage(pid, true))
+	require.NoError(t, pager.Close())
+
+	// Flip one bit on disk
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	raw[BlockOffset(pid, BLOCK_SIZE)+100] ^= 1
+	require.NoError(t, os.WriteFile(path, raw, 0666))
+
+	file, err = os.OpenFile(path, os.O_RDWR, 0666)
+	require.NoError(t, err)
+	pager, err = NewPagerWithCapacity(file, NewFileAllocator(), 4)
+	require.NoError(t, err)
+	defer pager.Close()
+
+	_, err = pager.FetchPage(pid)
+	var corrupt *ErrPageCorrupt
+	require.ErrorAs(t, err, &corrupt)
+	assert.Equal(t, pid, corrupt.PageID)
+
+	// Pages past the end of the file read as zeroes and are accepted
+	_, err = pager.FetchPage(pid + 10)
+	require.NoError(t, err)
+	require.NoError(t, pager.UnpinPage(pid+10, false))
+}
+
+func TestPager_WritePage(t *testing.T) {
+	pager := setupPager(t, 2)
+
+	pid, _, err := pager.NewPage()
+	require.NoError(t, err)
+	require.NoError(t, pager.UnpinPage(pid, true))
+
+	data := make([]byte, BLOCK_SIZE)
+	data[PAGE_HEADER_SIZE] = 42
+	require.NoError(t, pager.WritePage(pid, data))
+
+	// Push the page out of the pool and read it back from disk
+	for i := 0; i < 3; i++ {
+		other, _, err := pager.NewPage()
+		require.NoError(t, err)
+		require.NoError(t, pager.UnpinPage(other, true))
+	}
+	buf, err := pager.FetchPage(pid)
+	require.NoError(t, err)
+	assert.Equal(t, byte(42), buf[PAGE_HEADER_SIZE])
+	require.NoError(t, pager.UnpinPage(pid, false))
+
+	// Shorter data clears the rest of the page
+	require.NoError(t, pager.WritePage(pid, data[:PAGE_HEADER_SIZE]))
+	buf, err = pager.FetchPage(pid)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0), buf[PAGE_HEADER_SIZE])
+	require.NoError(t, pager.UnpinPage(pid, false))
+}
+
+func TestPager_Stats(t *testing.T) {
+	pager := setupPager(t, 2)
+
+	pids := make([]uint64, 0)
+	for i := 0; i < 3; i++ {
+		pid, _, err := pager.NewPage()
+		require.NoError(t, err)
+		require.NoError(t, pager.UnpinPage(pid, true))
+		pids = append(pids, pid)
+	}
+	// The third page pushed the first one out
+	assert.Equal(t, PagerStats{Flushes: 1}, pager.Stats())
+
+	_, err := pager.FetchPage(pids[2])
+	require.NoError(t, err)
+	require.NoError(t, pager.UnpinPage(pids[2], false))
+	_, err = pager.FetchPage(pids[0])
+	require.NoError(t, err)
+	require.NoError(t, pager.UnpinPage(pids[0], false))
+
+	stats := pager.Stats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(2), stats.Flushes)
+
+	require.NoError(t, pager.FlushAll())
+	assert.Equal(t, uint64(3), pager.Stats().Flushes)
+
+	size, err := pager.FileSize()
+	require.NoError(t, err)
+	assert.Equal(t, int64(BlockOffset(pids[2], pager.BlockSize())+uint64(pager.BlockSize())), size)
+}
+
+func TestPager_UpdatePage(t *testing.T) {
+	pager := setupPager(t, 2)
+
+	pid, buf, err := pager.NewPage()
+	require.NoError(t, err)
+	buf[PAGE_HEADER_SIZE] = 1
+	require.NoError(t, pager.UnpinPage(pid, true))
+	require.NoError(t, pager.FlushAll())
+
+	// An unchanged page stays clean
+	require.NoError(t, pager.UpdatePage(pid, func(buf []byte) bool { return false }))
+	assert.False(t, pager.frames[pager.table[pid]].dirty)
+
+	require.NoError(t, pager.UpdatePage(pid, func(buf []byte) bool {
+		buf[PAGE_HEADER_SIZE]++
+		return true
+	}))
+	assert.True(t, pager.frames[pager.table[pid]].dirty)
+
+	// Push the page out; an update of an uncached page reads it first
+	for i := 0; i < 2; i++ {
+		other, _, err := pager.NewPage()
+		require.NoError(t, err)
+		require.NoError(t, pager.UnpinPage(other, true))
+	}
+	require.NoError(t, pager.UpdatePage(pid, func(buf []byte) bool {
+		assert.Equal(t, byte(2), buf[PAGE_HEADER_SIZE])
+		return false
+	}))
+}
+
+func TestPager_NoSteal(t *testing.T) {
+	file, err := os.OpenFile(filepath.Join(t.TempDir(), "pager.db"), os.O_RDWR|os.O_CREATE, 0666)
+	require.NoError(t, err)
+	pager, err := NewPagerWithOptions(file, NewFileAllocator(), PagerOptions{Capacity: 2, NoSteal: true})
+	require.NoError(t, err)
+	defer pager.Close()
+
+	pager.SetLSN(7)
+	var pids []uint64
+	for i := 0; i < 2; i++ {
+		pid, buf, err := pager.NewPage()
+		require.NoError(t, err)
+		buf[PAGE_HEADER_SIZE] = byte(i + 1)
+		require.NoError(t, pager.UnpinPage(pid, true))
+		pids = append(pids, pid)
+	}
+	assert.Equal(t, 2, pager.DirtyPages())
+
+	// Dirty pages are never evicted
+	_, _, err = pager.NewPage()
+	assert.ErrorIs(t, err, ErrNoCleanFrame)
+
+	images := pager.SnapshotDirty()
+	require.Len(t, images, 2)
+	assert.Equal(t, uint64(7), PageLSNOf(images[0].Buf))
+	assert.Equal(t, 0, pager.DirtyPages())
+
+	// Nor are pages whose copy is not on disk yet
+	_, _, err = pager.NewPage()
+	assert.ErrorIs(t, err, ErrNoCleanFrame)
+
+	require.NoError(t, pager.WriteImages(images))
+	pid, _, err := pager.NewPage()
+	require.NoError(t, err)
+	require.NoError(t, pager.UnpinPage(pid, false))
+
+	for i, pid := range pids {
+		buf, err := pager.FetchPage(pid)
+		require.NoError(t, err)
+		assert.Equal(t, byte(i+1), buf[PAGE_HEADER_SIZE])
+		require.NoError(t, pager.UnpinPage(pid, false))
+	}
+}
+
+func TestPager_AbortImages(t *testing.T) {
+	file, err := os.OpenFile(filepath.Join(t.TempDir(), "pager.db"), os.O_RDWR|os.O_CREATE, 0666)
+	require.NoError(t, err)
+	pager, err := NewPagerWithOptions(file, NewFileAllocator(), PagerOptions{Capacity: 2, NoSteal: true})
+	require.NoError(t, err)
+	defer pager.Close()
+
+	pid, buf, err := pager.NewPage()
+	require.NoError(t, err)
+	buf[PAGE_HEADER_SIZE] = 1
+	require.NoError(t, pager.UnpinPage(pid, true))
+
+	// An aborted snapshot leaves the page dirty, and the next one takes it
+	images := pager.SnapshotDirty()
+	require.Len(t, images, 1)
+	assert.Zero(t, pager.DirtyPages())
+	pager.AbortImages(images)
+	assert.Equal(t, 1, pager.DirtyPages())
+
+	images = pager.SnapshotDirty()
+	require.Len(t, images, 1)
+	require.NoError(t, pager.WriteImages(images))
+	for i := 0; i < 2; i++ {
+		pid, _, err := pager.NewPage()
+		require.NoError(t, err)
+		require.NoError(t, pager.UnpinPage(pid, false))
+	}
+}
+
+func TestPager_NoStealCloseDropsDirtyPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pager.db")
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	require.NoError(t, err)
+	pager, err := NewPagerWithOptions(file, NewFileAllocator(), PagerOptions{Capacity: 4, NoSteal: true})
+	require.NoError(t, err)
+
+	pid, buf, err := pager.NewPage()
+	require.NoError(t, err)
+	buf[PAGE_HEADER_SIZE] = 1
+	require.NoError(t, pager.UnpinPage(pid, true))
+	require.NoError(t, pager.WriteImages(pager.SnapshotDirty()))
+
+	// Discard drops the change, and so does Close
+	require.NoError(t, pager.UpdatePage(pid, func(buf []byte) bool { buf[PAGE_HEADER_SIZE] = 2; return true }))
+	pager.Discard()
+	assert.Zero(t, pager.DirtyPages())
+	buf, err = pager.FetchPage(pid)
+	require.NoError(t, err)
+	assert.Equal(t, byte(1), buf[PAGE_HEADER_SIZE])
+	require.NoError(t, pager.UnpinPage(pid, false))
+
+	require.NoError(t, pager.UpdatePage(pid, func(buf []byte) bool { buf[PAGE_HEADER_SIZE] = 3; return true }))
+	require.NoError(t, pager.Close())
+
+	file, err = os.OpenFile(path, os.O_RDWR, 0666)
+	require.NoError(t, err)
+	pager, err = NewPagerWithOptions(file, NewFileAllocator(), PagerOptions{Capacity: 4})
+	require.NoError(t, err)
+	defer pager.Close()
+	buf, err = pager.FetchPage(pid)
+	require.NoError(t, err)
+	assert.Equal(t, byte(1), buf[PAGE_HEADER_SIZE])
+	require.NoError(t, pager.UnpinPage(pid, false))
+}
@@ -0,0 +1,44 @@
+package disk
+
+import "errors"
+
+// Leaf and internal pages use a slotted layout:
+//
+//	[ header | count | cell start | (child0) | slot 0 | slot 1 | ... | free | cells ]
+//
+// Each slot is the page offset of its cell. Offsets are 16 bits, which
+// covers every page size up to MAX_BLOCK_SIZE. Slots are kept in key order;
+// cells are packed at the end of the page. Capacity is measured in bytes,
+// so a page holds as many entries as fit.
+const SLOT_SIZE = 2
+
+// MinFill is the occupancy below which a non-root page is rebalanced
+func MinFill(blockSize int) int {
+	return blockSize / 4
+}
+
+// MaxLeafCellSize keeps every cell under a quarter of the page, so
+// splitting an overflowing page always yields two pages that fit.
+func MaxLeafCellSize(blockSize int) int {
+	return (blockSize - LEAF_HEADER_SIZE) / 4
+}
+
+var ErrPageOverflow = errors.New("page content exceeds block size")
+
+// splitPoint returns the index of the first cell that moves to the right
+// half, so that the left half holds about half of the cell bytes.
+// Both halves keep at least one cell.
+func splitPoint(sizes []int) int {
+	total := 0
+	for _, s := range sizes {
+		total += s
+	}
+
+	acc := 0
+	mid := 0
+	for mid < len(sizes)-1 && acc < total/2 {
+		acc += sizes[mid]
+		mid++
+	}
+	return max(mid, 1)
+}
@@ -0,0 +1,95 @@
+package bptree_disk
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+)
+
+func TestBPlusTree_Allocator_PersistsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), fileName)
+
+	tree, err := Open(path)
+	require.NoError(t, err)
+
+	for i := 1; i <= 20; i++ {
+		k, v := kv(i)
+		require.NoError(t, tree.Set(k, v))
+	}
+
+	// Free more blocks than the meta page can hold inline
+	alloc := tree.pager.Allocator()
+	numFree := disk.MetaMaxFreeIDs(disk.BLOCK_SIZE) + 2*disk.FreeListMaxIDs(disk.BLOCK_SIZE)
+	ids := make([]uint64, 0, numFree)
+	for i := 0; i < numFree; i++ {
+		ids = append(ids, alloc.Allocate())
+	}
+	for _, id := range ids {
+		alloc.Free(id)
+	}
+	require.NoError(t, tree.commit())
+
+	wantNext, wantFree, _ := alloc.Snapshot()
+	require.NoError(t, tree.Close())
+
+	// Reopen and compare allocator state
+	tree, err = Open(path)
+	require.NoError(t, err)
+	defer tree.Close()
+
+	gotNext, gotFree, dirty := tree.pager.Allocator().Snapshot()
+	assert.Equal(t, wantNext, gotNext)
+	assert.ElementsMatch(t, wantFree, gotFree)
+	assert.False(t, dirty)
+
+	// New writes must not clobber live pages
+	for i := 21; i <= 200; i++ {
+		k, v := kv(i)
+		require.NoError(t, tree.Set(k, v))
+	}
+	for i := 1; i <= 200; i++ {
+		k, v := kv(i)
+		kv, err := tree.Find(k)
+		require.NoError(t, err)
+		assert.Equal(t, v, kv.Val)
+	}
+}
+
+func TestBPlusTree_Allocator_FreeListShrinksAfterReuse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), fileName)
+
+	tree, err := Open(path)
+	require.NoError(t, err)
+
+	alloc := tree.pager.Allocator()
+	numFree := disk.MetaMaxFreeIDs(disk.BLOCK_SIZE) + disk.FreeListMaxIDs(disk.BLOCK_SIZE)
+	ids := make([]uint64, 0, numFree)
+	for i := 0; i < numFree; i++ {
+		ids = append(ids, alloc.Allocate())
+	}
+	for _, id := range ids {
+		alloc.Free(id)
+	}
+	require.NoError(t, tree.commit())
+
+	// Reuse almost everything, including blocks next to the old chain
+	for i := 0; i < numFree-1; i++ {
+		alloc.Allocate()
+	}
+	require.NoError(t, tree.commit())
+
+	wantNext, wantFree, _ := alloc.Snapshot()
+	require.NoError(t, tree.Close())
+
+	tree, err = Open(path)
+	require.NoError(t, err)
+	defer tree.Close()
+
+	gotNext, gotFree, _ := tree.pager.Allocator().Snapshot()
+	assert.Equal(t, wantNext, gotNext)
+	assert.ElementsMatch(t, wantFree, gotFree)
+}
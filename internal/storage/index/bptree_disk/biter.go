@@ -1,14 +1,60 @@
 package bptree_disk
 
-import "github.com/spaghetti-lover/go-db/internal/storage/disk"
+import (
+	"sort"
 
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+)
+
+// seekMode selects which leaf a descent ends in
+type seekMode int
+
+const (
+	seekGE     seekMode = iota // the leaf that holds key
+	seekBefore                 // the leaf that holds the keys just below key
+	seekLast                   // the last leaf
+	seekFirst                  // the first leaf
+)
+
+// child returns the index of the child of internal to follow
+func (m seekMode) child(internal disk.InternalView, key []byte, cmp *disk.Comparator) int {
+	switch m {
+	case seekLast:
+		return internal.NKeys()
+	case seekFirst:
+		return 0
+	case seekBefore:
+		i := internal.FindLastLE(key)
+		if i >= 0 && cmp.Compare(internal.Key(i), key) == 0 {
+			return i
+		}
+		return i + 1
+	default:
+		return internal.FindLastLE(key) + 1
+	}
+}
+
+// leafPos is a leaf reached by a descent, with the separator keys that
+// bound it: every key of the leaf is in [lo, hi). nil bounds are open.
+type leafPos struct {
+	pid    uint64
+	view   disk.LeafView  // the pinned page, while readLeaf's latch is held
+	leaf   *disk.LeafPage // a decoded copy, filled in by leafFor
+	lo, hi []byte
+}
+
+// BIter walks the keys in either direction. It works on a copy of one leaf
+// at a time, with spilled values already read, and holds no latch between
+// calls, so writers may change the tree while it is in use. Moving past
+// either end of the leaf descends from the root again to the leaf after
+// the bound of the current one, which sees the tree as it is by then.
 type BIter struct {
-	tree    *BPlusTree
-	leafPID uint64
-	leaf    *disk.LeafPage
-	buf     []byte
-	idx     int
-	valid   bool
+	tree  *BPlusTree
+	pos   *leafPos
+	kvs   []disk.KeyVal // entries of the current leaf, values inline
+	idx   int
+	valid bool
+	err   error
 }
 
 // Valid returns whether the iterator is valid
@@ -21,7 +67,15 @@ func (it *BIter) Deref() *disk.KeyVal {
 	if !it.Valid() {
 		return nil
 	}
-	return &it.leaf.KVs[it.idx]
+	return &it.kvs[it.idx]
+}
+
+// Err returns the error that stopped the iterator, if any
+func (it *BIter) Err() error {
+	if it == nil {
+		return nil
+	}
+	return it.err
 }
 
 // Next: advances the iterator to the next key-value pair
@@ -31,27 +85,114 @@ func (it *BIter) Next() {
 	}
 
 	it.idx++
+	if it.idx < len(it.kvs) {
+		return
+	}
+
+	it.tree.treeLatch.RLock()
+	defer it.tree.treeLatch.RUnlock()
+	it.nextLeaf()
+}
 
-	if it.idx < int(it.leaf.NKV) {
+// Prev moves the iterator back to the previous key-value pair
+func (it *BIter) Prev() {
+	if !it.Valid() {
 		return
 	}
 
-	// move to next leaf
-	nextPID := it.leaf.Header.NextPagePointer
-	if nextPID == 0 {
-		it.valid = false
+	it.idx--
+	if it.idx >= 0 {
 		return
 	}
 
-	leaf, buf, err := it.tree.loadLeaf(nextPID)
+	it.tree.treeLatch.RLock()
+	defer it.tree.treeLatch.RUnlock()
+	it.prevLeaf()
+}
+
+// seek positions a new iterator on the leaf for key. The index within the
+// leaf is left to the caller.
+func (t *BPlusTree) seek(key []byte, mode seekMode) *BIter {
+	it := &BIter{tree: t}
+	it.load(key, mode)
+	return it
+}
+
+// load reads the leaf for key into the iterator
+func (it *BIter) load(key []byte, mode seekMode) bool {
+	t := it.tree
+
+	pos, err := t.readLeaf(key, mode)
 	if err != nil {
+		it.err = err
 		it.valid = false
-		return
+		return false
+	}
+	defer t.releaseLeaf(pos)
+
+	// Spilled values are read while the leaf latch still keeps writers
+	// from freeing them
+	kvs := make([]disk.KeyVal, pos.view.NKeys())
+	for i := range kvs {
+		cell := pos.view.Cell(i)
+		kv, err := t.materialize(&cell)
+		if err != nil {
+			it.err = err
+			it.valid = false
+			return false
+		}
+		kvs[i] = *kv
 	}
 
-	it.leafPID = nextPID
-	it.leaf = leaf
-	it.buf = buf
-	it.idx = 0
+	it.pos, it.kvs = pos, kvs
 	it.valid = true
-}
\ No newline at end of file
+	return true
+}
+
+// nextLeaf moves to the first entry at or after the upper bound of the
+// current leaf
+func (it *BIter) nextLeaf() {
+	for {
+		hi := it.pos.hi
+		if hi == nil || !it.load(hi, seekGE) {
+			it.valid = false
+			return
+		}
+
+		// Skip entries the iterator has already passed
+		it.idx = it.lowerBound(hi)
+		if it.idx < len(it.kvs) {
+			return
+		}
+	}
+}
+
+// prevLeaf moves to the last entry below the lower bound of the current leaf
+func (it *BIter) prevLeaf() {
+	for {
+		lo := it.pos.lo
+		if lo == nil || !it.load(lo, seekBefore) {
+			it.valid = false
+			return
+		}
+
+		it.idx = it.lowerBound(lo) - 1
+		if it.idx >= 0 {
+			return
+		}
+	}
+}
+
+// lowerBound returns the first position in the current leaf whose key >= key
+func (it *BIter) lowerBound(key []byte) int {
+	return sort.Search(len(it.kvs), func(i int) bool {
+		return it.tree.cmp.Compare(it.kvs[i].Key, key) >= 0
+	})
+}
+
+// lastLE returns the last position in the current leaf whose key <= key
+func (it *BIter) lastLE(key []byte) int {
+	return sort.Search(len(it.kvs), func(i int) bool {
+		return it.tree.cmp.Compare(it.kvs[i].Key, key) > 0
+	}) - 1
+}
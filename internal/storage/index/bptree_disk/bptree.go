@@ -1,99 +1,216 @@
 package bptree_disk
 
 import (
-	"bytes"
+	"fmt"
 	"os"
+	"sync"
 
 	"github.com/spaghetti-lover/go-db/internal/storage/disk"
 )
 
+// BPlusTree is safe for concurrent use. Readers and writers descend with
+// latch crabbing on per-page latches, so operations on different parts of
+// the tree run in parallel.
 type BPlusTree struct {
-	pager   *disk.Pager
-	metaPID uint64
+	pager     *disk.Pager
+	metaPID   uint64
+	meta      *disk.MetaPage // in-memory copy, written back by commit
+	metaDirty bool
+	blockSize int
+	cmp       *disk.Comparator // key order, named in the file header
+	logged    bool             // see Options.Logged
+
+	// treeLatch is held shared by every operation and exclusively by those
+	// that restructure the whole tree: BulkLoad, DeleteRange and Close
+	treeLatch sync.RWMutex
+	// commitLatch is held shared while a writer changes pages and
+	// exclusively by commit, so the free list and meta page are written
+	// while no page is allocated or modified
+	commitLatch sync.RWMutex
+	// rootLatch guards the root pointer
+	rootLatch sync.RWMutex
+	latches   latchTable
 }
 
 func NewBPlusTree(pager *disk.Pager) (*BPlusTree, error) {
+	return NewBPlusTreeWithComparator(pager, "")
+}
+
+// NewBPlusTreeWithComparator opens the tree in pager. A new tree orders its
+// keys with the named comparator (BYTEWISE when empty) and records the name
+// in its header; an existing tree uses the one it was built with, and
+// naming a different one is an error.
+func NewBPlusTreeWithComparator(pager *disk.Pager, comparator string) (*BPlusTree, error) {
 	metaPID := uint64(0)
+	t := &BPlusTree{
+		pager:     pager,
+		metaPID:   metaPID,
+		blockSize: pager.BlockSize(),
+	}
 
-	buf, err := pager.FetchPage(metaPID)
+	meta, err := t.loadMeta()
 	if err != nil {
 		return nil, err
 	}
 
-	meta := &disk.MetaPage{}
-	metaBuf := bytes.NewBuffer(buf)
-
 	// Case 1: fresh file
-	if err := meta.ReadFromBuffer(metaBuf); err != nil || meta.Magic != disk.META_MAGIC {
-		meta := disk.NewMetaPage()
-
-		// create root leaf
-		rootPID, rootBuf := pager.NewPage()
-		leaf := disk.NewLeafPage()
-
-		rootWriter := bytes.NewBuffer(rootBuf[:0])
-		if err := leaf.WriteToBuffer(rootWriter); err != nil {
+	if meta.File.Magic == 0 {
+		if t.cmp, err = disk.LookupComparator(comparator); err != nil {
 			return nil, err
 		}
+		t.meta = disk.NewMetaPage(t.blockSize)
+		t.meta.File.Comparator = t.cmp.Name
 
-		if err := pager.FlushPage(rootPID); err != nil {
+		// create root leaf
+		rootPID, err := t.newPage(t.newLeaf())
+		if err != nil {
 			return nil, err
 		}
 
 		// update meta
-		meta.RootPID = rootPID
-		metaWriter := bytes.NewBuffer(buf[:0])
-		if err := meta.WriteToBuffer(metaWriter); err != nil {
+		if err := t.setRootPID(rootPID); err != nil {
 			return nil, err
 		}
 
-		if err := pager.FlushPage(metaPID); err != nil {
+		if err := t.commit(); err != nil {
 			return nil, err
 		}
 
-		return &BPlusTree{
-			pager:   pager,
-			metaPID: metaPID,
-		}, nil
+		return t, nil
 	}
 
 	// Case 2: existing tree
-	return &BPlusTree{
-		pager:   pager,
-		metaPID: metaPID,
-	}, nil
+	if err := meta.File.Validate(); err != nil {
+		return nil, err
+	}
+	if meta.File.Features&disk.FeatureHashIndex != 0 {
+		return nil, fmt.Errorf("%w: hash index", disk.ErrWrongIndexType)
+	}
+	if int(meta.File.BlockSize) != t.blockSize {
+		return nil, fmt.Errorf("%w: file uses %d byte pages, pager uses %d", disk.ErrInvalidBlockSize, meta.File.BlockSize, t.blockSize)
+	}
+	if t.cmp, err = disk.LookupComparator(meta.File.Comparator); err != nil {
+		return nil, err
+	}
+	if comparator != "" && comparator != t.cmp.Name {
+		return nil, fmt.Errorf("%w: file uses %s, asked for %s", disk.ErrComparatorMismatch, t.cmp.Name, comparator)
+	}
+
+	t.meta = meta
+	if err := t.loadAllocator(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Options configures how a tree file is opened
+type Options struct {
+	// CachePages bounds the number of pages held in memory.
+	// Zero means disk.DEFAULT_CACHE_PAGES.
+	CachePages int
+
+	// BlockSize is the page size of a new file: 4K, 8K, 16K or 32K.
+	// Zero means disk.BLOCK_SIZE. An existing file keeps the page size
+	// recorded in its header.
+	BlockSize int
+
+	// Comparator names the key order of a new file, one of the built-in
+	// comparators or one added with disk.RegisterComparator. Empty means
+	// disk.BYTEWISE for a new file and whatever the header records for an
+	// existing one.
+	Comparator string
+
+	// Logged is for callers that log every change to a write-ahead log
+	// themselves. Operations then leave their pages in the cache instead
+	// of committing, no dirty page is written before Checkpoint, and the
+	// cache must be big enough to hold the pages changed between two
+	// checkpoints.
+	Logged bool
 }
 
 func Open(file string) (*BPlusTree, error) {
+	return OpenWithOptions(file, Options{})
+}
+
+func OpenWithOptions(file string, opts Options) (*BPlusTree, error) {
 	allocator := disk.NewFileAllocator()
 	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0666)
 	if err != nil {
 		return nil, err
 	}
-	pager := disk.NewPager(f, allocator)
-	return NewBPlusTree(pager)
+
+	// A checkpoint cut short is finished before anything is read
+	if _, err := disk.RecoverDoubleWrite(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// The page size of an existing file comes from its header
+	header, err := disk.ReadFileHeader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	blockSize := opts.BlockSize
+	if header != nil {
+		blockSize = int(header.BlockSize)
+	}
+
+	pager, err := disk.NewPagerWithOptions(f, allocator, disk.PagerOptions{
+		Capacity:  opts.CachePages,
+		BlockSize: blockSize,
+		NoSteal:   opts.Logged,
+	})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	tree, err := NewBPlusTreeWithComparator(pager, opts.Comparator)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	tree.logged = opts.Logged
+	return tree, nil
+}
+
+// Comparator returns the key order of the tree
+func (t *BPlusTree) Comparator() *disk.Comparator {
+	return t.cmp
+}
+
+// BlockSize returns the page size of the tree file
+func (t *BPlusTree) BlockSize() int {
+	return t.blockSize
 }
 
 func (t *BPlusTree) Close() error {
+	t.treeLatch.Lock()
+	defer t.treeLatch.Unlock()
+
+	return t.pager.Close()
+}
+
+// Discard closes the tree without writing the pages changed in the cache,
+// leaving the file as the last commit or checkpoint wrote it
+func (t *BPlusTree) Discard() error {
+	t.treeLatch.Lock()
+	defer t.treeLatch.Unlock()
+
+	t.pager.Discard()
 	return t.pager.Close()
 }
 
 func (t *BPlusTree) rootPID() (uint64, error) {
-	meta, _, err := t.loadMeta()
-	if err != nil {
-		return 0, err
-	}
-	return meta.RootPID, nil
+	return t.meta.RootPID, nil
 }
 
+// setRootPID updates the root pointer; it reaches disk on the next commit
 func (t *BPlusTree) setRootPID(pid uint64) error {
-	meta, buf, err := t.loadMeta()
-	if err != nil {
-		return err
-	}
-
-	meta.RootPID = pid
-	return t.flushMeta(meta, buf)
+	t.meta.RootPID = pid
+	t.metaDirty = true
+	return nil
 }
 
 type InsertResult struct {
@@ -115,73 +232,60 @@ type DeleteResult struct {
 	MergeDir  MergeDir
 }
 
-// SeekGE positions the iterator at the first key >= target key
+// SeekGE positions the iterator at the first key >= target key. A nil key
+// is before every key, whatever the order of the tree.
 func (t *BPlusTree) SeekGE(key []byte) *BIter {
-	rootPID, err := t.rootPID()
-	if err != nil {
-		return &BIter{valid: false}
+	t.treeLatch.RLock()
+	defer t.treeLatch.RUnlock()
+
+	if key == nil {
+		it := t.seek(nil, seekFirst)
+		if it.Valid() && len(it.kvs) == 0 {
+			it.nextLeaf()
+		}
+		return it
 	}
 
-	kv := disk.NewKeyEntryFromBytes(key)
+	it := t.seek(key, seekGE)
+	if it.Valid() {
+		it.idx = it.lowerBound(key)
+
+		// Not found in this leaf: move on to the next one
+		if it.idx >= len(it.kvs) {
+			it.nextLeaf()
+		}
+	}
+	return it
+}
 
-	disk.RightAlignCopy(kv.Key[:], key)
+// SeekLE positions the iterator at the last key <= target key
+func (t *BPlusTree) SeekLE(key []byte) *BIter {
+	t.treeLatch.RLock()
+	defer t.treeLatch.RUnlock()
 
-	pid := rootPID
+	it := t.seek(key, seekGE)
+	if it.Valid() {
+		it.idx = it.lastLE(key)
 
-	for {
-		node, _, err := t.loadNode(pid)
-		if err != nil {
-			return &BIter{valid: false}
+		// Every key of this leaf is larger: move back to the previous one
+		if it.idx < 0 {
+			it.prevLeaf()
 		}
+	}
+	return it
+}
 
-		if node.IsLeaf() {
-			leaf, buf, _ := t.loadLeaf(pid)
-
-			idx := 0
-			for idx < int(leaf.NKV) {
-				entry := disk.NewKeyEntryFromKeyVal(&leaf.KVs[idx])
-				if entry.Compare(kv) >= 0 {
-					break
-				}
-				idx++
-			}
-
-			// Case 1: found key >= target
-			if idx < int(leaf.NKV) {
-				return &BIter{
-					tree:    t,
-					leafPID: pid,
-					leaf:    leaf,
-					buf:     buf,
-					idx:     idx,
-					valid:   true,
-				}
-			}
-
-			// Case 2: not found, point to next leaf
-			next := leaf.Header.NextPagePointer
-			if next == 0 {
-				return &BIter{valid: false}
-			}
-
-			leaf, buf, _ = t.loadLeaf(next)
-			if leaf.NKV == 0 {
-				return &BIter{valid: false}
-			}
-
-			return &BIter{
-				tree:    t,
-				leafPID: next,
-				leaf:    leaf,
-				buf:     buf,
-				idx:     0,
-				valid:   true,
-			}
-		}
+// SeekLast positions the iterator at the largest key of the tree
+func (t *BPlusTree) SeekLast() *BIter {
+	t.treeLatch.RLock()
+	defer t.treeLatch.RUnlock()
 
-		// internal node
-		internal := node.(*disk.InternalPage)
-		pos := internal.FindLastLE(kv)
-		pid = internal.Children[pos+1]
+	it := t.seek(nil, seekLast)
+	if it.Valid() {
+		it.idx = len(it.kvs) - 1
+		if it.idx < 0 {
+			it.prevLeaf()
+		}
 	}
+	return it
 }
@@ -0,0 +1,421 @@
+package bptree_disk
+
+import (
+	"fmt"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+)
+
+// DEFAULT_FILL_FACTOR leaves some room in bulk-loaded pages so that later
+// inserts do not split every page straight away
+const DEFAULT_FILL_FACTOR = 0.9
+
+var (
+	ErrUnsortedInput     = fmt.Errorf("bulk load input is not in ascending key order")
+	ErrInvalidFillFactor = fmt.Errorf("fill factor must be between 0.5 and 1")
+	ErrLoggedTree        = fmt.Errorf("operation commits on its own and cannot run on a logged tree")
+)
+
+// BulkIterator yields the pairs to load, in strictly ascending key order
+type BulkIterator interface {
+	Valid() bool
+	Key() []byte
+	Value() []byte
+	Next()
+}
+
+// BulkOptions configures BulkLoad
+type BulkOptions struct {
+	// FillFactor is the share of each page filled with entries.
+	// Zero means DEFAULT_FILL_FACTOR.
+	FillFactor float64
+}
+
+// sliceIterator is a BulkIterator over sorted, in-memory pairs
+type sliceIterator struct {
+	kvs []disk.KeyVal
+	pos int
+}
+
+// NewSliceIterator returns a BulkIterator over kvs, which must be sorted
+func NewSliceIterator(kvs []disk.KeyVal) BulkIterator {
+	return &sliceIterator{kvs: kvs}
+}
+
+func (it *sliceIterator) Valid() bool   { return it.pos < len(it.kvs) }
+func (it *sliceIterator) Key() []byte   { return it.kvs[it.pos].Key }
+func (it *sliceIterator) Value() []byte { return it.kvs[it.pos].Val }
+func (it *sliceIterator) Next()         { it.pos++ }
+
+// BulkLoad builds the tree bottom-up from the pairs of it merged with the
+// current contents of the tree. Pages are packed up to the fill factor and
+// written once; the whole load is made durable by a single commit.
+// A key already in the tree takes the value from it, as with Set.
+// Other operations wait until the load is done. A logged tree returns
+// ErrLoggedTree, as the load is not in its log.
+func (t *BPlusTree) BulkLoad(it BulkIterator, opts BulkOptions) error {
+	if t.logged {
+		return ErrLoggedTree
+	}
+
+	t.treeLatch.Lock()
+	defer t.treeLatch.Unlock()
+
+	fill := opts.FillFactor
+	if fill == 0 {
+		fill = DEFAULT_FILL_FACTOR
+	}
+	if fill < 0.5 || fill > 1 {
+		return ErrInvalidFillFactor
+	}
+
+	oldRoot, err := t.rootPID()
+	if err != nil {
+		return err
+	}
+
+	b := &bulkBuilder{tree: t, limit: int(fill * float64(t.blockSize))}
+	if err := b.load(it, oldRoot); err != nil {
+		b.abort()
+		return err
+	}
+
+	newRoot, err := b.buildInternal()
+	if err != nil {
+		b.abort()
+		return err
+	}
+
+	// The old pages are only released once the new tree is complete
+	if err := t.freeTree(oldRoot); err != nil {
+		return err
+	}
+	for _, pid := range b.replaced {
+		if err := t.freeOverflow(pid); err != nil {
+			return err
+		}
+	}
+
+	if err := t.setRootPID(newRoot); err != nil {
+		return err
+	}
+	return t.commit()
+}
+
+// bulkEntry is a child of the level being built: the page and its first key
+type bulkEntry struct {
+	key []byte
+	pid uint64
+}
+
+type bulkBuilder struct {
+	tree  *BPlusTree
+	limit int // bytes filled per page
+
+	// leaves are written one behind, so the last two can be rebalanced
+	prev, cur       *disk.LeafPage
+	prevPID, curPID uint64
+
+	level    []bulkEntry // written leaves, input of the first internal level
+	pages    []uint64    // every page of the new tree, freed on abort
+	spilled  []uint64    // overflow chains written for the input
+	replaced []uint64    // overflow chains of overwritten entries
+}
+
+// load merges the existing entries of the tree at oldRoot with the input
+// into packed leaves
+func (b *bulkBuilder) load(in BulkIterator, oldRoot uint64) error {
+	t := b.tree
+
+	var last []byte
+	first := true
+
+	// addInput adds the input entries with a key up to limit, or all of
+	// them if limit is nil
+	addInput := func(limit []byte) error {
+		for in.Valid() && (limit == nil || t.cmp.Compare(in.Key(), limit) <= 0) {
+			key := in.Key()
+			if !first && t.cmp.Compare(key, last) <= 0 {
+				return fmt.Errorf("%w: %q after %q", ErrUnsortedInput, key, last)
+			}
+			first = false
+			last = append(last[:0], key...)
+
+			kv, err := t.newCell(key, in.Value())
+			if err != nil {
+				return err
+			}
+			if kv.HasOverflow() {
+				b.spilled = append(b.spilled, kv.Overflow)
+			}
+			if err := b.add(kv); err != nil {
+				return err
+			}
+			in.Next()
+		}
+		return nil
+	}
+
+	// existing entries keep their cell as is, unless the input replaces them
+	err := t.walkCells(oldRoot, func(cell disk.KeyVal) error {
+		if err := addInput(cell.Key); err != nil {
+			return err
+		}
+		if !first && t.cmp.Compare(last, cell.Key) == 0 {
+			if cell.HasOverflow() {
+				b.replaced = append(b.replaced, cell.Overflow)
+			}
+			return nil
+		}
+		return b.add(cell)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := addInput(nil); err != nil {
+		return err
+	}
+	return b.finishLeaves()
+}
+
+// walkCells calls fn for every leaf cell of the tree at pid in key order.
+// Spilled values are passed as the reference stored in the leaf.
+func (t *BPlusTree) walkCells(pid uint64, fn func(cell disk.KeyVal) error) error {
+	node, err := t.loadNode(pid)
+	if err != nil {
+		return err
+	}
+
+	switch node := node.(type) {
+	case *disk.LeafPage:
+		for _, cell := range node.KVs {
+			if err := fn(cell); err != nil {
+				return err
+			}
+		}
+	case *disk.InternalPage:
+		for _, child := range node.Children {
+			if err := t.walkCells(child, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// allocPage reserves a page ID for the new tree
+func (b *bulkBuilder) allocPage() (uint64, error) {
+	pid, _, err := b.tree.pager.NewPage()
+	if err != nil {
+		return 0, err
+	}
+	b.pages = append(b.pages, pid)
+	return pid, b.tree.pager.UnpinPage(pid, true)
+}
+
+// add appends one entry to the current leaf, starting a new leaf when the
+// fill limit is reached
+func (b *bulkBuilder) add(kv disk.KeyVal) error {
+	if b.cur != nil && len(b.cur.KVs) > 0 && b.cur.Size()+kv.CellSize() > b.limit {
+		if err := b.flushPrev(); err != nil {
+			return err
+		}
+		b.prev, b.prevPID = b.cur, b.curPID
+		b.cur = nil
+	}
+
+	if b.cur == nil {
+		pid, err := b.allocPage()
+		if err != nil {
+			return err
+		}
+		b.cur, b.curPID = b.tree.newLeaf(), pid
+	}
+
+	b.cur.KVs = append(b.cur.KVs, kv)
+	return nil
+}
+
+// flushPrev writes the leaf before the current one, linked to it
+func (b *bulkBuilder) flushPrev() error {
+	if b.prev == nil {
+		return nil
+	}
+	b.prev.Header.NextPagePointer = b.curPID
+	if err := writePage(b.tree, b.prevPID, b.prev); err != nil {
+		return err
+	}
+	b.level = append(b.level, bulkEntry{key: b.prev.KVs[0].Key, pid: b.prevPID})
+	b.prev = nil
+	return nil
+}
+
+// finishLeaves writes the last leaves. An under-full last leaf is merged
+// into its neighbour or shares its entries with it.
+func (b *bulkBuilder) finishLeaves() error {
+	t := b.tree
+
+	if b.cur == nil {
+		// nothing to load: the tree is a single empty leaf
+		pid, err := b.allocPage()
+		if err != nil {
+			return err
+		}
+		b.cur, b.curPID = t.newLeaf(), pid
+	}
+
+	if b.prev != nil && b.cur.IsUnderflow() {
+		merged := t.newLeaf()
+		merged.KVs = append(append(merged.KVs, b.prev.KVs...), b.cur.KVs...)
+
+		if !merged.IsOverflow() {
+			b.freePage(b.curPID)
+			b.cur, b.curPID = merged, b.prevPID
+			b.prev = nil
+		} else {
+			right, _ := merged.Split()
+			b.prev.KVs, b.cur.KVs = merged.KVs, right.KVs
+		}
+	}
+
+	if err := b.flushPrev(); err != nil {
+		return err
+	}
+
+	b.cur.Header.NextPagePointer = 0
+	if err := writePage(t, b.curPID, b.cur); err != nil {
+		return err
+	}
+	var key []byte
+	if len(b.cur.KVs) > 0 {
+		key = b.cur.KVs[0].Key
+	}
+	b.level = append(b.level, bulkEntry{key: key, pid: b.curPID})
+	return nil
+}
+
+// buildInternal adds internal levels above the leaves until a single root
+// remains and returns it
+func (b *bulkBuilder) buildInternal() (uint64, error) {
+	level := b.level
+	for len(level) > 1 {
+		groups := b.groupLevel(level)
+
+		next := make([]bulkEntry, 0, len(groups))
+		for _, g := range groups {
+			node := b.tree.newInternal()
+			node.Children = make([]uint64, 0, len(g))
+			for i, e := range g {
+				node.Children = append(node.Children, e.pid)
+				if i > 0 {
+					node.Keys = append(node.Keys, disk.KeyEntry{Key: e.key})
+				}
+			}
+
+			pid, err := b.allocPage()
+			if err != nil {
+				return 0, err
+			}
+			if err := writePage(b.tree, pid, node); err != nil {
+				return 0, err
+			}
+			next = append(next, bulkEntry{key: g[0].key, pid: pid})
+		}
+		level = next
+	}
+	return level[0].pid, nil
+}
+
+// groupLevel splits the children of one level into internal pages. Each
+// page takes children up to the fill limit and holds at least two of them;
+// the last page is rebalanced with its neighbour if it is under-full.
+func (b *bulkBuilder) groupLevel(level []bulkEntry) [][]bulkEntry {
+	cellSize := func(e bulkEntry) int {
+		return (&disk.KeyEntry{Key: e.key}).CellSize()
+	}
+	groupSize := func(g []bulkEntry) int {
+		size := disk.INTERNAL_HEADER_SIZE
+		for _, e := range g[1:] {
+			size += cellSize(e)
+		}
+		return size
+	}
+
+	var groups [][]bulkEntry
+	start, size := 0, disk.INTERNAL_HEADER_SIZE
+	for i := 1; i < len(level); i++ {
+		if i-start >= 2 && size+cellSize(level[i]) > b.limit {
+			groups = append(groups, level[start:i])
+			start, size = i, disk.INTERNAL_HEADER_SIZE
+			continue
+		}
+		size += cellSize(level[i])
+	}
+	groups = append(groups, level[start:])
+
+	n := len(groups)
+	if n < 2 {
+		return groups
+	}
+	last := groups[n-1]
+	if len(last) >= 2 && groupSize(last) >= disk.MinFill(b.tree.blockSize) {
+		return groups
+	}
+
+	// merge the last two pages, or split their children evenly
+	both := level[len(level)-len(groups[n-2])-len(last):]
+	if groupSize(both) <= b.tree.blockSize {
+		return append(groups[:n-2], both)
+	}
+
+	// both[mid] starts the second page; its key moves up a level
+	half := (groupSize(both) - disk.INTERNAL_HEADER_SIZE) / 2
+	mid, acc := 1, 0
+	for mid < len(both)-2 && (mid < 2 || acc < half) {
+		acc += cellSize(both[mid])
+		mid++
+	}
+	return append(groups[:n-2], both[:mid], both[mid:])
+}
+
+// freePage releases a page of the new tree that is no longer needed
+func (b *bulkBuilder) freePage(pid uint64) {
+	for i, p := range b.pages {
+		if p == pid {
+			b.pages = append(b.pages[:i], b.pages[i+1:]...)
+			break
+		}
+	}
+	b.tree.pager.FreePage(pid)
+}
+
+// abort releases the pages written for an unfinished load
+func (b *bulkBuilder) abort() {
+	for _, pid := range b.spilled {
+		b.tree.freeOverflow(pid)
+	}
+	for _, pid := range b.pages {
+		b.tree.pager.FreePage(pid)
+	}
+}
+
+// freeTree releases every node page of the tree rooted at pid. Overflow
+// chains are left alone: the new tree still references them.
+func (t *BPlusTree) freeTree(pid uint64) error {
+	node, err := t.loadNode(pid)
+	if err != nil {
+		return err
+	}
+
+	if internal, ok := node.(*disk.InternalPage); ok {
+		for _, child := range internal.Children {
+			if err := t.freeTree(child); err != nil {
+				return err
+			}
+		}
+	}
+
+	t.pager.FreePage(pid)
+	return nil
+}
@@ -0,0 +1,219 @@
+package bptree_disk
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+)
+
+func bulkKey(i int) []byte {
+	return []byte(fmt.Sprintf("bulk-%07d", i))
+}
+
+func bulkVal(i int) []byte {
+	return bytes.Repeat([]byte{byte(i)}, 20+i%50)
+}
+
+func bulkInput(from, to, step int) []disk.KeyVal {
+	kvs := make([]disk.KeyVal, 0, (to-from)/step+1)
+	for i := from; i < to; i += step {
+		kvs = append(kvs, disk.KeyVal{Key: bulkKey(i), Val: bulkVal(i)})
+	}
+	return kvs
+}
+
+// checkTree walks the tree and checks key order, separator bounds and that
+// all leaves are at the same depth. It returns the number of entries.
+func checkTree(t *testing.T, tree *BPlusTree) int {
+	rootPID, err := tree.rootPID()
+	require.NoError(t, err)
+
+	leafDepth := -1
+	count := 0
+	var walk func(pid uint64, lo, hi []byte, depth int)
+	walk = func(pid uint64, lo, hi []byte, depth int) {
+		node, err := tree.loadNode(pid)
+		require.NoError(t, err)
+
+		if leaf, ok := node.(*disk.LeafPage); ok {
+			if leafDepth < 0 {
+				leafDepth = depth
+			}
+			require.Equal(t, leafDepth, depth, "leaves at different depths")
+			for i, kv := range leaf.KVs {
+				if i > 0 {
+					require.Less(t, bytes.Compare(leaf.KVs[i-1].Key, kv.Key), 0)
+				}
+				if lo != nil {
+					require.GreaterOrEqual(t, bytes.Compare(kv.Key, lo), 0)
+				}
+				if hi != nil {
+					require.Less(t, bytes.Compare(kv.Key, hi), 0)
+				}
+			}
+			count += len(leaf.KVs)
+			return
+		}
+
+		internal := node.(*disk.InternalPage)
+		require.Equal(t, len(internal.Keys)+1, len(internal.Children))
+		for i, child := range internal.Children {
+			childLo, childHi := lo, hi
+			if i > 0 {
+				childLo = internal.Keys[i-1].Key
+			}
+			if i < len(internal.Keys) {
+				childHi = internal.Keys[i].Key
+			}
+			walk(child, childLo, childHi, depth+1)
+		}
+	}
+	walk(rootPID, nil, nil, 0)
+	return count
+}
+
+func TestBPlusTree_BulkLoad_Empty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), fileName)
+	tree, err := Open(path)
+	require.NoError(t, err)
+
+	n := 20000
+	require.NoError(t, tree.BulkLoad(NewSliceIterator(bulkInput(0, n, 1)), BulkOptions{}))
+	assert.Equal(t, n, checkTree(t, tree))
+
+	// Leaves are packed close to the fill factor
+	next, _, _ := tree.pager.Allocator().Snapshot()
+	assert.Less(t, int(next), n*(4+2+12+45)*10/(8*disk.BLOCK_SIZE))
+
+	i := 0
+	require.NoError(t, tree.Scan(nil, nil, func(key, val []byte) bool {
+		assert.Equal(t, bulkKey(i), key)
+		assert.Equal(t, bulkVal(i), val)
+		i++
+		return true
+	}))
+	assert.Equal(t, n, i)
+
+	// The loaded tree takes regular writes and survives a reopen
+	require.NoError(t, tree.Set(bulkKey(n), bulkVal(n)))
+	ok, err := tree.Del(bulkKey(0))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NoError(t, tree.Close())
+
+	tree, err = Open(path)
+	require.NoError(t, err)
+	defer tree.Close()
+	assert.Equal(t, n, checkTree(t, tree))
+	kv, err := tree.Find(bulkKey(n / 2))
+	require.NoError(t, err)
+	assert.Equal(t, bulkVal(n/2), kv.Val)
+}
+
+func TestBPlusTree_BulkLoad_MergesExisting(t *testing.T) {
+	tree := setupBPlusTree(t)
+
+	// Even keys through Set, odd keys (and an overlap) through the bulk load
+	for i := 0; i < 2000; i += 2 {
+		require.NoError(t, tree.Set(bulkKey(i), []byte("old")))
+	}
+	input := bulkInput(1, 2000, 2)
+	input = append(input, disk.KeyVal{Key: bulkKey(2001), Val: []byte("tail")})
+	require.NoError(t, tree.BulkLoad(NewSliceIterator(input), BulkOptions{FillFactor: 1}))
+	require.NoError(t, tree.BulkLoad(NewSliceIterator(bulkInput(0, 10, 2)), BulkOptions{}))
+
+	assert.Equal(t, 2001, checkTree(t, tree))
+	for i := 0; i < 2000; i++ {
+		kv, err := tree.Find(bulkKey(i))
+		require.NoError(t, err)
+		if i%2 == 1 || i < 10 {
+			assert.Equal(t, bulkVal(i), kv.Val)
+		} else {
+			assert.Equal(t, []byte("old"), kv.Val)
+		}
+	}
+}
+
+func TestBPlusTree_BulkLoad_ReusesOldPages(t *testing.T) {
+	tree := setupBPlusTree(t)
+
+	require.NoError(t, tree.BulkLoad(NewSliceIterator(bulkInput(0, 5000, 1)), BulkOptions{}))
+	next, _, _ := tree.pager.Allocator().Snapshot()
+
+	// Reloading the same data frees the old tree for the next load
+	for i := 0; i < 3; i++ {
+		require.NoError(t, tree.BulkLoad(NewSliceIterator(bulkInput(0, 5000, 1)), BulkOptions{}))
+	}
+	again, _, _ := tree.pager.Allocator().Snapshot()
+	assert.LessOrEqual(t, again, 2*next+2)
+	assert.Equal(t, 5000, checkTree(t, tree))
+}
+
+func TestBPlusTree_BulkLoad_LargeValues(t *testing.T) {
+	tree := setupBPlusTree(t)
+
+	input := []disk.KeyVal{
+		{Key: []byte("a"), Val: []byte("small")},
+		{Key: []byte("b"), Val: largeVal(2)},
+		{Key: []byte("c"), Val: largeVal(3)},
+	}
+	require.NoError(t, tree.BulkLoad(NewSliceIterator(input), BulkOptions{}))
+
+	for _, in := range input {
+		kv, err := tree.Find(in.Key)
+		require.NoError(t, err)
+		assert.Equal(t, in.Val, kv.Val)
+	}
+
+	// Overwriting a spilled value by a bulk load frees its chain
+	before := freeCount(tree)
+	require.NoError(t, tree.BulkLoad(NewSliceIterator([]disk.KeyVal{{Key: []byte("b"), Val: []byte("x")}}), BulkOptions{}))
+	assert.GreaterOrEqual(t, freeCount(tree), before+4)
+	kv, err := tree.Find([]byte("c"))
+	require.NoError(t, err)
+	assert.Equal(t, largeVal(3), kv.Val)
+}
+
+func TestBPlusTree_BulkLoad_Errors(t *testing.T) {
+	tree := setupBPlusTree(t)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, tree.Set(bulkKey(i), bulkVal(i)))
+	}
+	rootPID, err := tree.rootPID()
+	require.NoError(t, err)
+
+	err = tree.BulkLoad(NewSliceIterator(nil), BulkOptions{FillFactor: 0.2})
+	assert.ErrorIs(t, err, ErrInvalidFillFactor)
+
+	// Unsorted input leaves the tree as it was
+	input := bulkInput(100, 3000, 1)
+	input[2000], input[2001] = input[2001], input[2000]
+	err = tree.BulkLoad(NewSliceIterator(input), BulkOptions{})
+	assert.ErrorIs(t, err, ErrUnsortedInput)
+
+	after, err := tree.rootPID()
+	require.NoError(t, err)
+	assert.Equal(t, rootPID, after)
+	assert.Equal(t, 10, checkTree(t, tree))
+
+	// ...and the pages written for it are free again
+	next, free, _ := tree.pager.Allocator().Snapshot()
+	assert.Equal(t, int(next)-2, len(free), "only the root leaf is in use")
+}
+
+func TestBPlusTree_BulkLoad_Logged(t *testing.T) {
+	tree, err := OpenWithOptions(filepath.Join(t.TempDir(), "logged.db"), Options{Logged: true})
+	require.NoError(t, err)
+	defer tree.Close()
+
+	err = tree.BulkLoad(NewSliceIterator(bulkInput(0, 100, 1)), BulkOptions{})
+	assert.ErrorIs(t, err, ErrLoggedTree)
+	_, err = tree.Find(bulkKey(0))
+	assert.Error(t, err)
+}
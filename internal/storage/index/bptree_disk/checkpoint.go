@@ -0,0 +1,85 @@
+package bptree_disk
+
+import (
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+)
+
+// SetLSN sets the LSN stamped on the pages changed by the next operations.
+// A logged tree's caller sets it to the record of each change before
+// making it.
+func (t *BPlusTree) SetLSN(lsn uint64) {
+	t.pager.SetLSN(lsn)
+}
+
+// CheckpointLSN returns the LSN of the last checkpoint; changes logged
+// from there on may be missing from the file
+func (t *BPlusTree) CheckpointLSN() uint64 {
+	t.commitLatch.RLock()
+	defer t.commitLatch.RUnlock()
+	return t.meta.CheckpointLSN
+}
+
+// PageLSN returns the page LSN of the leaf that holds key, or would. A
+// change to key logged at or before it is already in the tree.
+func (t *BPlusTree) PageLSN(key []byte) (uint64, error) {
+	t.treeLatch.RLock()
+	defer t.treeLatch.RUnlock()
+
+	pos, err := t.readLeaf(key, seekGE)
+	if err != nil {
+		return 0, err
+	}
+	defer t.releaseLeaf(pos)
+	return pos.view.LSN(), nil
+}
+
+// DirtyPages returns the number of changed pages in the cache
+func (t *BPlusTree) DirtyPages() int {
+	return t.pager.DirtyPages()
+}
+
+// CacheCapacity returns the number of pages the cache holds
+func (t *BPlusTree) CacheCapacity() int {
+	return t.pager.Capacity()
+}
+
+// Checkpoint is a copy of the tree as of a checkpoint LSN, taken by
+// BeginCheckpoint
+type Checkpoint struct {
+	t       *BPlusTree
+	meta    *disk.MetaCheckpoint
+	prevLSN uint64 // checkpoint LSN before this one
+}
+
+// BeginCheckpoint copies the changed pages and the meta page, which
+// records lsn as the checkpoint LSN. The caller makes sure every change
+// logged before lsn has been applied and none after it has started; the
+// tree only waits for writers already changing pages. The copy is written
+// by Write while writers carry on.
+func (t *BPlusTree) BeginCheckpoint(lsn uint64) (*Checkpoint, error) {
+	t.commitLatch.Lock()
+	defer t.commitLatch.Unlock()
+
+	prevLSN := t.meta.CheckpointLSN
+	meta, err := disk.BeginCheckpoint(t.pager, t.metaPID, t.meta, lsn)
+	if err != nil {
+		t.meta.CheckpointLSN = prevLSN
+		return nil, err
+	}
+	t.metaDirty = false
+	return &Checkpoint{t: t, meta: meta, prevLSN: prevLSN}, nil
+}
+
+// Write makes the checkpoint durable, pages first and the meta page last
+func (c *Checkpoint) Write() error {
+	return c.meta.Write()
+}
+
+// Abort keeps the copied pages dirty when the checkpoint cannot be
+// written, and the checkpoint LSN where it was
+func (c *Checkpoint) Abort() {
+	c.t.commitLatch.Lock()
+	defer c.t.commitLatch.Unlock()
+	c.meta.Abort()
+	c.t.meta.CheckpointLSN = c.prevLSN
+}
@@ -0,0 +1,110 @@
+package bptree_disk
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+)
+
+func TestBPlusTree_ReverseComparator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), fileName)
+	tree, err := OpenWithOptions(path, Options{Comparator: disk.REVERSE_BYTEWISE})
+	require.NoError(t, err)
+
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key-%05d", i)) }
+	r := rand.New(rand.NewSource(1))
+	for _, i := range r.Perm(3000) {
+		require.NoError(t, tree.Set(key(i), []byte(fmt.Sprint(i))))
+	}
+	for i := 0; i < 3000; i += 3 {
+		ok, err := tree.Del(key(i))
+		require.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	report, err := tree.Verify()
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%v", report.Violations)
+	assert.Equal(t, 2000, report.Keys)
+
+	// Scan bounds follow the order of the tree: from the largest key down
+	var got []int
+	require.NoError(t, tree.Scan(key(20), key(10), func(k, v []byte) bool {
+		var i int
+		fmt.Sscanf(string(k), "key-%d", &i)
+		got = append(got, i)
+		return true
+	}))
+	assert.Equal(t, []int{20, 19, 17, 16, 14, 13, 11, 10}, got)
+
+	n, err := tree.DeleteRange(key(2999), key(1000))
+	require.NoError(t, err)
+	assert.Equal(t, 1333, n)
+	require.NoError(t, tree.Close())
+
+	// The order is read back from the header
+	tree, err = Open(path)
+	require.NoError(t, err)
+	defer tree.Close()
+	assert.Equal(t, disk.ReverseBytewise, tree.Comparator())
+
+	it := tree.SeekGE(nil)
+	require.True(t, it.Valid())
+	assert.Equal(t, key(1000), it.Deref().Key)
+	stats, err := tree.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, disk.REVERSE_BYTEWISE, stats.Comparator)
+	assert.Equal(t, int64(667), stats.Keys)
+}
+
+func TestBPlusTree_CaseInsensitiveComparator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), fileName)
+	tree, err := OpenWithOptions(path, Options{Comparator: disk.CASE_INSENSITIVE})
+	require.NoError(t, err)
+	defer tree.Close()
+
+	require.NoError(t, tree.Set([]byte("Apple"), []byte("1")))
+	require.NoError(t, tree.Set([]byte("banana"), []byte("2")))
+	require.NoError(t, tree.Set([]byte("CHERRY"), []byte("3")))
+
+	// Keys that differ only in case are the same key
+	kv, err := tree.Find([]byte("APPLE"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), kv.Val)
+	require.NoError(t, tree.Set([]byte("apple"), []byte("4")))
+
+	var keys []string
+	require.NoError(t, tree.Scan([]byte("a"), []byte("Banana"), func(k, v []byte) bool {
+		keys = append(keys, string(k)+"="+string(v))
+		return true
+	}))
+	assert.Equal(t, []string{"apple=4", "banana=2"}, keys)
+
+	ok, err := tree.Del([]byte("cherry"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestBPlusTree_ComparatorMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), fileName)
+	tree, err := Open(path)
+	require.NoError(t, err)
+	assert.Equal(t, disk.Bytewise, tree.Comparator())
+	require.NoError(t, tree.Close())
+
+	_, err = OpenWithOptions(path, Options{Comparator: disk.CASE_INSENSITIVE})
+	assert.ErrorIs(t, err, disk.ErrComparatorMismatch)
+
+	tree, err = OpenWithOptions(path, Options{Comparator: disk.BYTEWISE})
+	require.NoError(t, err)
+	require.NoError(t, tree.Close())
+
+	_, err = OpenWithOptions(filepath.Join(t.TempDir(), "other.db"), Options{Comparator: "no-such-order"})
+	assert.ErrorIs(t, err, disk.ErrUnknownComparator)
+}
@@ -0,0 +1,140 @@
+package bptree_disk
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBPlusTree_ConcurrentWriters(t *testing.T) {
+	tree := setupBPlusTree(t)
+
+	workers, perWorker := 8, 300
+	key := func(w, i int) []byte { return []byte(fmt.Sprintf("w%d-%05d", w, i)) }
+	val := func(w, i int) []byte { return bytes.Repeat([]byte{byte(w + i)}, 50+i%400) }
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				assert.NoError(t, tree.Set(key(w, i), val(w, i)))
+			}
+			// delete every third key again
+			for i := 0; i < perWorker; i += 3 {
+				ok, err := tree.Del(key(w, i))
+				assert.NoError(t, err)
+				assert.True(t, ok)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	want := 0
+	for w := 0; w < workers; w++ {
+		for i := 0; i < perWorker; i++ {
+			kv, err := tree.Find(key(w, i))
+			if i%3 == 0 {
+				assert.Error(t, err)
+				continue
+			}
+			want++
+			require.NoError(t, err)
+			assert.Equal(t, val(w, i), kv.Val)
+		}
+	}
+	assert.Equal(t, want, checkTree(t, tree))
+}
+
+func TestBPlusTree_ConcurrentReadersAndWriters(t *testing.T) {
+	tree := setupBPlusTree(t)
+
+	// Even keys are stable, odd keys come and go while readers run
+	n := 2000
+	for i := 0; i < n; i += 2 {
+		k, v := seqKey(i)
+		require.NoError(t, tree.Set(k, v))
+	}
+
+	stop := make(chan struct{})
+	var writers sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		writers.Add(1)
+		go func(w int) {
+			defer writers.Done()
+			for round := 0; ; round++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				for i := 1 + 2*w; i < n; i += 8 {
+					k, v := seqKey(i)
+					if round%2 == 0 {
+						assert.NoError(t, tree.Set(k, v))
+					} else {
+						_, err := tree.Del(k)
+						assert.NoError(t, err)
+					}
+				}
+			}
+		}(w)
+	}
+
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func(r int) {
+			defer readers.Done()
+			for round := 0; round < 5; round++ {
+				// Point lookups of stable keys
+				for i := 0; i < n; i += 2 * (r + 1) {
+					k, v := seqKey(i)
+					kv, err := tree.Find(k)
+					if assert.NoError(t, err) {
+						assert.Equal(t, v, kv.Val)
+					}
+				}
+
+				// Iterators see every stable key, in order, in both directions
+				var last []byte
+				stable := 0
+				it := tree.SeekGE(nil)
+				for ; it.Valid(); it.Next() {
+					kv := it.Deref()
+					if last != nil {
+						assert.Less(t, bytes.Compare(last, kv.Key), 0)
+					}
+					last = kv.Key
+					if kv.Key[len(kv.Key)-1]%2 == 0 {
+						stable++
+					}
+				}
+				assert.NoError(t, it.Err())
+				assert.Equal(t, n/2, stable)
+
+				stable = 0
+				it = tree.SeekLast()
+				for ; it.Valid(); it.Prev() {
+					kv := it.Deref()
+					if kv.Key[len(kv.Key)-1]%2 == 0 {
+						stable++
+					}
+				}
+				assert.NoError(t, it.Err())
+				assert.Equal(t, n/2, stable)
+			}
+		}(r)
+	}
+
+	readers.Wait()
+	close(stop)
+	writers.Wait()
+
+	checkTree(t, tree)
+}
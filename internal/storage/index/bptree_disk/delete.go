@@ -1,93 +1,91 @@
 package bptree_disk
 
 import (
-	"bytes"
-
 	"github.com/spaghetti-lover/go-db/internal/storage/disk"
 )
 
 func (t *BPlusTree) Del(key []byte) (bool, error) {
-	rootPID, err := t.rootPID()
+	t.beginWrite()
+	err := t.endWrite(t.del(key))
+	if err == disk.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (t *BPlusTree) del(key []byte) error {
+	w, err := t.lockPath(key, t.deleteSafe)
 	if err != nil {
-		return false, err
+		return err
 	}
+	defer w.release()
 
 	keyEntry := disk.NewKeyEntryFromBytes(key)
 
-	res, err := t.deleteRecursive(rootPID, keyEntry)
+	res, err := t.deleteRecursive(w.top(), keyEntry)
 	if err != nil {
-		if err == disk.ErrKeyNotFound {
-			return false, nil
-		}
-		return false, err
+		return err
 	}
 
-	// root shrink: internal root with 0 key
-	if res.Underflow {
-		root, _, err := t.loadNode(rootPID)
+	// root shrink: only an unsafe root can underflow, and then the root
+	// latch is still held
+	if res.Underflow && w.rootHeld {
+		rootPID := w.top()
+		root, err := t.loadNode(rootPID)
 		if err != nil {
-			return false, err
+			return err
 		}
 
 		// internal root with 0 key → promote only child
 		if !root.IsLeaf() {
 			internal := root.(*disk.InternalPage)
-			if internal.NKeys == 0 {
-				newRootPID := internal.Children[0]
+			if internal.NKeys() == 0 {
 				t.pager.FreePage(rootPID)
-				if err := t.pager.Sync(); err != nil {
-					return false, err
-				}
-				return true, t.setRootPID(newRootPID)
+				return t.setRootPID(internal.Children[0])
 			}
 		}
 	}
-
-	if err := t.pager.Sync(); err != nil {
-		return false, err
-	}
-	return true, nil
+	return nil
 }
 
 func (t *BPlusTree) deleteRecursive(nodePID uint64, key *disk.KeyEntry) (DeleteResult, error) {
-	node, buf, err := t.loadNode(nodePID)
+	ci, childPID, isLeaf, err := t.route(nodePID, key.Key)
 	if err != nil {
 		return DeleteResult{}, err
 	}
 
 	// ================= LEAF =================
-	if node.IsLeaf() {
-		leaf := node.(*disk.LeafPage)
-
-		ok := leaf.DelKey(key)
-		if !ok {
+	if isLeaf {
+		// deleting never needs more room, so it is always done in place
+		var found, underflow bool
+		var overflow uint64
+		err := t.pager.UpdatePage(nodePID, func(buf []byte) bool {
+			leaf := t.leafView(buf)
+			pos := leaf.LowerBound(key.Key)
+			if pos == leaf.NKeys() || t.cmp.Compare(leaf.Key(pos), key.Key) != 0 {
+				return false
+			}
+			found = true
+			overflow = leaf.Cell(pos).Overflow
+			leaf.Delete(pos)
+			underflow = leaf.Size() < disk.MinFill(len(buf))
+			return true
+		})
+		if err != nil {
+			return DeleteResult{}, err
+		}
+		if !found {
 			return DeleteResult{}, disk.ErrKeyNotFound
 		}
 
-		// write back leaf
-		writer := bytes.NewBuffer(buf[:0])
-		if err := leaf.WriteToBuffer(writer); err != nil {
-			return DeleteResult{}, err
-		}
-		if err := t.pager.FlushPage(nodePID); err != nil {
+		if err := t.freeOverflow(overflow); err != nil {
 			return DeleteResult{}, err
 		}
-		// no underflow
-		if leaf.NKV >= leaf.MinKeys() {
-			return DeleteResult{Underflow: false}, nil
-		}
 
-		// underflow
-		return DeleteResult{Underflow: true}, nil
+		return DeleteResult{Underflow: underflow}, nil
 	}
 
 	// ================= INTERNAL =================
-	internal := node.(*disk.InternalPage)
-
-	// find child
-	idx := internal.FindLastLE(key)
-	childPID := internal.Children[idx+1]
-
 	res, err := t.deleteRecursive(childPID, key)
 	if err != nil {
 		return DeleteResult{}, err
@@ -97,298 +95,288 @@ func (t *BPlusTree) deleteRecursive(nodePID uint64, key *disk.KeyEntry) (DeleteR
 		return DeleteResult{Underflow: false}, nil
 	}
 
-	// reload child after modification
-	childNode, childBuf, err := t.loadNode(childPID)
+	internal, err := t.loadInternal(nodePID)
 	if err != nil {
 		return DeleteResult{}, err
 	}
 
 	// ================= HANDLE UNDERFLOW =================
+	if err := t.rebalance(internal, ci); err != nil {
+		return DeleteResult{}, err
+	}
 
-	// -------- try borrow from LEFT --------
-	if idx >= 0 {
-		leftPID := internal.Children[idx]
-		leftNode, leftBuf, err := t.loadNode(leftPID)
-		if err != nil {
-			return DeleteResult{}, err
-		}
-
-		if childNode.IsLeaf() {
-			left := leftNode.(*disk.LeafPage)
-			cur := childNode.(*disk.LeafPage)
+	// write parent
+	if err := writePage(t, nodePID, internal); err != nil {
+		return DeleteResult{}, err
+	}
 
-			if left.NKV > left.MinKeys() {
-				borrowFromLeftLeaf(left, cur, internal, idx)
+	// check parent underflow
+	return DeleteResult{Underflow: internal.IsUnderflow() || internal.NKeys() == 0}, nil
+}
 
-				// flush pages
-				writePage(t, leftPID, leftBuf, left)
-				writePage(t, childPID, childBuf, cur)
-				writePage(t, nodePID, buf, internal)
+// rebalance fixes the under-full child at parent.Children[ci].
+// The child is merged with a sibling when both fit in one page; otherwise
+// entries are borrowed from a sibling. If neither is possible the child is
+// left under-full, which is still a valid tree. The caller writes parent.
+func (t *BPlusTree) rebalance(parent *disk.InternalPage, ci int) error {
+	child, err := t.loadNode(parent.Children[ci])
+	if err != nil {
+		return err
+	}
 
-				return DeleteResult{Underflow: false}, nil
-			}
-		} else {
-			left := leftNode.(*disk.InternalPage)
-			cur := childNode.(*disk.InternalPage)
+	if child.IsLeaf() {
+		return t.rebalanceLeaf(parent, ci, child.(*disk.LeafPage))
+	}
+	return t.rebalanceInternal(parent, ci, child.(*disk.InternalPage))
+}
 
-			if left.NKeys > left.MinKeys() {
-				borrowFromLeftInternal(internal, idx, left, cur)
+func (t *BPlusTree) rebalanceLeaf(parent *disk.InternalPage, ci int, cur *disk.LeafPage) error {
+	curPID := parent.Children[ci]
+	release := t.lockSiblings(parent, ci)
+	defer release()
 
-				writePage(t, leftPID, leftBuf, left)
-				writePage(t, childPID, childBuf, cur)
-				writePage(t, nodePID, buf, internal)
+	var left, right *disk.LeafPage
+	var leftPID, rightPID uint64
+	var err error
 
-				return DeleteResult{Underflow: false}, nil
-			}
+	if ci > 0 {
+		leftPID = parent.Children[ci-1]
+		if left, err = t.loadLeaf(leftPID); err != nil {
+			return err
+		}
+	}
+	if ci+1 < len(parent.Children) {
+		rightPID = parent.Children[ci+1]
+		if right, err = t.loadLeaf(rightPID); err != nil {
+			return err
 		}
 	}
 
-	// -------- try borrow from RIGHT --------
-	if idx+2 <= int(internal.NKeys) {
-		rightPID := internal.Children[idx+2]
-		rightNode, rightBuf, _ := t.loadNode(rightPID)
-
-		if childNode.IsLeaf() {
-			cur := childNode.(*disk.LeafPage)
-			right := rightNode.(*disk.LeafPage)
-
-			if right.NKV > right.MinKeys() {
-				borrowFromRightLeaf(cur, right, internal, idx+1)
-
-				writePage(t, childPID, childBuf, cur)
-				writePage(t, rightPID, rightBuf, right)
-				writePage(t, nodePID, buf, internal)
-
-				return DeleteResult{Underflow: false}, nil
-			}
-		} else {
-			cur := childNode.(*disk.InternalPage)
-			right := rightNode.(*disk.InternalPage)
-
-			if right.NKeys > right.MinKeys() {
-				borrowFromRightInternal(internal, idx+1, right, cur)
-
-				writePage(t, childPID, childBuf, cur)
-				writePage(t, rightPID, rightBuf, right)
-				writePage(t, nodePID, buf, internal)
+	// -------- MERGE --------
+	if left != nil && left.Size()+cur.Size()-disk.LEAF_HEADER_SIZE <= t.blockSize {
+		mergeLeaf(parent, ci, left, cur)
+		t.pager.FreePage(curPID)
+		return writePage(t, leftPID, left)
+	}
+	if right != nil && cur.Size()+right.Size()-disk.LEAF_HEADER_SIZE <= t.blockSize {
+		mergeLeaf(parent, ci+1, cur, right)
+		t.pager.FreePage(rightPID)
+		return writePage(t, curPID, cur)
+	}
 
-				return DeleteResult{Underflow: false}, nil
-			}
+	// -------- BORROW --------
+	if left != nil && borrowFromLeftLeaf(left, cur, parent, ci-1) {
+		if err := writePage(t, leftPID, left); err != nil {
+			return err
 		}
+		return writePage(t, curPID, cur)
 	}
-
-	// -------- MERGE (must) --------
-	if idx >= 0 {
-		// merge into left
-		leftPID := internal.Children[idx]
-		leftNode, leftBuf, err := t.loadNode(leftPID)
-		if err != nil {
-			return DeleteResult{}, err
+	if right != nil && borrowFromRightLeaf(cur, right, parent, ci) {
+		if err := writePage(t, rightPID, right); err != nil {
+			return err
 		}
+		return writePage(t, curPID, cur)
+	}
 
-		if childNode.IsLeaf() {
-			mergeLeaf(internal, idx+1, leftNode.(*disk.LeafPage), childNode.(*disk.LeafPage))
-		} else {
-			mergeInternal(internal, idx+1, leftNode.(*disk.InternalPage), childNode.(*disk.InternalPage))
-		}
+	return nil
+}
 
-		if err := writePage(t, leftPID, leftBuf, leftNode.(disk.Page)); err != nil {
-			return DeleteResult{}, err
-		}
-		// Free the merged child page
-		t.pager.FreePage(childPID)
-	} else {
-		// merge with right
-		rightPID := internal.Children[idx+2]
-		rightNode, _, err := t.loadNode(rightPID)
-		if err != nil {
-			return DeleteResult{}, err
-		}
+func (t *BPlusTree) rebalanceInternal(parent *disk.InternalPage, ci int, cur *disk.InternalPage) error {
+	curPID := parent.Children[ci]
+	release := t.lockSiblings(parent, ci)
+	defer release()
 
-		if childNode.IsLeaf() {
-			mergeLeaf(internal, idx+2, childNode.(*disk.LeafPage), rightNode.(*disk.LeafPage))
-		} else {
-			mergeInternal(internal, idx+2, childNode.(*disk.InternalPage), rightNode.(*disk.InternalPage))
-		}
+	var left, right *disk.InternalPage
+	var leftPID, rightPID uint64
+	var err error
 
-		if err := writePage(t, childPID, childBuf, childNode.(disk.Page)); err != nil {
-			return DeleteResult{}, err
+	if ci > 0 {
+		leftPID = parent.Children[ci-1]
+		if left, err = t.loadInternal(leftPID); err != nil {
+			return err
+		}
+	}
+	if ci+1 < len(parent.Children) {
+		rightPID = parent.Children[ci+1]
+		if right, err = t.loadInternal(rightPID); err != nil {
+			return err
 		}
-		// Free the merged right page
-		t.pager.FreePage(rightPID)
 	}
 
-	// write parent
-	writer := bytes.NewBuffer(buf[:0])
-	if err := internal.WriteToBuffer(writer); err != nil {
-		return DeleteResult{}, err
+	// -------- MERGE --------
+	// the separator from the parent comes down between the two halves
+	if left != nil && internalMergeSize(left, &parent.Keys[ci-1], cur) <= t.blockSize {
+		mergeInternal(parent, ci, left, cur)
+		t.pager.FreePage(curPID)
+		return writePage(t, leftPID, left)
 	}
-	if err := t.pager.FlushPage(nodePID); err != nil {
-		return DeleteResult{}, err
+	if right != nil && internalMergeSize(cur, &parent.Keys[ci], right) <= t.blockSize {
+		mergeInternal(parent, ci+1, cur, right)
+		t.pager.FreePage(rightPID)
+		return writePage(t, curPID, cur)
 	}
 
-	// check parent underflow
-	if internal.NKeys >= internal.MinKeys() {
-		return DeleteResult{Underflow: false}, nil
+	// -------- BORROW --------
+	if left != nil && borrowFromLeftInternal(parent, ci, left, cur) {
+		if err := writePage(t, leftPID, left); err != nil {
+			return err
+		}
+		return writePage(t, curPID, cur)
+	}
+	if right != nil && borrowFromRightInternal(parent, ci, right, cur) {
+		if err := writePage(t, rightPID, right); err != nil {
+			return err
+		}
+		return writePage(t, curPID, cur)
 	}
 
-	return DeleteResult{Underflow: true}, nil
+	return nil
 }
 
-func borrowFromLeftInternal(parent *disk.InternalPage, idx int, left *disk.InternalPage, cur *disk.InternalPage) {
-	// 1. shift cur keys + children right by 1
-	for i := int(cur.NKeys); i > 0; i-- {
-		cur.Keys[i] = cur.Keys[i-1]
+// lockSiblings write-latches the children next to parent.Children[ci].
+// The parent is latched by the caller, so this keeps the top-down order.
+// The returned function releases them.
+func (t *BPlusTree) lockSiblings(parent *disk.InternalPage, ci int) func() {
+	var pids []uint64
+	if ci > 0 {
+		pids = append(pids, parent.Children[ci-1])
 	}
-	for i := int(cur.NKeys) + 1; i > 0; i-- {
-		cur.Children[i] = cur.Children[i-1]
+	if ci+1 < len(parent.Children) {
+		pids = append(pids, parent.Children[ci+1])
 	}
 
-	// 2. bring separator key from parent down to cur
-	cur.Keys[0] = parent.Keys[idx-1]
-	cur.Children[0] = left.Children[left.NKeys]
-	cur.NKeys++
-
-	// 3. move left's last key up to parent
-	parent.Keys[idx-1] = left.Keys[left.NKeys-1]
-
-	left.Keys[left.NKeys-1] = disk.KeyEntry{}
-	left.Children[left.NKeys] = 0
-
-	// 4. shrink left
-	left.NKeys--
+	for _, pid := range pids {
+		t.latches.lock(pid)
+	}
+	return func() {
+		for _, pid := range pids {
+			t.latches.unlock(pid)
+		}
+	}
 }
 
-func borrowFromRightInternal(parent *disk.InternalPage, idx int, right *disk.InternalPage, cur *disk.InternalPage) {
-	// 1. bring separator key from parent down
-	cur.Keys[cur.NKeys] = parent.Keys[idx]
-	cur.Children[cur.NKeys+1] = right.Children[0]
-	cur.NKeys++
+func internalMergeSize(left *disk.InternalPage, sep *disk.KeyEntry, right *disk.InternalPage) int {
+	return left.Size() + sep.CellSize() + right.Size() - disk.INTERNAL_HEADER_SIZE
+}
 
-	// 2. move right's first key up to parent
-	parent.Keys[idx] = right.Keys[0]
+// borrowFromLeftInternal rotates keys from left through the parent into
+// cur (at parent.Children[idx]) until cur is no longer under-full.
+func borrowFromLeftInternal(parent *disk.InternalPage, idx int, left *disk.InternalPage, cur *disk.InternalPage) bool {
+	moved := false
+	for cur.IsUnderflow() {
+		last := left.NKeys() - 1
+		if last < 0 || !left.CanLend(last) || !parent.CanReplaceKey(idx-1, &left.Keys[last]) {
+			break
+		}
 
-	// 3. shift right left
-	for i := 0; i < int(right.NKeys)-1; i++ {
-		right.Keys[i] = right.Keys[i+1]
-	}
-	for i := 0; i < int(right.NKeys); i++ {
-		right.Children[i] = right.Children[i+1]
-	}
+		// 1. bring separator key from parent down to the front of cur
+		cur.Keys = append([]disk.KeyEntry{parent.Keys[idx-1]}, cur.Keys...)
+		cur.Children = append([]uint64{left.Children[last+1]}, cur.Children...)
 
-	right.Keys[right.NKeys-1] = disk.KeyEntry{}
-	right.Children[right.NKeys] = 0
+		// 2. move left's last key up to parent
+		parent.Keys[idx-1] = left.Keys[last]
 
-	right.NKeys--
+		// 3. shrink left
+		left.Keys = left.Keys[:last]
+		left.Children = left.Children[:last+1]
+		moved = true
+	}
+	return moved
 }
 
-func borrowFromLeftLeaf(left *disk.LeafPage, curr *disk.LeafPage, parent *disk.InternalPage, parentKeyIdx int) {
-	// left must have >= min+1 kv
-
-	// 1. shift curr right to make space at front
-	for i := int(curr.NKV); i > 0; i-- {
-		curr.KVs[i] = curr.KVs[i-1]
-	}
+// borrowFromRightInternal rotates keys from right through the parent into
+// cur (at parent.Children[idx]) until cur is no longer under-full.
+func borrowFromRightInternal(parent *disk.InternalPage, idx int, right *disk.InternalPage, cur *disk.InternalPage) bool {
+	moved := false
+	for cur.IsUnderflow() {
+		if right.NKeys() == 0 || !right.CanLend(0) || !parent.CanReplaceKey(idx, &right.Keys[0]) {
+			break
+		}
 
-	// 2. move last kv from left to curr[0]
-	curr.KVs[0] = left.KVs[left.NKV-1]
-	curr.NKV++
+		// 1. bring separator key from parent down to the end of cur
+		cur.Keys = append(cur.Keys, parent.Keys[idx])
+		cur.Children = append(cur.Children, right.Children[0])
 
-	// clear left slot
-	left.KVs[left.NKV-1] = disk.KeyVal{}
-	left.NKV--
+		// 2. move right's first key up to parent
+		parent.Keys[idx] = right.Keys[0]
 
-	// 3. update separator key in parent
-	// separator = first key of curr
-	parent.Keys[parentKeyIdx] = *disk.NewKeyEntryFromKeyVal(&curr.KVs[0])
+		// 3. shift right left
+		right.Keys = right.Keys[1:]
+		right.Children = right.Children[1:]
+		moved = true
+	}
+	return moved
 }
-func mergeInternal(parent *disk.InternalPage, idx int, left *disk.InternalPage, cur *disk.InternalPage) {
-	// 1. bring separator key down from parent to left
-	left.Keys[left.NKeys] = parent.Keys[idx-1]
-	left.NKeys++
 
-	// 2. copy cur keys into left
-	for i := 0; i < int(cur.NKeys); i++ {
-		left.Keys[left.NKeys] = cur.Keys[i]
-		left.NKeys++
-	}
+// borrowFromLeftLeaf moves the last entries of left to the front of curr
+// until curr is no longer under-full.
+func borrowFromLeftLeaf(left *disk.LeafPage, curr *disk.LeafPage, parent *disk.InternalPage, parentKeyIdx int) bool {
+	moved := false
+	for curr.IsUnderflow() {
+		last := len(left.KVs) - 1
+		if last < 0 || !left.CanLend(last) {
+			break
+		}
 
-	// 3. copy cur children into left
-	base := int(left.NKeys) - int(cur.NKeys) - 1
-	for i := 0; i <= int(cur.NKeys); i++ {
-		left.Children[base+1+i] = cur.Children[i]
-	}
+		// separator = first key of curr after the move
+		sep := disk.NewKeyEntryFromKeyVal(&left.KVs[last])
+		if !parent.CanReplaceKey(parentKeyIdx, sep) {
+			break
+		}
 
-	// 4. remove key idx-1 and child idx from parent
-	for i := idx - 1; i < int(parent.NKeys)-1; i++ {
-		parent.Keys[i] = parent.Keys[i+1]
+		curr.KVs = append([]disk.KeyVal{left.KVs[last]}, curr.KVs...)
+		left.KVs = left.KVs[:last]
+		parent.Keys[parentKeyIdx] = *sep
+		moved = true
 	}
-	for i := idx; i < int(parent.NKeys); i++ {
-		parent.Children[i] = parent.Children[i+1]
-	}
-
-	parent.NKeys--
-
-	parent.Keys[parent.NKeys] = disk.KeyEntry{}
-	parent.Children[parent.NKeys+1] = 0
+	return moved
 }
 
-func borrowFromRightLeaf(curr *disk.LeafPage, right *disk.LeafPage, parent *disk.InternalPage, parentKeyIdx int) {
-	// right must have >= min+1 kv
+// borrowFromRightLeaf moves the first entries of right to the end of curr
+// until curr is no longer under-full.
+func borrowFromRightLeaf(curr *disk.LeafPage, right *disk.LeafPage, parent *disk.InternalPage, parentKeyIdx int) bool {
+	moved := false
+	for curr.IsUnderflow() {
+		if len(right.KVs) < 2 || !right.CanLend(0) {
+			break
+		}
 
-	// 1. move first kv from right to end of curr
-	curr.KVs[curr.NKV] = right.KVs[0]
-	curr.NKV++
+		// separator = first key of right after the move
+		sep := disk.NewKeyEntryFromKeyVal(&right.KVs[1])
+		if !parent.CanReplaceKey(parentKeyIdx, sep) {
+			break
+		}
 
-	// 2. shift right left
-	for i := 0; i < int(right.NKV)-1; i++ {
-		right.KVs[i] = right.KVs[i+1]
+		curr.KVs = append(curr.KVs, right.KVs[0])
+		right.KVs = right.KVs[1:]
+		parent.Keys[parentKeyIdx] = *sep
+		moved = true
 	}
+	return moved
+}
 
-	right.KVs[right.NKV-1] = disk.KeyVal{}
-	right.NKV--
+// mergeInternal appends cur (at parent.Children[idx]) to left, pulling the
+// separator key down from the parent.
+func mergeInternal(parent *disk.InternalPage, idx int, left *disk.InternalPage, cur *disk.InternalPage) {
+	// 1. bring separator key down from parent to left
+	left.Keys = append(left.Keys, parent.Keys[idx-1])
+
+	// 2. copy cur keys and children into left
+	left.Keys = append(left.Keys, cur.Keys...)
+	left.Children = append(left.Children, cur.Children...)
 
-	// 3. update separator key in parent
-	// separator = first key of right
-	parent.Keys[parentKeyIdx] = *disk.NewKeyEntryFromKeyVal(&right.KVs[0])
+	// 3. remove key idx-1 and child idx from parent
+	parent.DelKVAtPos(idx - 1)
 }
 
+// mergeLeaf appends cur (at parent.Children[idx]) to left
 func mergeLeaf(parent *disk.InternalPage, idx int, left *disk.LeafPage, cur *disk.LeafPage) {
 	// 1. append all kvs from cur into left
-	for i := 0; i < int(cur.NKV); i++ {
-		left.KVs[left.NKV] = cur.KVs[i]
-		left.NKV++
-	}
+	left.KVs = append(left.KVs, cur.KVs...)
 
 	// 2. link leaf chain
 	left.Header.NextPagePointer = cur.Header.NextPagePointer
 
-	// 3. remove separator key (idx - 1) from parent
-	for i := idx - 1; i < int(parent.NKeys)-1; i++ {
-		parent.Keys[i] = parent.Keys[i+1]
-	}
-
-	// 4. remove child pointer (cur) from parent
-	for i := idx; i < int(parent.NKeys); i++ {
-		parent.Children[i] = parent.Children[i+1]
-	}
-
-	parent.Children[parent.NKeys] = 0
-	parent.NKeys--
-}
-
-func updateSeparatorKey(parent *disk.InternalPage, childIdx int, newKey *disk.KeyEntry) {
-	// parent.Keys[i] separates Children[i] | Children[i+1]
-	// so update key at childIdx-1
-	if childIdx > 0 {
-		parent.Keys[childIdx-1] = *newKey
-	}
-}
-
-func writePage(t *BPlusTree, pid uint64, buf []byte, node disk.Page) error {
-	writer := bytes.NewBuffer(buf[:0])
-	if err := node.WriteToBuffer(writer); err != nil {
-		return err
-	}
-	return t.pager.FlushPage(pid)
+	// 3. remove separator key (idx - 1) and child pointer (cur) from parent
+	parent.DelKVAtPos(idx - 1)
 }
@@ -0,0 +1,310 @@
+package bptree_disk
+
+import (
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+)
+
+// rangeResult reports what deleting a range did to a subtree
+type rangeResult struct {
+	Removed   int  // number of keys deleted
+	Empty     bool // nothing is left; the caller frees the page
+	Underflow bool
+}
+
+// DeleteRange removes every key in [start, end) and returns how many were
+// removed. A nil start or end leaves that side unbounded.
+// Subtrees that lie entirely inside the range are released without being
+// rewritten; only the pages on the paths to the two boundaries are updated
+// and rebalanced, and the result is made durable by a single commit.
+// Other operations wait until the deletion is done. A logged tree returns
+// ErrLoggedTree, as the deletion is not in its log.
+func (t *BPlusTree) DeleteRange(start, end []byte) (int, error) {
+	if t.logged {
+		return 0, ErrLoggedTree
+	}
+
+	t.treeLatch.Lock()
+	defer t.treeLatch.Unlock()
+
+	if start != nil && end != nil && t.cmp.Compare(start, end) >= 0 {
+		return 0, nil
+	}
+
+	rootPID, err := t.rootPID()
+	if err != nil {
+		return 0, err
+	}
+
+	r := &rangeDeleter{tree: t, keyRange: keyRange{start, end, t.cmp}}
+	res, err := r.deleteRecursive(rootPID, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	if res.Removed == 0 {
+		return 0, nil
+	}
+
+	// Everything is gone: start over with an empty leaf
+	if res.Empty {
+		t.pager.FreePage(rootPID)
+		if rootPID, err = t.newPage(t.newLeaf()); err != nil {
+			return 0, err
+		}
+		if err := t.setRootPID(rootPID); err != nil {
+			return 0, err
+		}
+	}
+
+	// root shrink: promote the only child until the root has a key again
+	for {
+		root, err := t.loadNode(rootPID)
+		if err != nil {
+			return 0, err
+		}
+		internal, ok := root.(*disk.InternalPage)
+		if !ok || internal.NKeys() > 0 {
+			break
+		}
+		t.pager.FreePage(rootPID)
+		rootPID = internal.Children[0]
+		if err := t.setRootPID(rootPID); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := t.relinkLeaves(start); err != nil {
+		return 0, err
+	}
+	return res.Removed, t.commit()
+}
+
+// keyRange is the half-open key range [start, end); nil bounds are unbounded
+type keyRange struct {
+	start, end []byte
+	cmp        *disk.Comparator
+}
+
+// covers reports whether the key range [lo, hi) of a node lies inside the
+// range. nil bounds are unbounded.
+func (r keyRange) covers(lo, hi []byte) bool {
+	startOK := r.start == nil || (lo != nil && r.cmp.Compare(lo, r.start) >= 0)
+	endOK := r.end == nil || (hi != nil && r.cmp.Compare(hi, r.end) <= 0)
+	return startOK && endOK
+}
+
+// disjoint reports whether [lo, hi) does not overlap the range
+func (r keyRange) disjoint(lo, hi []byte) bool {
+	before := r.start != nil && hi != nil && r.cmp.Compare(hi, r.start) <= 0
+	after := r.end != nil && lo != nil && r.cmp.Compare(lo, r.end) >= 0
+	return before || after
+}
+
+// bounds returns the positions [from, to) of the leaf entries in the range
+func (r keyRange) bounds(leaf *disk.LeafPage) (int, int) {
+	from, to := 0, len(leaf.KVs)
+	if r.start != nil {
+		from = leaf.LowerBound(disk.NewKeyEntryFromBytes(r.start))
+	}
+	if r.end != nil {
+		to = leaf.LowerBound(disk.NewKeyEntryFromBytes(r.end))
+	}
+	return from, to
+}
+
+type rangeDeleter struct {
+	tree *BPlusTree
+	keyRange
+}
+
+// deleteRecursive deletes the range from the node at pid, whose keys are
+// bounded by [lo, hi)
+func (r *rangeDeleter) deleteRecursive(pid uint64, lo, hi []byte) (rangeResult, error) {
+	t := r.tree
+
+	node, err := t.loadNode(pid)
+	if err != nil {
+		return rangeResult{}, err
+	}
+
+	// ================= LEAF =================
+	if node.IsLeaf() {
+		leaf := node.(*disk.LeafPage)
+
+		from, to := r.bounds(leaf)
+		if from >= to {
+			return rangeResult{}, nil
+		}
+
+		for _, kv := range leaf.KVs[from:to] {
+			if err := t.freeOverflow(kv.Overflow); err != nil {
+				return rangeResult{}, err
+			}
+		}
+		leaf.KVs = append(leaf.KVs[:from], leaf.KVs[to:]...)
+
+		res := rangeResult{Removed: to - from, Empty: len(leaf.KVs) == 0}
+		if res.Empty {
+			return res, nil
+		}
+		res.Underflow = leaf.IsUnderflow()
+		return res, writePage(t, pid, leaf)
+	}
+
+	// ================= INTERNAL =================
+	internal := node.(*disk.InternalPage)
+
+	var res rangeResult
+	keys := make([]disk.KeyEntry, 0, len(internal.Keys))
+	children := make([]uint64, 0, len(internal.Children))
+	var boundary []int // kept children that were partly deleted
+
+	for i, child := range internal.Children {
+		clo, chi := lo, hi
+		if i > 0 {
+			clo = internal.Keys[i-1].Key
+		}
+		if i < len(internal.Keys) {
+			chi = internal.Keys[i].Key
+		}
+
+		keep, touched := true, false
+		switch {
+		case r.disjoint(clo, chi):
+		case r.covers(clo, chi):
+			n, err := t.dropSubtree(child)
+			if err != nil {
+				return rangeResult{}, err
+			}
+			res.Removed += n
+			keep = false
+		default:
+			sub, err := r.deleteRecursive(child, clo, chi)
+			if err != nil {
+				return rangeResult{}, err
+			}
+			res.Removed += sub.Removed
+			if sub.Empty {
+				t.pager.FreePage(child)
+				keep = false
+			}
+			touched = sub.Removed > 0
+		}
+
+		if !keep {
+			continue
+		}
+		// the lower bound of a child separates it from the kept one before
+		if len(children) > 0 {
+			keys = append(keys, disk.KeyEntry{Key: clo})
+		}
+		if touched {
+			boundary = append(boundary, len(children))
+		}
+		children = append(children, child)
+	}
+
+	if res.Removed == 0 {
+		return res, nil
+	}
+	if len(children) == 0 {
+		res.Empty = true
+		return res, nil
+	}
+	internal.Keys, internal.Children = keys, children
+
+	// ================= ONE REBALANCING PASS =================
+	// Right to left, so merges never shift the children still to visit
+	for i := len(boundary) - 1; i >= 0; i-- {
+		if err := t.rebalance(internal, boundary[i]); err != nil {
+			return rangeResult{}, err
+		}
+	}
+
+	if err := writePage(t, pid, internal); err != nil {
+		return rangeResult{}, err
+	}
+	res.Underflow = internal.IsUnderflow() || internal.NKeys() == 0
+	return res, nil
+}
+
+// dropSubtree frees every page of the subtree at pid, including overflow
+// chains, and returns the number of keys it held
+func (t *BPlusTree) dropSubtree(pid uint64) (int, error) {
+	node, err := t.loadNode(pid)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	switch node := node.(type) {
+	case *disk.LeafPage:
+		for _, kv := range node.KVs {
+			if err := t.freeOverflow(kv.Overflow); err != nil {
+				return 0, err
+			}
+		}
+		n = len(node.KVs)
+	case *disk.InternalPage:
+		for _, child := range node.Children {
+			c, err := t.dropSubtree(child)
+			if err != nil {
+				return 0, err
+			}
+			n += c
+		}
+	}
+
+	t.pager.FreePage(pid)
+	return n, nil
+}
+
+// relinkLeaves repairs the sibling pointers around the leaf that covers key.
+// After DeleteRange this is the only place where a leaf can still point at
+// a freed page.
+func (t *BPlusTree) relinkLeaves(key []byte) error {
+	cur, err := t.leafFor(key, seekGE)
+	if err != nil {
+		return err
+	}
+
+	// link the previous leaf to this one
+	if cur.lo != nil {
+		prev, err := t.leafFor(cur.lo, seekBefore)
+		if err != nil {
+			return err
+		}
+		if prev.leaf.Header.NextPagePointer != cur.pid {
+			prev.leaf.Header.NextPagePointer = cur.pid
+			if err := writePage(t, prev.pid, prev.leaf); err != nil {
+				return err
+			}
+		}
+	}
+
+	// and this leaf to the next one, or to nothing
+	var nextPID uint64
+	if cur.hi != nil {
+		next, err := t.leafFor(cur.hi, seekGE)
+		if err != nil {
+			return err
+		}
+		nextPID = next.pid
+	}
+	if cur.leaf.Header.NextPagePointer != nextPID {
+		cur.leaf.Header.NextPagePointer = nextPID
+		return writePage(t, cur.pid, cur.leaf)
+	}
+	return nil
+}
+
+// leafFor is readLeaf for callers that already have the tree to themselves.
+// The leaf is decoded into pos.leaf and released.
+func (t *BPlusTree) leafFor(key []byte, mode seekMode) (*leafPos, error) {
+	pos, err := t.readLeaf(key, mode)
+	if err != nil {
+		return nil, err
+	}
+	pos.leaf = pos.view.Decode()
+	t.releaseLeaf(pos)
+	return pos, nil
+}
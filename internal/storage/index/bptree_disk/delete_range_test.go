@@ -0,0 +1,143 @@
+package bptree_disk
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkLeafChain follows the forward sibling pointers from the first leaf
+// and returns the number of keys reached
+func checkLeafChain(t *testing.T, tree *BPlusTree) int {
+	first, err := tree.leafFor(nil, seekGE)
+	require.NoError(t, err)
+
+	count := 0
+	pid := first.pid
+	for pid != 0 {
+		leaf, err := tree.loadLeaf(pid)
+		require.NoError(t, err)
+		count += len(leaf.KVs)
+		pid = leaf.Header.NextPagePointer
+	}
+	return count
+}
+
+func TestBPlusTree_DeleteRange(t *testing.T) {
+	tree := setupBPlusTree(t)
+
+	n := 3000
+	for i := 0; i < n; i++ {
+		k, v := seqKey(i)
+		require.NoError(t, tree.Set(k, v))
+	}
+
+	start, _ := seqKey(500)
+	end, _ := seqKey(2500)
+	removed, err := tree.DeleteRange(start, end)
+	require.NoError(t, err)
+	assert.Equal(t, 2000, removed)
+
+	assert.Equal(t, n-2000, checkTree(t, tree))
+	assert.Equal(t, n-2000, checkLeafChain(t, tree))
+
+	for i := 0; i < n; i++ {
+		k, v := seqKey(i)
+		kv, err := tree.Find(k)
+		if i >= 500 && i < 2500 {
+			assert.Error(t, err, "key %d", i)
+			continue
+		}
+		require.NoError(t, err, "key %d", i)
+		assert.Equal(t, v, kv.Val)
+	}
+
+	// The tree keeps working, and reuses the freed pages
+	free := freeCount(tree)
+	assert.Greater(t, free, 0)
+	for i := 500; i < 1000; i++ {
+		k, v := seqKey(i)
+		require.NoError(t, tree.Set(k, v))
+	}
+	assert.Less(t, freeCount(tree), free)
+	assert.Equal(t, n-1500, checkTree(t, tree))
+}
+
+func TestBPlusTree_DeleteRange_Unbounded(t *testing.T) {
+	tree := setupBPlusTree(t)
+
+	for i := 0; i < 1000; i++ {
+		k, v := seqKey(i)
+		require.NoError(t, tree.Set(k, v))
+	}
+
+	// Everything before a key
+	end, _ := seqKey(100)
+	removed, err := tree.DeleteRange(nil, end)
+	require.NoError(t, err)
+	assert.Equal(t, 100, removed)
+
+	// Everything from a key on
+	start, _ := seqKey(900)
+	removed, err = tree.DeleteRange(start, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 100, removed)
+
+	assert.Equal(t, 800, checkTree(t, tree))
+	assert.Equal(t, 800, checkLeafChain(t, tree))
+
+	it := tree.SeekGE(nil)
+	first, _ := seqKey(100)
+	assert.Equal(t, first, it.Deref().Key)
+	it = tree.SeekLast()
+	last, _ := seqKey(899)
+	assert.Equal(t, last, it.Deref().Key)
+
+	// An empty range removes nothing
+	removed, err = tree.DeleteRange(start, end)
+	require.NoError(t, err)
+	assert.Equal(t, 0, removed)
+}
+
+func TestBPlusTree_DeleteRange_All(t *testing.T) {
+	tree := setupBPlusTree(t)
+
+	for i := 1; i <= 30; i++ {
+		k, _ := kv(i)
+		require.NoError(t, tree.Set(k, largeVal(i)))
+	}
+	next, _, _ := tree.pager.Allocator().Snapshot()
+
+	removed, err := tree.DeleteRange(nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 30, removed)
+	assert.Equal(t, 0, checkTree(t, tree))
+
+	// Every page but the meta page and the new root is free, overflow
+	// chains included
+	assert.Equal(t, int(next)-2, freeCount(tree))
+
+	k, v := kv(1)
+	require.NoError(t, tree.Set(k, v))
+	kv, err := tree.Find(k)
+	require.NoError(t, err)
+	assert.Equal(t, v, kv.Val)
+}
+
+func TestBPlusTree_DeleteRange_Logged(t *testing.T) {
+	tree, err := OpenWithOptions(filepath.Join(t.TempDir(), "logged.db"), Options{Logged: true})
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for i := 1; i <= 10; i++ {
+		k, v := kv(i)
+		require.NoError(t, tree.Set(k, v))
+	}
+
+	removed, err := tree.DeleteRange(nil, nil)
+	assert.ErrorIs(t, err, ErrLoggedTree)
+	assert.Equal(t, 0, removed)
+	assert.Equal(t, 10, checkLeafChain(t, tree))
+}
@@ -2,7 +2,9 @@ package bptree_disk
 
 import (
 	"bytes"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -15,24 +17,24 @@ func kv(key int) ([]byte, []byte) {
 	return []byte{byte(key)}, []byte{byte(key + 100)}
 }
 
+// bigVal returns a value large enough that only a few fit in one leaf
+func bigVal(i int) []byte {
+	return bytes.Repeat([]byte{byte(i)}, 500)
+}
+
 var fileName = "btree_disk.db"
 
 func setupBPlusTree(t *testing.T) *BPlusTree {
 	allocator := disk.NewFileAllocator()
-	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0666)
+	file, err := os.OpenFile(filepath.Join(t.TempDir(), fileName), os.O_RDWR|os.O_CREATE, 0666)
 	assert.NoError(t, err)
 	pager := disk.NewPager(file, allocator)
 	btree, err := NewBPlusTree(pager)
 	assert.Equal(t, nil, err)
 
-	meta := disk.NewMetaPage()
-	buf := make([]byte, disk.BLOCK_SIZE)
-	writer := bytes.NewBuffer(buf[:0])
-	err = meta.WriteToBuffer(writer)
-	assert.Equal(t, nil, err)
-
-	err = pager.FlushPage(btree.metaPID)
-	assert.Equal(t, nil, err)
+	t.Cleanup(func() {
+		btree.Close()
+	})
 	return btree
 }
 
@@ -42,22 +44,22 @@ func TestBPlusTree_Delete_Simple(t *testing.T) {
 
 	// insert
 	for i := 1; i <= 5; i++ {
-		kV := disk.NewKeyValFromInt(int64(i), int64(i))
-		require.NoError(t, tree.Insert(kV.Key[:], kV.Val[:]))
+		k, v := kv(i)
+		require.NoError(t, tree.Insert(k, v))
 	}
 
 	// delete
-	ok, err := tree.Del(disk.NewKeyEntryFromBytes([]byte{3}).Key[:])
+	ok, err := tree.Del([]byte{3})
 	require.NoError(t, err)
 	assert.True(t, ok)
 
 	// not found after delete
-	_, err = tree.Find(disk.NewKeyEntryFromBytes([]byte{3}).Key[:])
+	_, err = tree.Find([]byte{3})
 	assert.ErrorIs(t, err, disk.ErrKeyNotFound)
 
 	// others still exist
 	for _, i := range []int{1, 2, 4, 5} {
-		_, err := tree.Find(disk.NewKeyEntryFromBytes([]byte{byte(i)}).Key[:])
+		_, err := tree.Find([]byte{byte(i)})
 		require.NoError(t, err)
 	}
 }
@@ -68,7 +70,7 @@ func TestBPlusTree_Delete_NotFound(t *testing.T) {
 	k, v := kv(1)
 	require.NoError(t, tree.Insert(k, v))
 
-	ok, err := tree.Del(disk.NewKeyEntryFromBytes([]byte{2}).Key[:])
+	ok, err := tree.Del([]byte{2})
 	assert.NoError(t, err)
 	assert.False(t, ok)
 }
@@ -76,29 +78,70 @@ func TestBPlusTree_Delete_NotFound(t *testing.T) {
 func TestBPlusTree_Delete_MergeAndRootShrink(t *testing.T) {
 	tree := setupBPlusTree(t)
 
-	// Insert multiple keys to cause splits
+	// Insert multiple keys with large values to cause splits
 	numInserts := 20
 	for i := 1; i <= numInserts+1; i++ {
-		Kv := disk.NewKeyValFromInt(int64(i), int64(i))
-		require.NoError(t, tree.Insert(Kv.Key[:], Kv.Val[:]))
+		k, _ := kv(i)
+		require.NoError(t, tree.Insert(k, bigVal(i)))
 	}
 
+	rootPID, err := tree.rootPID()
+	require.NoError(t, err)
+	node, err := tree.loadNode(rootPID)
+	require.NoError(t, err)
+	require.False(t, node.IsLeaf(), "root should have split")
+
 	// Delete keys to cause merges and root shrink
 	for i := 1; i <= numInserts; i++ {
-		ok, err := tree.Del(disk.NewKeyEntryFromBytes([]byte{byte(i)}).Key[:])
+		ok, err := tree.Del([]byte{byte(i)})
 		require.NoError(t, err)
 		require.True(t, ok)
 	}
 
 	// Verify only the last key remains
-	_, err := tree.Find(disk.NewKeyEntryFromBytes([]byte{byte(numInserts + 1)}).Key[:])
+	got, err := tree.Find([]byte{byte(numInserts + 1)})
 	require.NoError(t, err)
+	assert.Equal(t, bigVal(numInserts+1), got.Val)
 
 	// Root should be a leaf now
-	rootPID, err := tree.rootPID()
+	rootPID, err = tree.rootPID()
 	require.NoError(t, err)
 
-	node, _, err := tree.loadNode(rootPID)
+	node, err = tree.loadNode(rootPID)
 	require.NoError(t, err)
 	assert.True(t, node.IsLeaf(), "root should shrink to leaf")
 }
+
+func TestBPlusTree_Delete_Many(t *testing.T) {
+	tree := setupBPlusTree(t)
+
+	// Enough entries for a three-level tree
+	n := 2000
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key-%05d", i)) }
+	for i := 0; i < n; i++ {
+		require.NoError(t, tree.Insert(key(i), bigVal(i)[:100]))
+	}
+
+	// Delete every other key, then the rest in reverse order
+	for i := 0; i < n; i += 2 {
+		ok, err := tree.Del(key(i))
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+	for i := 1; i < n; i += 2 {
+		got, err := tree.Find(key(i))
+		require.NoError(t, err)
+		assert.Equal(t, bigVal(i)[:100], got.Val)
+	}
+	for i := n - 1; i > 0; i -= 2 {
+		ok, err := tree.Del(key(i))
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+
+	rootPID, err := tree.rootPID()
+	require.NoError(t, err)
+	root, err := tree.loadLeaf(rootPID)
+	require.NoError(t, err)
+	assert.Empty(t, root.KVs)
+}
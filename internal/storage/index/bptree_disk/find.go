@@ -4,37 +4,23 @@ import (
 	"github.com/spaghetti-lover/go-db/internal/storage/disk"
 )
 
+// Find returns the entry for key, with a spilled value read back
 func (t *BPlusTree) Find(key []byte) (*disk.KeyVal, error) {
-	currPID, err := t.rootPID()
+	t.treeLatch.RLock()
+	defer t.treeLatch.RUnlock()
+
+	pos, err := t.readLeaf(key, seekGE)
 	if err != nil {
 		return nil, err
 	}
+	defer t.releaseLeaf(pos)
 
-	searchKV := disk.NewKeyValFromBytes(key, nil)
-
-	for {
-		node, _, err := t.loadNode(currPID)
-		if err != nil {
-			return nil, err
-		}
-
-		// Leaf
-		if node.IsLeaf() {
-			leaf := node.(*disk.LeafPage)
-
-			pos := leaf.FindLastLE(&searchKV)
-			if pos >= 0 && leaf.KVs[pos].Compare(&searchKV) == 0 {
-				kv := leaf.KVs[pos] // copy for consistency
-				return &kv, nil
-			}
-
-			return nil, disk.ErrKeyNotFound
-		}
-
-		// Internal
-		internal := node.(*disk.InternalPage)
-		pos := internal.FindLastLE(disk.NewKeyEntryFromKeyVal(&searchKV))
-
-		currPID = internal.Children[pos+1]
+	// binary search on the page itself; only the entry found is copied
+	i := pos.view.LowerBound(key)
+	if i < pos.view.NKeys() && t.cmp.Compare(pos.view.Key(i), key) == 0 {
+		cell := pos.view.Cell(i)
+		return t.materialize(&cell)
 	}
+
+	return nil, disk.ErrKeyNotFound
 }
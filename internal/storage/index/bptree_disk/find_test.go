@@ -1,10 +1,13 @@
 package bptree_disk
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/spaghetti-lover/go-db/internal/storage/disk"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestBPlusTree_Find_Simple(t *testing.T) {
@@ -22,7 +25,7 @@ func TestBPlusTree_Find_Simple(t *testing.T) {
 		k, v := kv(i)
 		kv, err := tree.Find(k)
 		assert.Equal(t, nil, err)
-		assert.Equal(t, v, kv.Val[len(kv.Val)-int(kv.ValLen):])
+		assert.Equal(t, v, kv.Val)
 	}
 
 }
@@ -49,3 +52,51 @@ func TestBPlusTree_Find_EmptyTree(t *testing.T) {
 	_, err := tree.Find([]byte{1})
 	assert.ErrorIs(t, err, disk.ErrKeyNotFound)
 }
+
+func TestBPlusTree_Find_CorruptPage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), fileName)
+
+	tree, err := Open(path)
+	require.NoError(t, err)
+	for i := 1; i <= 5; i++ {
+		k, v := kv(i)
+		require.NoError(t, tree.Insert(k, v))
+	}
+	rootPID, err := tree.rootPID()
+	require.NoError(t, err)
+	require.NoError(t, tree.Close())
+
+	// Damage the root leaf on disk
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	raw[disk.BlockOffset(rootPID, disk.BLOCK_SIZE)+disk.BLOCK_SIZE-1] ^= 0xFF
+	require.NoError(t, os.WriteFile(path, raw, 0666))
+
+	tree, err = Open(path)
+	require.NoError(t, err)
+	defer tree.Close()
+
+	k, _ := kv(1)
+	_, err = tree.Find(k)
+	var corrupt *disk.ErrPageCorrupt
+	require.ErrorAs(t, err, &corrupt)
+	assert.Equal(t, rootPID, corrupt.PageID)
+}
+
+func TestBPlusTree_Open_CorruptMeta(t *testing.T) {
+	path := filepath.Join(t.TempDir(), fileName)
+
+	tree, err := Open(path)
+	require.NoError(t, err)
+	require.NoError(t, tree.Close())
+
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	raw[disk.BLOCK_SIZE-1] ^= 0xFF
+	require.NoError(t, os.WriteFile(path, raw, 0666))
+
+	// A damaged meta page must not be mistaken for a fresh file
+	_, err = Open(path)
+	var corrupt *disk.ErrPageCorrupt
+	assert.ErrorAs(t, err, &corrupt)
+}
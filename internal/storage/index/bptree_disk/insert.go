@@ -1,7 +1,6 @@
 package bptree_disk
 
 import (
-	"bytes"
 	"fmt"
 
 	"github.com/spaghetti-lover/go-db/internal/storage/disk"
@@ -10,124 +9,77 @@ import (
 var ErrDuplicateKey = fmt.Errorf("duplicate key")
 
 func (t *BPlusTree) Insert(key, value []byte) error {
-	kv := disk.NewKeyValFromBytes(key, value)
-	keyEntry := disk.NewKeyEntryFromKeyVal(&kv)
+	t.beginWrite()
+	return t.endWrite(t.insert(key, value))
+}
 
-	rootPID, err := t.rootPID()
+func (t *BPlusTree) insert(key, value []byte) error {
+	kv, err := t.newCell(key, value)
 	if err != nil {
 		return err
 	}
+	keyEntry := disk.NewKeyEntryFromKeyVal(&kv)
 
-	res, err := t.insertRecursive(rootPID, keyEntry, &kv)
+	w, err := t.lockPath(key, t.insertSafe)
 	if err != nil {
+		t.freeOverflow(kv.Overflow)
 		return err
 	}
+	defer w.release()
 
-	// Root does not split → done
-	if !res.Split {
-		return t.pager.Sync()
-	}
-
-	// Root split → create new root
-	newRoot := disk.NewInternalPage()
-
-	// children: [oldRoot | newRight]
-	newRoot.Children[0] = rootPID
-	newRoot.Children[1] = res.NewPID
-	newRoot.Keys[0] = *res.PromoteKey
-	newRoot.NKeys = 1
-
-	// allocate page
-	newRootPID, buf := t.pager.NewPage()
-
-	writer := bytes.NewBuffer(buf[:0])
-	if err := newRoot.WriteToBuffer(writer); err != nil {
-		return err
-	}
-
-	if err := t.pager.FlushPage(newRootPID); err != nil {
+	res, err := t.insertRecursive(w.top(), keyEntry, &kv)
+	if err != nil {
+		// the entry never reached the tree
+		t.freeOverflow(kv.Overflow)
 		return err
 	}
 
-	if err := t.setRootPID(newRootPID); err != nil {
-		return err
+	// Root split → create new root. Only an unsafe root can split, and
+	// then the root latch is still held.
+	if res.Split {
+		if err := t.growRoot(w.top(), res); err != nil {
+			return err
+		}
 	}
-
-	return t.pager.Sync()
+	return nil
 }
 
 func (t *BPlusTree) insertRecursive(nodePID uint64, key *disk.KeyEntry, kv *disk.KeyVal) (InsertResult, error) {
-	node, buf, err := t.loadNode(nodePID)
+	// 1. Find child to descend, reading the page in place
+	_, childPID, isLeaf, err := t.route(nodePID, key.Key)
 	if err != nil {
 		return InsertResult{}, err
 	}
 
-	if node.IsLeaf() {
-		leaf := node.(*disk.LeafPage)
-
-		// Check duplicate key
-		for i := 0; i < int(leaf.NKV); i++ {
-			if leaf.KVs[i].Compare(kv) == 0 {
-				return InsertResult{}, ErrDuplicateKey
+	if isLeaf {
+		// Insert in place when the entry fits
+		var dup, fit bool
+		err := t.pager.UpdatePage(nodePID, func(buf []byte) bool {
+			leaf := t.leafView(buf)
+			pos := leaf.LowerBound(kv.Key)
+			if pos < leaf.NKeys() && t.cmp.Compare(leaf.Key(pos), kv.Key) == 0 {
+				dup = true
+				return false
 			}
-		}
-
-		// 1. Insert KV into leaf
-		leaf.InsertKV(kv)
-
-		// 2. No overflow
-		if !leaf.IsOverflow() {
-			writer := bytes.NewBuffer(buf[:0])
-			if err := leaf.WriteToBuffer(writer); err != nil {
-				return InsertResult{}, err
-			}
-			if err := t.pager.FlushPage(nodePID); err != nil {
-				return InsertResult{}, err
+			fit = leaf.Insert(pos, kv)
+			return fit
+		})
+		if err != nil || dup || fit {
+			if dup {
+				err = ErrDuplicateKey
 			}
-
-			return InsertResult{Split: false}, nil
-		}
-
-		// 3. Overflow → split
-		// ASSUME:
-		//   rightLeaf, promoteKey := leaf.Split()
-		//   promoteKey is FIRST KEY of right leaf
-		rightLeaf, promoteKey := leaf.Split()
-		rightPID, rightBuf := t.pager.NewPage()
-		oldNext := leaf.Header.NextPagePointer
-		leaf.Header.NextPagePointer = rightPID
-		rightLeaf.Header.NextPagePointer = oldNext
-		rightWriter := bytes.NewBuffer(rightBuf[:0])
-		if err := rightLeaf.WriteToBuffer(rightWriter); err != nil {
-			return InsertResult{}, err
-		}
-
-		// write left leaf back
-		leftWriter := bytes.NewBuffer(buf[:0])
-		if err := leaf.WriteToBuffer(leftWriter); err != nil {
 			return InsertResult{}, err
 		}
 
-		if err := t.pager.FlushPage(nodePID); err != nil {
+		// Otherwise insert KV into the decoded leaf and split it
+		leaf, err := t.loadLeaf(nodePID)
+		if err != nil {
 			return InsertResult{}, err
 		}
-		if err := t.pager.FlushPage(rightPID); err != nil {
-			return InsertResult{}, err
-		}
-
-		return InsertResult{
-			Split:      true,
-			PromoteKey: promoteKey,
-			NewPID:     rightPID,
-		}, nil
+		leaf.InsertKV(kv)
+		return t.writeLeaf(nodePID, leaf)
 	}
 
-	internal := node.(*disk.InternalPage)
-
-	// 1. Find child to descend
-	idx := internal.FindLastLE(key)
-	childPID := internal.Children[idx+1]
-
 	// 2. Recurse
 	res, err := t.insertRecursive(childPID, key, kv)
 	if err != nil {
@@ -139,43 +91,54 @@ func (t *BPlusTree) insertRecursive(nodePID uint64, key *disk.KeyEntry, kv *disk
 		return InsertResult{Split: false}, nil
 	}
 
-	// 4. absorb promoted key from child
+	// 4. absorb promoted key from child, split if it no longer fits
+	internal, err := t.loadInternal(nodePID)
+	if err != nil {
+		return InsertResult{}, err
+	}
 	internal.InsertKV(res.PromoteKey, res.NewPID)
+	return t.writeInternal(nodePID, internal)
+}
 
-	// 5. no overflow
-	if !internal.IsOverflow() {
-		writer := bytes.NewBuffer(buf[:0])
-		if err := internal.WriteToBuffer(writer); err != nil {
-			return InsertResult{}, err
-		}
-		if err := t.pager.FlushPage(nodePID); err != nil {
-			return InsertResult{}, err
-		}
-
-		return InsertResult{Split: false}, nil
+// writeLeaf writes a leaf back, splitting it first if it overflows.
+// The promoted key is the first key of the new right leaf.
+func (t *BPlusTree) writeLeaf(pid uint64, leaf *disk.LeafPage) (InsertResult, error) {
+	if !leaf.IsOverflow() {
+		return InsertResult{Split: false}, writePage(t, pid, leaf)
 	}
 
-	// 6. overflow → split
-	// ASSUME:
-	//   rightInternal, promoteKey := internal.Split()
-	rightInternal, promoteKey := internal.Split()
-
-	rightPID, rightBuf := t.pager.NewPage()
-	rightWriter := bytes.NewBuffer(rightBuf[:0])
-	if err := rightInternal.WriteToBuffer(rightWriter); err != nil {
+	rightLeaf, promoteKey := leaf.Split()
+	rightPID, err := t.newPage(rightLeaf)
+	if err != nil {
 		return InsertResult{}, err
 	}
+	leaf.Header.NextPagePointer = rightPID
 
-	// write left internal back
-	leftWriter := bytes.NewBuffer(buf[:0])
-	if err := internal.WriteToBuffer(leftWriter); err != nil {
+	if err := writePage(t, pid, leaf); err != nil {
 		return InsertResult{}, err
 	}
 
-	if err := t.pager.FlushPage(nodePID); err != nil {
+	return InsertResult{
+		Split:      true,
+		PromoteKey: promoteKey,
+		NewPID:     rightPID,
+	}, nil
+}
+
+// writeInternal writes an internal node back, splitting it first if it
+// overflows. The middle key moves up to the parent.
+func (t *BPlusTree) writeInternal(pid uint64, internal *disk.InternalPage) (InsertResult, error) {
+	if !internal.IsOverflow() {
+		return InsertResult{Split: false}, writePage(t, pid, internal)
+	}
+
+	rightInternal, promoteKey := internal.Split()
+	rightPID, err := t.newPage(rightInternal)
+	if err != nil {
 		return InsertResult{}, err
 	}
-	if err := t.pager.FlushPage(rightPID); err != nil {
+
+	if err := writePage(t, pid, internal); err != nil {
 		return InsertResult{}, err
 	}
 
@@ -185,3 +148,19 @@ func (t *BPlusTree) insertRecursive(nodePID uint64, key *disk.KeyEntry, kv *disk
 		NewPID:     rightPID,
 	}, nil
 }
+
+// growRoot puts a new internal root above a root that split
+func (t *BPlusTree) growRoot(rootPID uint64, res InsertResult) error {
+	newRoot := t.newInternal()
+
+	// children: [oldRoot | newRight]
+	newRoot.Children = []uint64{rootPID, res.NewPID}
+	newRoot.Keys = []disk.KeyEntry{*res.PromoteKey}
+
+	newRootPID, err := t.newPage(newRoot)
+	if err != nil {
+		return err
+	}
+
+	return t.setRootPID(newRootPID)
+}
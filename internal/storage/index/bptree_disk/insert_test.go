@@ -1,9 +1,14 @@
 package bptree_disk
 
 import (
+	"bytes"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
 )
 
 func TestBPlusTree_Insert_Simple(t *testing.T) {
@@ -21,7 +26,7 @@ func TestBPlusTree_Insert_Simple(t *testing.T) {
 		k, v := kv(i)
 		kv, err := tree.Find(k)
 		assert.Equal(t, nil, err)
-		assert.Equal(t, v, kv.Val[len(kv.Val)-int(kv.ValLen):])
+		assert.Equal(t, v, kv.Val)
 	}
 }
 
@@ -57,6 +62,37 @@ func TestBPlusTree_Insert_CauseSplit(t *testing.T) {
 		k, v := kv(i)
 		kv, err := tree.Find(k)
 		assert.Equal(t, nil, err)
-		assert.Equal(t, v, kv.Val[len(kv.Val)-int(kv.ValLen):])
+		assert.Equal(t, v, kv.Val)
+	}
+}
+
+func TestBPlusTree_Insert_VariableLength(t *testing.T) {
+	tree := setupBPlusTree(t)
+
+	// Key and value sizes vary so leaves and internal pages split by bytes
+	entry := func(i int) ([]byte, []byte) {
+		k := []byte(fmt.Sprintf("%s-%05d", bytes.Repeat([]byte{'k'}, i%200), i))
+		v := bytes.Repeat([]byte{byte(i)}, i%700)
+		return k, v
+	}
+
+	numInserts := 3000
+	for i := 0; i < numInserts; i++ {
+		k, v := entry(i)
+		require.NoError(t, tree.Insert(k, v))
 	}
-}
\ No newline at end of file
+
+	for i := 0; i < numInserts; i++ {
+		k, v := entry(i)
+		kv, err := tree.Find(k)
+		require.NoError(t, err)
+		assert.Equal(t, v, kv.Val)
+	}
+}
+
+func TestBPlusTree_Insert_TooLarge(t *testing.T) {
+	tree := setupBPlusTree(t)
+
+	err := tree.Insert(bytes.Repeat([]byte{1}, disk.MAX_KEY_SIZE+1), nil)
+	assert.ErrorIs(t, err, disk.ErrKeyTooLarge)
+}
@@ -0,0 +1,232 @@
+package bptree_disk
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+)
+
+// pageLatch is the reader/writer latch of one tree page
+type pageLatch struct {
+	sync.RWMutex
+	refs int // goroutines holding or waiting for the latch
+}
+
+// latchTable hands out page latches. An entry only exists while some
+// goroutine holds or waits for it, so freed pages leave nothing behind.
+type latchTable struct {
+	mu      sync.Mutex
+	latches map[uint64]*pageLatch
+}
+
+func (lt *latchTable) acquire(pid uint64) *pageLatch {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	if lt.latches == nil {
+		lt.latches = make(map[uint64]*pageLatch)
+	}
+	l, ok := lt.latches[pid]
+	if !ok {
+		l = &pageLatch{}
+		lt.latches[pid] = l
+	}
+	l.refs++
+	return l
+}
+
+func (lt *latchTable) release(pid uint64) *pageLatch {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	l := lt.latches[pid]
+	l.refs--
+	if l.refs == 0 {
+		delete(lt.latches, pid)
+	}
+	return l
+}
+
+func (lt *latchTable) rlock(pid uint64)   { lt.acquire(pid).RLock() }
+func (lt *latchTable) lock(pid uint64)    { lt.acquire(pid).Lock() }
+func (lt *latchTable) runlock(pid uint64) { lt.release(pid).RUnlock() }
+func (lt *latchTable) unlock(pid uint64)  { lt.release(pid).Unlock() }
+
+// maxSeparatorSize is the largest cell a split can add to an internal page
+var maxSeparatorSize = disk.NewKeyEntryFromBytes(make([]byte, disk.MAX_KEY_SIZE)).CellSize()
+
+// insertSafe reports whether the page in buf can take one more entry, or
+// a grown entry, without splitting. Latches above a safe page can be
+// released.
+func (t *BPlusTree) insertSafe(buf []byte) bool {
+	switch disk.PageTypeOf(buf) {
+	case disk.PageTypeLeaf:
+		return t.leafView(buf).Size()+disk.MaxLeafCellSize(t.blockSize) <= t.blockSize
+	case disk.PageTypeInternal:
+		return t.internalView(buf).Size()+maxSeparatorSize <= t.blockSize
+	}
+	return false
+}
+
+// deleteSafe reports whether the page in buf can lose one entry without
+// needing to be rebalanced
+func (t *BPlusTree) deleteSafe(buf []byte) bool {
+	minFill := disk.MinFill(t.blockSize)
+	switch disk.PageTypeOf(buf) {
+	case disk.PageTypeLeaf:
+		v := t.leafView(buf)
+		return v.NKeys() > 1 && v.Size()-disk.MaxLeafCellSize(t.blockSize) >= minFill
+	case disk.PageTypeInternal:
+		v := t.internalView(buf)
+		return v.NKeys() > 1 && v.Size()-maxSeparatorSize >= minFill
+	}
+	return false
+}
+
+// readLeaf descends to the leaf for key with read-latch crabbing: the latch
+// of a child is taken before the one of its parent is released. Internal
+// pages are read in place. The leaf is returned read-latched and pinned,
+// for pos.view to read it; the caller releases it with releaseLeaf.
+func (t *BPlusTree) readLeaf(key []byte, mode seekMode) (*leafPos, error) {
+	t.rootLatch.RLock()
+	pid := t.meta.RootPID
+	t.latches.rlock(pid)
+	t.rootLatch.RUnlock()
+
+	pos := &leafPos{}
+	for {
+		buf, err := t.pager.FetchPage(pid)
+		if err != nil {
+			t.latches.runlock(pid)
+			return nil, err
+		}
+
+		switch disk.PageTypeOf(buf) {
+		case disk.PageTypeLeaf:
+			pos.pid, pos.view = pid, t.leafView(buf)
+			return pos, nil
+		case disk.PageTypeInternal:
+		default:
+			t.pager.UnpinPage(pid, false)
+			t.latches.runlock(pid)
+			return nil, fmt.Errorf("page %d is not a tree page", pid)
+		}
+
+		// the bounds outlive the pin, so they are copied
+		internal := t.internalView(buf)
+		ci := mode.child(internal, key, t.cmp)
+		if ci > 0 {
+			pos.lo = append([]byte{}, internal.Key(ci-1)...)
+		}
+		if ci < internal.NKeys() {
+			pos.hi = append([]byte{}, internal.Key(ci)...)
+		}
+		child := internal.Child(ci)
+		t.pager.UnpinPage(pid, false)
+
+		t.latches.rlock(child)
+		t.latches.runlock(pid)
+		pid = child
+	}
+}
+
+// releaseLeaf unpins and unlatches a leaf returned by readLeaf
+func (t *BPlusTree) releaseLeaf(pos *leafPos) {
+	t.pager.UnpinPage(pos.pid, false)
+	t.latches.runlock(pos.pid)
+}
+
+// writePath is the set of pages a writer holds exclusively: the top-most
+// page that may change and everything below it on the way to a leaf
+type writePath struct {
+	tree     *BPlusTree
+	pids     []uint64
+	rootHeld bool // rootLatch is held: the root may change
+}
+
+// lockPath descends to the leaf for key taking write latches. Whenever a
+// page is safe for the operation, the latches above it are released, so a
+// writer blocks only the part of the tree it may actually change.
+func (t *BPlusTree) lockPath(key []byte, safe func(buf []byte) bool) (*writePath, error) {
+	t.rootLatch.Lock()
+	pid := t.meta.RootPID
+	t.latches.lock(pid)
+	w := &writePath{tree: t, pids: []uint64{pid}, rootHeld: true}
+
+	for {
+		buf, err := t.pager.FetchPage(pid)
+		if err != nil {
+			w.release()
+			return nil, err
+		}
+
+		if safe(buf) {
+			w.releaseAbove(pid)
+		}
+
+		if disk.PageTypeOf(buf) != disk.PageTypeInternal {
+			t.pager.UnpinPage(pid, false)
+			return w, nil
+		}
+
+		internal := t.internalView(buf)
+		child := internal.Child(internal.FindLastLE(key) + 1)
+		t.pager.UnpinPage(pid, false)
+
+		pid = child
+		t.latches.lock(pid)
+		w.pids = append(w.pids, pid)
+	}
+}
+
+// top returns the highest page the operation may modify
+func (w *writePath) top() uint64 {
+	return w.pids[0]
+}
+
+// releaseAbove drops the latches on every page above pid
+func (w *writePath) releaseAbove(pid uint64) {
+	if w.rootHeld {
+		w.tree.rootLatch.Unlock()
+		w.rootHeld = false
+	}
+	for len(w.pids) > 0 && w.pids[0] != pid {
+		w.tree.latches.unlock(w.pids[0])
+		w.pids = w.pids[1:]
+	}
+}
+
+// release drops every latch still held
+func (w *writePath) release() {
+	for _, pid := range w.pids {
+		w.tree.latches.unlock(pid)
+	}
+	w.pids = nil
+	if w.rootHeld {
+		w.tree.rootLatch.Unlock()
+		w.rootHeld = false
+	}
+}
+
+// beginWrite starts a modification of the tree. Writers share the commit
+// latch while they change pages; commit takes it exclusively.
+func (t *BPlusTree) beginWrite() {
+	t.treeLatch.RLock()
+	t.commitLatch.RLock()
+}
+
+// endWrite commits the modification started by beginWrite, unless it
+// failed, and lets other writers proceed
+func (t *BPlusTree) endWrite(err error) error {
+	t.commitLatch.RUnlock()
+	defer t.treeLatch.RUnlock()
+
+	if err != nil || t.logged {
+		return err
+	}
+
+	t.commitLatch.Lock()
+	defer t.commitLatch.Unlock()
+	return t.commit()
+}
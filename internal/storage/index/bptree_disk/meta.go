@@ -1,33 +1,31 @@
 package bptree_disk
 
 import (
-	"bytes"
-
 	"github.com/spaghetti-lover/go-db/internal/storage/disk"
 )
 
-func (t *BPlusTree) loadMeta() (*disk.MetaPage, []byte, error) {
-	buf, err := t.pager.FetchPage(t.metaPID)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	meta := &disk.MetaPage{}
-	reader := bytes.NewBuffer(buf)
-
-	if err := meta.ReadFromBuffer(reader); err != nil {
-		return nil, nil, err
-	}
+func (t *BPlusTree) loadMeta() (*disk.MetaPage, error) {
+	return disk.LoadMeta(t.pager, t.metaPID)
+}
 
-	return meta, buf, nil
+// loadAllocator restores the allocator from the meta page and the
+// free-list chain hanging off it.
+func (t *BPlusTree) loadAllocator() error {
+	return disk.LoadAllocator(t.pager, t.meta)
 }
 
-func (t *BPlusTree) flushMeta(meta *disk.MetaPage, buf []byte) error {
-	writer := bytes.NewBuffer(buf[:0])
+// commit makes the current operation durable. The meta page, which holds
+// both the root pointer and the allocator state, is written after every
+// other page so the two always agree on disk.
+func (t *BPlusTree) commit() error {
+	_, _, allocDirty := t.pager.Allocator().Snapshot()
+	if !allocDirty && !t.metaDirty {
+		return t.pager.Sync()
+	}
 
-	if err := meta.WriteToBuffer(writer); err != nil {
+	if err := disk.CommitMeta(t.pager, t.metaPID, t.meta); err != nil {
 		return err
 	}
-
-	return t.pager.FlushPage(t.metaPID)
+	t.metaDirty = false
+	return nil
 }
@@ -11,59 +11,128 @@ type Node interface {
 	IsLeaf() bool
 }
 
-func (t *BPlusTree) loadNode(pid uint64) (Node, []byte, error) {
+// loadNode decodes the page into a leaf or internal node.
+// The returned node is a copy; the page is unpinned before returning.
+func (t *BPlusTree) loadNode(pid uint64) (Node, error) {
 	buf, err := t.pager.FetchPage(pid)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
+	defer t.pager.UnpinPage(pid, false)
 
 	reader := bytes.NewBuffer(buf)
 
 	var header disk.PageHeader
 	if err := header.ReadFromBuffer(reader); err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	switch header.PageType {
 	case disk.PageTypeLeaf:
-		return t.loadLeaf(pid)
+		page := &disk.LeafPage{Header: header, Cmp: t.cmp}
+		if err := page.ReadFromBuffer(reader, false); err != nil {
+			return nil, err
+		}
+		return page, nil
 
 	case disk.PageTypeInternal:
-		return t.loadInternal(pid)
+		page := &disk.InternalPage{Header: header, Cmp: t.cmp}
+		if err := page.ReadFromBuffer(reader, false); err != nil {
+			return nil, err
+		}
+		return page, nil
 
 	default:
-		return nil, nil, fmt.Errorf("unknown page type: %d", header.PageType)
+		return nil, fmt.Errorf("unknown page type: %d", header.PageType)
 	}
 }
 
-func (t *BPlusTree) loadInternal(pid uint64) (*disk.InternalPage, []byte, error) {
-	buf, err := t.pager.FetchPage(pid)
+func (t *BPlusTree) loadInternal(pid uint64) (*disk.InternalPage, error) {
+	node, err := t.loadNode(pid)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	page := &disk.InternalPage{}
-	reader := bytes.NewBuffer(buf)
+	page, ok := node.(*disk.InternalPage)
+	if !ok {
+		return nil, fmt.Errorf("page %d is not an internal page", pid)
+	}
+	return page, nil
+}
 
-	if err := page.ReadFromBuffer(reader, true); err != nil {
-		return nil, nil, err
+func (t *BPlusTree) loadLeaf(pid uint64) (*disk.LeafPage, error) {
+	node, err := t.loadNode(pid)
+	if err != nil {
+		return nil, err
 	}
 
-	return page, buf, nil
+	page, ok := node.(*disk.LeafPage)
+	if !ok {
+		return nil, fmt.Errorf("page %d is not a leaf page", pid)
+	}
+	return page, nil
 }
 
-func (t *BPlusTree) loadLeaf(pid uint64) (*disk.LeafPage, []byte, error) {
+// route reads the page at pid in place. For an internal page it returns
+// the index and page ID of the child that covers key; leaf is true when
+// pid is a leaf.
+func (t *BPlusTree) route(pid uint64, key []byte) (ci int, child uint64, leaf bool, err error) {
 	buf, err := t.pager.FetchPage(pid)
 	if err != nil {
-		return nil, nil, err
+		return 0, 0, false, err
 	}
+	defer t.pager.UnpinPage(pid, false)
 
-	page := &disk.LeafPage{}
-	reader := bytes.NewBuffer(buf)
-
-	if err := page.ReadFromBuffer(reader, true); err != nil {
-		return nil, nil, err
+	switch disk.PageTypeOf(buf) {
+	case disk.PageTypeLeaf:
+		return 0, 0, true, nil
+	case disk.PageTypeInternal:
+		internal := t.internalView(buf)
+		ci = internal.FindLastLE(key) + 1
+		return ci, internal.Child(ci), false, nil
+	default:
+		return 0, 0, false, fmt.Errorf("page %d is not a tree page", pid)
 	}
+}
+
+// leafView reads the leaf page in buf in the order of the tree
+func (t *BPlusTree) leafView(buf []byte) disk.LeafView {
+	return disk.NewLeafView(buf, t.cmp)
+}
+
+// internalView reads the internal page in buf in the order of the tree
+func (t *BPlusTree) internalView(buf []byte) disk.InternalView {
+	return disk.NewInternalView(buf, t.cmp)
+}
+
+// newLeaf returns an empty leaf sized for this tree
+func (t *BPlusTree) newLeaf() *disk.LeafPage {
+	leaf := disk.NewLeafPage()
+	leaf.BlockSize = t.blockSize
+	leaf.Cmp = t.cmp
+	return leaf
+}
+
+// newInternal returns an empty internal page sized for this tree
+func (t *BPlusTree) newInternal() *disk.InternalPage {
+	internal := disk.NewInternalPage()
+	internal.BlockSize = t.blockSize
+	internal.Cmp = t.cmp
+	return internal
+}
+
+type pageWriter interface {
+	WriteToBuffer(buf *bytes.Buffer) error
+}
+
+// writePage serializes node and stores it as the page content. The page is
+// built aside and copied in by the pager, so a concurrent flush never sees
+// it half-written.
+func writePage(t *BPlusTree, pid uint64, node pageWriter) error {
+	return disk.WritePageTo(t.pager, pid, node)
+}
 
-	return page, buf, nil
+// newPage allocates a page, serializes node into it and returns its ID
+func (t *BPlusTree) newPage(node pageWriter) (uint64, error) {
+	return disk.NewPageFrom(t.pager, node)
 }
@@ -0,0 +1,86 @@
+package bptree_disk
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+)
+
+func TestBPlusTree_Open_BlockSizes(t *testing.T) {
+	for _, size := range []int{4096, 8192, 16384, 32768} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), fileName)
+
+			tree, err := OpenWithOptions(path, Options{BlockSize: size})
+			require.NoError(t, err)
+			assert.Equal(t, size, tree.BlockSize())
+
+			key := func(i int) []byte { return []byte(fmt.Sprintf("key-%05d", i)) }
+			for i := 0; i < 500; i++ {
+				require.NoError(t, tree.Set(key(i), bytes.Repeat([]byte{byte(i)}, 100)))
+			}
+			require.NoError(t, tree.Set([]byte("big"), bytes.Repeat([]byte{1}, 3*size)))
+			require.NoError(t, tree.Close())
+
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			assert.Zero(t, info.Size()%int64(size))
+
+			// The page size comes from the header, not the options
+			tree, err = Open(path)
+			require.NoError(t, err)
+			defer tree.Close()
+			assert.Equal(t, size, tree.BlockSize())
+
+			for i := 0; i < 500; i++ {
+				kv, err := tree.Find(key(i))
+				require.NoError(t, err)
+				assert.Equal(t, bytes.Repeat([]byte{byte(i)}, 100), kv.Val)
+			}
+			kv, err := tree.Find([]byte("big"))
+			require.NoError(t, err)
+			assert.Equal(t, 3*size, len(kv.Val))
+		})
+	}
+}
+
+func TestBPlusTree_Open_InvalidBlockSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), fileName)
+
+	_, err := OpenWithOptions(path, Options{BlockSize: 6000})
+	assert.ErrorIs(t, err, disk.ErrInvalidBlockSize)
+}
+
+func TestBPlusTree_Open_RejectsForeignFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), fileName)
+	require.NoError(t, os.WriteFile(path, bytes.Repeat([]byte("hello"), 2000), 0666))
+
+	_, err := Open(path)
+	assert.ErrorIs(t, err, disk.ErrNotDatabaseFile)
+
+	// The file is left untouched
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte("hello"), 2000), raw)
+}
+
+func TestBPlusTree_Open_RejectsNewerFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), fileName)
+
+	tree, err := Open(path)
+	require.NoError(t, err)
+	tree.meta.File.FormatVersion = disk.FORMAT_VERSION + 1
+	tree.metaDirty = true
+	require.NoError(t, tree.commit())
+	require.NoError(t, tree.Close())
+
+	_, err = Open(path)
+	assert.ErrorIs(t, err, disk.ErrUnsupportedVersion)
+}
@@ -0,0 +1,68 @@
+package bptree_disk
+
+import (
+	"math"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+)
+
+// newCell builds the leaf entry for key/value. Values that do not fit in
+// the leaf next to their key are written to a chain of overflow pages and
+// the entry keeps only a reference.
+func (t *BPlusTree) newCell(key, value []byte) (disk.KeyVal, error) {
+	kv := disk.NewKeyValFromBytes(key, value)
+	if len(key) > disk.MAX_KEY_SIZE {
+		return disk.KeyVal{}, disk.ErrKeyTooLarge
+	}
+	if kv.CellSize() <= disk.MaxLeafCellSize(t.blockSize) {
+		return kv, nil
+	}
+	if uint64(len(value)) > math.MaxUint32 {
+		return disk.KeyVal{}, disk.ErrValueTooLarge
+	}
+
+	head, err := t.writeOverflow(value)
+	if err != nil {
+		return disk.KeyVal{}, err
+	}
+
+	kv.Val = nil
+	kv.Overflow = head
+	kv.ValSize = uint32(len(value))
+	return kv, nil
+}
+
+// writeOverflow stores value in a chain of overflow pages and returns the
+// first page ID
+func (t *BPlusTree) writeOverflow(value []byte) (uint64, error) {
+	return disk.WriteOverflow(t.pager, value)
+}
+
+// readOverflow reassembles a value stored in overflow pages
+func (t *BPlusTree) readOverflow(kv *disk.KeyVal) ([]byte, error) {
+	return disk.ReadOverflow(t.pager, kv.Overflow, kv.ValSize)
+}
+
+// freeOverflow returns every page of the chain starting at pid to the allocator
+func (t *BPlusTree) freeOverflow(pid uint64) error {
+	return disk.FreeOverflow(t.pager, pid)
+}
+
+// materialize returns a copy of kv with its value inline, reading overflow
+// pages if needed. The copy does not alias the page kv was read from.
+func (t *BPlusTree) materialize(kv *disk.KeyVal) (*disk.KeyVal, error) {
+	if !kv.HasOverflow() {
+		out := disk.NewKeyValFromBytes(kv.Key, kv.Val)
+		return &out, nil
+	}
+
+	val, err := t.readOverflow(kv)
+	if err != nil {
+		return nil, err
+	}
+	return &disk.KeyVal{Key: append([]byte{}, kv.Key...), Val: val}, nil
+}
+
+func (t *BPlusTree) loadOverflow(pid uint64) (*disk.OverflowPage, error) {
+	return disk.LoadOverflow(t.pager, pid)
+}
@@ -0,0 +1,137 @@
+package bptree_disk
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+)
+
+// largeVal spans several overflow pages
+func largeVal(i int) []byte {
+	return bytes.Repeat([]byte{byte(i)}, 3*disk.OverflowPageCapacity(disk.BLOCK_SIZE)+i)
+}
+
+func freeCount(tree *BPlusTree) int {
+	_, free, _ := tree.pager.Allocator().Snapshot()
+	return len(free)
+}
+
+func TestBPlusTree_Overflow_SetFind(t *testing.T) {
+	tree := setupBPlusTree(t)
+
+	for i := 1; i <= 20; i++ {
+		k, _ := kv(i)
+		require.NoError(t, tree.Set(k, largeVal(i)))
+	}
+
+	for i := 1; i <= 20; i++ {
+		k, _ := kv(i)
+		kv, err := tree.Find(k)
+		require.NoError(t, err)
+		assert.Equal(t, largeVal(i), kv.Val)
+	}
+
+	// The leaf holds only a reference
+	rootPID, err := tree.rootPID()
+	require.NoError(t, err)
+	leaf, err := tree.loadLeaf(rootPID)
+	require.NoError(t, err)
+	require.NotEmpty(t, leaf.KVs)
+	assert.True(t, leaf.KVs[0].HasOverflow())
+	assert.Empty(t, leaf.KVs[0].Val)
+}
+
+func TestBPlusTree_Overflow_Scan(t *testing.T) {
+	tree := setupBPlusTree(t)
+
+	for i := 1; i <= 10; i++ {
+		k, v := kv(i)
+		if i%2 == 0 {
+			v = largeVal(i)
+		}
+		require.NoError(t, tree.Insert(k, v))
+	}
+
+	i := 1
+	err := tree.Scan([]byte{1}, nil, func(key, val []byte) bool {
+		k, v := kv(i)
+		if i%2 == 0 {
+			v = largeVal(i)
+		}
+		assert.Equal(t, k, key)
+		assert.Equal(t, v, val)
+		i++
+		return true
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 11, i)
+
+	it := tree.SeekGE([]byte{4})
+	require.True(t, it.Valid())
+	assert.Equal(t, largeVal(4), it.Deref().Val)
+	assert.NoError(t, it.Err())
+}
+
+func TestBPlusTree_Overflow_OverwriteAndDeleteFreeChain(t *testing.T) {
+	tree := setupBPlusTree(t)
+
+	k, _ := kv(1)
+	require.NoError(t, tree.Set(k, largeVal(1)))
+	before := freeCount(tree)
+
+	// Overwriting with an inline value frees all four overflow pages
+	require.NoError(t, tree.Set(k, []byte("small")))
+	assert.Equal(t, before+4, freeCount(tree))
+
+	kv, err := tree.Find(k)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("small"), kv.Val)
+
+	// Deleting a spilled value frees its chain
+	require.NoError(t, tree.Set(k, largeVal(1)))
+	before = freeCount(tree)
+	ok, err := tree.Del(k)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, before+4, freeCount(tree))
+}
+
+func TestBPlusTree_Overflow_DuplicateInsertFreesChain(t *testing.T) {
+	tree := setupBPlusTree(t)
+
+	k, v := kv(1)
+	require.NoError(t, tree.Insert(k, v))
+	before := freeCount(tree)
+
+	err := tree.Insert(k, largeVal(1))
+	assert.ErrorIs(t, err, ErrDuplicateKey)
+	assert.Equal(t, before+4, freeCount(tree))
+}
+
+func TestBPlusTree_Overflow_PersistsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), fileName)
+
+	tree, err := Open(path)
+	require.NoError(t, err)
+	for i := 1; i <= 5; i++ {
+		k, _ := kv(i)
+		require.NoError(t, tree.Set(k, largeVal(i)))
+	}
+	require.NoError(t, tree.Close())
+
+	tree, err = Open(path)
+	require.NoError(t, err)
+	defer tree.Close()
+
+	for i := 1; i <= 5; i++ {
+		k, _ := kv(i)
+		kv, err := tree.Find(k)
+		require.NoError(t, err)
+		assert.Equal(t, largeVal(i), kv.Val)
+	}
+}
@@ -1,7 +1,5 @@
 package bptree_disk
 
-import "bytes"
-
 // Scan performs a range scan from startKey to endKey, invoking fn for each key-value pair.
 func (b *BPlusTree) Scan(startKey, endKey []byte, fn func(key, val []byte) bool) error {
 	iter := b.SeekGE(startKey)
@@ -10,13 +8,39 @@ func (b *BPlusTree) Scan(startKey, endKey []byte, fn func(key, val []byte) bool)
 		if kv == nil {
 			break
 		}
-		if endKey != nil && bytes.Compare(kv.Key[:kv.KeyLen], endKey) > 0 {
+		if endKey != nil && b.cmp.Compare(kv.Key, endKey) > 0 {
 			break
 		}
-		if !fn(kv.Key[:kv.KeyLen], kv.Val[:kv.ValLen]) {
+		if !fn(kv.Key, kv.Val) {
 			break
 		}
 		iter.Next()
 	}
-	return nil
+	return iter.Err()
+}
+
+// ScanReverse visits the pairs from endKey down to startKey, largest key
+// first. A nil endKey starts at the last key, a nil startKey runs to the
+// first one.
+func (b *BPlusTree) ScanReverse(startKey, endKey []byte, fn func(key, val []byte) bool) error {
+	var iter *BIter
+	if endKey == nil {
+		iter = b.SeekLast()
+	} else {
+		iter = b.SeekLE(endKey)
+	}
+	for iter.Valid() {
+		kv := iter.Deref()
+		if kv == nil {
+			break
+		}
+		if startKey != nil && b.cmp.Compare(kv.Key, startKey) < 0 {
+			break
+		}
+		if !fn(kv.Key, kv.Val) {
+			break
+		}
+		iter.Prev()
+	}
+	return iter.Err()
 }
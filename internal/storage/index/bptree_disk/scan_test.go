@@ -1,9 +1,11 @@
 package bptree_disk
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestBPlusTree_Scan(t *testing.T) {
@@ -50,3 +52,112 @@ func TestBPlusTree_Scan_StopEarly(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, 2, len(collected))
 }
+
+// seqKey returns keys that sort in numeric order, with values large enough
+// to spread them over many leaves
+func seqKey(i int) ([]byte, []byte) {
+	return []byte(fmt.Sprintf("key-%05d", i)), bigVal(i)
+}
+
+func TestBIter_Prev(t *testing.T) {
+	tree := setupBPlusTree(t)
+
+	// Enough leaves for a tree of height three
+	n := 6000
+	for i := 0; i < n; i += 2 {
+		k, v := seqKey(i)
+		require.NoError(t, tree.Set(k, v))
+	}
+
+	// Walk the whole tree backwards
+	it := tree.SeekLast()
+	for i := n - 2; i >= 0; i -= 2 {
+		require.True(t, it.Valid(), "key %d", i)
+		k, v := seqKey(i)
+		kv := it.Deref()
+		assert.Equal(t, k, kv.Key)
+		assert.Equal(t, v, kv.Val)
+		it.Prev()
+	}
+	assert.False(t, it.Valid())
+	assert.NoError(t, it.Err())
+
+	// Change direction in the middle of the tree
+	k, _ := seqKey(200)
+	it = tree.SeekGE(k)
+	it.Next()
+	it.Prev()
+	it.Prev()
+	k, _ = seqKey(198)
+	assert.Equal(t, k, it.Deref().Key)
+}
+
+func TestBIter_SeekLE(t *testing.T) {
+	tree := setupBPlusTree(t)
+
+	for i := 10; i < 400; i += 10 {
+		k, v := seqKey(i)
+		require.NoError(t, tree.Set(k, v))
+	}
+
+	// Exact match
+	k, _ := seqKey(150)
+	it := tree.SeekLE(k)
+	require.True(t, it.Valid())
+	assert.Equal(t, k, it.Deref().Key)
+
+	// Between two keys
+	k, _ = seqKey(155)
+	it = tree.SeekLE(k)
+	require.True(t, it.Valid())
+	want, _ := seqKey(150)
+	assert.Equal(t, want, it.Deref().Key)
+
+	// Before the first key
+	k, _ = seqKey(5)
+	assert.False(t, tree.SeekLE(k).Valid())
+
+	// After the last key
+	k, _ = seqKey(1000)
+	it = tree.SeekLE(k)
+	require.True(t, it.Valid())
+	want, _ = seqKey(390)
+	assert.Equal(t, want, it.Deref().Key)
+}
+
+func TestBIter_SeekLast_Empty(t *testing.T) {
+	tree := setupBPlusTree(t)
+
+	assert.False(t, tree.SeekLast().Valid())
+	assert.False(t, tree.SeekLE([]byte("a")).Valid())
+}
+
+func TestBPlusTree_ScanReverse(t *testing.T) {
+	tree := setupBPlusTree(t)
+
+	for i := 0; i < 300; i++ {
+		k, v := seqKey(i)
+		require.NoError(t, tree.Set(k, v))
+	}
+
+	start, _ := seqKey(100)
+	end, _ := seqKey(250)
+	var got []string
+	err := tree.ScanReverse(start, end, func(key, val []byte) bool {
+		got = append(got, string(key))
+		return true
+	})
+	require.NoError(t, err)
+	require.Len(t, got, 151)
+	assert.Equal(t, string(end), got[0])
+	assert.Equal(t, string(start), got[150])
+
+	// Latest N entries
+	got = got[:0]
+	err = tree.ScanReverse(nil, nil, func(key, val []byte) bool {
+		got = append(got, string(key))
+		return len(got) < 3
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"key-00299", "key-00298", "key-00297"}, got)
+}
@@ -1,120 +1,98 @@
 package bptree_disk
 
 import (
-	"bytes"
-
 	"github.com/spaghetti-lover/go-db/internal/storage/disk"
 )
 
 func (t *BPlusTree) Set(key, value []byte) error {
-	kv := disk.NewKeyValFromBytes(key, value)
+	t.beginWrite()
+	return t.endWrite(t.set(key, value))
+}
 
-	rootPID, err := t.rootPID()
+func (t *BPlusTree) set(key, value []byte) error {
+	kv, err := t.newCell(key, value)
 	if err != nil {
 		return err
 	}
 
-	res, err := t.setRecursive(rootPID, &kv)
+	w, err := t.lockPath(key, t.insertSafe)
 	if err != nil {
+		t.freeOverflow(kv.Overflow)
+		return err
+	}
+	defer w.release()
+
+	res, err := t.setRecursive(w.top(), &kv)
+	if err != nil {
+		t.freeOverflow(kv.Overflow)
 		return err
 	}
 
 	// root split
 	if res.Split {
-		newRoot := disk.NewInternalPage()
-
-		newRoot.Children[0] = rootPID
-		newRoot.Children[1] = res.NewPID
-		newRoot.Keys[0] = *res.PromoteKey
-		newRoot.NKeys = 1
-
-		newRootPID, buf := t.pager.NewPage()
-		writer := bytes.NewBuffer(buf[:0])
-		if err := newRoot.WriteToBuffer(writer); err != nil {
-			return err
-		}
-		if err := t.pager.FlushPage(newRootPID); err != nil {
-			return err
-		}
-
-		err := t.setRootPID(newRootPID)
-		if err != nil {
+		if err := t.growRoot(w.top(), res); err != nil {
 			return err
 		}
-
-		return t.pager.Sync()
 	}
-
-	return t.pager.Sync()
+	return nil
 }
 
 func (t *BPlusTree) setRecursive(nodePID uint64, kv *disk.KeyVal) (InsertResult, error) {
-
-	node, buf, err := t.loadNode(nodePID)
+	_, childPID, isLeaf, err := t.route(nodePID, kv.Key)
 	if err != nil {
 		return InsertResult{}, err
 	}
 
-	if node.IsLeaf() {
-		leaf := node.(*disk.LeafPage)
-
-		pos := leaf.FindLastLE(kv)
-
-		// UPDATE
-		if pos >= 0 && leaf.KVs[pos].Compare(kv) == 0 {
-			leaf.KVs[pos] = *kv
-
-			writer := bytes.NewBuffer(buf[:0])
-			if err := leaf.WriteToBuffer(writer); err != nil {
-				return InsertResult{}, err
+	if isLeaf {
+		// Insert or replace in place when the entry fits
+		var oldOverflow uint64
+		var fit bool
+		err := t.pager.UpdatePage(nodePID, func(buf []byte) bool {
+			leaf := t.leafView(buf)
+			pos := leaf.LowerBound(kv.Key)
+			if pos < leaf.NKeys() && t.cmp.Compare(leaf.Key(pos), kv.Key) == 0 {
+				old := leaf.Cell(pos)
+				if leaf.Size()-old.CellSize()+kv.CellSize() > len(buf) {
+					return false
+				}
+				oldOverflow = old.Overflow
+				leaf.Delete(pos)
 			}
-			return InsertResult{}, t.pager.FlushPage(nodePID)
+			fit = leaf.Insert(pos, kv)
+			return fit
+		})
+		if err != nil {
+			return InsertResult{}, err
 		}
-
-		// INSERT
-		leaf.InsertKV(kv)
-
-		if !leaf.IsOverflow() {
-			writer := bytes.NewBuffer(buf[:0])
-			if err := leaf.WriteToBuffer(writer); err != nil {
-				return InsertResult{}, err
-			}
-			return InsertResult{}, t.pager.FlushPage(nodePID)
+		if fit {
+			return InsertResult{}, t.freeOverflow(oldOverflow)
 		}
 
-		// SPLIT
-		rightLeaf, promoteKey := leaf.Split()
-
-		rightPID, rightBuf := t.pager.NewPage()
-		if err := rightLeaf.WriteToBuffer(bytes.NewBuffer(rightBuf[:0])); err != nil {
+		leaf, err := t.loadLeaf(nodePID)
+		if err != nil {
 			return InsertResult{}, err
 		}
 
-		if err := leaf.WriteToBuffer(bytes.NewBuffer(buf[:0])); err != nil {
-			return InsertResult{}, err
+		// Otherwise update the decoded leaf and split it
+		pos := leaf.FindLastLE(kv)
+		if pos >= 0 && t.cmp.Compare(leaf.KVs[pos].Key, kv.Key) == 0 {
+			// UPDATE: a longer value may still overflow the page
+			oldOverflow = leaf.KVs[pos].Overflow
+			leaf.KVs[pos] = *kv
+		} else {
+			// INSERT
+			leaf.InsertKV(kv)
 		}
 
-		if err := t.pager.FlushPage(nodePID); err != nil {
-			return InsertResult{}, err
-		}
-		if err := t.pager.FlushPage(rightPID); err != nil {
+		res, err := t.writeLeaf(nodePID, leaf)
+		if err != nil {
 			return InsertResult{}, err
 		}
 
-		return InsertResult{
-			Split:      true,
-			PromoteKey: promoteKey,
-			NewPID:     rightPID,
-		}, nil
+		// the old value is no longer referenced
+		return res, t.freeOverflow(oldOverflow)
 	}
 
-	internal := node.(*disk.InternalPage)
-
-	keyEntry := disk.NewKeyEntryFromKeyVal(kv)
-
-	idx := internal.FindLastLE(keyEntry)
-	childPID := internal.Children[idx+1]
-
 	res, err := t.setRecursive(childPID, kv)
 	if err != nil {
 		return InsertResult{}, err
@@ -124,36 +102,10 @@ func (t *BPlusTree) setRecursive(nodePID uint64, kv *disk.KeyVal) (InsertResult,
 		return InsertResult{}, nil
 	}
 
-	internal.InsertKV(res.PromoteKey, res.NewPID)
-
-	if !internal.IsOverflow() {
-		if err := internal.WriteToBuffer(bytes.NewBuffer(buf[:0])); err != nil {
-			return InsertResult{}, err
-		}
-		return InsertResult{}, t.pager.FlushPage(nodePID)
-	}
-
-	rightInternal, promoteKey := internal.Split()
-
-	rightPID, rightBuf := t.pager.NewPage()
-	if err := rightInternal.WriteToBuffer(bytes.NewBuffer(rightBuf[:0])); err != nil {
-		return InsertResult{}, err
-	}
-
-	if err := internal.WriteToBuffer(bytes.NewBuffer(buf[:0])); err != nil {
-		return InsertResult{}, err
-	}
-
-	if err := t.pager.FlushPage(nodePID); err != nil {
-		return InsertResult{}, err
-	}
-	if err := t.pager.FlushPage(rightPID); err != nil {
+	internal, err := t.loadInternal(nodePID)
+	if err != nil {
 		return InsertResult{}, err
 	}
-
-	return InsertResult{
-		Split:      true,
-		PromoteKey: promoteKey,
-		NewPID:     rightPID,
-	}, nil
+	internal.InsertKV(res.PromoteKey, res.NewPID)
+	return t.writeInternal(nodePID, internal)
 }
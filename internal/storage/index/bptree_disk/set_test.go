@@ -1,9 +1,11 @@
 package bptree_disk
 
 import (
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestBPlusTree_Set_Simple(t *testing.T) {
@@ -21,7 +23,7 @@ func TestBPlusTree_Set_Simple(t *testing.T) {
 		k, v := kv(i)
 		kv, err := tree.Find(k)
 		assert.Equal(t, nil, err)
-		assert.Equal(t, v, kv.Val[len(kv.Val)-int(kv.ValLen):])
+		assert.Equal(t, v, kv.Val)
 	}
 }
 
@@ -49,7 +51,7 @@ func TestBPlusTree_Set_UpdateExistingKey(t *testing.T) {
 		v := []byte{byte(i + 200)}
 		kv, err := tree.Find(k)
 		assert.Equal(t, nil, err)
-		assert.Equal(t, v, kv.Val[len(kv.Val)-int(kv.ValLen):])
+		assert.Equal(t, v, kv.Val)
 	}
 }
 
@@ -69,6 +71,25 @@ func TestBPlusTree_Set_CauseSplit(t *testing.T) {
 		k, v := kv(i)
 		kv, err := tree.Find(k)
 		assert.Equal(t, nil, err)
-		assert.Equal(t, v, kv.Val[len(kv.Val)-int(kv.ValLen):])
+		assert.Equal(t, v, kv.Val)
 	}
-}
\ No newline at end of file
+}
+func TestBPlusTree_Set_SmallCache(t *testing.T) {
+	tree, err := OpenWithOptions(filepath.Join(t.TempDir(), fileName), Options{CachePages: 1})
+	require.NoError(t, err)
+	defer tree.Close()
+
+	// Every page access evicts the previous page
+	numSets := 25
+	for i := 1; i <= numSets; i++ {
+		k, v := kv(i)
+		require.NoError(t, tree.Set(k, v))
+	}
+
+	for i := 1; i <= numSets; i++ {
+		k, v := kv(i)
+		kv, err := tree.Find(k)
+		require.NoError(t, err)
+		assert.Equal(t, v, kv.Val)
+	}
+}
@@ -0,0 +1,213 @@
+package bptree_disk
+
+import (
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+)
+
+// ESTIMATE_SAMPLES is the number of leaves EstimateRange reads in full to
+// size the leaves it only counts
+const ESTIMATE_SAMPLES = 16
+
+// Stats describes the shape of the tree and how it uses its file
+type Stats struct {
+	BlockSize     int     `json:"block_size"`
+	Comparator    string  `json:"comparator"`
+	Height        int     `json:"height"`
+	LeafPages     int     `json:"leaf_pages"`
+	InternalPages int     `json:"internal_pages"`
+	OverflowPages int     `json:"overflow_pages"`
+	FreePages     int     `json:"free_pages"` // length of the free list
+	Keys          int64   `json:"keys"`
+	FillFactor    float64 `json:"fill_factor"` // average share of a leaf or internal page in use
+	Pages         uint64  `json:"pages"`       // pages allocated, meta page included
+	FileSize      int64   `json:"file_size"`
+
+	Pager disk.PagerStats `json:"pager"`
+}
+
+// Stats walks the tree and reports its size and the pager counters.
+// Overflow pages are counted from the value sizes without being read.
+// Other operations wait until the walk is done.
+func (t *BPlusTree) Stats() (*Stats, error) {
+	t.treeLatch.Lock()
+	defer t.treeLatch.Unlock()
+
+	next, free, _ := t.pager.Allocator().Snapshot()
+	s := &Stats{BlockSize: t.blockSize, Comparator: t.cmp.Name, FreePages: len(free), Pages: next}
+
+	rootPID, err := t.rootPID()
+	if err != nil {
+		return nil, err
+	}
+
+	used := 0
+	capacity := disk.OverflowPageCapacity(t.blockSize)
+	var walk func(pid uint64, depth int) error
+	walk = func(pid uint64, depth int) error {
+		node, err := t.loadNode(pid)
+		if err != nil {
+			return err
+		}
+
+		switch node := node.(type) {
+		case *disk.LeafPage:
+			s.LeafPages++
+			s.Keys += int64(len(node.KVs))
+			s.Height = depth + 1
+			used += node.Size()
+			for i := range node.KVs {
+				if node.KVs[i].HasOverflow() {
+					s.OverflowPages += (int(node.KVs[i].ValSize) + capacity - 1) / capacity
+				}
+			}
+		case *disk.InternalPage:
+			s.InternalPages++
+			used += node.Size()
+			for _, child := range node.Children {
+				if err := walk(child, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(rootPID, 0); err != nil {
+		return nil, err
+	}
+
+	s.FillFactor = float64(used) / float64((s.LeafPages+s.InternalPages)*t.blockSize)
+	if s.FileSize, err = t.pager.FileSize(); err != nil {
+		return nil, err
+	}
+	s.Pager = t.pager.Stats()
+	return s, nil
+}
+
+// RangeEstimate is the size of a key range as estimated by EstimateRange
+type RangeEstimate struct {
+	Keys  int64 `json:"keys"`
+	Bytes int64 `json:"bytes"` // keys plus values, spilled values at full length
+	Exact bool  `json:"exact"` // every leaf in the range was read
+}
+
+// EstimateRange estimates the number of keys in [start, end) and their size;
+// nil bounds are unbounded. Internal pages over the range are read, and so
+// are the leaves that straddle a bound. Of the leaves entirely inside the
+// range, the first ESTIMATE_SAMPLES are read and after that only the middle
+// one below each parent; the others are assumed to look like the ones
+// that were.
+// Pages are read-latched on the way down, so writers keep running.
+func (t *BPlusTree) EstimateRange(start, end []byte) (RangeEstimate, error) {
+	t.treeLatch.RLock()
+	defer t.treeLatch.RUnlock()
+
+	t.rootLatch.RLock()
+	rootPID := t.meta.RootPID
+	t.latches.rlock(rootPID)
+	t.rootLatch.RUnlock()
+
+	e := &estimator{tree: t, keyRange: keyRange{start, end, t.cmp}, leafDepth: -1}
+	err := e.visit(rootPID, nil, nil, 0)
+	t.latches.runlock(rootPID)
+	if err != nil {
+		return RangeEstimate{}, err
+	}
+
+	e.est.Exact = e.skipped == 0
+	if e.skipped > 0 && e.samples > 0 {
+		e.est.Keys += e.skipped * e.sampleKeys / e.samples
+		e.est.Bytes += e.skipped * e.sampleBytes / e.samples
+	}
+	return e.est, nil
+}
+
+type estimator struct {
+	tree *BPlusTree
+	keyRange
+	est       RangeEstimate
+	leafDepth int // known once the first leaf is reached
+
+	skipped                          int64 // leaves inside the range that were not read
+	samples, sampleKeys, sampleBytes int64 // leaves inside the range that were
+}
+
+// visit adds the part of the range held by the read-latched page at pid,
+// whose keys are bounded by [lo, hi). The first leaves are always read, so
+// the leaf depth is known before any leaf is skipped.
+func (e *estimator) visit(pid uint64, lo, hi []byte, depth int) error {
+	t := e.tree
+
+	node, err := t.loadNode(pid)
+	if err != nil {
+		return err
+	}
+
+	if leaf, ok := node.(*disk.LeafPage); ok {
+		e.leafDepth = depth
+		from, to := e.bounds(leaf)
+		var n int64
+		for i := from; i < to; i++ {
+			n += int64(len(leaf.KVs[i].Key) + valueLen(&leaf.KVs[i]))
+		}
+		e.est.Keys += int64(max(to-from, 0))
+		e.est.Bytes += n
+		if e.covers(lo, hi) {
+			e.samples++
+			e.sampleKeys += int64(len(leaf.KVs))
+			e.sampleBytes += n
+		}
+		return nil
+	}
+
+	internal := node.(*disk.InternalPage)
+	bounds := func(i int) ([]byte, []byte) {
+		clo, chi := lo, hi
+		if i > 0 {
+			clo = internal.Keys[i-1].Key
+		}
+		if i < internal.NKeys() {
+			chi = internal.Keys[i].Key
+		}
+		return clo, chi
+	}
+
+	// Leaves inside the range are read until there are enough samples;
+	// after that only the middle one of each parent is
+	var covered []int
+	for i := range internal.Children {
+		if e.covers(bounds(i)) {
+			covered = append(covered, i)
+		}
+	}
+	pick := -1
+	if len(covered) > 0 {
+		pick = covered[len(covered)/2]
+	}
+
+	for i, child := range internal.Children {
+		clo, chi := bounds(i)
+		if e.disjoint(clo, chi) {
+			continue
+		}
+		if depth+1 == e.leafDepth && e.samples >= ESTIMATE_SAMPLES && i != pick && e.covers(clo, chi) {
+			e.skipped++
+			continue
+		}
+
+		t.latches.rlock(child)
+		err := e.visit(child, clo, chi, depth+1)
+		t.latches.runlock(child)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// valueLen is the length of the stored value, spilled or not
+func valueLen(kv *disk.KeyVal) int {
+	if kv.HasOverflow() {
+		return int(kv.ValSize)
+	}
+	return len(kv.Val)
+}
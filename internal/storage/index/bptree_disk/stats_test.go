@@ -0,0 +1,91 @@
+package bptree_disk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+)
+
+func TestBPlusTree_Stats(t *testing.T) {
+	tree := verifyTree(t)
+	require.NoError(t, tree.pager.Sync())
+
+	stats, err := tree.Stats()
+	require.NoError(t, err)
+
+	report, err := tree.Verify()
+	require.NoError(t, err)
+	assert.Equal(t, report.Height, stats.Height)
+	assert.Equal(t, report.LeafPages, stats.LeafPages)
+	assert.Equal(t, report.InternalPages, stats.InternalPages)
+	assert.Equal(t, report.OverflowPages, stats.OverflowPages)
+	assert.Equal(t, int64(3000), stats.Keys)
+	assert.Equal(t, report.Pages, stats.Pages)
+
+	assert.Greater(t, stats.FillFactor, 0.25)
+	assert.LessOrEqual(t, stats.FillFactor, 1.0)
+	assert.Equal(t, int64(disk.BlockOffset(stats.Pages-1, stats.BlockSize))+int64(stats.BlockSize), stats.FileSize)
+	assert.Greater(t, stats.Pager.Hits, uint64(0))
+	assert.Greater(t, stats.Pager.Flushes, uint64(0))
+
+	// Freed pages show up on the free list
+	start, _ := seqKey(0)
+	end, _ := seqKey(2000)
+	_, err = tree.DeleteRange(start, end)
+	require.NoError(t, err)
+	stats, err = tree.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), stats.Keys)
+	assert.Equal(t, freeCount(tree), stats.FreePages)
+}
+
+func TestBPlusTree_EstimateRange(t *testing.T) {
+	tree := verifyTree(t)
+
+	// Exact size of [from, to) in seqKey order, spilled values included
+	size := func(from, to int) int64 {
+		var n int64
+		for i := from; i < to; i++ {
+			k, v := seqKey(i)
+			if i%100 == 0 {
+				v = largeVal(i % 7)
+			}
+			n += int64(len(k) + len(v))
+		}
+		return n
+	}
+
+	// A short range straddles at most a few leaves and is read in full
+	start, _ := seqKey(1000)
+	end, _ := seqKey(1010)
+	est, err := tree.EstimateRange(start, end)
+	require.NoError(t, err)
+	assert.Equal(t, RangeEstimate{Keys: 10, Bytes: size(1000, 1010), Exact: true}, est)
+
+	// A long one is sampled; without spilled values the leaves are alike
+	plain := setupBPlusTree(t)
+	for i := 0; i < 3000; i++ {
+		k, v := seqKey(i)
+		require.NoError(t, plain.Set(k, v))
+	}
+	start, _ = seqKey(100)
+	end, _ = seqKey(2900)
+	est, err = plain.EstimateRange(start, end)
+	require.NoError(t, err)
+	assert.False(t, est.Exact)
+	assert.InEpsilon(t, 2800, est.Keys, 0.1)
+	k, v := seqKey(0)
+	assert.InEpsilon(t, 2800*(len(k)+len(v)), est.Bytes, 0.1)
+
+	// Unbounded and empty ranges
+	est, err = plain.EstimateRange(nil, nil)
+	require.NoError(t, err)
+	assert.InEpsilon(t, 3000, est.Keys, 0.1)
+
+	est, err = tree.EstimateRange([]byte("zzz"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, RangeEstimate{Exact: true}, est)
+}
@@ -0,0 +1,295 @@
+package bptree_disk
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+)
+
+// ViolationKind names one kind of structural problem found by Verify
+type ViolationKind string
+
+const (
+	ViolationUnreadable      ViolationKind = "unreadable-page"
+	ViolationCorrupt         ViolationKind = "corrupt-page"
+	ViolationPageType        ViolationKind = "bad-page-type"
+	ViolationKeyOrder        ViolationKind = "key-order"
+	ViolationSeparator       ViolationKind = "separator-bounds"
+	ViolationLeafDepth       ViolationKind = "leaf-depth"
+	ViolationOverfull        ViolationKind = "overfull"
+	ViolationUnderfull       ViolationKind = "underfull"
+	ViolationLeafChain       ViolationKind = "leaf-chain"
+	ViolationOverflowChain   ViolationKind = "overflow-chain"
+	ViolationUnreachable     ViolationKind = "unreachable-page"
+	ViolationDoubleReference ViolationKind = "double-reference"
+	ViolationOutOfRange      ViolationKind = "page-out-of-range"
+)
+
+// Violation is one problem found by Verify
+type Violation struct {
+	Kind   ViolationKind `json:"kind"`
+	PageID uint64        `json:"page"`
+	Detail string        `json:"detail"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("page %d: %s: %s", v.PageID, v.Kind, v.Detail)
+}
+
+// VerifyReport is the result of Verify: what was checked and every
+// violation found
+type VerifyReport struct {
+	BlockSize     int         `json:"block_size"`
+	Pages         uint64      `json:"pages"` // pages in the file, meta page included
+	Height        int         `json:"height"`
+	LeafPages     int         `json:"leaf_pages"`
+	InternalPages int         `json:"internal_pages"`
+	OverflowPages int         `json:"overflow_pages"`
+	FreePages     int         `json:"free_pages"`
+	Keys          int         `json:"keys"`
+	Violations    []Violation `json:"violations"`
+}
+
+// OK reports whether no violation was found
+func (r *VerifyReport) OK() bool {
+	return len(r.Violations) == 0
+}
+
+// Verify walks the whole tree from the meta page and checks its structure:
+// page types, key order within and across nodes, separator bounds, uniform
+// leaf depth, page occupancy, the leaf sibling chain, overflow chains, and
+// that every page of the file is used exactly once, by the tree, an
+// overflow chain or the free list. Problems are collected in the report;
+// the error is only set when the check itself cannot run.
+// Other operations wait until the check is done.
+func (t *BPlusTree) Verify() (*VerifyReport, error) {
+	t.treeLatch.Lock()
+	defer t.treeLatch.Unlock()
+
+	next, free, _ := t.pager.Allocator().Snapshot()
+	v := &verifier{
+		tree:   t,
+		report: &VerifyReport{BlockSize: t.blockSize, Pages: next, FreePages: len(free), Violations: []Violation{}},
+		refs:   make(map[uint64]int, next),
+		depth:  -1,
+	}
+
+	v.use(t.metaPID, "meta page")
+	for _, pid := range free {
+		v.use(pid, "free list")
+	}
+
+	rootPID, err := t.rootPID()
+	if err != nil {
+		return nil, err
+	}
+	v.walk(rootPID, nil, nil, 0, true)
+	v.checkChain()
+
+	for pid := uint64(0); pid < next; pid++ {
+		if v.refs[pid] == 0 {
+			v.add(ViolationUnreachable, pid, "page is neither in the tree nor free")
+		}
+	}
+
+	v.report.Height = v.depth + 1
+	return v.report, nil
+}
+
+type verifier struct {
+	tree   *BPlusTree
+	report *VerifyReport
+	refs   map[uint64]int // times each page is referenced
+	depth  int            // depth of the first leaf found
+	leaves []*disk.LeafPage
+	pids   []uint64 // leaf page IDs in key order
+}
+
+func (v *verifier) add(kind ViolationKind, pid uint64, format string, args ...any) {
+	v.report.Violations = append(v.report.Violations, Violation{
+		Kind:   kind,
+		PageID: pid,
+		Detail: fmt.Sprintf(format, args...),
+	})
+}
+
+// use records a reference to pid and reports whether this is the first
+func (v *verifier) use(pid uint64, by string) bool {
+	if pid >= v.report.Pages {
+		v.add(ViolationOutOfRange, pid, "referenced by %s beyond the end of the file (%d pages)", by, v.report.Pages)
+		return false
+	}
+	v.refs[pid]++
+	if v.refs[pid] > 1 {
+		v.add(ViolationDoubleReference, pid, "referenced again by %s", by)
+		return false
+	}
+	return true
+}
+
+// pageType reads the type byte of a page
+func (v *verifier) pageType(pid uint64) (uint8, bool) {
+	buf, err := v.tree.pager.FetchPage(pid)
+	if err != nil {
+		var corrupt *disk.ErrPageCorrupt
+		if errors.As(err, &corrupt) {
+			v.add(ViolationCorrupt, pid, "%v", err)
+		} else {
+			v.add(ViolationUnreadable, pid, "%v", err)
+		}
+		return 0, false
+	}
+	defer v.tree.pager.UnpinPage(pid, false)
+
+	var header disk.PageHeader
+	if err := header.ReadFromBuffer(bytes.NewBuffer(buf)); err != nil {
+		v.add(ViolationUnreadable, pid, "%v", err)
+		return 0, false
+	}
+	return header.PageType, true
+}
+
+// walk checks the node at pid, whose keys must lie in [lo, hi)
+func (v *verifier) walk(pid uint64, lo, hi []byte, depth int, root bool) {
+	if !v.use(pid, "the tree") {
+		return
+	}
+
+	typ, ok := v.pageType(pid)
+	if !ok {
+		return
+	}
+	if typ != disk.PageTypeLeaf && typ != disk.PageTypeInternal {
+		v.add(ViolationPageType, pid, "tree page has type %d", typ)
+		return
+	}
+
+	node, err := v.tree.loadNode(pid)
+	if err != nil {
+		v.add(ViolationUnreadable, pid, "%v", err)
+		return
+	}
+
+	switch node := node.(type) {
+	case *disk.LeafPage:
+		v.report.LeafPages++
+		v.report.Keys += len(node.KVs)
+		v.leaves = append(v.leaves, node)
+		v.pids = append(v.pids, pid)
+
+		if v.depth < 0 {
+			v.depth = depth
+		} else if depth != v.depth {
+			v.add(ViolationLeafDepth, pid, "leaf at depth %d, others at %d", depth, v.depth)
+		}
+
+		for i := range node.KVs {
+			key := node.KVs[i].Key
+			if i > 0 && v.tree.cmp.Compare(node.KVs[i-1].Key, key) >= 0 {
+				v.add(ViolationKeyOrder, pid, "key %d %q not above key %d %q", i, key, i-1, node.KVs[i-1].Key)
+			}
+			v.checkBounds(pid, key, lo, hi)
+			v.checkOverflow(pid, &node.KVs[i])
+		}
+		v.checkFill(pid, node.Size(), root)
+
+	case *disk.InternalPage:
+		v.report.InternalPages++
+
+		for i := range node.Keys {
+			key := node.Keys[i].Key
+			if i > 0 && v.tree.cmp.Compare(node.Keys[i-1].Key, key) >= 0 {
+				v.add(ViolationKeyOrder, pid, "separator %d %q not above separator %d %q", i, key, i-1, node.Keys[i-1].Key)
+			}
+			v.checkBounds(pid, key, lo, hi)
+		}
+		if root && node.NKeys() == 0 {
+			v.add(ViolationUnderfull, pid, "internal root has a single child")
+		}
+		v.checkFill(pid, node.Size(), root)
+
+		for i, child := range node.Children {
+			childLo, childHi := lo, hi
+			if i > 0 {
+				childLo = node.Keys[i-1].Key
+			}
+			if i < node.NKeys() {
+				childHi = node.Keys[i].Key
+			}
+			v.walk(child, childLo, childHi, depth+1, false)
+		}
+	}
+}
+
+// checkBounds reports a key outside the separator bounds of its node
+func (v *verifier) checkBounds(pid uint64, key, lo, hi []byte) {
+	if lo != nil && v.tree.cmp.Compare(key, lo) < 0 {
+		v.add(ViolationSeparator, pid, "key %q below separator %q", key, lo)
+	}
+	if hi != nil && v.tree.cmp.Compare(key, hi) >= 0 {
+		v.add(ViolationSeparator, pid, "key %q not below separator %q", key, hi)
+	}
+}
+
+// checkFill reports pages over the page size, and pages other than the
+// root below MinFill
+func (v *verifier) checkFill(pid uint64, size int, root bool) {
+	bs := v.tree.blockSize
+	if size > bs {
+		v.add(ViolationOverfull, pid, "%d bytes in a %d byte page", size, bs)
+	}
+	if !root && size < disk.MinFill(bs) {
+		v.add(ViolationUnderfull, pid, "%d bytes, minimum is %d", size, disk.MinFill(bs))
+	}
+}
+
+// checkOverflow follows the overflow chain of a spilled value
+func (v *verifier) checkOverflow(leafPID uint64, kv *disk.KeyVal) {
+	if !kv.HasOverflow() {
+		return
+	}
+
+	total := 0
+	for pid := kv.Overflow; pid != 0; {
+		if !v.use(pid, fmt.Sprintf("the value of %q in leaf %d", kv.Key, leafPID)) {
+			return
+		}
+		typ, ok := v.pageType(pid)
+		if !ok {
+			return
+		}
+		if typ != disk.PageTypeOverflow {
+			v.add(ViolationPageType, pid, "overflow page has type %d", typ)
+			return
+		}
+
+		page, err := v.tree.loadOverflow(pid)
+		if err != nil {
+			v.add(ViolationUnreadable, pid, "%v", err)
+			return
+		}
+		v.report.OverflowPages++
+		total += len(page.Data)
+		pid = page.Header.NextPagePointer
+	}
+
+	if total != int(kv.ValSize) {
+		v.add(ViolationOverflowChain, leafPID, "value of %q is %d bytes, its overflow chain holds %d", kv.Key, kv.ValSize, total)
+	}
+}
+
+// checkChain compares the leaf sibling pointers with the key order of the
+// leaves found by the walk
+func (v *verifier) checkChain() {
+	for i, leaf := range v.leaves {
+		want := uint64(0)
+		if i+1 < len(v.pids) {
+			want = v.pids[i+1]
+		}
+		if got := leaf.Header.NextPagePointer; got != want {
+			v.add(ViolationLeafChain, v.pids[i], "next leaf is %d, expected %d", got, want)
+		}
+	}
+}
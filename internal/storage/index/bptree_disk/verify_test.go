@@ -0,0 +1,142 @@
+package bptree_disk
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+)
+
+// verifyTree builds a tree of height three with a few spilled values
+func verifyTree(t *testing.T) *BPlusTree {
+	tree := setupBPlusTree(t)
+	for i := 0; i < 3000; i++ {
+		k, v := seqKey(i)
+		if i%100 == 0 {
+			v = largeVal(i % 7)
+		}
+		require.NoError(t, tree.Set(k, v))
+	}
+	return tree
+}
+
+// kinds returns the kinds of violations in the report
+func kinds(report *VerifyReport) []ViolationKind {
+	var out []ViolationKind
+	for _, v := range report.Violations {
+		out = append(out, v.Kind)
+	}
+	return out
+}
+
+// someLeaf returns a leaf in the middle of the tree
+func someLeaf(t *testing.T, tree *BPlusTree) (uint64, *disk.LeafPage) {
+	k, _ := seqKey(1500)
+	pos, err := tree.leafFor(k, seekGE)
+	require.NoError(t, err)
+	return pos.pid, pos.leaf
+}
+
+func TestBPlusTree_Verify_Healthy(t *testing.T) {
+	tree := verifyTree(t)
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 3000; i++ {
+		k, v := seqKey(r.Intn(4000))
+		if r.Intn(3) == 0 {
+			_, err := tree.Del(k)
+			require.NoError(t, err)
+		} else {
+			require.NoError(t, tree.Set(k, v))
+		}
+	}
+	start, _ := seqKey(1000)
+	end, _ := seqKey(2000)
+	_, err := tree.DeleteRange(start, end)
+	require.NoError(t, err)
+
+	report, err := tree.Verify()
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%v", report.Violations)
+	assert.Equal(t, checkTree(t, tree), report.Keys)
+	assert.Greater(t, report.Height, 1)
+	assert.Greater(t, report.OverflowPages, 0)
+}
+
+func TestBPlusTree_Verify_KeyOrder(t *testing.T) {
+	tree := verifyTree(t)
+
+	pid, leaf := someLeaf(t, tree)
+	leaf.KVs[0], leaf.KVs[1] = leaf.KVs[1], leaf.KVs[0]
+	require.NoError(t, writePage(tree, pid, leaf))
+
+	report, err := tree.Verify()
+	require.NoError(t, err)
+	assert.Contains(t, kinds(report), ViolationKeyOrder)
+	assert.Equal(t, pid, report.Violations[0].PageID)
+}
+
+func TestBPlusTree_Verify_SeparatorBounds(t *testing.T) {
+	tree := verifyTree(t)
+
+	pid, leaf := someLeaf(t, tree)
+	k, v := seqKey(99999)
+	leaf.KVs = append(leaf.KVs, disk.NewKeyValFromBytes(k, v))
+	require.NoError(t, writePage(tree, pid, leaf))
+
+	report, err := tree.Verify()
+	require.NoError(t, err)
+	assert.Equal(t, []ViolationKind{ViolationSeparator}, kinds(report))
+}
+
+func TestBPlusTree_Verify_LeafChain(t *testing.T) {
+	tree := verifyTree(t)
+
+	pid, leaf := someLeaf(t, tree)
+	leaf.Header.NextPagePointer = 0
+	require.NoError(t, writePage(tree, pid, leaf))
+
+	report, err := tree.Verify()
+	require.NoError(t, err)
+	assert.Equal(t, []ViolationKind{ViolationLeafChain}, kinds(report))
+}
+
+func TestBPlusTree_Verify_PageAccounting(t *testing.T) {
+	tree := verifyTree(t)
+
+	// A page that is allocated but never linked is lost
+	leaked, _, err := tree.pager.NewPage()
+	require.NoError(t, err)
+	require.NoError(t, tree.pager.UnpinPage(leaked, true))
+
+	// A tree page that is also on the free list
+	pid, _ := someLeaf(t, tree)
+	tree.pager.Allocator().Free(pid)
+
+	report, err := tree.Verify()
+	require.NoError(t, err)
+	assert.Contains(t, kinds(report), ViolationUnreachable)
+	assert.Contains(t, kinds(report), ViolationDoubleReference)
+	assert.Contains(t, report.Violations, Violation{
+		Kind:   ViolationUnreachable,
+		PageID: leaked,
+		Detail: "page is neither in the tree nor free",
+	})
+}
+
+func TestBPlusTree_Verify_PageType(t *testing.T) {
+	tree := verifyTree(t)
+
+	pid, _ := someLeaf(t, tree)
+	page := disk.NewOverflowPage([]byte("not a leaf"), 0)
+	page.BlockSize = tree.blockSize
+	require.NoError(t, writePage(tree, pid, page))
+
+	report, err := tree.Verify()
+	require.NoError(t, err)
+	assert.Contains(t, kinds(report), ViolationPageType)
+	assert.False(t, report.OK())
+}
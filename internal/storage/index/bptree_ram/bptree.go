@@ -1,87 +1,161 @@
 package bptree_ram
 
 import (
-	"github.com/spaghetti-lover/go-db/internal/utils"
+	"cmp"
+	"fmt"
+	"sort"
 )
 
 const (
-	MAX_KEYS     = 4
-	MAX_CHILDREN = MAX_KEYS + 1
+	DEFAULT_ORDER = 32
+	MIN_ORDER     = 3
 )
 
-type BPlusTree struct {
-	root BPlusTreeNode
+// Comparator orders keys: negative when a < b, zero when equal, positive
+// when a > b
+type Comparator[K any] func(a, b K) int
+
+// BPlusTree is an in-memory B+tree. Order is the maximum number of
+// children of an internal node; nodes hold at most order-1 keys and, other
+// than the root, at least (order-1)/2. It is not safe for concurrent use.
+type BPlusTree[K, V any] struct {
+	root    BPlusTreeNode[K, V]
+	cmp     Comparator[K]
+	maxKeys int
+	minKeys int
+	length  int
 }
 
-func NewBPlusTree() *BPlusTree {
-	return &BPlusTree{
-		root: NewBPlusTreeLeafNode(),
+// NewBPlusTree creates an empty tree ordered by cmp. An order of 0 selects
+// DEFAULT_ORDER.
+func NewBPlusTree[K, V any](cmp Comparator[K], order int) *BPlusTree[K, V] {
+	if order == 0 {
+		order = DEFAULT_ORDER
+	}
+	if order < MIN_ORDER {
+		panic(fmt.Sprintf("bptree_ram: order %d is below %d", order, MIN_ORDER))
+	}
+	return &BPlusTree[K, V]{
+		root:    NewBPlusTreeLeafNode[K, V](),
+		cmp:     cmp,
+		maxKeys: order - 1,
+		minKeys: (order - 1) / 2,
 	}
 }
 
-func (tree *BPlusTree) Insert(key int, val int) {
-	disSplit, sep, newNode := tree.insertHelper(tree.root, key, val)
+// NewOrdered creates an empty tree over keys with a natural order
+func NewOrdered[K cmp.Ordered, V any](order int) *BPlusTree[K, V] {
+	return NewBPlusTree[K, V](cmp.Compare[K], order)
+}
+
+// Len returns the number of keys in the tree
+func (tree *BPlusTree[K, V]) Len() int {
+	return tree.length
+}
 
-	if disSplit {
+// lowerBound returns the first position in keys whose key >= key
+func (tree *BPlusTree[K, V]) lowerBound(keys []K, key K) int {
+	return sort.Search(len(keys), func(i int) bool {
+		return tree.cmp(keys[i], key) >= 0
+	})
+}
+
+// childIndex returns the child of n that key belongs to
+func (tree *BPlusTree[K, V]) childIndex(n *BPlusTreeInternalNode[K, V], key K) int {
+	return sort.Search(len(n.keys), func(i int) bool {
+		return tree.cmp(n.keys[i], key) > 0
+	})
+}
+
+// findLeaf returns the leaf that holds key if it is present
+func (tree *BPlusTree[K, V]) findLeaf(key K) *BPlusTreeLeafNode[K, V] {
+	node := tree.root
+	for {
+		switch n := node.(type) {
+		case *BPlusTreeLeafNode[K, V]:
+			return n
+		case *BPlusTreeInternalNode[K, V]:
+			node = n.children[tree.childIndex(n, key)]
+		}
+	}
+}
+
+// Get returns the value stored under key
+func (tree *BPlusTree[K, V]) Get(key K) (V, bool) {
+	leaf := tree.findLeaf(key)
+	i := tree.lowerBound(leaf.keys, key)
+	if i < len(leaf.keys) && tree.cmp(leaf.keys[i], key) == 0 {
+		return leaf.values[i], true
+	}
+	var zero V
+	return zero, false
+}
+
+// Insert stores val under key, replacing any previous value
+func (tree *BPlusTree[K, V]) Insert(key K, val V) {
+	didSplit, sep, newNode := tree.insertHelper(tree.root, key, val)
+
+	if didSplit {
 		// Root split! Create new root
-		newRoot := NewBPlusTreeInternalNode()
-		newRoot.children[0] = tree.root
-		newRoot.children[1] = newNode
-		newRoot.keys[0] = sep
-		newRoot.nkey = 1
+		newRoot := NewBPlusTreeInternalNode[K, V]()
+		newRoot.keys = []K{sep}
+		newRoot.children = []BPlusTreeNode[K, V]{tree.root, newNode}
 		tree.root = newRoot
 	}
 }
 
-func (tree *BPlusTree) insertHelper(node BPlusTreeNode, key, val int) (bool, int, BPlusTreeNode) {
+func (tree *BPlusTree[K, V]) insertHelper(node BPlusTreeNode[K, V], key K, val V) (bool, K, BPlusTreeNode[K, V]) {
 	switch n := node.(type) {
-	case *BPlusTreeLeafNode:
+	case *BPlusTreeLeafNode[K, V]:
 		return tree.insertIntoLeaf(n, key, val)
-	case *BPlusTreeInternalNode:
+	case *BPlusTreeInternalNode[K, V]:
 		return tree.insertIntoInternal(n, key, val)
 	}
-	return false, 0, nil
+	var zero K
+	return false, zero, nil
 }
 
-func (tree *BPlusTree) insertIntoLeaf(n *BPlusTreeLeafNode, key, val int) (bool, int, BPlusTreeNode) {
-	if !n.isFull() {
-		n.InsertKV(key, val)
-		return false, 0, nil
+func (tree *BPlusTree[K, V]) insertIntoLeaf(n *BPlusTreeLeafNode[K, V], key K, val V) (bool, K, BPlusTreeNode[K, V]) {
+	var zero K
+	i := tree.lowerBound(n.keys, key)
+	if i < len(n.keys) && tree.cmp(n.keys[i], key) == 0 {
+		n.values[i] = val
+		return false, zero, nil
+	}
+
+	tree.length++
+	if len(n.keys) < tree.maxKeys {
+		n.insertAt(i, key, val)
+		return false, zero, nil
 	}
 
 	// Split and insert
 	right := n.Split()
-	if key < right.keys[0] {
-		n.InsertKV(key, val)
+	if tree.cmp(key, right.keys[0]) < 0 {
+		n.insertAt(i, key, val)
 	} else {
-		right.InsertKV(key, val)
+		right.insertAt(i-len(n.keys), key, val)
 	}
 	return true, right.keys[0], right
 }
 
-func (tree *BPlusTree) insertIntoInternal(n *BPlusTreeInternalNode, key, val int) (bool, int, BPlusTreeNode) {
-	// Find child for insertion
-	idx := utils.FindLastLE(n.keys[:], n.nkey, key)
-	childIdx := idx + 1
+func (tree *BPlusTree[K, V]) insertIntoInternal(n *BPlusTreeInternalNode[K, V], key K, val V) (bool, K, BPlusTreeNode[K, V]) {
+	var zero K
 
 	// Recursively insert
+	childIdx := tree.childIndex(n, key)
 	didSplit, sep, newNode := tree.insertHelper(n.children[childIdx], key, val)
 	if !didSplit {
-		return false, 0, nil
+		return false, zero, nil
 	}
 
 	// Handle child split
-	if !n.isFull() {
-		n.InsertKV(sep, newNode)
-		return false, 0, nil
+	n.insertAt(childIdx, sep, newNode)
+	if len(n.keys) <= tree.maxKeys {
+		return false, zero, nil
 	}
 
-	// This node is also full, split it
+	// This node is now overfull, split it
 	promotedKey, rightChild := n.Split()
-	if sep <= promotedKey {
-		n.InsertKV(sep, newNode)
-	} else {
-		rightChild.InsertKV(sep, newNode)
-	}
 	return true, promotedKey, rightChild
 }
@@ -1,43 +1,50 @@
 package bptree_ram
 
 import (
+	"math/rand"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// setupTree creates a tree whose nodes hold at most 4 keys
+func setupTree() *BPlusTree[int, int] {
+	return NewOrdered[int, int](5)
+}
+
 func TestInternalInsertKV(t *testing.T) {
-	root := NewBPlusTreeInternalNode()
-	leaf1 := NewBPlusTreeLeafNode()
-	leaf2 := NewBPlusTreeLeafNode()
+	leaf0 := NewBPlusTreeLeafNode[int, int]()
+	leaf1 := NewBPlusTreeLeafNode[int, int]()
+	leaf2 := NewBPlusTreeLeafNode[int, int]()
+	root := NewBPlusTreeInternalNode[int, int]()
+	root.children = []BPlusTreeNode[int, int]{leaf0}
 
 	// Insert first key and child
-	root.InsertKV(10, leaf1)
-	assert.Equal(t, root.nkey, 1)
-	assert.Equal(t, root.keys[0], 10)
-	assert.Equal(t, root.children[1], leaf1)
-
-	// Insert second key and child
-	root.InsertKV(20, leaf2)
-	assert.Equal(t, root.nkey, 2)
-	assert.Equal(t, root.keys[1], 20)
-	assert.Equal(t, root.children[2], leaf2)
-
-	// Split
-	leaf3 := NewBPlusTreeLeafNode()
-	leaf4 := NewBPlusTreeLeafNode()
-	leaf5 := NewBPlusTreeLeafNode()
-	root.InsertKV(30, leaf3)
-	root.InsertKV(40, leaf4)
-	assert.Panics(t, func() { root.InsertKV(50, leaf5) })
+	root.insertAt(0, 10, leaf1)
+	assert.Equal(t, []int{10}, root.keys)
+	assert.Equal(t, leaf1, root.children[1])
+
+	// Insert a key before it; the new child goes to its right
+	root.insertAt(0, 5, leaf2)
+	assert.Equal(t, []int{5, 10}, root.keys)
+	assert.Equal(t, []BPlusTreeNode[int, int]{leaf0, leaf2, leaf1}, root.children)
+
+	root.removeAt(0)
+	assert.Equal(t, []int{10}, root.keys)
+	assert.Equal(t, []BPlusTreeNode[int, int]{leaf0, leaf1}, root.children)
 }
 
 func TestInternalNodeSplit(t *testing.T) {
-	node := NewBPlusTreeInternalNode()
+	node := NewBPlusTreeInternalNode[int, int]()
 
 	// Setup: Create a full internal node
-	node.keys = [MAX_KEYS]int{10, 20, 30, 40}
-	node.nkey = MAX_KEYS
+	node.keys = []int{10, 20, 30, 40}
+	for i := 0; i < 5; i++ {
+		node.children = append(node.children, NewBPlusTreeLeafNode[int, int]())
+	}
+	children := append([]BPlusTreeNode[int, int]{}, node.children...)
 
 	// Split
 	promotedKey, right := node.Split()
@@ -46,62 +53,60 @@ func TestInternalNodeSplit(t *testing.T) {
 	assert.Equal(t, 30, promotedKey, "Middle key should be promoted")
 
 	// Verify left node (original)
-	assert.Equal(t, 2, node.nkey, "Left should have 2 keys")
-	assert.Equal(t, 10, node.keys[0])
-	assert.Equal(t, 20, node.keys[1])
+	assert.Equal(t, []int{10, 20}, node.keys, "Left should have 2 keys")
+	assert.Equal(t, children[:3], node.children)
 
 	// Verify right node
-	assert.Equal(t, 1, right.nkey, "Right should have 1 key")
-	assert.Equal(t, 40, right.keys[0])
+	assert.Equal(t, []int{40}, right.keys, "Right should have 1 key")
+	assert.Equal(t, children[3:], right.children)
 }
 
 func TestLeafNodeInsertKV(t *testing.T) {
-	leaf := NewBPlusTreeLeafNode()
+	tree := setupTree()
 
 	// Insert out of order
 	// Expected: [5, 10, 20, 30]
-	leaf.InsertKV(30, 300)
-	leaf.InsertKV(10, 100)
-	leaf.InsertKV(20, 200)
-	leaf.InsertKV(5, 50)
+	tree.Insert(30, 300)
+	tree.Insert(10, 100)
+	tree.Insert(20, 200)
+	tree.Insert(5, 50)
 
-	assert.Equal(t, 50, leaf.values[0])
-	assert.Equal(t, 100, leaf.values[1])
-	assert.Equal(t, 200, leaf.values[2])
-	assert.Equal(t, 300, leaf.values[3])
+	leaf := tree.root.(*BPlusTreeLeafNode[int, int])
+	assert.Equal(t, []int{5, 10, 20, 30}, leaf.keys)
+	assert.Equal(t, []int{50, 100, 200, 300}, leaf.values)
 
 	// Insert duplicated key
 	// Expected: keys: [5, 10, 20, 30], values: [50, 150, 200, 300]
-	leaf.InsertKV(10, 150)
+	tree.Insert(10, 150)
 	assert.Equal(t, 150, leaf.values[1])
-
-	// Insert full node
-	assert.Panics(t, func() { leaf.InsertKV(40, 400) })
+	assert.Equal(t, 4, tree.Len())
 }
 
 func TestLeafNodeSplit(t *testing.T) {
-	node := NewBPlusTreeLeafNode()
+	node := NewBPlusTreeLeafNode[int, int]()
+	next := NewBPlusTreeLeafNode[int, int]()
 
-	// Setup: Create a full internal node
-	node.keys = [MAX_KEYS]int{10, 20, 30, 40}
-	node.nkey = MAX_KEYS
+	// Setup: Create a full leaf node
+	node.keys = []int{10, 20, 30, 40}
+	node.values = []int{100, 200, 300, 400}
+	node.next = next
 
 	// Split
 	right := node.Split()
 
 	// Verify left node (original)
-	assert.Equal(t, 2, node.nkey, "Left should have 2 keys")
-	assert.Equal(t, 10, node.keys[0])
-	assert.Equal(t, 20, node.keys[1])
+	assert.Equal(t, []int{10, 20}, node.keys, "Left should have 2 keys")
+	assert.Equal(t, []int{100, 200}, node.values)
+	assert.Equal(t, right, node.next)
 
 	// Verify right node
-	assert.Equal(t, 2, right.nkey, "Right should have 2 key")
-	assert.Equal(t, 30, right.keys[0])
-	assert.Equal(t, 40, right.keys[1])
+	assert.Equal(t, []int{30, 40}, right.keys, "Right should have 2 keys")
+	assert.Equal(t, []int{300, 400}, right.values)
+	assert.Equal(t, next, right.next)
 }
 
 func TestBPlusTreeMultipleInserts(t *testing.T) {
-	tree := NewBPlusTree()
+	tree := setupTree()
 
 	// Insert keys to trigger root split
 	tree.Insert(10, 100)
@@ -117,20 +122,18 @@ func TestBPlusTreeMultipleInserts(t *testing.T) {
 	tree.Insert(12, 120)
 
 	// Verify structure
-	root, ok := tree.root.(*BPlusTreeInternalNode)
+	root, ok := tree.root.(*BPlusTreeInternalNode[int, int])
 	assert.True(t, ok, "Root should be internal")
 
-	assert.Equal(t, 2, root.nkey)
-	assert.Equal(t, 20, root.keys[0])
-	assert.Equal(t, 30, root.keys[1])
+	assert.Equal(t, []int{20, 30}, root.keys)
 
-	assert.Equal(t, 10, root.children[0].(*BPlusTreeLeafNode).keys[0])
-	assert.Equal(t, 20, root.children[1].(*BPlusTreeLeafNode).keys[0])
-	assert.Equal(t, 30, root.children[2].(*BPlusTreeLeafNode).keys[0])
+	assert.Equal(t, 10, root.children[0].(*BPlusTreeLeafNode[int, int]).keys[0])
+	assert.Equal(t, 20, root.children[1].(*BPlusTreeLeafNode[int, int]).keys[0])
+	assert.Equal(t, 30, root.children[2].(*BPlusTreeLeafNode[int, int]).keys[0])
 }
 
 func TestBPlusTreeInsertWithSplit(t *testing.T) {
-	tree := NewBPlusTree()
+	tree := setupTree()
 
 	tree.Insert(10, 100)
 	tree.Insert(20, 200)
@@ -142,27 +145,196 @@ func TestBPlusTreeInsertWithSplit(t *testing.T) {
 	tree.Insert(35, 350)
 
 	// Expected:
-	// Left: [10, 20, 25], nkey=3
-	// Right: [30, 35, 40], nkey=2
+	// Left: [10, 20, 25]
+	// Right: [30, 35, 40]
 	// Verify root is now internal
-	root, ok := tree.root.(*BPlusTreeInternalNode)
+	root, ok := tree.root.(*BPlusTreeInternalNode[int, int])
 	assert.True(t, ok, "Root should be internal node after split")
 
 	// Verify children
-	leftChild, ok := root.children[0].(*BPlusTreeLeafNode)
+	leftChild, ok := root.children[0].(*BPlusTreeLeafNode[int, int])
 	assert.True(t, ok, "Left child should be leaf")
 
-	rightChild, ok := root.children[1].(*BPlusTreeLeafNode)
+	rightChild, ok := root.children[1].(*BPlusTreeLeafNode[int, int])
 	assert.True(t, ok, "Right child should be leaf")
 
 	// Verify keys in each leaf
-	assert.Equal(t, 3, leftChild.nkey)
-	assert.Equal(t, 10, leftChild.keys[0])
-	assert.Equal(t, 20, leftChild.keys[1])
-	assert.Equal(t, 25, leftChild.keys[2])
-
-	assert.Equal(t, 3, rightChild.nkey)
-	assert.Equal(t, 30, rightChild.keys[0])
-	assert.Equal(t, 35, rightChild.keys[1])
-	assert.Equal(t, 40, rightChild.keys[2])
+	assert.Equal(t, []int{10, 20, 25}, leftChild.keys)
+	assert.Equal(t, []int{30, 35, 40}, rightChild.keys)
+	assert.Equal(t, rightChild, leftChild.next)
+}
+
+// checkInvariants walks the tree and checks key order, separator bounds,
+// node occupancy, uniform leaf depth and the leaf chain
+func checkInvariants(t *testing.T, tree *BPlusTree[int, int]) {
+	var leaves []*BPlusTreeLeafNode[int, int]
+	depth := -1
+
+	var walk func(node BPlusTreeNode[int, int], lo, hi *int, d int, root bool)
+	walk = func(node BPlusTreeNode[int, int], lo, hi *int, d int, root bool) {
+		if !root {
+			require.GreaterOrEqual(t, node.numKeys(), tree.minKeys, "underfull node")
+		}
+		require.LessOrEqual(t, node.numKeys(), tree.maxKeys, "overfull node")
+
+		var keys []int
+		switch n := node.(type) {
+		case *BPlusTreeLeafNode[int, int]:
+			keys = n.keys
+			leaves = append(leaves, n)
+			if depth < 0 {
+				depth = d
+			}
+			require.Equal(t, depth, d, "leaves at different depths")
+		case *BPlusTreeInternalNode[int, int]:
+			keys = n.keys
+			require.Len(t, n.children, len(n.keys)+1)
+			for i, child := range n.children {
+				clo, chi := lo, hi
+				if i > 0 {
+					clo = &n.keys[i-1]
+				}
+				if i < len(n.keys) {
+					chi = &n.keys[i]
+				}
+				walk(child, clo, chi, d+1, false)
+			}
+		}
+
+		require.True(t, sort.IntsAreSorted(keys))
+		for _, k := range keys {
+			if lo != nil {
+				require.GreaterOrEqual(t, k, *lo)
+			}
+			if hi != nil {
+				require.Less(t, k, *hi)
+			}
+		}
+	}
+	walk(tree.root, nil, nil, 0, true)
+
+	for i, leaf := range leaves {
+		if i+1 < len(leaves) {
+			require.Equal(t, leaves[i+1], leaf.next)
+		} else {
+			require.Nil(t, leaf.next)
+		}
+		if i > 0 {
+			require.Equal(t, leaves[i-1], leaf.prev)
+		} else {
+			require.Nil(t, leaf.prev)
+		}
+	}
+}
+
+func TestBPlusTreeRandomOps(t *testing.T) {
+	for _, order := range []int{3, 4, 5, 8, 0} {
+		tree := NewOrdered[int, int](order)
+		ref := map[int]int{}
+		r := rand.New(rand.NewSource(int64(order)))
+
+		for i := 0; i < 5000; i++ {
+			k := r.Intn(1000)
+			if r.Intn(3) == 0 {
+				_, ok := ref[k]
+				assert.Equal(t, ok, tree.Delete(k))
+				delete(ref, k)
+			} else {
+				tree.Insert(k, i)
+				ref[k] = i
+			}
+		}
+		checkInvariants(t, tree)
+		require.Equal(t, len(ref), tree.Len())
+
+		for k := 0; k < 1000; k++ {
+			want, ok := ref[k]
+			got, found := tree.Get(k)
+			require.Equal(t, ok, found)
+			require.Equal(t, want, got)
+		}
+
+		// Deleting everything leaves an empty leaf as root
+		for k := range ref {
+			require.True(t, tree.Delete(k))
+		}
+		checkInvariants(t, tree)
+		assert.Equal(t, 0, tree.Len())
+		assert.True(t, tree.root.isLeaf())
+		assert.False(t, tree.First().Valid())
+	}
+}
+
+func TestBPlusTreeIteration(t *testing.T) {
+	tree := setupTree()
+	for i := 0; i < 100; i++ {
+		tree.Insert(i*2, i)
+	}
+
+	var keys []int
+	tree.Ascend(func(k, v int) bool {
+		keys = append(keys, k)
+		return true
+	})
+	require.Len(t, keys, 100)
+	assert.True(t, sort.IntsAreSorted(keys))
+
+	// [start, end) with bounds between keys
+	keys = nil
+	tree.AscendRange(9, 20, func(k, v int) bool {
+		keys = append(keys, k)
+		return true
+	})
+	assert.Equal(t, []int{10, 12, 14, 16, 18}, keys)
+
+	// Stopping early
+	keys = nil
+	tree.AscendRange(0, 200, func(k, v int) bool {
+		keys = append(keys, k)
+		return len(keys) < 3
+	})
+	assert.Equal(t, []int{0, 2, 4}, keys)
+
+	it := tree.SeekGE(197)
+	require.True(t, it.Valid())
+	assert.Equal(t, 198, it.Key())
+	assert.Equal(t, 99, it.Value())
+	it.Next()
+	assert.False(t, it.Valid())
+	assert.False(t, tree.SeekGE(199).Valid())
+
+	// Backwards
+	keys = nil
+	for it := tree.SeekLE(9); it.Valid(); it.Prev() {
+		keys = append(keys, it.Key())
+	}
+	assert.Equal(t, []int{8, 6, 4, 2, 0}, keys)
+	assert.Equal(t, 198, tree.Last().Key())
+	assert.Equal(t, 10, tree.SeekLE(10).Key())
+	assert.False(t, tree.SeekLE(-1).Valid())
+}
+
+func TestBPlusTreeComparator(t *testing.T) {
+	// Descending order through a custom comparator
+	tree := NewBPlusTree[string, int](func(a, b string) int {
+		switch {
+		case a > b:
+			return -1
+		case a < b:
+			return 1
+		}
+		return 0
+	}, 3)
+	for _, k := range []string{"b", "d", "a", "c", "e"} {
+		tree.Insert(k, len(k))
+	}
+
+	var keys []string
+	tree.Ascend(func(k string, v int) bool {
+		keys = append(keys, k)
+		return true
+	})
+	assert.Equal(t, []string{"e", "d", "c", "b", "a"}, keys)
+
+	assert.Panics(t, func() { NewOrdered[int, int](2) })
 }
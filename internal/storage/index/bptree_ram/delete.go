@@ -0,0 +1,121 @@
+package bptree_ram
+
+// Delete removes key and reports whether it was present. Nodes left below
+// the minimum borrow a key from a sibling, or are merged with it when the
+// sibling has none to spare.
+func (tree *BPlusTree[K, V]) Delete(key K) bool {
+	if !tree.deleteHelper(tree.root, key) {
+		return false
+	}
+	tree.length--
+
+	// The root shrinks once it is left with a single child
+	if root, ok := tree.root.(*BPlusTreeInternalNode[K, V]); ok && len(root.keys) == 0 {
+		tree.root = root.children[0]
+	}
+	return true
+}
+
+func (tree *BPlusTree[K, V]) deleteHelper(node BPlusTreeNode[K, V], key K) bool {
+	switch n := node.(type) {
+	case *BPlusTreeLeafNode[K, V]:
+		i := tree.lowerBound(n.keys, key)
+		if i == len(n.keys) || tree.cmp(n.keys[i], key) != 0 {
+			return false
+		}
+		n.removeAt(i)
+		return true
+
+	case *BPlusTreeInternalNode[K, V]:
+		childIdx := tree.childIndex(n, key)
+		if !tree.deleteHelper(n.children[childIdx], key) {
+			return false
+		}
+		if n.children[childIdx].numKeys() < tree.minKeys {
+			tree.rebalance(n, childIdx)
+		}
+		return true
+	}
+	return false
+}
+
+// rebalance fixes the underflowing child idx of parent
+func (tree *BPlusTree[K, V]) rebalance(parent *BPlusTreeInternalNode[K, V], idx int) {
+	var left, right BPlusTreeNode[K, V]
+	if idx > 0 {
+		left = parent.children[idx-1]
+	}
+	if idx+1 < len(parent.children) {
+		right = parent.children[idx+1]
+	}
+
+	switch {
+	case left != nil && left.numKeys() > tree.minKeys:
+		tree.borrowFromLeft(parent, idx)
+	case right != nil && right.numKeys() > tree.minKeys:
+		tree.borrowFromRight(parent, idx)
+	case left != nil:
+		tree.merge(parent, idx-1)
+	default:
+		tree.merge(parent, idx)
+	}
+}
+
+// borrowFromLeft moves the last entry of child idx-1 to child idx
+func (tree *BPlusTree[K, V]) borrowFromLeft(parent *BPlusTreeInternalNode[K, V], idx int) {
+	switch n := parent.children[idx].(type) {
+	case *BPlusTreeLeafNode[K, V]:
+		left := parent.children[idx-1].(*BPlusTreeLeafNode[K, V])
+		last := len(left.keys) - 1
+		n.insertAt(0, left.keys[last], left.values[last])
+		left.removeAt(last)
+		parent.keys[idx-1] = n.keys[0]
+
+	case *BPlusTreeInternalNode[K, V]:
+		left := parent.children[idx-1].(*BPlusTreeInternalNode[K, V])
+		last := len(left.keys) - 1
+		n.keys = append([]K{parent.keys[idx-1]}, n.keys...)
+		n.children = append([]BPlusTreeNode[K, V]{left.children[last+1]}, n.children...)
+		parent.keys[idx-1] = left.keys[last]
+		left.removeAt(last)
+	}
+}
+
+// borrowFromRight moves the first entry of child idx+1 to child idx
+func (tree *BPlusTree[K, V]) borrowFromRight(parent *BPlusTreeInternalNode[K, V], idx int) {
+	switch n := parent.children[idx].(type) {
+	case *BPlusTreeLeafNode[K, V]:
+		right := parent.children[idx+1].(*BPlusTreeLeafNode[K, V])
+		n.insertAt(len(n.keys), right.keys[0], right.values[0])
+		right.removeAt(0)
+		parent.keys[idx] = right.keys[0]
+
+	case *BPlusTreeInternalNode[K, V]:
+		right := parent.children[idx+1].(*BPlusTreeInternalNode[K, V])
+		n.keys = append(n.keys, parent.keys[idx])
+		n.children = append(n.children, right.children[0])
+		parent.keys[idx] = right.keys[0]
+		right.keys = right.keys[1:]
+		right.children = right.children[1:]
+	}
+}
+
+// merge folds child idx+1 into child idx and drops their separator
+func (tree *BPlusTree[K, V]) merge(parent *BPlusTreeInternalNode[K, V], idx int) {
+	switch n := parent.children[idx].(type) {
+	case *BPlusTreeLeafNode[K, V]:
+		right := parent.children[idx+1].(*BPlusTreeLeafNode[K, V])
+		n.keys = append(n.keys, right.keys...)
+		n.values = append(n.values, right.values...)
+		n.next = right.next
+		if n.next != nil {
+			n.next.prev = n
+		}
+
+	case *BPlusTreeInternalNode[K, V]:
+		right := parent.children[idx+1].(*BPlusTreeInternalNode[K, V])
+		n.keys = append(append(n.keys, parent.keys[idx]), right.keys...)
+		n.children = append(n.children, right.children...)
+	}
+	parent.removeAt(idx)
+}
@@ -1,59 +1,54 @@
 package bptree_ram
 
-import "github.com/spaghetti-lover/go-db/internal/utils"
-
-type BPlusTreeInternalNode struct {
-	nkey     int
-	keys     [MAX_KEYS]int
-	children [MAX_CHILDREN]BPlusTreeNode
+// BPlusTreeInternalNode routes keys to its children:
+// keys[i] separates children[i] | children[i+1]
+type BPlusTreeInternalNode[K, V any] struct {
+	keys     []K
+	children []BPlusTreeNode[K, V]
 }
 
-func NewBPlusTreeInternalNode() *BPlusTreeInternalNode {
-	return &BPlusTreeInternalNode{}
+func NewBPlusTreeInternalNode[K, V any]() *BPlusTreeInternalNode[K, V] {
+	return &BPlusTreeInternalNode[K, V]{}
 }
 
-func (n *BPlusTreeInternalNode) isFull() bool {
-	return n.nkey >= MAX_KEYS
+func (n *BPlusTreeInternalNode[K, V]) isLeaf() bool {
+	return false
 }
 
-func (n *BPlusTreeInternalNode) InsertKV(key int, child BPlusTreeNode) {
-	idx := utils.FindLastLE(n.keys[:], n.nkey, key)
-
-	if idx != -1 && n.keys[idx] == key {
-		n.children[idx+1] = child
-		return
-	}
+func (n *BPlusTreeInternalNode[K, V]) numKeys() int {
+	return len(n.keys)
+}
 
-	if n.isFull() {
-		panic("InsertKV called on full internal node")
-	}
+// insertAt puts key at position i with child to its right
+func (n *BPlusTreeInternalNode[K, V]) insertAt(i int, key K, child BPlusTreeNode[K, V]) {
+	var zero K
+	n.keys = append(n.keys, zero)
+	copy(n.keys[i+1:], n.keys[i:])
+	n.keys[i] = key
 
-	for i := n.nkey; i > idx+1; i-- {
-		n.keys[i] = n.keys[i-1]
-		n.children[i+1] = n.children[i]
-	}
+	n.children = append(n.children, nil)
+	copy(n.children[i+2:], n.children[i+1:])
+	n.children[i+1] = child
+}
 
-	n.children[idx+2] = child
-	n.keys[idx+1] = key
-	n.nkey++
+// removeAt deletes keys[i] and the child to its right
+func (n *BPlusTreeInternalNode[K, V]) removeAt(i int) {
+	n.keys = append(n.keys[:i], n.keys[i+1:]...)
+	n.children = append(n.children[:i+1], n.children[i+2:]...)
 }
 
-func (n *BPlusTreeInternalNode) Split() (int, *BPlusTreeInternalNode) {
-	mid := n.nkey / 2
+// Split moves the keys above the middle one to a new right sibling. The
+// middle key is returned for the parent and kept in neither half.
+func (n *BPlusTreeInternalNode[K, V]) Split() (K, *BPlusTreeInternalNode[K, V]) {
+	mid := len(n.keys) / 2
 	promotedKey := n.keys[mid]
 
-	right := NewBPlusTreeInternalNode()
-
-	for i := mid + 1; i < n.nkey; i++ {
-		right.keys[i-mid-1] = n.keys[i]
+	right := &BPlusTreeInternalNode[K, V]{
+		keys:     append([]K{}, n.keys[mid+1:]...),
+		children: append([]BPlusTreeNode[K, V]{}, n.children[mid+1:]...),
 	}
 
-	for i := mid + 1; i <= n.nkey; i++ {
-		right.children[i-mid-1] = n.children[i]
-	}
-
-	right.nkey = n.nkey - mid - 1
-	n.nkey = mid
-
+	n.keys = n.keys[:mid:mid]
+	n.children = n.children[: mid+1 : mid+1]
 	return promotedKey, right
 }
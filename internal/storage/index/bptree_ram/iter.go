@@ -0,0 +1,120 @@
+package bptree_ram
+
+// Iter walks the tree in key order along the leaf chain. It is invalidated
+// by any change to the tree.
+type Iter[K, V any] struct {
+	leaf *BPlusTreeLeafNode[K, V]
+	idx  int
+}
+
+// First returns an iterator at the smallest key
+func (tree *BPlusTree[K, V]) First() *Iter[K, V] {
+	node := tree.root
+	for {
+		switch n := node.(type) {
+		case *BPlusTreeLeafNode[K, V]:
+			it := &Iter[K, V]{leaf: n}
+			it.skipEmpty()
+			return it
+		case *BPlusTreeInternalNode[K, V]:
+			node = n.children[0]
+		}
+	}
+}
+
+// Last returns an iterator at the largest key
+func (tree *BPlusTree[K, V]) Last() *Iter[K, V] {
+	node := tree.root
+	for {
+		switch n := node.(type) {
+		case *BPlusTreeLeafNode[K, V]:
+			it := &Iter[K, V]{leaf: n, idx: len(n.keys) - 1}
+			it.skipEmptyBack()
+			return it
+		case *BPlusTreeInternalNode[K, V]:
+			node = n.children[len(n.children)-1]
+		}
+	}
+}
+
+// SeekLE returns an iterator at the last key <= key
+func (tree *BPlusTree[K, V]) SeekLE(key K) *Iter[K, V] {
+	leaf := tree.findLeaf(key)
+	i := tree.lowerBound(leaf.keys, key)
+	if i == len(leaf.keys) || tree.cmp(leaf.keys[i], key) != 0 {
+		i--
+	}
+	it := &Iter[K, V]{leaf: leaf, idx: i}
+	it.skipEmptyBack()
+	return it
+}
+
+// SeekGE returns an iterator at the first key >= key
+func (tree *BPlusTree[K, V]) SeekGE(key K) *Iter[K, V] {
+	leaf := tree.findLeaf(key)
+	it := &Iter[K, V]{leaf: leaf, idx: tree.lowerBound(leaf.keys, key)}
+	it.skipEmpty()
+	return it
+}
+
+// skipEmpty moves past the end of the current leaf
+func (it *Iter[K, V]) skipEmpty() {
+	for it.leaf != nil && it.idx >= len(it.leaf.keys) {
+		it.leaf = it.leaf.next
+		it.idx = 0
+	}
+}
+
+// skipEmptyBack moves before the start of the current leaf
+func (it *Iter[K, V]) skipEmptyBack() {
+	for it.leaf != nil && it.idx < 0 {
+		it.leaf = it.leaf.prev
+		if it.leaf != nil {
+			it.idx = len(it.leaf.keys) - 1
+		}
+	}
+}
+
+// Valid reports whether the iterator is at a key
+func (it *Iter[K, V]) Valid() bool {
+	return it.leaf != nil
+}
+
+func (it *Iter[K, V]) Key() K {
+	return it.leaf.keys[it.idx]
+}
+
+func (it *Iter[K, V]) Value() V {
+	return it.leaf.values[it.idx]
+}
+
+// Next moves to the following key
+func (it *Iter[K, V]) Next() {
+	it.idx++
+	it.skipEmpty()
+}
+
+// Prev moves to the preceding key
+func (it *Iter[K, V]) Prev() {
+	it.idx--
+	it.skipEmptyBack()
+}
+
+// Ascend calls fn for every key in order until fn returns false
+func (tree *BPlusTree[K, V]) Ascend(fn func(key K, val V) bool) {
+	for it := tree.First(); it.Valid(); it.Next() {
+		if !fn(it.Key(), it.Value()) {
+			return
+		}
+	}
+}
+
+// AscendRange calls fn for every key in [start, end) in order until fn
+// returns false
+func (tree *BPlusTree[K, V]) AscendRange(start, end K, fn func(key K, val V) bool) {
+	for it := tree.SeekGE(start); it.Valid() && tree.cmp(it.Key(), end) < 0; it.Next() {
+		if !fn(it.Key(), it.Value()) {
+			return
+		}
+	}
+}
@@ -1,57 +1,59 @@
 package bptree_ram
 
-import "github.com/spaghetti-lover/go-db/internal/utils"
-
-type BPlusTreeLeafNode struct {
-	nkey   int
-	keys   [MAX_KEYS]int
-	values [MAX_KEYS]int
+// BPlusTreeLeafNode holds keys in order with their values. Leaves are
+// linked to both siblings for iteration.
+type BPlusTreeLeafNode[K, V any] struct {
+	keys   []K
+	values []V
+	prev   *BPlusTreeLeafNode[K, V]
+	next   *BPlusTreeLeafNode[K, V]
 }
 
-func NewBPlusTreeLeafNode() *BPlusTreeLeafNode {
-	return &BPlusTreeLeafNode{}
+func NewBPlusTreeLeafNode[K, V any]() *BPlusTreeLeafNode[K, V] {
+	return &BPlusTreeLeafNode[K, V]{}
 }
 
-func (n *BPlusTreeLeafNode) isFull() bool {
-	return n.nkey >= MAX_KEYS
+func (n *BPlusTreeLeafNode[K, V]) isLeaf() bool {
+	return true
 }
 
-func (n *BPlusTreeLeafNode) InsertKV(key int, val int) {
-	idx := utils.FindLastLE(n.keys[:], n.nkey, key)
-
-	// Update existing key
-	if idx != -1 && key == n.keys[idx] {
-		n.values[idx] = val
-		return
-	}
-
-	if n.isFull() {
-		panic("InsertKV called on full leaf node")
-	}
-
-	// Shift and insert
-	if n.nkey < MAX_KEYS {
-		for i := n.nkey; i > idx+1; i-- {
-			n.keys[i] = n.keys[i-1]
-			n.values[i] = n.values[i-1]
-		}
+func (n *BPlusTreeLeafNode[K, V]) numKeys() int {
+	return len(n.keys)
+}
 
-		n.values[idx+1] = val
-		n.keys[idx+1] = key
-		n.nkey++
-	}
+// insertAt puts key and val at position i
+func (n *BPlusTreeLeafNode[K, V]) insertAt(i int, key K, val V) {
+	var zeroK K
+	var zeroV V
+	n.keys = append(n.keys, zeroK)
+	n.values = append(n.values, zeroV)
+	copy(n.keys[i+1:], n.keys[i:])
+	copy(n.values[i+1:], n.values[i:])
+	n.keys[i] = key
+	n.values[i] = val
 }
 
-func (n *BPlusTreeLeafNode) Split() *BPlusTreeLeafNode {
-	mid := n.nkey / 2
-	right := NewBPlusTreeLeafNode()
+// removeAt deletes the entry at position i
+func (n *BPlusTreeLeafNode[K, V]) removeAt(i int) {
+	n.keys = append(n.keys[:i], n.keys[i+1:]...)
+	n.values = append(n.values[:i], n.values[i+1:]...)
+}
 
-	for i := mid; i < n.nkey; i++ {
-		right.keys[i-mid] = n.keys[i]
-		right.values[i-mid] = n.values[i]
+// Split moves the upper half of the entries to a new right sibling
+func (n *BPlusTreeLeafNode[K, V]) Split() *BPlusTreeLeafNode[K, V] {
+	mid := len(n.keys) / 2
+	right := &BPlusTreeLeafNode[K, V]{
+		keys:   append([]K{}, n.keys[mid:]...),
+		values: append([]V{}, n.values[mid:]...),
+		prev:   n,
+		next:   n.next,
+	}
+	if n.next != nil {
+		n.next.prev = right
 	}
 
-	right.nkey = n.nkey - mid
-	n.nkey = mid
+	n.keys = n.keys[:mid:mid]
+	n.values = n.values[:mid:mid]
+	n.next = right
 	return right
 }
@@ -1,4 +1,7 @@
 package bptree_ram
 
-type BPlusTreeNode interface {
+// BPlusTreeNode is a leaf or an internal node
+type BPlusTreeNode[K, V any] interface {
+	isLeaf() bool
+	numKeys() int
 }
@@ -0,0 +1,226 @@
+package hash_disk
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+)
+
+// newCell builds the bucket entry for key/value, spilling a value that
+// does not fit next to its key to overflow pages
+func (h *HashIndex) newCell(key, value []byte) (disk.KeyVal, error) {
+	kv := disk.NewKeyValFromBytes(key, value)
+	if len(key) > disk.MAX_KEY_SIZE {
+		return disk.KeyVal{}, disk.ErrKeyTooLarge
+	}
+	if kv.CellSize() <= disk.MaxLeafCellSize(h.blockSize) {
+		return kv, nil
+	}
+	if uint64(len(value)) > math.MaxUint32 {
+		return disk.KeyVal{}, disk.ErrValueTooLarge
+	}
+
+	head, err := disk.WriteOverflow(h.pager, value)
+	if err != nil {
+		return disk.KeyVal{}, err
+	}
+	kv.Val = nil
+	kv.Overflow = head
+	kv.ValSize = uint32(len(value))
+	return kv, nil
+}
+
+// bucketPage returns a bucket page holding entries, sorted by key
+func (h *HashIndex) bucketPage(entries []disk.KeyVal, next uint64) *disk.LeafPage {
+	page := disk.NewLeafPage()
+	page.Header.PageType = disk.PageTypeBucket
+	page.Header.NextPagePointer = next
+	page.BlockSize = h.blockSize
+	page.KVs = entries
+	return page
+}
+
+func (h *HashIndex) loadBucket(pid uint64) (*disk.LeafPage, error) {
+	buf, err := h.pager.FetchPage(pid)
+	if err != nil {
+		return nil, err
+	}
+	defer h.pager.UnpinPage(pid, false)
+
+	if typ := disk.PageTypeOf(buf); typ != disk.PageTypeBucket {
+		return nil, fmt.Errorf("page %d: bucket page has type %d", pid, typ)
+	}
+	page := &disk.LeafPage{}
+	if err := page.ReadFromBuffer(bytes.NewBuffer(buf), true); err != nil {
+		return nil, fmt.Errorf("page %d: %w", pid, err)
+	}
+	return page, nil
+}
+
+// insert puts cell in the first page of the bucket chain with room for it
+// and reports whether one had
+func (h *HashIndex) insert(bucket uint64, cell *disk.KeyVal) (bool, error) {
+	for pid := bucket; pid != 0; {
+		var fit bool
+		var next uint64
+		err := h.pager.UpdatePage(pid, func(buf []byte) bool {
+			v := disk.NewLeafView(buf, nil)
+			next = v.Next()
+			fit = v.Insert(v.LowerBound(cell.Key), cell)
+			return fit
+		})
+		if err != nil || fit {
+			return fit, err
+		}
+		pid = next
+	}
+	return false, nil
+}
+
+// remove deletes key from its bucket chain, freeing a spilled value
+func (h *HashIndex) remove(key []byte) (bool, error) {
+	for pid := h.dir[h.bucketOf(key)]; pid != 0; {
+		var found bool
+		var next, overflow uint64
+		err := h.pager.UpdatePage(pid, func(buf []byte) bool {
+			v := disk.NewLeafView(buf, nil)
+			next = v.Next()
+			i := v.LowerBound(key)
+			if i == v.NKeys() || !bytes.Equal(v.Key(i), key) {
+				return false
+			}
+			if cell := v.Cell(i); cell.HasOverflow() {
+				overflow = cell.Overflow
+			}
+			v.Delete(i)
+			found = true
+			return true
+		})
+		if err != nil {
+			return false, err
+		}
+		if found {
+			if overflow != 0 {
+				return true, disk.FreeOverflow(h.pager, overflow)
+			}
+			return true, nil
+		}
+		pid = next
+	}
+	return false, nil
+}
+
+// split divides the bucket of directory slot on the next bit of the hash,
+// doubling the directory first when that bit is beyond its depth
+func (h *HashIndex) split(slot int) error {
+	bucket := h.dir[slot]
+	d := h.local[bucket]
+	if d == h.depth {
+		h.dir = append(h.dir, h.dir...)
+		h.depth++
+		for i := 0; i*dirPerPage(h.blockSize) < len(h.dir); i++ {
+			h.dirDirty[i] = true
+		}
+	}
+
+	var low, high []disk.KeyVal
+	for pid := bucket; pid != 0; {
+		page, err := h.loadBucket(pid)
+		if err != nil {
+			return err
+		}
+		for _, kv := range page.KVs {
+			if hashKey(kv.Key)>>d&1 == 0 {
+				low = append(low, kv)
+			} else {
+				high = append(high, kv)
+			}
+		}
+		if pid != bucket {
+			h.pager.FreePage(pid)
+		}
+		pid = page.Header.NextPagePointer
+	}
+
+	if _, err := h.writeChain(bucket, low); err != nil {
+		return err
+	}
+	sibling, err := h.writeChain(0, high)
+	if err != nil {
+		return err
+	}
+
+	per := dirPerPage(h.blockSize)
+	for j := range h.dir {
+		if h.dir[j] == bucket && j>>d&1 == 1 {
+			h.dir[j] = sibling
+			h.dirDirty[j/per] = true
+		}
+	}
+	h.local[bucket] = d + 1
+	h.local[sibling] = d + 1
+	return nil
+}
+
+// writeChain stores entries in a chain of bucket pages, the first one at
+// first, or in a new page when first is 0, and returns the first page
+func (h *HashIndex) writeChain(first uint64, entries []disk.KeyVal) (uint64, error) {
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].Key, entries[j].Key) < 0
+	})
+
+	groups := [][]disk.KeyVal{nil}
+	size := disk.LEAF_HEADER_SIZE
+	for _, kv := range entries {
+		if size+kv.CellSize() > h.blockSize {
+			groups = append(groups, nil)
+			size = disk.LEAF_HEADER_SIZE
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], kv)
+		size += kv.CellSize()
+	}
+
+	// Back to front, so every page knows the next one
+	next := uint64(0)
+	for i := len(groups) - 1; i > 0; i-- {
+		pid, err := disk.NewPageFrom(h.pager, h.bucketPage(groups[i], next))
+		if err != nil {
+			return 0, err
+		}
+		next = pid
+	}
+	page := h.bucketPage(groups[0], next)
+	if first == 0 {
+		return disk.NewPageFrom(h.pager, page)
+	}
+	return first, disk.WritePageTo(h.pager, first, page)
+}
+
+// extendChain appends a page holding cell to the bucket chain
+func (h *HashIndex) extendChain(bucket uint64, cell *disk.KeyVal) error {
+	last := bucket
+	for {
+		buf, err := h.pager.FetchPage(last)
+		if err != nil {
+			return err
+		}
+		next := disk.NextPageOf(buf)
+		h.pager.UnpinPage(last, false)
+		if next == 0 {
+			break
+		}
+		last = next
+	}
+
+	pid, err := disk.NewPageFrom(h.pager, h.bucketPage([]disk.KeyVal{*cell}, 0))
+	if err != nil {
+		return err
+	}
+	return h.pager.UpdatePage(last, func(buf []byte) bool {
+		disk.NewLeafView(buf, nil).SetNext(pid)
+		return true
+	})
+}
@@ -0,0 +1,107 @@
+package hash_disk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+)
+
+// Directory page: header | n(2) | bucket page IDs(8 each). The pages are
+// chained through Header.NextPagePointer from the root pointer of the
+// meta page and hold the directory in slot order.
+const DIR_HEADER_SIZE = disk.PAGE_HEADER_SIZE + 2
+
+// dirPerPage is the number of directory slots one page holds
+func dirPerPage(blockSize int) int {
+	return (blockSize - DIR_HEADER_SIZE) / 8
+}
+
+// loadDirectory reads the directory pages and derives the global depth
+// and the local depth of every bucket: a bucket of local depth d is
+// referenced by 2^(global depth-d) slots
+func (h *HashIndex) loadDirectory() error {
+	for pid := h.meta.RootPID; pid != 0; {
+		buf, err := h.pager.FetchPage(pid)
+		if err != nil {
+			return err
+		}
+		if typ := disk.PageTypeOf(buf); typ != disk.PageTypeHashDir {
+			h.pager.UnpinPage(pid, false)
+			return fmt.Errorf("page %d: directory page has type %d", pid, typ)
+		}
+		n := int(binary.BigEndian.Uint16(buf[disk.PAGE_HEADER_SIZE:]))
+		for i := 0; i < n; i++ {
+			h.dir = append(h.dir, binary.BigEndian.Uint64(buf[DIR_HEADER_SIZE+8*i:]))
+		}
+		h.dirPages = append(h.dirPages, pid)
+		next := disk.NextPageOf(buf)
+		h.pager.UnpinPage(pid, false)
+		pid = next
+	}
+
+	n := len(h.dir)
+	if n == 0 || n&(n-1) != 0 {
+		return fmt.Errorf("hash directory has %d slots, expected a power of two", n)
+	}
+	h.depth = log2(n)
+
+	refs := map[uint64]int{}
+	for _, bucket := range h.dir {
+		refs[bucket]++
+	}
+	for bucket, r := range refs {
+		if r&(r-1) != 0 {
+			return fmt.Errorf("bucket %d is referenced by %d directory slots", bucket, r)
+		}
+		h.local[bucket] = h.depth - log2(r)
+	}
+	return nil
+}
+
+// writeDirectory writes the directory pages that changed, adding pages
+// when the directory has grown
+func (h *HashIndex) writeDirectory() error {
+	per := dirPerPage(h.blockSize)
+	for len(h.dirPages)*per < len(h.dir) {
+		pid, _, err := h.pager.NewPage()
+		if err != nil {
+			return err
+		}
+		h.pager.UnpinPage(pid, true)
+
+		if len(h.dirPages) == 0 {
+			h.meta.RootPID = pid
+			h.metaDirty = true
+		} else {
+			h.dirDirty[len(h.dirPages)-1] = true
+		}
+		h.dirDirty[len(h.dirPages)] = true
+		h.dirPages = append(h.dirPages, pid)
+	}
+
+	for i := range h.dirDirty {
+		slots := h.dir[i*per : min((i+1)*per, len(h.dir))]
+		header := disk.PageHeader{PageType: disk.PageTypeHashDir}
+		if i+1 < len(h.dirPages) {
+			header.NextPagePointer = h.dirPages[i+1]
+		}
+
+		buf := bytes.NewBuffer(make([]byte, 0, h.blockSize))
+		if err := header.WriteToBuffer(buf); err != nil {
+			return err
+		}
+		if err := binary.Write(buf, binary.BigEndian, uint16(len(slots))); err != nil {
+			return err
+		}
+		if err := binary.Write(buf, binary.BigEndian, slots); err != nil {
+			return err
+		}
+		if err := h.pager.WritePage(h.dirPages[i], buf.Bytes()); err != nil {
+			return err
+		}
+		delete(h.dirDirty, i)
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+package hash_disk
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"os"
+	"sync"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+)
+
+// MAX_GLOBAL_DEPTH bounds the directory at 2^MAX_GLOBAL_DEPTH buckets.
+// A full bucket whose local depth has reached it grows a chain of bucket
+// pages instead of splitting.
+const MAX_GLOBAL_DEPTH = 24
+
+var ErrKeyNotFound = errors.New("key not found")
+
+// HashIndex is an extendible hash table stored in a paged file. The
+// directory maps the low GlobalDepth bits of a key's hash to a bucket
+// page, and is kept in memory as well as in a chain of directory pages,
+// so a lookup reads a single bucket page. A full bucket is split in two
+// on the next bit of the hash; the directory doubles when that bit is
+// beyond its depth. Buckets are never merged.
+//
+// Bucket pages use the leaf page layout, keys sorted within a page, and
+// spill large values to overflow pages like the B+tree. The index is safe
+// for concurrent use; writers are serialized.
+type HashIndex struct {
+	pager     *disk.Pager
+	metaPID   uint64
+	meta      *disk.MetaPage
+	metaDirty bool
+	blockSize int
+
+	mu       sync.RWMutex
+	depth    uint            // global depth
+	dir      []uint64        // bucket page of each hash suffix
+	local    map[uint64]uint // local depth of each bucket
+	dirPages []uint64        // directory pages, in order
+	dirDirty map[int]bool    // directory pages to write on commit
+}
+
+// Options configures how an index file is opened
+type Options struct {
+	// CachePages bounds the number of pages held in memory.
+	// Zero means disk.DEFAULT_CACHE_PAGES.
+	CachePages int
+
+	// BlockSize is the page size of a new file. Zero means disk.BLOCK_SIZE.
+	BlockSize int
+}
+
+func Open(file string) (*HashIndex, error) {
+	return OpenWithOptions(file, Options{})
+}
+
+func OpenWithOptions(file string, opts Options) (*HashIndex, error) {
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := disk.ReadFileHeader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	blockSize := opts.BlockSize
+	if header != nil {
+		blockSize = int(header.BlockSize)
+	}
+
+	pager, err := disk.NewPagerWithOptions(f, disk.NewFileAllocator(), disk.PagerOptions{
+		Capacity:  opts.CachePages,
+		BlockSize: blockSize,
+	})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	h, err := NewHashIndex(pager)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+// NewHashIndex opens the index in pager, creating it in an empty file
+func NewHashIndex(pager *disk.Pager) (*HashIndex, error) {
+	h := &HashIndex{
+		pager:     pager,
+		blockSize: pager.BlockSize(),
+		local:     map[uint64]uint{},
+		dirDirty:  map[int]bool{},
+	}
+
+	meta, err := disk.LoadMeta(pager, h.metaPID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fresh file: one empty bucket
+	if meta.File.Magic == 0 {
+		h.meta = disk.NewMetaPage(h.blockSize)
+		h.meta.File.Features |= disk.FeatureHashIndex
+
+		bucket, err := h.writeChain(0, nil)
+		if err != nil {
+			return nil, err
+		}
+		h.dir = []uint64{bucket}
+		h.local[bucket] = 0
+		h.dirDirty[0] = true
+		if err := h.commit(); err != nil {
+			return nil, err
+		}
+		return h, nil
+	}
+
+	if err := meta.File.Validate(); err != nil {
+		return nil, err
+	}
+	if meta.File.Features&disk.FeatureHashIndex == 0 {
+		return nil, fmt.Errorf("%w: B+tree", disk.ErrWrongIndexType)
+	}
+	if int(meta.File.BlockSize) != h.blockSize {
+		return nil, fmt.Errorf("%w: file uses %d byte pages, pager uses %d", disk.ErrInvalidBlockSize, meta.File.BlockSize, h.blockSize)
+	}
+	h.meta = meta
+
+	if err := h.loadDirectory(); err != nil {
+		return nil, err
+	}
+	if err := disk.LoadAllocator(pager, meta); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *HashIndex) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.pager.Close()
+}
+
+// BlockSize returns the page size of the index file
+func (h *HashIndex) BlockSize() int {
+	return h.blockSize
+}
+
+// hashKey is stable across runs: the directory depends on it
+func hashKey(key []byte) uint64 {
+	f := fnv.New64a()
+	f.Write(key)
+	return f.Sum64()
+}
+
+// bucketOf returns the directory slot of key. Caller holds h.mu.
+func (h *HashIndex) bucketOf(key []byte) int {
+	return int(hashKey(key) & (1<<h.depth - 1))
+}
+
+// Get returns a copy of the value stored under key
+func (h *HashIndex) Get(key []byte) ([]byte, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for pid := h.dir[h.bucketOf(key)]; pid != 0; {
+		buf, err := h.pager.FetchPage(pid)
+		if err != nil {
+			return nil, err
+		}
+		v := disk.NewLeafView(buf, nil)
+		if i := v.LowerBound(key); i < v.NKeys() && bytes.Equal(v.Key(i), key) {
+			cell := v.Cell(i)
+			var val []byte
+			if cell.HasOverflow() {
+				h.pager.UnpinPage(pid, false)
+				return disk.ReadOverflow(h.pager, cell.Overflow, cell.ValSize)
+			}
+			val = append([]byte{}, cell.Val...)
+			h.pager.UnpinPage(pid, false)
+			return val, nil
+		}
+		next := v.Next()
+		h.pager.UnpinPage(pid, false)
+		pid = next
+	}
+	return nil, ErrKeyNotFound
+}
+
+// Set stores value under key, replacing any previous value
+func (h *HashIndex) Set(key, value []byte) error {
+	cell, err := h.newCell(key, value)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, err := h.remove(key); err != nil {
+		return err
+	}
+	for {
+		bucket := h.dir[h.bucketOf(key)]
+		ok, err := h.insert(bucket, &cell)
+		if err != nil {
+			return err
+		}
+		if ok {
+			break
+		}
+		if h.local[bucket] == MAX_GLOBAL_DEPTH {
+			if err := h.extendChain(bucket, &cell); err != nil {
+				return err
+			}
+			break
+		}
+		if err := h.split(h.bucketOf(key)); err != nil {
+			return err
+		}
+	}
+	return h.commit()
+}
+
+// Del removes key and reports whether it was present
+func (h *HashIndex) Del(key []byte) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	found, err := h.remove(key)
+	if err != nil || !found {
+		return found, err
+	}
+	return true, h.commit()
+}
+
+// ForEach calls fn for every pair, in no particular order, until it
+// returns false. Keys and values are copies. fn must not modify the index.
+func (h *HashIndex) ForEach(fn func(key, val []byte) bool) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	seen := make(map[uint64]bool, len(h.local))
+	for _, bucket := range h.dir {
+		if seen[bucket] {
+			continue
+		}
+		seen[bucket] = true
+
+		for pid := bucket; pid != 0; {
+			page, err := h.loadBucket(pid)
+			if err != nil {
+				return err
+			}
+			for i := range page.KVs {
+				kv := &page.KVs[i]
+				val := kv.Val
+				if kv.HasOverflow() {
+					if val, err = disk.ReadOverflow(h.pager, kv.Overflow, kv.ValSize); err != nil {
+						return err
+					}
+				}
+				if !fn(kv.Key, val) {
+					return nil
+				}
+			}
+			pid = page.Header.NextPagePointer
+		}
+	}
+	return nil
+}
+
+// Stats describes the directory and the buckets
+type Stats struct {
+	GlobalDepth    int   `json:"global_depth"`
+	Buckets        int   `json:"buckets"`
+	BucketPages    int   `json:"bucket_pages"` // chained pages included
+	DirectoryPages int   `json:"directory_pages"`
+	Keys           int64 `json:"keys"`
+}
+
+// Stats walks every bucket
+func (h *HashIndex) Stats() (*Stats, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	s := &Stats{GlobalDepth: int(h.depth), Buckets: len(h.local), DirectoryPages: len(h.dirPages)}
+	for bucket := range h.local {
+		for pid := bucket; pid != 0; {
+			buf, err := h.pager.FetchPage(pid)
+			if err != nil {
+				return nil, err
+			}
+			v := disk.NewLeafView(buf, nil)
+			s.BucketPages++
+			s.Keys += int64(v.NKeys())
+			next := v.Next()
+			h.pager.UnpinPage(pid, false)
+			pid = next
+		}
+	}
+	return s, nil
+}
+
+// commit writes the directory pages that changed and makes everything
+// durable, the meta page last
+func (h *HashIndex) commit() error {
+	if err := h.writeDirectory(); err != nil {
+		return err
+	}
+
+	_, _, allocDirty := h.pager.Allocator().Snapshot()
+	if !allocDirty && !h.metaDirty {
+		return h.pager.Sync()
+	}
+	if err := disk.CommitMeta(h.pager, h.metaPID, h.meta); err != nil {
+		return err
+	}
+	h.metaDirty = false
+	return nil
+}
+
+// log2 of a power of two
+func log2(n int) uint {
+	return uint(bits.TrailingZeros(uint(n)))
+}
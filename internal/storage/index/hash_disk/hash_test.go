@@ -0,0 +1,169 @@
+package hash_disk
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+	"github.com/spaghetti-lover/go-db/internal/storage/index/bptree_disk"
+)
+
+func setupHashIndex(t *testing.T) (*HashIndex, string) {
+	file := filepath.Join(t.TempDir(), "hash.db")
+	h, err := Open(file)
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+	return h, file
+}
+
+func hashKV(i int) ([]byte, []byte) {
+	return []byte(fmt.Sprintf("id-%d", i)), []byte(fmt.Sprintf("value-%d", i))
+}
+
+func TestHashIndex_Basic(t *testing.T) {
+	h, _ := setupHashIndex(t)
+
+	_, err := h.Get([]byte("missing"))
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	require.NoError(t, h.Set([]byte("a"), []byte("1")))
+	require.NoError(t, h.Set([]byte("a"), []byte("2")))
+	val, err := h.Get([]byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("2"), val)
+
+	ok, err := h.Del([]byte("a"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = h.Del([]byte("a"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+// TestHashIndex_Model checks the index against a map while buckets split
+// and the directory doubles, then after reopening
+func TestHashIndex_Model(t *testing.T) {
+	h, file := setupHashIndex(t)
+
+	model := map[string][]byte{}
+	r := rand.New(rand.NewSource(1))
+	for n := 0; n < 20000; n++ {
+		i := r.Intn(8000)
+		k, v := hashKV(i)
+		switch {
+		case r.Intn(4) == 0:
+			_, err := h.Del(k)
+			require.NoError(t, err)
+			delete(model, string(k))
+		case i%500 == 0:
+			// spilled to overflow pages
+			v = bytes.Repeat(v, 1000)
+			fallthrough
+		default:
+			require.NoError(t, h.Set(k, v))
+			model[string(k)] = v
+		}
+	}
+
+	check := func(h *HashIndex) {
+		for i := 0; i < 8000; i++ {
+			k, _ := hashKV(i)
+			val, err := h.Get(k)
+			if want, ok := model[string(k)]; ok {
+				require.NoError(t, err)
+				assert.Equal(t, want, val)
+			} else {
+				assert.ErrorIs(t, err, ErrKeyNotFound)
+			}
+		}
+
+		seen := map[string][]byte{}
+		require.NoError(t, h.ForEach(func(key, val []byte) bool {
+			seen[string(key)] = val
+			return true
+		}))
+		assert.Equal(t, model, seen)
+
+		stats, err := h.Stats()
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(model)), stats.Keys)
+		assert.Greater(t, stats.GlobalDepth, 4)
+		assert.LessOrEqual(t, stats.Buckets, 1<<stats.GlobalDepth)
+	}
+	check(h)
+
+	require.NoError(t, h.Close())
+	h, err := Open(file)
+	require.NoError(t, err)
+	defer h.Close()
+	check(h)
+}
+
+// TestHashIndex_PageAccesses checks that a lookup reads a single page
+func TestHashIndex_PageAccesses(t *testing.T) {
+	h, _ := setupHashIndex(t)
+	for i := 0; i < 5000; i++ {
+		k, v := hashKV(i)
+		require.NoError(t, h.Set(k, v))
+	}
+
+	before := h.pager.Stats()
+	for i := 0; i < 5000; i++ {
+		k, _ := hashKV(i)
+		_, err := h.Get(k)
+		require.NoError(t, err)
+	}
+	after := h.pager.Stats()
+	assert.Equal(t, uint64(5000), after.Hits+after.Misses-before.Hits-before.Misses)
+}
+
+// TestHashIndex_Chain fills one bucket at the maximum depth, which can
+// only grow a chain of pages
+func TestHashIndex_Chain(t *testing.T) {
+	h, _ := setupHashIndex(t)
+
+	var keys [][]byte
+	for i := 0; i < 300; i++ {
+		k, _ := hashKV(i)
+		keys = append(keys, k)
+	}
+
+	// Pretend the only bucket is as deep as it may get
+	h.mu.Lock()
+	h.local[h.dir[0]] = MAX_GLOBAL_DEPTH
+	h.mu.Unlock()
+	for _, k := range keys {
+		require.NoError(t, h.Set(k, bytes.Repeat([]byte{'v'}, 100)))
+	}
+
+	stats, err := h.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Buckets)
+	assert.Greater(t, stats.BucketPages, 1)
+	for _, k := range keys {
+		_, err := h.Get(k)
+		require.NoError(t, err)
+	}
+	ok, err := h.Del(keys[299])
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestHashIndex_WrongIndexType(t *testing.T) {
+	_, file := setupHashIndex(t)
+	_, err := bptree_disk.Open(file)
+	assert.ErrorIs(t, err, disk.ErrWrongIndexType)
+
+	tree := filepath.Join(t.TempDir(), "tree.db")
+	bt, err := bptree_disk.Open(tree)
+	require.NoError(t, err)
+	require.NoError(t, bt.Close())
+	_, err = Open(tree)
+	assert.ErrorIs(t, err, disk.ErrWrongIndexType)
+}
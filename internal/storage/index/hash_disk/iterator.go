@@ -0,0 +1,97 @@
+package hash_disk
+
+import (
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+)
+
+// Iterator walks every pair of the index one bucket page at a time, in
+// no particular order. It holds no lock between calls; a key written or
+// moved by a split while it runs may be missed or seen twice.
+type Iterator struct {
+	h    *HashIndex
+	slot int    // directory slot of the bucket being walked
+	pid  uint64 // next page of its chain, 0 = go to the next bucket
+	seen map[uint64]bool
+
+	keys, vals [][]byte
+	i          int
+	err        error
+}
+
+// NewIterator returns an iterator that is not positioned; call First
+func (h *HashIndex) NewIterator() *Iterator {
+	return &Iterator{h: h}
+}
+
+// First moves to the first pair
+func (it *Iterator) First() {
+	it.slot, it.pid, it.err = -1, 0, nil
+	it.seen = make(map[uint64]bool)
+	it.loadPage()
+}
+
+func (it *Iterator) Valid() bool {
+	return it.err == nil && it.i < len(it.keys)
+}
+
+func (it *Iterator) Key() []byte {
+	return it.keys[it.i]
+}
+
+func (it *Iterator) Value() []byte {
+	return it.vals[it.i]
+}
+
+func (it *Iterator) Next() {
+	it.i++
+	if it.i == len(it.keys) {
+		it.loadPage()
+	}
+}
+
+// Err returns the error that stopped the iterator, if any
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// loadPage copies the next page holding pairs; at the end of the index it
+// leaves the iterator invalid
+func (it *Iterator) loadPage() {
+	it.h.mu.RLock()
+	defer it.h.mu.RUnlock()
+
+	it.keys, it.vals, it.i = nil, nil, 0
+	for {
+		if it.pid == 0 {
+			for it.slot++; it.slot < len(it.h.dir) && it.seen[it.h.dir[it.slot]]; it.slot++ {
+			}
+			if it.slot >= len(it.h.dir) {
+				return
+			}
+			it.pid = it.h.dir[it.slot]
+			it.seen[it.pid] = true
+		}
+
+		page, err := it.h.loadBucket(it.pid)
+		if err != nil {
+			it.err = err
+			return
+		}
+		for i := range page.KVs {
+			kv := &page.KVs[i]
+			val := kv.Val
+			if kv.HasOverflow() {
+				if val, err = disk.ReadOverflow(it.h.pager, kv.Overflow, kv.ValSize); err != nil {
+					it.err = err
+					return
+				}
+			}
+			it.keys = append(it.keys, kv.Key)
+			it.vals = append(it.vals, val)
+		}
+		it.pid = page.Header.NextPagePointer
+		if len(it.keys) > 0 {
+			return
+		}
+	}
+}
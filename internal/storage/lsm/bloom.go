@@ -0,0 +1,55 @@
+package lsm
+
+import (
+	"hash/fnv"
+)
+
+// BLOOM_BITS_PER_KEY gives about a 1% false positive rate
+const BLOOM_BITS_PER_KEY = 10
+
+// bloomFilter answers "definitely not present" for keys of one table.
+// Encoded as: number of probes(1) | bits
+type bloomFilter []byte
+
+func newBloomFilter(keys [][]byte) bloomFilter {
+	// k = bits per key * ln 2
+	k := max(1, min(30, BLOOM_BITS_PER_KEY*69/100))
+	nbits := max(64, len(keys)*BLOOM_BITS_PER_KEY)
+	filter := make(bloomFilter, 1+(nbits+7)/8)
+	filter[0] = byte(k)
+
+	nbits = (len(filter) - 1) * 8
+	for _, key := range keys {
+		h1, h2 := bloomHash(key)
+		for i := 0; i < k; i++ {
+			bit := (h1 + uint32(i)*h2) % uint32(nbits)
+			filter[1+bit/8] |= 1 << (bit % 8)
+		}
+	}
+	return filter
+}
+
+// mayContain reports false only when key was not added
+func (f bloomFilter) mayContain(key []byte) bool {
+	if len(f) < 2 {
+		return true
+	}
+	k := int(f[0])
+	nbits := uint32(len(f)-1) * 8
+	h1, h2 := bloomHash(key)
+	for i := 0; i < k; i++ {
+		bit := (h1 + uint32(i)*h2) % nbits
+		if f[1+bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomHash derives the two hashes of double hashing from one 64-bit hash
+func bloomHash(key []byte) (uint32, uint32) {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	return uint32(sum), uint32(sum>>32) | 1
+}
@@ -0,0 +1,222 @@
+package lsm
+
+import (
+	"bytes"
+	"os"
+)
+
+// compaction merges the inputs of a level with the tables of the next
+// level that overlap them
+type compaction struct {
+	level  int
+	inputs [2][]*table // tables of level and level+1
+	v      *version
+}
+
+// maxBytes is the size above which level l, from 1 on, is compacted
+func (t *Tree) maxBytes(l int) int64 {
+	n := t.opts.LevelBaseSize
+	for ; l > 1; l-- {
+		n *= LEVEL_SIZE_MULTIPLIER
+	}
+	return n
+}
+
+// pickCompaction returns the compaction of the level furthest over its
+// limit, or nil when every level is within it. Level 0 is limited by its
+// number of tables, which may overlap, so all of them are compacted at
+// once. Other levels give up one table at a time, taken round-robin
+// through their key range.
+func (t *Tree) pickCompaction() *compaction {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	v := t.current
+	best, score := -1, 1.0
+	if n := len(v.levels[0]); n >= t.opts.L0CompactionTrigger {
+		best, score = 0, float64(n)/float64(t.opts.L0CompactionTrigger)
+	}
+	for l := 1; l < NUM_LEVELS-1; l++ {
+		if s := float64(levelBytes(v.levels[l])) / float64(t.maxBytes(l)); s >= score {
+			best, score = l, s
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+
+	c := &compaction{level: best, v: v}
+	if best == 0 {
+		c.inputs[0] = append([]*table{}, v.levels[0]...)
+	} else {
+		level := v.levels[best]
+		pick := level[0]
+		for _, tbl := range level {
+			if t.pointers[best] == nil || bytes.Compare(tbl.smallest, t.pointers[best]) > 0 {
+				pick = tbl
+				break
+			}
+		}
+		c.inputs[0] = []*table{pick}
+		t.pointers[best] = pick.largest
+	}
+
+	lo, hi := keyRange(c.inputs[0])
+	c.inputs[1] = overlapping(v.levels[best+1], lo, hi)
+	v.ref()
+	return c
+}
+
+// keyRange returns the smallest and largest key of tables
+func keyRange(tables []*table) (lo, hi []byte) {
+	for _, tbl := range tables {
+		if lo == nil || bytes.Compare(tbl.smallest, lo) < 0 {
+			lo = tbl.smallest
+		}
+		if hi == nil || bytes.Compare(tbl.largest, hi) > 0 {
+			hi = tbl.largest
+		}
+	}
+	return lo, hi
+}
+
+// runCompaction writes the merged inputs to level+1 and installs the
+// result. A table with nothing to merge with is moved down as it is.
+// Tombstones are dropped when no deeper level may hold the key.
+func (t *Tree) runCompaction(c *compaction) error {
+	defer c.v.unref()
+
+	if c.level > 0 && len(c.inputs[1]) == 0 {
+		return t.installCompaction(c, c.inputs[0])
+	}
+
+	lo, hi := keyRange(append(append([]*table{}, c.inputs[0]...), c.inputs[1]...))
+	dropTombstones := true
+	for _, level := range c.v.levels[c.level+2:] {
+		if len(overlapping(level, lo, hi)) > 0 {
+			dropTombstones = false
+		}
+	}
+
+	// Level 0 tables are newest last; the merge wants newest first
+	var sources []source
+	for i := len(c.inputs[0]) - 1; i >= 0; i-- {
+		sources = append(sources, &tableIter{t: c.inputs[0][i]})
+	}
+	sources = append(sources, &levelIter{tables: c.inputs[1]})
+	it := &mergeIter{sources: sources, keepTombstones: true}
+
+	var outputs []*table
+	var w *tableWriter
+	var num uint64
+	fail := func(err error) error {
+		if w != nil {
+			w.abort()
+		}
+		for _, tbl := range outputs {
+			tbl.f.Close()
+			os.Remove(tbl.path)
+		}
+		return err
+	}
+	finish := func() error {
+		if w.count == 0 {
+			w.abort()
+			w = nil
+			return nil
+		}
+		if err := w.finish(); err != nil {
+			w = nil
+			return err
+		}
+		tbl, err := openTable(w.path, num)
+		w = nil
+		if err != nil {
+			return err
+		}
+		outputs = append(outputs, tbl)
+		return nil
+	}
+
+	for it.seek(nil); it.valid(); it.next() {
+		e := it.entry()
+		if e.kind == kindDelete && dropTombstones {
+			continue
+		}
+		if w == nil {
+			t.mu.Lock()
+			num = t.newFileNum()
+			t.mu.Unlock()
+
+			var err error
+			if w, err = newTableWriter(tablePath(t.dir, num), t.opts.BlockSize); err != nil {
+				return fail(err)
+			}
+		}
+		if err := w.add(e.kind, e.key, e.value); err != nil {
+			return fail(err)
+		}
+		if w.size() >= uint64(t.opts.TableSize) {
+			if err := finish(); err != nil {
+				return fail(err)
+			}
+		}
+	}
+	if err := it.err(); err != nil {
+		return fail(err)
+	}
+	if w != nil {
+		if err := finish(); err != nil {
+			return fail(err)
+		}
+	}
+
+	if err := t.installCompaction(c, outputs); err != nil {
+		return fail(err)
+	}
+	return nil
+}
+
+// installCompaction replaces the inputs of c with outputs in level+1.
+// The input files are removed once no reader uses them.
+func (t *Tree) installCompaction(c *compaction, outputs []*table) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	gone := map[*table]bool{}
+	for _, in := range c.inputs {
+		for _, tbl := range in {
+			gone[tbl] = true
+		}
+	}
+	moved := map[*table]bool{}
+	for _, tbl := range outputs {
+		moved[tbl] = true
+	}
+
+	levels := t.current.clone()
+	for l := c.level; l <= c.level+1; l++ {
+		kept := levels[l][:0]
+		for _, tbl := range levels[l] {
+			if !gone[tbl] {
+				kept = append(kept, tbl)
+			}
+		}
+		levels[l] = kept
+	}
+	levels[c.level+1] = append(levels[c.level+1], outputs...)
+	sortLevel(levels[c.level+1])
+
+	for tbl := range gone {
+		if !moved[tbl] {
+			tbl.obsolete.Store(true)
+		}
+	}
+	if err := t.install(levels); err != nil {
+		for tbl := range gone {
+			tbl.obsolete.Store(false)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,410 @@
+package lsm
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/index/bptree_ram"
+)
+
+// MEM_ITER_BATCH is the number of entries a memtable iterator copies out
+// each time it takes the memtable lock
+const MEM_ITER_BATCH = 64
+
+// source is a sorted run of entries, tombstones included: the memtable,
+// one table or one level. A reverse source walks from larger keys to
+// smaller ones. Keys and values stay valid after next.
+type source interface {
+	// seek moves to the first key >= key, or the last key <= key for a
+	// reverse source. A nil key is the start of the run.
+	seek(key []byte)
+	valid() bool
+	entry() entry
+	next()
+	err() error
+}
+
+// memIter walks a memtable. It copies a batch of entries at a time under
+// the memtable lock, so writers are not held up and later batches see
+// their writes.
+type memIter struct {
+	m       *memtable
+	reverse bool
+	batch   []entry
+	i       int
+	more    bool // the memtable may hold entries after the batch
+}
+
+func (it *memIter) seek(key []byte) {
+	it.fill(key, true)
+}
+
+// fill copies the entries from key on; the entry at key is skipped when
+// inclusive is false
+func (it *memIter) fill(key []byte, inclusive bool) {
+	it.m.mu.RLock()
+	defer it.m.mu.RUnlock()
+
+	tree := it.m.tree
+	var cur *bptree_ram.Iter[[]byte, memValue]
+	switch {
+	case key == nil && !it.reverse:
+		cur = tree.First()
+	case key == nil:
+		cur = tree.Last()
+	case !it.reverse:
+		cur = tree.SeekGE(key)
+	default:
+		cur = tree.SeekLE(key)
+	}
+	if !inclusive && cur.Valid() && bytes.Equal(cur.Key(), key) {
+		it.step(cur)
+	}
+
+	it.batch, it.i = it.batch[:0], 0
+	for ; cur.Valid() && len(it.batch) < MEM_ITER_BATCH; it.step(cur) {
+		v := cur.Value()
+		it.batch = append(it.batch, entry{kind: v.kind, key: cur.Key(), value: v.value})
+	}
+	it.more = cur.Valid()
+}
+
+func (it *memIter) step(cur *bptree_ram.Iter[[]byte, memValue]) {
+	if it.reverse {
+		cur.Prev()
+	} else {
+		cur.Next()
+	}
+}
+
+func (it *memIter) valid() bool {
+	return it.i < len(it.batch)
+}
+
+func (it *memIter) entry() entry {
+	return it.batch[it.i]
+}
+
+func (it *memIter) next() {
+	it.i++
+	if it.i == len(it.batch) && it.more {
+		last := it.batch[it.i-1].key
+		it.batch = nil // entries already handed out keep their batch
+		it.fill(last, false)
+	}
+}
+
+func (it *memIter) err() error {
+	return nil
+}
+
+// tableIter walks one table a data block at a time
+type tableIter struct {
+	t       *table
+	reverse bool
+	block   int
+	entries []entry
+	i       int
+	e       error
+}
+
+// load reads data block bi; it reports false at either end of the table
+func (it *tableIter) load(bi int) bool {
+	it.entries, it.i = nil, 0
+	if bi < 0 || bi >= len(it.t.index) {
+		return false
+	}
+	it.block = bi
+	it.entries, it.e = it.t.readDataBlock(bi)
+	return it.e == nil
+}
+
+func (it *tableIter) seek(key []byte) {
+	if !it.reverse {
+		bi := 0
+		if key != nil {
+			bi = it.t.blockFor(key)
+		}
+		if !it.load(bi) {
+			return
+		}
+		if key != nil {
+			it.i = sort.Search(len(it.entries), func(i int) bool {
+				return bytes.Compare(it.entries[i].key, key) >= 0
+			})
+		}
+		if it.i == len(it.entries) {
+			it.next()
+		}
+		return
+	}
+
+	bi := len(it.t.index) - 1
+	if key != nil {
+		bi = min(it.t.blockFor(key), bi)
+	}
+	if !it.load(bi) {
+		return
+	}
+	it.i = len(it.entries) - 1
+	if key != nil {
+		it.i = sort.Search(len(it.entries), func(i int) bool {
+			return bytes.Compare(it.entries[i].key, key) > 0
+		}) - 1
+	}
+	if it.i < 0 {
+		it.i = 0
+		it.next()
+	}
+}
+
+func (it *tableIter) valid() bool {
+	return it.e == nil && it.i >= 0 && it.i < len(it.entries)
+}
+
+func (it *tableIter) entry() entry {
+	return it.entries[it.i]
+}
+
+func (it *tableIter) next() {
+	if !it.reverse {
+		it.i++
+		for it.i >= len(it.entries) && it.e == nil {
+			if !it.load(it.block + 1) {
+				return
+			}
+		}
+		return
+	}
+
+	it.i--
+	for it.i < 0 && it.e == nil {
+		if !it.load(it.block - 1) {
+			return
+		}
+		it.i = len(it.entries) - 1
+	}
+}
+
+func (it *tableIter) err() error {
+	return it.e
+}
+
+// levelIter walks the tables of a level other than 0, which are sorted
+// and do not overlap, one after the other
+type levelIter struct {
+	tables  []*table
+	reverse bool
+	ti      int
+	cur     *tableIter
+}
+
+func (it *levelIter) open(ti int, key []byte) {
+	it.ti, it.cur = ti, nil
+	for it.ti >= 0 && it.ti < len(it.tables) {
+		it.cur = &tableIter{t: it.tables[it.ti], reverse: it.reverse}
+		it.cur.seek(key)
+		if it.cur.valid() || it.cur.err() != nil {
+			return
+		}
+		// the rest of the level starts at the next table
+		key = nil
+		if it.reverse {
+			it.ti--
+		} else {
+			it.ti++
+		}
+	}
+}
+
+func (it *levelIter) seek(key []byte) {
+	switch {
+	case key == nil && !it.reverse:
+		it.open(0, nil)
+	case key == nil:
+		it.open(len(it.tables)-1, nil)
+	case !it.reverse:
+		// first table whose largest key >= key
+		it.open(sort.Search(len(it.tables), func(i int) bool {
+			return bytes.Compare(it.tables[i].largest, key) >= 0
+		}), key)
+	default:
+		// last table whose smallest key <= key
+		it.open(sort.Search(len(it.tables), func(i int) bool {
+			return bytes.Compare(it.tables[i].smallest, key) > 0
+		})-1, key)
+	}
+}
+
+func (it *levelIter) valid() bool {
+	return it.cur != nil && it.cur.valid()
+}
+
+func (it *levelIter) entry() entry {
+	return it.cur.entry()
+}
+
+func (it *levelIter) next() {
+	it.cur.next()
+	if it.cur.valid() || it.cur.err() != nil {
+		return
+	}
+	if it.reverse {
+		it.open(it.ti-1, nil)
+	} else {
+		it.open(it.ti+1, nil)
+	}
+}
+
+func (it *levelIter) err() error {
+	if it.cur == nil {
+		return nil
+	}
+	return it.cur.err()
+}
+
+// mergeIter merges sources ordered from newest to oldest. Of the entries
+// for one key only the newest is returned; tombstones are skipped unless
+// keepTombstones is set.
+type mergeIter struct {
+	sources        []source
+	reverse        bool
+	keepTombstones bool
+
+	cur   entry
+	ok    bool
+	error error
+}
+
+func (it *mergeIter) seek(key []byte) {
+	for _, s := range it.sources {
+		s.seek(key)
+	}
+	it.find()
+}
+
+// before reports whether a comes before b in the direction of iteration
+func (it *mergeIter) before(a, b []byte) bool {
+	if it.reverse {
+		return bytes.Compare(a, b) > 0
+	}
+	return bytes.Compare(a, b) < 0
+}
+
+// find moves to the next entry to return, consuming the entries it hides
+func (it *mergeIter) find() {
+	for {
+		best := -1
+		for i, s := range it.sources {
+			if err := s.err(); err != nil {
+				it.error, it.ok = err, false
+				return
+			}
+			if s.valid() && (best < 0 || it.before(s.entry().key, it.sources[best].entry().key)) {
+				best = i
+			}
+		}
+		if best < 0 {
+			it.ok = false
+			return
+		}
+
+		it.cur = it.sources[best].entry()
+		for _, s := range it.sources {
+			if s.valid() && bytes.Equal(s.entry().key, it.cur.key) {
+				s.next()
+			}
+		}
+		if it.cur.kind != kindDelete || it.keepTombstones {
+			it.ok = true
+			return
+		}
+	}
+}
+
+func (it *mergeIter) valid() bool {
+	return it.ok
+}
+
+func (it *mergeIter) entry() entry {
+	return it.cur
+}
+
+func (it *mergeIter) next() {
+	it.find()
+}
+
+func (it *mergeIter) err() error {
+	return it.error
+}
+
+// Iterator walks the live keys of the tree as of when it was created,
+// in ascending order or, for a reverse iterator, descending. Later writes
+// to the memtable may or may not be seen. Close releases the tables it
+// reads.
+type Iterator struct {
+	merge  *mergeIter
+	v      *version
+	closed bool
+}
+
+// NewIterator returns an iterator that is not positioned; call Seek
+func (t *Tree) NewIterator(reverse bool) *Iterator {
+	t.mu.Lock()
+	mem, imm, v := t.mem, t.imm, t.current
+	v.ref()
+	t.mu.Unlock()
+
+	// Newest first: memtables, level 0 newest first, then the levels
+	var sources []source
+	sources = append(sources, &memIter{m: mem, reverse: reverse})
+	if imm != nil {
+		sources = append(sources, &memIter{m: imm, reverse: reverse})
+	}
+	for i := len(v.levels[0]) - 1; i >= 0; i-- {
+		sources = append(sources, &tableIter{t: v.levels[0][i], reverse: reverse})
+	}
+	for _, level := range v.levels[1:] {
+		if len(level) > 0 {
+			sources = append(sources, &levelIter{tables: level, reverse: reverse})
+		}
+	}
+	return &Iterator{merge: &mergeIter{sources: sources, reverse: reverse}, v: v}
+}
+
+// Seek moves to the first key >= key, or the last key <= key for a
+// reverse iterator. A nil key is the first key, or the last one.
+func (it *Iterator) Seek(key []byte) {
+	it.merge.seek(key)
+}
+
+func (it *Iterator) Valid() bool {
+	return !it.closed && it.merge.valid()
+}
+
+// Key returns the current key. It stays valid after Next.
+func (it *Iterator) Key() []byte {
+	return it.merge.entry().key
+}
+
+// Value returns the current value. It stays valid after Next.
+func (it *Iterator) Value() []byte {
+	return it.merge.entry().value
+}
+
+func (it *Iterator) Next() {
+	it.merge.next()
+}
+
+// Err returns the error that stopped the iterator, if any
+func (it *Iterator) Err() error {
+	return it.merge.err()
+}
+
+func (it *Iterator) Close() error {
+	if !it.closed {
+		it.closed = true
+		it.v.unref()
+	}
+	return nil
+}
@@ -0,0 +1,667 @@
+package lsm
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_MEMTABLE_SIZE         = 4 << 20
+	DEFAULT_TABLE_SIZE            = 2 << 20
+	DEFAULT_BLOCK_SIZE            = 4 << 10
+	DEFAULT_L0_COMPACTION_TRIGGER = 4
+	DEFAULT_LEVEL_BASE_SIZE       = 10 << 20
+	DEFAULT_SYNC_INTERVAL         = 10 * time.Millisecond
+
+	// L0_STOP_WRITES_FACTOR times the compaction trigger is the number of
+	// level 0 tables at which writers wait for compaction to catch up
+	L0_STOP_WRITES_FACTOR = 3
+	LEVEL_SIZE_MULTIPLIER = 10
+	NUM_LEVELS            = 7
+)
+
+var ErrClosed = errors.New("lsm tree is closed")
+
+// Durability says when a write reaches the disk
+type Durability int
+
+const (
+	DurabilityDefault Durability = iota // the tree's setting
+	SyncCommit                          // before the write returns
+	SyncPeriodic                        // within the tree's sync interval
+	SyncOS                              // when the OS writes it back, or at a flush
+)
+
+// Options tunes the tree. Zero fields take the defaults.
+type Options struct {
+	MemtableSize        int           // bytes of writes buffered before a flush
+	TableSize           int           // target size of tables written by compaction
+	BlockSize           int           // target size of SSTable data blocks
+	L0CompactionTrigger int           // level 0 tables that start a compaction
+	LevelBaseSize       int64         // size of level 1; each level below is LEVEL_SIZE_MULTIPLIER times larger
+	Durability          Durability    // for writes that do not ask for their own; zero means SyncCommit
+	SyncInterval        time.Duration // how often SyncPeriodic writes are synced
+}
+
+func (o *Options) withDefaults() Options {
+	opts := *o
+	if opts.MemtableSize == 0 {
+		opts.MemtableSize = DEFAULT_MEMTABLE_SIZE
+	}
+	if opts.TableSize == 0 {
+		opts.TableSize = DEFAULT_TABLE_SIZE
+	}
+	if opts.BlockSize == 0 {
+		opts.BlockSize = DEFAULT_BLOCK_SIZE
+	}
+	if opts.L0CompactionTrigger == 0 {
+		opts.L0CompactionTrigger = DEFAULT_L0_COMPACTION_TRIGGER
+	}
+	if opts.LevelBaseSize == 0 {
+		opts.LevelBaseSize = DEFAULT_LEVEL_BASE_SIZE
+	}
+	if opts.Durability == DurabilityDefault {
+		opts.Durability = SyncCommit
+	}
+	if opts.SyncInterval == 0 {
+		opts.SyncInterval = DEFAULT_SYNC_INTERVAL
+	}
+	return opts
+}
+
+// Tree is a log-structured merge tree kept in a directory. Writes go to
+// the WAL and the memtable; a full memtable is frozen and written out as
+// a level 0 SSTable by a background goroutine, which then compacts
+// levels that grew too large into the level below. It is safe for
+// concurrent use.
+type Tree struct {
+	dir  string
+	opts Options
+
+	mu       sync.Mutex
+	cond     *sync.Cond // signalled when background work makes progress
+	mem      *memtable
+	imm      *memtable // frozen memtable waiting to be flushed
+	current  *version
+	nextFile uint64
+	logNum   uint64
+	pointers [NUM_LEVELS][]byte // where the next compaction of each level starts
+	closed   bool
+	busy     bool
+	bgErr    error
+
+	work     chan struct{}
+	bgDone   chan struct{}
+	syncStop chan struct{}
+	syncDone chan struct{}
+}
+
+// Open opens the tree in dir, creating it if needed. WALs that were not
+// flushed before the tree was last closed are replayed into level 0.
+func Open(dir string, opts Options) (*Tree, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	m, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tree{
+		dir:      dir,
+		opts:     opts.withDefaults(),
+		nextFile: m.NextFile,
+		logNum:   m.LogNumber,
+		work:     make(chan struct{}, 1),
+		bgDone:   make(chan struct{}),
+		syncStop: make(chan struct{}),
+		syncDone: make(chan struct{}),
+	}
+	t.cond = sync.NewCond(&t.mu)
+
+	levels := make([][]*table, NUM_LEVELS)
+	live := map[uint64]bool{}
+	for l, nums := range m.Levels {
+		for _, num := range nums {
+			tbl, err := openTable(tablePath(dir, num), num)
+			if err != nil {
+				closeTables(levels)
+				return nil, err
+			}
+			levels[l] = append(levels[l], tbl)
+			live[num] = true
+		}
+		if l > 0 {
+			sortLevel(levels[l])
+		}
+	}
+	t.current = newVersion(levels)
+
+	wals, err := t.cleanDir(live)
+	if err != nil {
+		t.current.unref()
+		return nil, err
+	}
+
+	t.mem, err = openMemtable(walPath(dir, t.nextFile), t.nextFile)
+	if err != nil {
+		t.current.unref()
+		return nil, err
+	}
+	t.nextFile++
+
+	// Recovered writes go straight to level 0
+	for _, num := range wals {
+		if err := t.recoverWAL(num); err != nil {
+			t.mem.close()
+			t.current.unref()
+			return nil, err
+		}
+	}
+
+	go t.background()
+	go t.syncer()
+	t.mu.Lock()
+	t.signal()
+	t.mu.Unlock()
+	return t, nil
+}
+
+// cleanDir removes files the manifest does not reference and returns the
+// WALs still to replay, in order. File numbers are bumped past every file
+// found.
+func (t *Tree) cleanDir(live map[uint64]bool) ([]uint64, error) {
+	names, err := os.ReadDir(t.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var wals []uint64
+	for _, e := range names {
+		name := e.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			os.Remove(filepath.Join(t.dir, name))
+			continue
+		}
+		ext := filepath.Ext(name)
+		num, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 64)
+		if err != nil || (ext != ".sst" && ext != ".wal") {
+			continue
+		}
+		t.nextFile = max(t.nextFile, num+1)
+
+		switch {
+		case ext == ".sst" && !live[num]:
+			os.Remove(filepath.Join(t.dir, name))
+		case ext == ".wal" && num < t.logNum:
+			os.Remove(filepath.Join(t.dir, name))
+		case ext == ".wal":
+			wals = append(wals, num)
+		}
+	}
+	sort.Slice(wals, func(i, j int) bool { return wals[i] < wals[j] })
+	return wals, nil
+}
+
+// recoverWAL writes the contents of an old WAL to level 0 and removes it
+func (t *Tree) recoverWAL(num uint64) error {
+	m, err := openMemtable(walPath(t.dir, num), num)
+	if err != nil {
+		return err
+	}
+	if err := t.flushMemtable(m); err != nil {
+		m.close()
+		return err
+	}
+	return nil
+}
+
+func closeTables(levels [][]*table) {
+	for _, level := range levels {
+		for _, tbl := range level {
+			tbl.f.Close()
+		}
+	}
+}
+
+// newFileNum allocates a number for a table or WAL. Caller holds t.mu.
+func (t *Tree) newFileNum() uint64 {
+	num := t.nextFile
+	t.nextFile++
+	return num
+}
+
+// install makes levels the current version and records it in the
+// manifest. Caller holds t.mu.
+func (t *Tree) install(levels [][]*table) error {
+	m := &manifest{NextFile: t.nextFile, LogNumber: t.logNum, Levels: make([][]uint64, NUM_LEVELS)}
+	for l, level := range levels {
+		m.Levels[l] = []uint64{}
+		for _, tbl := range level {
+			m.Levels[l] = append(m.Levels[l], tbl.num)
+		}
+	}
+	if err := writeManifest(t.dir, m); err != nil {
+		return err
+	}
+
+	old := t.current
+	t.current = newVersion(levels)
+	old.unref()
+	t.cond.Broadcast()
+	return nil
+}
+
+// Get returns the value of key. found is false when the key is absent or
+// deleted.
+func (t *Tree) Get(key []byte) (val []byte, found bool, err error) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil, false, ErrClosed
+	}
+	mem, imm, v := t.mem, t.imm, t.current
+	v.ref()
+	t.mu.Unlock()
+	defer v.unref()
+	return lookup(key, mem, imm, v)
+}
+
+// lookup finds the newest entry of key in the memtables and the tables of v
+func lookup(key []byte, mem, imm *memtable, v *version) ([]byte, bool, error) {
+	for _, m := range []*memtable{mem, imm} {
+		if m == nil {
+			continue
+		}
+		if e, ok := m.get(key); ok {
+			return result(e.kind, e.value, nil)
+		}
+	}
+
+	// Level 0 tables may overlap: the newest one wins
+	for i := len(v.levels[0]) - 1; i >= 0; i-- {
+		e, ok, err := v.levels[0][i].get(key)
+		if err != nil || ok {
+			return result(e.kind, e.value, err)
+		}
+	}
+
+	for _, level := range v.levels[1:] {
+		i := sort.Search(len(level), func(i int) bool {
+			return bytes.Compare(level[i].largest, key) >= 0
+		})
+		if i == len(level) {
+			continue
+		}
+		e, ok, err := level[i].get(key)
+		if err != nil || ok {
+			return result(e.kind, e.value, err)
+		}
+	}
+	return nil, false, nil
+}
+
+// result is what Get returns for the newest entry of a key
+func result(kind byte, val []byte, err error) ([]byte, bool, error) {
+	if err != nil || kind == kindDelete {
+		return nil, false, err
+	}
+	return val, true, nil
+}
+
+// BatchOp is one write of a batch; a delete has no Value
+type BatchOp struct {
+	Key    []byte
+	Value  []byte
+	Delete bool
+}
+
+// Set stores val under key
+func (t *Tree) Set(key, val []byte) error {
+	_, err := t.write([]BatchOp{{Key: key, Value: val}}, DurabilityDefault, false)
+	return err
+}
+
+// Del deletes key by writing a tombstone and reports whether it was there.
+// The key is looked up under the same lock as the write, so of two
+// concurrent deletes only one finds it.
+func (t *Tree) Del(key []byte) (bool, error) {
+	return t.write([]BatchOp{{Key: key, Delete: true}}, DurabilityDefault, true)
+}
+
+// WriteBatch logs ops as one WAL transaction and applies them in order
+// under one memtable lock, so after a crash either all of them are there
+// or none. durability says when it returns.
+func (t *Tree) WriteBatch(ops []BatchOp, durability Durability) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	_, err := t.write(ops, durability, false)
+	return err
+}
+
+// write logs and applies ops under t.mu, then waits for the WAL as
+// durability asks. The fsync happens outside the lock, so writers that
+// arrive together share one. With mustExist, nothing is written unless
+// the key of the single op is live; it reports whether ops were written.
+func (t *Tree) write(ops []BatchOp, durability Durability, mustExist bool) (bool, error) {
+	if durability == DurabilityDefault {
+		durability = t.opts.Durability
+	}
+
+	t.mu.Lock()
+	if err := t.makeRoom(); err != nil {
+		t.mu.Unlock()
+		return false, err
+	}
+	mem := t.mem
+	if mustExist {
+		// The version cannot change while t.mu is held
+		_, found, err := lookup(ops[0].Key, mem, t.imm, t.current)
+		if err != nil || !found {
+			t.mu.Unlock()
+			return false, err
+		}
+	}
+	lsn, err := mem.add(ops)
+	if err == nil && durability == SyncPeriodic {
+		mem.periodic = max(mem.periodic, lsn)
+	}
+	t.mu.Unlock()
+
+	if err != nil || durability != SyncCommit {
+		return err == nil, err
+	}
+	return true, mem.sync(lsn)
+}
+
+// makeRoom freezes a full memtable and starts a new one with its own WAL.
+// Writers wait while the previous one is still being flushed, or while
+// level 0 has too many tables. Caller holds t.mu.
+func (t *Tree) makeRoom() error {
+	for {
+		switch {
+		case t.closed:
+			return ErrClosed
+		case t.bgErr != nil:
+			return t.bgErr
+		case t.mem.approximateSize() < t.opts.MemtableSize:
+			return nil
+		case t.imm != nil || len(t.current.levels[0]) >= L0_STOP_WRITES_FACTOR*t.opts.L0CompactionTrigger:
+			t.cond.Wait()
+			continue
+		}
+
+		num := t.newFileNum()
+		mem, err := openMemtable(walPath(t.dir, num), num)
+		if err != nil {
+			return err
+		}
+		t.imm, t.mem = t.mem, mem
+		t.signal()
+		return nil
+	}
+}
+
+// signal wakes the background goroutine. Caller holds t.mu.
+func (t *Tree) signal() {
+	if t.closed {
+		return
+	}
+	t.busy = true
+	select {
+	case t.work <- struct{}{}:
+	default:
+	}
+}
+
+// Flush writes the memtable to level 0 and waits until the background
+// goroutine has nothing left to do
+func (t *Tree) Flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for t.imm != nil && t.bgErr == nil && !t.closed {
+		t.cond.Wait()
+	}
+	if t.closed {
+		return ErrClosed
+	}
+	if t.mem.len() > 0 {
+		num := t.newFileNum()
+		mem, err := openMemtable(walPath(t.dir, num), num)
+		if err != nil {
+			return err
+		}
+		t.imm, t.mem = t.mem, mem
+	}
+	t.signal()
+
+	for t.busy && t.bgErr == nil {
+		t.cond.Wait()
+	}
+	return t.bgErr
+}
+
+// Close stops background work, syncs the WALs and closes the files.
+// Writes that are only in the memtable are in its WAL and are replayed by
+// the next Open.
+func (t *Tree) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return ErrClosed
+	}
+	t.closed = true
+	t.cond.Broadcast()
+	t.mu.Unlock()
+
+	close(t.work)
+	<-t.bgDone
+	close(t.syncStop)
+	<-t.syncDone
+
+	err := t.mem.close()
+	if t.imm != nil {
+		t.imm.close()
+	}
+	t.current.unref()
+	return err
+}
+
+// syncer syncs the WALs of SyncPeriodic writes every SyncInterval. A
+// failed sync stops writes like a failed flush.
+func (t *Tree) syncer() {
+	defer close(t.syncDone)
+	ticker := time.NewTicker(t.opts.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.syncStop:
+			return
+		case <-ticker.C:
+		}
+
+		t.mu.Lock()
+		var mems []*memtable
+		var lsns []uint64
+		for _, m := range []*memtable{t.imm, t.mem} {
+			if m != nil && m.periodic > 0 {
+				mems, lsns = append(mems, m), append(lsns, m.periodic)
+				m.periodic = 0
+			}
+		}
+		t.mu.Unlock()
+
+		for i, m := range mems {
+			if err := m.sync(lsns[i]); err != nil {
+				t.mu.Lock()
+				if t.bgErr == nil {
+					t.bgErr = err
+				}
+				t.cond.Broadcast()
+				t.mu.Unlock()
+				break
+			}
+		}
+	}
+}
+
+// background flushes frozen memtables and runs compactions
+func (t *Tree) background() {
+	defer close(t.bgDone)
+	for range t.work {
+		err := t.doWork()
+
+		t.mu.Lock()
+		if err != nil && t.bgErr == nil {
+			t.bgErr = err
+		}
+		t.busy = len(t.work) > 0
+		t.cond.Broadcast()
+		t.mu.Unlock()
+	}
+}
+
+func (t *Tree) doWork() error {
+	for {
+		t.mu.Lock()
+		imm, closed := t.imm, t.closed
+		t.mu.Unlock()
+		if closed {
+			return nil
+		}
+
+		if imm != nil {
+			if err := t.flushMemtable(imm); err != nil {
+				return err
+			}
+			continue
+		}
+
+		c := t.pickCompaction()
+		if c == nil {
+			return nil
+		}
+		if err := t.runCompaction(c); err != nil {
+			return err
+		}
+	}
+}
+
+// flushMemtable writes m to a level 0 table, then drops it and its WAL
+func (t *Tree) flushMemtable(m *memtable) error {
+	t.mu.Lock()
+	num := t.newFileNum()
+	t.mu.Unlock()
+
+	var tbl *table
+	if m.len() > 0 {
+		w, err := newTableWriter(tablePath(t.dir, num), t.opts.BlockSize)
+		if err != nil {
+			return err
+		}
+		m.mu.RLock()
+		for it := m.tree.First(); it.Valid() && err == nil; it.Next() {
+			err = w.add(it.Value().kind, it.Key(), it.Value().value)
+		}
+		m.mu.RUnlock()
+		if err != nil {
+			w.abort()
+			return err
+		}
+		if err := w.finish(); err != nil {
+			return err
+		}
+		if tbl, err = openTable(tablePath(t.dir, num), num); err != nil {
+			return err
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	levels := t.current.clone()
+	if tbl != nil {
+		levels[0] = append(levels[0], tbl)
+	}
+	// Every WAL up to this one has reached a table
+	t.logNum = m.walNum + 1
+	if err := t.install(levels); err != nil {
+		if tbl != nil {
+			tbl.f.Close()
+		}
+		return err
+	}
+	if t.imm == m {
+		t.imm = nil
+	}
+
+	m.close()
+	os.Remove(walPath(t.dir, m.walNum))
+	return nil
+}
+
+// Scan visits the live keys from start to end, both included; nil bounds
+// are unbounded. fn returns false to stop.
+func (t *Tree) Scan(start, end []byte, fn func(key, val []byte) bool) error {
+	t.mu.Lock()
+	closed := t.closed
+	t.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+
+	it := t.NewIterator(false)
+	defer it.Close()
+	for it.Seek(start); it.Valid(); it.Next() {
+		if end != nil && bytes.Compare(it.Key(), end) > 0 {
+			break
+		}
+		if !fn(it.Key(), it.Value()) {
+			break
+		}
+	}
+	return it.Err()
+}
+
+// LevelStats describes the tables of one level
+type LevelStats struct {
+	Tables int   `json:"tables"`
+	Bytes  int64 `json:"bytes"`
+	Keys   int   `json:"keys"` // entries, tombstones and shadowed values included
+}
+
+// Stats describes the memtable and the levels
+type Stats struct {
+	MemtableBytes int          `json:"memtable_bytes"`
+	MemtableKeys  int          `json:"memtable_keys"`
+	Levels        []LevelStats `json:"levels"`
+}
+
+func (t *Tree) Stats() *Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := &Stats{MemtableBytes: t.mem.approximateSize(), MemtableKeys: t.mem.len()}
+	if t.imm != nil {
+		s.MemtableBytes += t.imm.approximateSize()
+		s.MemtableKeys += t.imm.len()
+	}
+	for _, level := range t.current.levels {
+		ls := LevelStats{Tables: len(level), Bytes: levelBytes(level)}
+		for _, tbl := range level {
+			ls.Keys += tbl.count
+		}
+		s.Levels = append(s.Levels, ls)
+	}
+	return s
+}
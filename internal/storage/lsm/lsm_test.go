@@ -0,0 +1,466 @@
+package lsm
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// smallOptions makes flushes and compactions happen after a few hundred
+// writes
+func smallOptions() Options {
+	return Options{
+		MemtableSize:        4 << 10,
+		TableSize:           8 << 10,
+		BlockSize:           512,
+		L0CompactionTrigger: 2,
+		LevelBaseSize:       16 << 10,
+	}
+}
+
+func setupTree(t *testing.T, opts Options) (*Tree, string) {
+	dir := t.TempDir()
+	tree, err := Open(dir, opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { tree.Close() })
+	return tree, dir
+}
+
+func testKey(i int) []byte {
+	return []byte(fmt.Sprintf("key%06d", i))
+}
+
+func testVal(i, gen int) []byte {
+	return []byte(fmt.Sprintf("value-%d-%d", i, gen))
+}
+
+// collect returns the keys of the iterator from seek on
+func collect(t *testing.T, tree *Tree, reverse bool, seek []byte) []string {
+	it := tree.NewIterator(reverse)
+	defer it.Close()
+
+	var keys []string
+	for it.Seek(seek); it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	require.NoError(t, it.Err())
+	return keys
+}
+
+func TestTree_Basic(t *testing.T) {
+	tree, _ := setupTree(t, Options{})
+
+	require.NoError(t, tree.Set([]byte("b"), []byte("2")))
+	require.NoError(t, tree.Set([]byte("a"), []byte("1")))
+	require.NoError(t, tree.Set([]byte("a"), []byte("one")))
+
+	val, ok, err := tree.Get([]byte("a"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("one"), val)
+
+	deleted, err := tree.Del([]byte("b"))
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	deleted, err = tree.Del([]byte("b"))
+	require.NoError(t, err)
+	assert.False(t, deleted)
+
+	_, ok, err = tree.Get([]byte("b"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, []string{"a"}, collect(t, tree, false, nil))
+}
+
+// TestTree_Model checks the tree against a map through flushes and
+// compactions, then after reopening
+func TestTree_Model(t *testing.T) {
+	tree, dir := setupTree(t, smallOptions())
+
+	model := map[string][]byte{}
+	r := rand.New(rand.NewSource(1))
+	for gen := 0; gen < 5000; gen++ {
+		i := r.Intn(1500)
+		if r.Intn(4) == 0 {
+			_, err := tree.Del(testKey(i))
+			require.NoError(t, err)
+			delete(model, string(testKey(i)))
+		} else {
+			require.NoError(t, tree.Set(testKey(i), testVal(i, gen)))
+			model[string(testKey(i))] = testVal(i, gen)
+		}
+	}
+
+	check := func(tree *Tree) {
+		for i := 0; i < 1500; i++ {
+			val, ok, err := tree.Get(testKey(i))
+			require.NoError(t, err)
+			want, exists := model[string(testKey(i))]
+			require.Equal(t, exists, ok, "key %d", i)
+			assert.Equal(t, want, val)
+		}
+
+		var keys []string
+		for k := range model {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		assert.Equal(t, keys, collect(t, tree, false, nil))
+
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+		assert.Equal(t, keys, collect(t, tree, true, nil))
+	}
+
+	check(tree)
+	require.NoError(t, tree.Flush())
+	stats := tree.Stats()
+	assert.Less(t, stats.Levels[0].Tables, smallOptions().L0CompactionTrigger)
+	assert.Greater(t, stats.Levels[1].Tables+stats.Levels[2].Tables, 0)
+	check(tree)
+
+	require.NoError(t, tree.Close())
+	tree, err := Open(dir, smallOptions())
+	require.NoError(t, err)
+	defer tree.Close()
+	check(tree)
+}
+
+func TestTree_Recovery(t *testing.T) {
+	tree, dir := setupTree(t, Options{})
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, tree.Set(testKey(i), testVal(i, 0)))
+	}
+	_, err := tree.Del(testKey(50))
+	require.NoError(t, err)
+	require.NoError(t, tree.Close())
+
+	// Nothing was flushed: the writes come back from the WAL
+	tables, err := filepath.Glob(filepath.Join(dir, "*.sst"))
+	require.NoError(t, err)
+	assert.Empty(t, tables)
+
+	tree, err = Open(dir, Options{})
+	require.NoError(t, err)
+	defer tree.Close()
+
+	assert.Equal(t, 1, tree.Stats().Levels[0].Tables)
+	for i := 0; i < 100; i++ {
+		val, ok, err := tree.Get(testKey(i))
+		require.NoError(t, err)
+		assert.Equal(t, i != 50, ok)
+		if ok {
+			assert.Equal(t, testVal(i, 0), val)
+		}
+	}
+	wals, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	require.NoError(t, err)
+	assert.Len(t, wals, 1)
+}
+
+func TestTree_WriteBatch(t *testing.T) {
+	tree, dir := setupTree(t, Options{})
+
+	require.NoError(t, tree.Set(testKey(0), testVal(0, 0)))
+	require.NoError(t, tree.WriteBatch([]BatchOp{
+		{Key: testKey(0), Delete: true},
+		{Key: testKey(1), Value: testVal(1, 0)},
+		{Key: testKey(1), Value: testVal(1, 1)},
+	}, DurabilityDefault))
+	_, ok, err := tree.Get(testKey(0))
+	require.NoError(t, err)
+	assert.False(t, ok)
+	val, _, err := tree.Get(testKey(1))
+	require.NoError(t, err)
+	assert.Equal(t, testVal(1, 1), val)
+
+	require.NoError(t, tree.WriteBatch([]BatchOp{
+		{Key: testKey(2), Value: testVal(2, 0)},
+		{Key: testKey(3), Value: testVal(3, 0)},
+	}, SyncOS))
+	require.NoError(t, tree.Close())
+
+	// Cutting the commit record off drops the whole last batch
+	wals, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	require.NoError(t, err)
+	require.Len(t, wals, 1)
+	info, err := os.Stat(wals[0])
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(wals[0], info.Size()-1))
+
+	tree, err = Open(dir, Options{})
+	require.NoError(t, err)
+	defer tree.Close()
+	for i, want := range [][]byte{nil, testVal(1, 1), nil, nil} {
+		val, ok, err := tree.Get(testKey(i))
+		require.NoError(t, err)
+		assert.Equal(t, want != nil, ok, "key %d", i)
+		assert.Equal(t, want, val, "key %d", i)
+	}
+}
+
+func TestTree_Durability(t *testing.T) {
+	syncs := func(tree *Tree) uint64 {
+		tree.mu.Lock()
+		defer tree.mu.Unlock()
+		return tree.mem.wal.Stats().Syncs
+	}
+	set := func(t *testing.T, tree *Tree, n int) {
+		for i := 0; i < n; i++ {
+			require.NoError(t, tree.Set(testKey(i), testVal(i, 0)))
+		}
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		tree, _ := setupTree(t, Options{})
+		set(t, tree, 20)
+		assert.Equal(t, uint64(20), syncs(tree))
+
+		// Concurrent writers share fsyncs
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					assert.NoError(t, tree.Set(testKey(1000*(i+1)+j), testVal(j, 0)))
+				}
+			}(i)
+		}
+		wg.Wait()
+		assert.LessOrEqual(t, syncs(tree), uint64(20+160))
+	})
+
+	t.Run("periodic", func(t *testing.T) {
+		tree, _ := setupTree(t, Options{Durability: SyncPeriodic, SyncInterval: 50 * time.Millisecond})
+		set(t, tree, 20)
+		assert.Eventually(t, func() bool {
+			return syncs(tree) > 0
+		}, time.Second, time.Millisecond)
+		assert.Less(t, syncs(tree), uint64(20))
+	})
+
+	t.Run("os", func(t *testing.T) {
+		tree, _ := setupTree(t, Options{Durability: SyncOS, SyncInterval: time.Millisecond})
+		set(t, tree, 20)
+		time.Sleep(5 * time.Millisecond)
+		assert.Zero(t, syncs(tree))
+
+		// A batch can ask for more than the tree's setting
+		require.NoError(t, tree.WriteBatch([]BatchOp{{Key: []byte("a"), Value: []byte("b")}}, SyncCommit))
+		assert.Equal(t, uint64(1), syncs(tree))
+
+		// A memtable closed by its flush has nothing left to sync
+		tree.mu.Lock()
+		mem := tree.mem
+		tree.mu.Unlock()
+		require.NoError(t, tree.Set([]byte("c"), []byte("d")))
+		require.NoError(t, tree.Flush())
+		assert.NoError(t, mem.sync(mem.wal.NextLSN()-1))
+	})
+}
+
+func TestTree_Tombstones(t *testing.T) {
+	tree, _ := setupTree(t, smallOptions())
+
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, tree.Set(testKey(i), testVal(i, 0)))
+	}
+	require.NoError(t, tree.Flush())
+
+	// Tombstones in the memtable and in level 0 hide the older values
+	for i := 0; i < 1000; i += 2 {
+		_, err := tree.Del(testKey(i))
+		require.NoError(t, err)
+	}
+	keys := collect(t, tree, false, testKey(100))
+	assert.Len(t, keys, 450)
+	assert.Equal(t, string(testKey(101)), keys[0])
+
+	// Once compacted to the bottom they are gone
+	require.NoError(t, tree.Flush())
+	var entries int
+	for _, level := range tree.Stats().Levels {
+		entries += level.Keys
+	}
+	assert.Len(t, collect(t, tree, false, nil), 500)
+	assert.GreaterOrEqual(t, entries, 500)
+	assert.Less(t, entries, 1500)
+}
+
+func TestTree_Iterator(t *testing.T) {
+	tree, _ := setupTree(t, smallOptions())
+
+	// Keys spread over the memtable and several tables
+	for i := 0; i < 600; i += 2 {
+		require.NoError(t, tree.Set(testKey(i), testVal(i, 0)))
+	}
+	require.NoError(t, tree.Flush())
+	for i := 1; i < 600; i += 2 {
+		require.NoError(t, tree.Set(testKey(i), testVal(i, 1)))
+	}
+
+	keys := collect(t, tree, false, testKey(299))
+	assert.Len(t, keys, 301)
+	assert.Equal(t, string(testKey(299)), keys[0])
+
+	keys = collect(t, tree, true, testKey(299))
+	assert.Len(t, keys, 300)
+	assert.Equal(t, string(testKey(299)), keys[0])
+	assert.Equal(t, string(testKey(0)), keys[299])
+
+	// A reverse seek between keys lands on the one below
+	keys = collect(t, tree, true, []byte("key000010x"))
+	assert.Equal(t, string(testKey(10)), keys[0])
+
+	var n int
+	require.NoError(t, tree.Scan(testKey(10), testKey(19), func(key, val []byte) bool {
+		n++
+		return true
+	}))
+	assert.Equal(t, 10, n)
+}
+
+// TestTree_IteratorKeepsTables checks that an open iterator still reads
+// the tables a compaction replaced
+func TestTree_IteratorKeepsTables(t *testing.T) {
+	tree, dir := setupTree(t, smallOptions())
+
+	for i := 0; i < 300; i++ {
+		require.NoError(t, tree.Set(testKey(i), testVal(i, 0)))
+	}
+	require.NoError(t, tree.Flush())
+
+	it := tree.NewIterator(false)
+	for i := 0; i < 3000; i++ {
+		require.NoError(t, tree.Set(testKey(i), testVal(i, 1)))
+	}
+	require.NoError(t, tree.Flush())
+
+	n := 0
+	for it.Seek(nil); it.Valid(); it.Next() {
+		n++
+	}
+	require.NoError(t, it.Err())
+	assert.GreaterOrEqual(t, n, 300)
+	require.NoError(t, it.Close())
+
+	// The replaced files are gone once the iterator is closed
+	tables, err := filepath.Glob(filepath.Join(dir, "*.sst"))
+	require.NoError(t, err)
+	var live int
+	for _, level := range tree.Stats().Levels {
+		live += level.Tables
+	}
+	assert.Len(t, tables, live)
+}
+
+func TestTree_Concurrent(t *testing.T) {
+	tree, _ := setupTree(t, smallOptions())
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < 2000; i += 4 {
+				assert.NoError(t, tree.Set(testKey(i), testVal(i, 0)))
+			}
+		}(w)
+	}
+	for r := 0; r < 2; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				it := tree.NewIterator(r == 1)
+				prev := ""
+				for it.Seek(nil); it.Valid(); it.Next() {
+					if prev != "" {
+						assert.Equal(t, r == 1, string(it.Key()) < prev)
+					}
+					prev = string(it.Key())
+				}
+				assert.NoError(t, it.Err())
+				it.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.NoError(t, tree.Flush())
+	assert.Len(t, collect(t, tree, false, nil), 2000)
+}
+
+func TestTree_ConcurrentDel(t *testing.T) {
+	tree, _ := setupTree(t, Options{Durability: SyncOS})
+
+	// Of the deletes racing on a key, exactly one finds it
+	for i := 0; i < 200; i++ {
+		require.NoError(t, tree.Set(testKey(i), testVal(i, 0)))
+	}
+	var deleted [200]int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				ok, err := tree.Del(testKey(i))
+				assert.NoError(t, err)
+				if ok {
+					mu.Lock()
+					deleted[i]++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for i, n := range deleted {
+		assert.Equal(t, 1, n, "key %d", i)
+	}
+}
+
+func TestTable_Corrupt(t *testing.T) {
+	dir := t.TempDir()
+	path := tablePath(dir, 1)
+	w, err := newTableWriter(path, 256)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, w.add(kindSet, testKey(i), testVal(i, 0)))
+	}
+	require.NoError(t, w.finish())
+
+	tbl, err := openTable(path, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 100, tbl.count)
+	assert.Equal(t, testKey(0), tbl.smallest)
+	assert.Equal(t, testKey(99), tbl.largest)
+	e, ok, err := tbl.get(testKey(42))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, testVal(42, 0), e.value)
+	tbl.f.Close()
+
+	// Flip a byte of the first data block
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	raw[10] ^= 0xFF
+	require.NoError(t, os.WriteFile(path, raw, 0644))
+
+	tbl, err = openTable(path, 1)
+	require.NoError(t, err)
+	defer tbl.f.Close()
+	_, _, err = tbl.get(testKey(0))
+	assert.ErrorIs(t, err, ErrTableCorrupt)
+}
@@ -0,0 +1,145 @@
+package lsm
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/index/bptree_ram"
+	"github.com/spaghetti-lover/go-db/internal/wal"
+)
+
+// MEM_ENTRY_OVERHEAD is the memory an entry takes besides its key and
+// value, used to size the memtable
+const MEM_ENTRY_OVERHEAD = 32
+
+type memValue struct {
+	kind  byte
+	value []byte
+}
+
+// memtable holds the latest writes in an in-memory B+tree. Every write is
+// logged to its WAL first, so the memtable can be rebuilt after a crash
+// until it has been flushed to an SSTable. Stored keys and values are
+// never changed in place, so readers may keep them after the lock is
+// released.
+type memtable struct {
+	mu   sync.RWMutex
+	tree *bptree_ram.BPlusTree[[]byte, memValue]
+	size int
+
+	walNum   uint64
+	wal      *wal.Log
+	periodic uint64 // last LSN of a SyncPeriodic write, 0 once synced; guarded by Tree.mu
+
+	syncMu   sync.RWMutex // held by syncs, and exclusively by close
+	closed   bool
+	closeErr error // what syncing the WAL on close returned
+}
+
+// openMemtable opens the WAL at path and replays what it holds. The WAL
+// only returns whole transactions, so a batch comes back entirely or not
+// at all.
+func openMemtable(path string, walNum uint64) (*memtable, error) {
+	log, entries, err := wal.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &memtable{
+		tree:   bptree_ram.NewBPlusTree[[]byte, memValue](bytes.Compare, 0),
+		walNum: walNum,
+		wal:    log,
+	}
+
+	for _, e := range entries {
+		kind := kindSet
+		if e.Op == wal.OpDel {
+			kind = kindDelete
+		}
+		m.apply(kind, e.Key, e.Value)
+	}
+	return m, nil
+}
+
+// add logs ops, as one transaction if there are several, then applies
+// them together. It returns the LSN to sync for the ops to be durable.
+func (m *memtable) add(ops []BatchOp) (uint64, error) {
+	entries := make([]*wal.WALEntry, len(ops))
+	for i, op := range ops {
+		entries[i] = &wal.WALEntry{Op: wal.OpSet, Key: op.Key, Value: op.Value}
+		if op.Delete {
+			entries[i] = &wal.WALEntry{Op: wal.OpDel, Key: op.Key}
+		}
+	}
+	var lsn uint64
+	var err error
+	if len(entries) == 1 {
+		lsn, err = m.wal.Append(entries[0])
+	} else {
+		lsn, err = m.wal.AppendTx(entries)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, op := range ops {
+		if op.Delete {
+			m.apply(kindDelete, append([]byte{}, op.Key...), nil)
+		} else {
+			m.apply(kindSet, append([]byte{}, op.Key...), append([]byte{}, op.Value...))
+		}
+	}
+	return lsn, nil
+}
+
+func (m *memtable) apply(kind byte, key, value []byte) {
+	if old, ok := m.tree.Get(key); ok {
+		m.size -= len(old.value)
+	} else {
+		m.size += len(key) + MEM_ENTRY_OVERHEAD
+	}
+	m.size += len(value)
+	m.tree.Insert(key, memValue{kind: kind, value: value})
+}
+
+func (m *memtable) get(key []byte) (memValue, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.tree.Get(key)
+}
+
+func (m *memtable) approximateSize() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.size
+}
+
+func (m *memtable) len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.tree.Len()
+}
+
+// sync returns once the WAL is on disk up to lsn. A closed memtable was
+// synced by close, so there is nothing left to wait for.
+func (m *memtable) sync(lsn uint64) error {
+	m.syncMu.RLock()
+	defer m.syncMu.RUnlock()
+	if m.closed {
+		return m.closeErr
+	}
+	return m.wal.SyncTo(lsn)
+}
+
+// close syncs the WAL and closes it
+func (m *memtable) close() error {
+	m.syncMu.Lock()
+	defer m.syncMu.Unlock()
+	m.closed = true
+	m.closeErr = m.wal.Sync()
+	if err := m.wal.Close(); err != nil {
+		return err
+	}
+	return m.closeErr
+}
@@ -0,0 +1,394 @@
+package lsm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"sync/atomic"
+)
+
+// SSTable file layout:
+//
+//	data block* | index block | bloom block | footer
+//
+// A data block is a run of entries, kind(1) | klen uvarint | vlen uvarint |
+// key | value, cut once it reaches the block size. The index block holds
+// the smallest key of the table, the number of entries, then the last key,
+// offset and length of every data block. Each block is followed by its
+// CRC32C. The footer is index offset(8) | index length(8) | bloom
+// offset(8) | bloom length(8) | magic(8).
+
+const (
+	TABLE_MAGIC uint64 = 0x4C534D5353544231 // "LSMSSTB1"
+	FOOTER_SIZE        = 5 * 8
+)
+
+// Entry kinds. A delete leaves a tombstone that hides older values until
+// compaction reaches the last level holding the key.
+const (
+	kindSet    byte = 0
+	kindDelete byte = 1
+)
+
+var (
+	ErrTableCorrupt = errors.New("sstable is corrupt")
+	castagnoli      = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type blockHandle struct {
+	lastKey []byte
+	offset  uint64
+	length  uint64 // without the checksum
+}
+
+type entry struct {
+	kind  byte
+	key   []byte
+	value []byte
+}
+
+// tableWriter builds an SSTable from entries added in ascending key order
+type tableWriter struct {
+	f         *os.File
+	w         *bufio.Writer
+	path      string
+	blockSize int
+
+	offset   uint64
+	block    []byte
+	lastKey  []byte
+	smallest []byte
+	index    []blockHandle
+	keys     [][]byte
+	count    int
+}
+
+func newTableWriter(path string, blockSize int) (*tableWriter, error) {
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &tableWriter{f: f, w: bufio.NewWriter(f), path: path, blockSize: blockSize}, nil
+}
+
+func (w *tableWriter) add(kind byte, key, value []byte) error {
+	if w.count == 0 {
+		w.smallest = append([]byte{}, key...)
+	}
+	w.block = append(w.block, kind)
+	w.block = binary.AppendUvarint(w.block, uint64(len(key)))
+	w.block = binary.AppendUvarint(w.block, uint64(len(value)))
+	w.block = append(w.block, key...)
+	w.block = append(w.block, value...)
+	w.lastKey = append(w.lastKey[:0], key...)
+	w.keys = append(w.keys, append([]byte{}, key...))
+	w.count++
+
+	if len(w.block) >= w.blockSize {
+		return w.flushBlock()
+	}
+	return nil
+}
+
+// size is the number of bytes written so far, pending block included
+func (w *tableWriter) size() uint64 {
+	return w.offset + uint64(len(w.block))
+}
+
+func (w *tableWriter) writeBlock(data []byte) (uint64, uint64, error) {
+	offset := w.offset
+	if _, err := w.w.Write(data); err != nil {
+		return 0, 0, err
+	}
+	if err := binary.Write(w.w, binary.BigEndian, crc32.Checksum(data, castagnoli)); err != nil {
+		return 0, 0, err
+	}
+	w.offset += uint64(len(data)) + 4
+	return offset, uint64(len(data)), nil
+}
+
+func (w *tableWriter) flushBlock() error {
+	if len(w.block) == 0 {
+		return nil
+	}
+	offset, length, err := w.writeBlock(w.block)
+	if err != nil {
+		return err
+	}
+	w.index = append(w.index, blockHandle{lastKey: append([]byte{}, w.lastKey...), offset: offset, length: length})
+	w.block = w.block[:0]
+	return nil
+}
+
+// finish writes the index, bloom filter and footer and moves the table in
+// place. The file is synced before it is renamed.
+func (w *tableWriter) finish() error {
+	if err := w.flushBlock(); err != nil {
+		w.abort()
+		return err
+	}
+
+	var index []byte
+	index = binary.AppendUvarint(index, uint64(len(w.smallest)))
+	index = append(index, w.smallest...)
+	index = binary.AppendUvarint(index, uint64(w.count))
+	index = binary.AppendUvarint(index, uint64(len(w.index)))
+	for _, h := range w.index {
+		index = binary.AppendUvarint(index, uint64(len(h.lastKey)))
+		index = append(index, h.lastKey...)
+		index = binary.AppendUvarint(index, h.offset)
+		index = binary.AppendUvarint(index, h.length)
+	}
+	indexOff, indexLen, err := w.writeBlock(index)
+	if err != nil {
+		w.abort()
+		return err
+	}
+	bloomOff, bloomLen, err := w.writeBlock(newBloomFilter(w.keys))
+	if err != nil {
+		w.abort()
+		return err
+	}
+
+	footer := []uint64{indexOff, indexLen, bloomOff, bloomLen, TABLE_MAGIC}
+	if err := binary.Write(w.w, binary.BigEndian, footer); err != nil {
+		w.abort()
+		return err
+	}
+	if err := w.w.Flush(); err != nil {
+		w.abort()
+		return err
+	}
+	if err := w.f.Sync(); err != nil {
+		w.abort()
+		return err
+	}
+	if err := w.f.Close(); err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+	return os.Rename(w.f.Name(), w.path)
+}
+
+func (w *tableWriter) abort() {
+	w.f.Close()
+	os.Remove(w.f.Name())
+}
+
+// table is an open SSTable. Its index and bloom filter stay in memory;
+// data blocks are read on demand. Versions reference the tables they
+// hold; the file is closed when the last reference goes, and removed as
+// well once compaction has replaced it.
+type table struct {
+	num      uint64
+	path     string
+	f        *os.File
+	size     int64
+	count    int
+	smallest []byte
+	largest  []byte
+	index    []blockHandle
+	bloom    bloomFilter
+
+	refs     atomic.Int32
+	obsolete atomic.Bool
+}
+
+func openTable(path string, num uint64) (*table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &table{num: num, path: path, f: f}
+	if err := t.load(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+func (t *table) load() error {
+	info, err := t.f.Stat()
+	if err != nil {
+		return err
+	}
+	t.size = info.Size()
+	if t.size < FOOTER_SIZE {
+		return fmt.Errorf("%w: file is too short", ErrTableCorrupt)
+	}
+
+	footer := make([]uint64, 5)
+	raw := make([]byte, FOOTER_SIZE)
+	if _, err := t.f.ReadAt(raw, t.size-FOOTER_SIZE); err != nil {
+		return err
+	}
+	if err := binary.Read(bytes.NewReader(raw), binary.BigEndian, footer); err != nil {
+		return err
+	}
+	if footer[4] != TABLE_MAGIC {
+		return fmt.Errorf("%w: bad magic %016x", ErrTableCorrupt, footer[4])
+	}
+
+	index, err := t.readBlock(footer[0], footer[1])
+	if err != nil {
+		return err
+	}
+	if err := t.decodeIndex(index); err != nil {
+		return err
+	}
+	bloom, err := t.readBlock(footer[2], footer[3])
+	if err != nil {
+		return err
+	}
+	t.bloom = bloom
+	return nil
+}
+
+func (t *table) decodeIndex(buf []byte) error {
+	r := bytes.NewReader(buf)
+	readBytes := func() ([]byte, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(r.Len()) {
+			return nil, fmt.Errorf("%w: index entry overruns the block", ErrTableCorrupt)
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(r, b)
+		return b, err
+	}
+
+	var err error
+	if t.smallest, err = readBytes(); err != nil {
+		return err
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	t.count = int(count)
+	nblocks, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+
+	t.index = make([]blockHandle, 0, nblocks)
+	for i := uint64(0); i < nblocks; i++ {
+		var h blockHandle
+		if h.lastKey, err = readBytes(); err != nil {
+			return err
+		}
+		if h.offset, err = binary.ReadUvarint(r); err != nil {
+			return err
+		}
+		if h.length, err = binary.ReadUvarint(r); err != nil {
+			return err
+		}
+		t.index = append(t.index, h)
+	}
+	if len(t.index) > 0 {
+		t.largest = t.index[len(t.index)-1].lastKey
+	}
+	return nil
+}
+
+// readBlock reads a block and checks it against the checksum after it
+func (t *table) readBlock(offset, length uint64) ([]byte, error) {
+	if offset+length+4 > uint64(t.size) {
+		return nil, fmt.Errorf("%w: block at %d overruns the file", ErrTableCorrupt, offset)
+	}
+	buf := make([]byte, length+4)
+	if _, err := t.f.ReadAt(buf, int64(offset)); err != nil {
+		return nil, err
+	}
+	data := buf[:length]
+	if crc32.Checksum(data, castagnoli) != binary.BigEndian.Uint32(buf[length:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch in block at %d", ErrTableCorrupt, offset)
+	}
+	return data, nil
+}
+
+// readDataBlock decodes data block i. The entries alias a fresh buffer.
+func (t *table) readDataBlock(i int) ([]entry, error) {
+	h := t.index[i]
+	buf, err := t.readBlock(h.offset, h.length)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []entry
+	for len(buf) > 0 {
+		kind := buf[0]
+		klen, n1 := binary.Uvarint(buf[1:])
+		if n1 <= 0 {
+			return nil, fmt.Errorf("%w: bad entry in block at %d", ErrTableCorrupt, h.offset)
+		}
+		vlen, n2 := binary.Uvarint(buf[1+n1:])
+		if n2 <= 0 {
+			return nil, fmt.Errorf("%w: bad entry in block at %d", ErrTableCorrupt, h.offset)
+		}
+		start := 1 + n1 + n2
+		if uint64(len(buf)-start) < klen+vlen {
+			return nil, fmt.Errorf("%w: entry overruns block at %d", ErrTableCorrupt, h.offset)
+		}
+		key := buf[start : start+int(klen) : start+int(klen)]
+		val := buf[start+int(klen) : start+int(klen+vlen) : start+int(klen+vlen)]
+		entries = append(entries, entry{kind: kind, key: key, value: val})
+		buf = buf[start+int(klen+vlen):]
+	}
+	return entries, nil
+}
+
+// blockFor returns the first block whose last key >= key
+func (t *table) blockFor(key []byte) int {
+	return sort.Search(len(t.index), func(i int) bool {
+		return bytes.Compare(t.index[i].lastKey, key) >= 0
+	})
+}
+
+// get looks key up. found is false when the table does not hold it.
+func (t *table) get(key []byte) (e entry, found bool, err error) {
+	if bytes.Compare(key, t.smallest) < 0 || bytes.Compare(key, t.largest) > 0 || !t.bloom.mayContain(key) {
+		return entry{}, false, nil
+	}
+	bi := t.blockFor(key)
+	if bi == len(t.index) {
+		return entry{}, false, nil
+	}
+	entries, err := t.readDataBlock(bi)
+	if err != nil {
+		return entry{}, false, err
+	}
+	i := sort.Search(len(entries), func(i int) bool {
+		return bytes.Compare(entries[i].key, key) >= 0
+	})
+	if i < len(entries) && bytes.Equal(entries[i].key, key) {
+		return entries[i], true, nil
+	}
+	return entry{}, false, nil
+}
+
+// overlaps reports whether the table holds keys in [lo, hi]
+func (t *table) overlaps(lo, hi []byte) bool {
+	return bytes.Compare(t.largest, lo) >= 0 && bytes.Compare(t.smallest, hi) <= 0
+}
+
+func (t *table) ref() {
+	t.refs.Add(1)
+}
+
+func (t *table) unref() {
+	if t.refs.Add(-1) == 0 {
+		t.f.Close()
+		if t.obsolete.Load() {
+			os.Remove(t.path)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package lsm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+)
+
+const MANIFEST_FILE = "MANIFEST"
+
+// version is the set of tables that make up the tree at one point. Level
+// 0 holds flushed memtables, oldest first, whose key ranges may overlap;
+// every other level is sorted by key and its tables do not overlap.
+// Readers reference the version they started with, which keeps its files
+// open until they are done.
+type version struct {
+	levels [][]*table
+	refs   atomic.Int32
+}
+
+func newVersion(levels [][]*table) *version {
+	v := &version{levels: levels}
+	for _, level := range levels {
+		for _, t := range level {
+			t.ref()
+		}
+	}
+	v.refs.Store(1)
+	return v
+}
+
+func (v *version) ref() {
+	v.refs.Add(1)
+}
+
+func (v *version) unref() {
+	if v.refs.Add(-1) == 0 {
+		for _, level := range v.levels {
+			for _, t := range level {
+				t.unref()
+			}
+		}
+	}
+}
+
+// clone returns a copy of the level lists to build the next version from
+func (v *version) clone() [][]*table {
+	levels := make([][]*table, len(v.levels))
+	for i, level := range v.levels {
+		levels[i] = append([]*table{}, level...)
+	}
+	return levels
+}
+
+// sortLevel orders the tables of a level other than 0 by key
+func sortLevel(level []*table) {
+	sort.Slice(level, func(i, j int) bool {
+		return bytes.Compare(level[i].smallest, level[j].smallest) < 0
+	})
+}
+
+func levelBytes(level []*table) int64 {
+	var n int64
+	for _, t := range level {
+		n += t.size
+	}
+	return n
+}
+
+// overlapping returns the tables of level that hold keys in [lo, hi]
+func overlapping(level []*table, lo, hi []byte) []*table {
+	var out []*table
+	for _, t := range level {
+		if t.overlaps(lo, hi) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// manifest records which tables make up the tree. It is rewritten in full
+// and renamed into place on every change.
+type manifest struct {
+	NextFile  uint64     `json:"next_file"`
+	LogNumber uint64     `json:"log_number"` // WALs below this have been flushed
+	Levels    [][]uint64 `json:"levels"`
+}
+
+func readManifest(dir string) (*manifest, error) {
+	raw, err := os.ReadFile(filepath.Join(dir, MANIFEST_FILE))
+	if errors.Is(err, os.ErrNotExist) {
+		return &manifest{NextFile: 1, Levels: make([][]uint64, NUM_LEVELS)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := &manifest{}
+	if err := json.Unmarshal(raw, m); err != nil {
+		return nil, fmt.Errorf("manifest: %w", err)
+	}
+	if len(m.Levels) != NUM_LEVELS {
+		return nil, fmt.Errorf("manifest: %d levels, expected %d", len(m.Levels), NUM_LEVELS)
+	}
+	return m, nil
+}
+
+func writeManifest(dir string, m *manifest) error {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, MANIFEST_FILE)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(raw); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes renames and removals in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func tablePath(dir string, num uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%06d.sst", num))
+}
+
+func walPath(dir string, num uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%06d.wal", num))
+}
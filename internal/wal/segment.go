@@ -0,0 +1,353 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A segmented log keeps its records in a directory of segment files, each
+// named after the LSN of its first record, e.g. 00000000000000000001.wal.
+// Records go to the newest segment; once it would grow past the segment
+// size it is synced and a new one is started. Finished segments are
+// handed to the archive hook in order, a DONE_SUFFIX marker recording each
+// success, and are removed by Discard once a checkpoint covers them.
+
+// DEFAULT_SEGMENT_SIZE is the segment size used when Options leaves it 0
+const DEFAULT_SEGMENT_SIZE = 16 << 20
+
+const (
+	SEGMENT_SUFFIX = ".wal"
+	DONE_SUFFIX    = ".done" // appended to an archived segment's name
+)
+
+// Options configures a segmented log
+type Options struct {
+	// SegmentSize is the size at which a segment is finished. A
+	// transaction is never split, so a segment can run past it.
+	SegmentSize int64
+
+	// Archive, if set, is called with the path of each finished segment,
+	// oldest first, from a background goroutine. A segment is kept until
+	// Archive has returned nil for it; after an error the segment is
+	// tried again when the next one is finished.
+	Archive func(segment string) error
+}
+
+type segment struct {
+	path     string
+	firstLSN uint64
+	size     int64
+	archived bool
+}
+
+// SegmentName returns the file name of the segment that starts at lsn
+func SegmentName(lsn uint64) string {
+	return fmt.Sprintf("%020d%s", lsn, SEGMENT_SUFFIX)
+}
+
+// ListSegments returns the first LSNs of the segments in dir, in order
+func ListSegments(dir string) ([]uint64, error) {
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var lsns []uint64
+	for _, entry := range names {
+		name, ok := strings.CutSuffix(entry.Name(), SEGMENT_SUFFIX)
+		if !ok {
+			continue
+		}
+		if lsn, err := strconv.ParseUint(name, 10, 64); err == nil {
+			lsns = append(lsns, lsn)
+		}
+	}
+	sort.Slice(lsns, func(i, j int) bool { return lsns[i] < lsns[j] })
+	return lsns, nil
+}
+
+// OpenDir opens or creates a segmented log in dir and returns the
+// committed records of all its segments, as Open does. Only the newest
+// segment may end in a torn write; the others must be whole and follow
+// each other without a gap.
+func OpenDir(dir string, opts Options) (*Log, []WALEntry, error) {
+	if opts.SegmentSize == 0 {
+		opts.SegmentSize = DEFAULT_SEGMENT_SIZE
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, err
+	}
+	lsns, err := ListSegments(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	l := newLog(dir, opts)
+	if len(lsns) == 0 {
+		if err := l.startSegment(1); err != nil {
+			return nil, nil, err
+		}
+		l.startArchiver()
+		return l, nil, nil
+	}
+
+	var entries []WALEntry
+	next := lsns[0]
+	for _, lsn := range lsns[:len(lsns)-1] {
+		path := filepath.Join(dir, SegmentName(lsn))
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if lsn != next {
+			return nil, nil, fmt.Errorf("%s: %w: segment starts at LSN %d, want %d", path, ErrCorrupt, lsn, next)
+		}
+		units, segNext, end, err := scan(data, lsn)
+		if err == nil && end < len(data) {
+			err = fmt.Errorf("%w: torn write at offset %d of a finished segment", ErrCorrupt, end)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		_, err = os.Stat(path + DONE_SUFFIX)
+		l.old = append(l.old, segment{
+			path:     path,
+			firstLSN: lsn,
+			size:     int64(len(data)),
+			archived: opts.Archive == nil || err == nil,
+		})
+		entries = append(entries, writes(units)...)
+		next = segNext
+	}
+
+	last := lsns[len(lsns)-1]
+	if len(lsns) > 1 && last != next {
+		return nil, nil, fmt.Errorf("%s: %w: segment starts at LSN %d, want %d", dir, ErrCorrupt, last, next)
+	}
+	l.path = filepath.Join(dir, SegmentName(last))
+	l.f, err = os.OpenFile(l.path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	tail, err := l.loadCurrent(last)
+	if err != nil {
+		l.f.Close()
+		return nil, nil, fmt.Errorf("%s: %w", l.path, err)
+	}
+	l.startArchiver()
+	return l, append(entries, tail...), nil
+}
+
+// startSegment creates the segment that starts at lsn and makes it the
+// current one
+func (l *Log) startSegment(lsn uint64) error {
+	path := filepath.Join(l.dir, SegmentName(lsn))
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	l.f, l.path = f, path
+	if err := l.resetCurrent(lsn); err != nil {
+		return err
+	}
+	return syncDir(l.dir)
+}
+
+// Rotate finishes the current segment, if it holds any record, and starts
+// a new one, so that everything logged so far can be archived
+func (l *Log) Rotate() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.dir == "" {
+		return fmt.Errorf("wal: %s is a single-file log", l.path)
+	}
+	if l.err != nil {
+		return l.err
+	}
+	if l.size == FILE_HEADER_SIZE {
+		return nil
+	}
+	return l.rotate()
+}
+
+// rotate syncs and finishes the current segment and starts the next one
+func (l *Log) rotate() error {
+	l.waitSync()
+	if err := l.f.Sync(); err != nil {
+		l.err = err
+		return err
+	}
+	l.synced = l.nextLSN
+	l.stats.Syncs++
+	l.f.Close()
+
+	l.old = append(l.old, segment{
+		path:     l.path,
+		firstLSN: l.segFirst,
+		size:     l.size,
+		archived: l.opts.Archive == nil,
+	})
+	if err := l.startSegment(l.nextLSN); err != nil {
+		l.err = err
+		return err
+	}
+	l.archiveWake.Broadcast()
+	return nil
+}
+
+// Discard removes the finished segments whose records all come before
+// lsn, which a checkpoint has made redundant. Segments not archived yet
+// are kept, and so is every segment after them. A single-file log keeps
+// its records.
+func (l *Log) Discard(lsn uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := 0
+	for n < len(l.old) && l.old[n].archived {
+		end := l.segFirst
+		if n+1 < len(l.old) {
+			end = l.old[n+1].firstLSN
+		}
+		if end > lsn {
+			break
+		}
+		if err := removeSegment(l.old[n].path); err != nil {
+			return err
+		}
+		n++
+	}
+	l.old = l.old[n:]
+	return nil
+}
+
+// removeSegment deletes a segment and its archive marker
+func removeSegment(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(path + DONE_SUFFIX); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ArchiveErr returns the last error of the archive hook, or nil once a
+// later attempt succeeds
+func (l *Log) ArchiveErr() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.archiveErr
+}
+
+func (l *Log) startArchiver() {
+	if l.opts.Archive == nil {
+		return
+	}
+	l.wg.Add(1)
+	go l.archiveLoop()
+}
+
+// archiveLoop hands the finished segments to the archive hook in order
+func (l *Log) archiveLoop() {
+	defer l.wg.Done()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	retry := false // the last attempt failed; wait for the next segment
+	for {
+		i := 0
+		for i < len(l.old) && l.old[i].archived {
+			i++
+		}
+		if l.closed {
+			return
+		}
+		if i == len(l.old) || retry {
+			retry = false
+			l.archiveWake.Wait()
+			continue
+		}
+
+		seg := l.old[i]
+		l.mu.Unlock()
+		err := l.opts.Archive(seg.path)
+		if err == nil {
+			err = markArchived(seg.path)
+		}
+		l.mu.Lock()
+
+		l.archiveErr = err
+		if err != nil {
+			retry = true
+			continue
+		}
+		for j := range l.old {
+			if l.old[j].firstLSN == seg.firstLSN {
+				l.old[j].archived = true
+			}
+		}
+		l.stats.Archived++
+	}
+}
+
+func markArchived(path string) error {
+	f, err := os.Create(path + DONE_SUFFIX)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// CopyToDir returns an archive hook that copies each segment into dir.
+// The copy is synced under a temporary name and then renamed, so dir
+// only ever holds whole segments.
+func CopyToDir(dir string) func(segment string) error {
+	return func(segment string) error {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		src, err := os.Open(segment)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		dst := filepath.Join(dir, filepath.Base(segment))
+		tmp, err := os.Create(dst + ".tmp")
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(tmp, src); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(dst+".tmp", dst); err != nil {
+			return err
+		}
+		return syncDir(dir)
+	}
+}
+
+// syncDir makes the files created in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -1,45 +1,532 @@
 package wal
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
 	"os"
+	"sync"
+	"time"
+)
+
+// Segment file layout:
+//
+//	magic(4) | first LSN(8) | record*
+//
+// A record is crc(4) | length(4) | LSN(8) | payload, where the CRC32C
+// covers everything after itself and length is the payload size. The
+// payload is op(1) | time(8) | klen(4) | vlen(4) | key | value, time
+// being when the record was appended, in Unix nanoseconds. LSNs increase
+// by one from record to record.
+//
+// The records of a transaction sit between a begin and a commit record,
+// which have no key or value, and are written with one write, always to
+// one segment. A record outside such markers commits by itself. A restore
+// point record carries its name as key and changes nothing; see replay.go.
+//
+// A log opened with Open is a single segment file. A log opened with
+// OpenDir is a directory of segments, see segment.go.
+
+const (
+	WAL_MAGIC          uint32 = 0x57414C32 // "WAL2"
+	FILE_HEADER_SIZE          = 4 + 8
+	RECORD_HEADER_SIZE        = 4 + 4 + 8
+	PAYLOAD_FIXED_SIZE        = 1 + 8 + 4 + 4
+
+	// MAX_RECORD_SIZE bounds the payload of one record
+	MAX_RECORD_SIZE = 1 << 30
+)
+
+const (
+	OpSet    byte = 0
+	OpDel    byte = 1
+	OpBegin  byte = 2
+	OpCommit byte = 3
+
+	OpRestorePoint byte = 4
+)
+
+var (
+	ErrCorrupt = errors.New("wal: corrupt log")
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
 )
 
 type WALEntry struct {
-	Op    byte //0 = Set, 1 = Del
+	LSN   uint64 // assigned by Append
+	Time  int64  // Unix nanoseconds, assigned by Append
+	Op    byte   // OpSet or OpDel
 	Key   []byte
-	Value []byte // only for Set
+	Value []byte // only for OpSet
 }
 
-func WriteWAL(f *os.File, entry *WALEntry) error {
-	// Format: [Op][keyLen][valLen][key][val]
-	binary.Write(f, binary.BigEndian, entry.Op)
-	binary.Write(f, binary.BigEndian, uint32(len(entry.Key)))
-	binary.Write(f, binary.BigEndian, uint32(len(entry.Value)))
-	f.Write(entry.Key)
-	f.Write(entry.Value)
-	return f.Sync()
+// Log is an append-only write-ahead log. Appends only write; SyncTo
+// makes them durable, one fsync for every caller waiting at the time. It
+// is safe for concurrent use.
+type Log struct {
+	mu   sync.Mutex
+	dir  string // segment directory, "" for a single-file log
+	opts Options
+
+	old      []segment // finished segments, oldest first
+	f        *os.File  // current segment
+	path     string
+	size     int64
+	segFirst uint64 // first LSN of the current segment
+	nextLSN  uint64
+
+	synced   uint64     // the records before it are on disk
+	syncing  bool       // a SyncTo is running fsync
+	syncDone *sync.Cond // signalled when it ends
+	err      error      // a failed write or sync; the log is unusable
+	stats    Stats
+
+	archiveWake *sync.Cond // signalled when a segment is finished or the log closes
+	archiveErr  error      // last failure of Options.Archive
+	closed      bool
+	wg          sync.WaitGroup
+}
+
+// Stats counts log activity since the log was opened
+type Stats struct {
+	Records  uint64
+	Bytes    int64 // appended
+	Syncs    uint64
+	Archived uint64 // segments
+}
+
+func newLog(dir string, opts Options) *Log {
+	l := &Log{dir: dir, opts: opts}
+	l.syncDone = sync.NewCond(&l.mu)
+	l.archiveWake = sync.NewCond(&l.mu)
+	return l
 }
 
-func ReadAllWAL(f *os.File) ([]WALEntry, error) {
+// Open opens or creates a single-file log at path and returns the
+// committed Set and Del records it holds, without the transaction
+// markers. A torn record at the end of the log, left by a crash in the
+// middle of a write, is cut off along with the transaction it belongs to;
+// a damaged record with valid data after it is reported as ErrCorrupt.
+func Open(path string) (*Log, []WALEntry, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	l := newLog("", Options{})
+	l.f, l.path = f, path
+	entries, err := l.loadCurrent(0)
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return l, entries, nil
+}
+
+// loadCurrent reads the current segment, which must start at first
+// unless it is 0, and cuts off its torn tail
+func (l *Log) loadCurrent(first uint64) ([]WALEntry, error) {
+	data, err := io.ReadAll(l.f)
+	if err != nil {
+		return nil, err
+	}
+
+	// A crash while the segment was created can leave a short header
+	if len(data) < FILE_HEADER_SIZE {
+		return nil, l.resetCurrent(max(first, 1))
+	}
+	units, next, end, err := scan(data, first)
+	if err != nil {
+		return nil, err
+	}
+
+	if end < len(data) {
+		if err := l.f.Truncate(int64(end)); err != nil {
+			return nil, err
+		}
+		if err := l.f.Sync(); err != nil {
+			return nil, err
+		}
+	}
+	l.segFirst = binary.BigEndian.Uint64(data[4:])
+	l.nextLSN = next
+	l.size = int64(end)
+	return writes(units), nil
+}
+
+// unit is what commits at once: a record by itself or a transaction
+type unit struct {
+	writes []WALEntry // its Set and Del records
+	last   WALEntry   // the record itself or the commit record
+}
+
+// scan decodes a segment, which must start at LSN first unless it is 0.
+// It returns the complete units, the LSN after them and the offset where
+// they end; what follows is a torn write.
+func scan(data []byte, first uint64) (units []unit, next uint64, end int, err error) {
+	if len(data) < FILE_HEADER_SIZE {
+		return nil, 0, 0, fmt.Errorf("%w: short header", ErrCorrupt)
+	}
+	if magic := binary.BigEndian.Uint32(data); magic != WAL_MAGIC {
+		return nil, 0, 0, fmt.Errorf("%w: bad magic %08x", ErrCorrupt, magic)
+	}
+	lsn := binary.BigEndian.Uint64(data[4:])
+	if first != 0 && lsn != first {
+		return nil, 0, 0, fmt.Errorf("%w: segment starts at LSN %d, want %d", ErrCorrupt, lsn, first)
+	}
+
+	off := FILE_HEADER_SIZE
+	next, end = lsn, off
+	var tx *unit // the open transaction
+	for off < len(data) {
+		entry, n, err := decodeRecord(data[off:], lsn)
+		if err != nil {
+			if !tornTail(data[off:], err) {
+				return nil, 0, 0, fmt.Errorf("%w: record %d at offset %d: %v", ErrCorrupt, lsn, off, err)
+			}
+			break
+		}
+
+		write := entry.Op == OpSet || entry.Op == OpDel
+		switch {
+		case entry.Op == OpBegin && tx == nil:
+			tx = &unit{}
+		case entry.Op == OpCommit && tx != nil:
+			tx.last = entry
+			units = append(units, *tx)
+			tx = nil
+		case write && tx != nil:
+			tx.writes = append(tx.writes, entry)
+		case write:
+			units = append(units, unit{writes: []WALEntry{entry}, last: entry})
+		case entry.Op == OpRestorePoint && tx == nil:
+			units = append(units, unit{last: entry})
+		default:
+			return nil, 0, 0, fmt.Errorf("%w: record %d at offset %d: unexpected op %d", ErrCorrupt, lsn, off, entry.Op)
+		}
+		lsn++
+		off += n
+
+		// A transaction is written whole, so one without its commit
+		// record was cut short by a crash
+		if tx == nil {
+			next, end = lsn, off
+		}
+	}
+	return units, next, end, nil
+}
+
+// writes returns the Set and Del records of units, in order
+func writes(units []unit) []WALEntry {
 	var entries []WALEntry
+	for _, u := range units {
+		entries = append(entries, u.writes...)
+	}
+	return entries
+}
+
+var (
+	errShortRecord = errors.New("record runs past the end of the log")
+	errBadChecksum = errors.New("checksum mismatch")
+	errBadRecord   = errors.New("malformed record")
+)
+
+// tornTail reports whether a record that failed to decode can be the
+// partial last write of a crash: it runs past the end of the log, or it
+// is the last record and its checksum does not match, or the rest of the
+// log is zeros.
+func tornTail(rest []byte, err error) bool {
+	if errors.Is(err, errShortRecord) {
+		return true
+	}
+	if errors.Is(err, errBadChecksum) && RECORD_HEADER_SIZE+int(binary.BigEndian.Uint32(rest[4:])) == len(rest) {
+		return true
+	}
+	return len(bytes.Trim(rest, "\x00")) == 0
+}
+
+// decodeRecord decodes the record at the start of buf, which must carry
+// lsn, and returns it with its size
+func decodeRecord(buf []byte, lsn uint64) (WALEntry, int, error) {
+	if len(buf) < RECORD_HEADER_SIZE {
+		return WALEntry{}, 0, errShortRecord
+	}
+	length := binary.BigEndian.Uint32(buf[4:])
+	if length > MAX_RECORD_SIZE || length < PAYLOAD_FIXED_SIZE {
+		return WALEntry{}, 0, fmt.Errorf("%w: payload length %d", errBadRecord, length)
+	}
+	end := RECORD_HEADER_SIZE + int(length)
+	if end > len(buf) {
+		return WALEntry{}, 0, errShortRecord
+	}
+	if crc32.Checksum(buf[4:end], castagnoli) != binary.BigEndian.Uint32(buf) {
+		return WALEntry{}, 0, errBadChecksum
+	}
+	if got := binary.BigEndian.Uint64(buf[8:]); got != lsn {
+		return WALEntry{}, 0, fmt.Errorf("%w: LSN %d, want %d", errBadRecord, got, lsn)
+	}
+
+	payload := buf[RECORD_HEADER_SIZE:end]
+	klen := binary.BigEndian.Uint32(payload[9:])
+	vlen := binary.BigEndian.Uint32(payload[13:])
+	if uint64(klen)+uint64(vlen) != uint64(len(payload)-PAYLOAD_FIXED_SIZE) {
+		return WALEntry{}, 0, fmt.Errorf("%w: key and value lengths do not match the payload", errBadRecord)
+	}
+	body := payload[PAYLOAD_FIXED_SIZE:]
+	return WALEntry{
+		LSN:   lsn,
+		Time:  int64(binary.BigEndian.Uint64(payload[1:])),
+		Op:    payload[0],
+		Key:   append([]byte{}, body[:klen]...),
+		Value: append([]byte{}, body[klen:]...),
+	}, end, nil
+}
+
+// encodeRecord frames entry as a record
+func encodeRecord(entry *WALEntry) []byte {
+	length := PAYLOAD_FIXED_SIZE + len(entry.Key) + len(entry.Value)
+	buf := make([]byte, RECORD_HEADER_SIZE, RECORD_HEADER_SIZE+length)
+	binary.BigEndian.PutUint32(buf[4:], uint32(length))
+	binary.BigEndian.PutUint64(buf[8:], entry.LSN)
+	buf = append(buf, entry.Op)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(entry.Time))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(entry.Key)))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(entry.Value)))
+	buf = append(buf, entry.Key...)
+	buf = append(buf, entry.Value...)
+	binary.BigEndian.PutUint32(buf, crc32.Checksum(buf[4:], castagnoli))
+	return buf
+}
+
+// Append assigns entry the next LSN and writes it; SyncTo makes it
+// durable
+func (l *Log) Append(entry *WALEntry) (uint64, error) {
+	if err := checkSize(entry); err != nil {
+		return 0, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry.LSN, entry.Time = l.nextLSN, time.Now().UnixNano()
+	if err := l.write(encodeRecord(entry), 1); err != nil {
+		return 0, err
+	}
+	return entry.LSN, nil
+}
+
+// AppendTx logs entries as one transaction: a begin record, the entries
+// and a commit record, written together. It assigns the entries their
+// LSNs and the same time, and returns the LSN of the commit record, which SyncTo makes
+// durable with the rest.
+func (l *Log) AppendTx(entries []*WALEntry) (uint64, error) {
+	for _, entry := range entries {
+		if err := checkSize(entry); err != nil {
+			return 0, err
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lsn, now := l.nextLSN, time.Now().UnixNano()
+	buf := encodeRecord(&WALEntry{LSN: lsn, Time: now, Op: OpBegin})
+	for _, entry := range entries {
+		lsn++
+		entry.LSN, entry.Time = lsn, now
+		buf = append(buf, encodeRecord(entry)...)
+	}
+	lsn++
+	buf = append(buf, encodeRecord(&WALEntry{LSN: lsn, Time: now, Op: OpCommit})...)
+
+	if err := l.write(buf, len(entries)+2); err != nil {
+		return 0, err
+	}
+	return lsn, nil
+}
+
+// RestorePoint logs a restore point named name, a point in the log that
+// a replay can stop at, and returns its LSN
+func (l *Log) RestorePoint(name string) (uint64, error) {
+	if name == "" {
+		return 0, errors.New("wal: restore point without a name")
+	}
+	return l.Append(&WALEntry{Op: OpRestorePoint, Key: []byte(name)})
+}
+
+func checkSize(entry *WALEntry) error {
+	if PAYLOAD_FIXED_SIZE+len(entry.Key)+len(entry.Value) > MAX_RECORD_SIZE {
+		return fmt.Errorf("wal: record of %d bytes is too large", len(entry.Key)+len(entry.Value))
+	}
+	return nil
+}
+
+// write appends n encoded records, starting a new segment first if they
+// would take the current one past the segment size. On error the log is
+// left as it was: the next write goes over whatever part reached the
+// file.
+func (l *Log) write(buf []byte, n int) error {
+	if l.err != nil {
+		return l.err
+	}
+	if l.dir != "" && l.size > FILE_HEADER_SIZE && l.size+int64(len(buf)) > l.opts.SegmentSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := l.f.WriteAt(buf, l.size); err != nil {
+		return err
+	}
+	l.size += int64(len(buf))
+	l.nextLSN += uint64(n)
+	l.stats.Records += uint64(n)
+	l.stats.Bytes += int64(len(buf))
+	return nil
+}
+
+// SyncTo returns once every record up to lsn is on disk. Callers that
+// arrive while an fsync runs wait for it and then share the next one, so
+// concurrent committers pay for one fsync per group rather than one each.
+// After a failed fsync the log cannot tell what reached the disk, and it
+// fails every later call.
+func (l *Log) SyncTo(lsn uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
 	for {
-		var op byte
-		var klen, vlen uint32
-		if err := binary.Read(f, binary.BigEndian, &op); err != nil {
-			break
+		if l.err != nil {
+			return l.err
 		}
-		if err := binary.Read(f, binary.BigEndian, &klen); err != nil {
-			break
+		if lsn < l.synced {
+			return nil
 		}
-		if err := binary.Read(f, binary.BigEndian, &vlen); err != nil {
+		if !l.syncing {
 			break
 		}
-		key := make([]byte, klen)
-		val := make([]byte, vlen)
-		f.Read(key)
-		f.Read(val)
-		entries = append(entries, WALEntry{Op: op, Key: key, Value: val})
+		l.syncDone.Wait()
+	}
+
+	l.syncing = true
+	f, target := l.f, l.nextLSN
+	l.mu.Unlock()
+	err := f.Sync()
+	l.mu.Lock()
+
+	l.syncing = false
+	l.syncDone.Broadcast()
+	if err != nil {
+		l.err = err
+		return err
 	}
-	return entries, nil
+	l.synced = max(l.synced, target)
+	l.stats.Syncs++
+	return nil
+}
+
+// Sync makes every record written so far durable
+func (l *Log) Sync() error {
+	return l.SyncTo(l.NextLSN() - 1)
+}
+
+// waitSync waits for a running fsync, before the file is replaced or
+// closed under it
+func (l *Log) waitSync() {
+	for l.syncing {
+		l.syncDone.Wait()
+	}
+}
+
+// Stats returns the log counters
+func (l *Log) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+// NextLSN is the LSN the next record will get
+func (l *Log) NextLSN() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.nextLSN
+}
+
+// Size is the length of every segment the log holds in bytes
+func (l *Log) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	size := l.size
+	for _, seg := range l.old {
+		size += seg.size
+	}
+	return size
+}
+
+// FirstLSN is the LSN of the first record the log holds, or would hold
+func (l *Log) FirstLSN() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.old) > 0 {
+		return l.old[0].firstLSN
+	}
+	return l.segFirst
+}
+
+// Restart empties the log; the next record gets lsn. A segmented log
+// removes every segment and starts a new one.
+func (l *Log) Restart(lsn uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.waitSync()
+
+	if l.dir == "" {
+		return l.resetCurrent(lsn)
+	}
+	for _, seg := range l.old {
+		if err := removeSegment(seg.path); err != nil {
+			return err
+		}
+	}
+	l.old = nil
+	l.f.Close()
+	if err := removeSegment(l.path); err != nil {
+		return err
+	}
+	return l.startSegment(lsn)
+}
+
+// resetCurrent empties the current segment; its first record gets lsn
+func (l *Log) resetCurrent(lsn uint64) error {
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := l.f.WriteAt(fileHeader(lsn), 0); err != nil {
+		return err
+	}
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	l.size, l.segFirst, l.nextLSN, l.synced = FILE_HEADER_SIZE, lsn, lsn, lsn
+	return nil
+}
+
+func fileHeader(firstLSN uint64) []byte {
+	header := make([]byte, FILE_HEADER_SIZE)
+	binary.BigEndian.PutUint32(header, WAL_MAGIC)
+	binary.BigEndian.PutUint64(header[4:], firstLSN)
+	return header
+}
+
+// Close waits for a running archive copy, then closes the log
+func (l *Log) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	l.archiveWake.Broadcast()
+	l.mu.Unlock()
+	l.wg.Wait()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.waitSync()
+	return l.f.Close()
 }
@@ -0,0 +1,420 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeLog appends n records to a new log at path and returns the offset
+// each one starts at
+func writeLog(t *testing.T, path string, n int) []int64 {
+	l, entries, err := Open(path)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	var offsets []int64
+	for i := 0; i < n; i++ {
+		offsets = append(offsets, l.Size())
+		lsn, err := l.Append(&WALEntry{Op: OpSet, Key: []byte(fmt.Sprintf("key%d", i)), Value: []byte(fmt.Sprintf("val%d", i))})
+		require.NoError(t, err)
+		assert.Equal(t, uint64(i+1), lsn)
+	}
+	require.NoError(t, l.Close())
+	return offsets
+}
+
+func TestLog_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+	writeLog(t, path, 10)
+
+	l, entries, err := Open(path)
+	require.NoError(t, err)
+	require.Len(t, entries, 10)
+	assert.Equal(t, uint64(4), entries[3].LSN)
+	assert.Equal(t, []byte("key3"), entries[3].Key)
+	assert.Equal(t, []byte("val3"), entries[3].Value)
+
+	_, err = l.Append(&WALEntry{Op: OpDel, Key: []byte("key3")})
+	require.NoError(t, err)
+
+	// LSNs carry on after a restart
+	require.NoError(t, l.Restart(l.NextLSN()))
+	lsn, err := l.Append(&WALEntry{Op: OpSet, Key: []byte("a"), Value: []byte("b")})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(12), lsn)
+	require.NoError(t, l.Close())
+
+	l, entries, err = Open(path)
+	require.NoError(t, err)
+	defer l.Close()
+	require.Len(t, entries, 1)
+	assert.Equal(t, uint64(12), entries[0].LSN)
+	assert.Equal(t, uint64(13), l.NextLSN())
+}
+
+func TestLog_TornTail(t *testing.T) {
+	cases := map[string]func(t *testing.T, path string, last int64){
+		"cut header": func(t *testing.T, path string, last int64) {
+			require.NoError(t, os.Truncate(path, last+5))
+		},
+		"cut payload": func(t *testing.T, path string, last int64) {
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			require.NoError(t, os.Truncate(path, info.Size()-2))
+		},
+		"bad checksum": func(t *testing.T, path string, last int64) {
+			flipByte(t, path, last+RECORD_HEADER_SIZE+3)
+		},
+		"zero fill": func(t *testing.T, path string, last int64) {
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+			require.NoError(t, err)
+			_, err = f.Write(make([]byte, 4096))
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+		},
+	}
+
+	for name, damage := range cases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.wal")
+			offsets := writeLog(t, path, 5)
+			last := offsets[len(offsets)-1]
+			damage(t, path, last)
+
+			l, entries, err := Open(path)
+			require.NoError(t, err)
+			want := 4
+			if name == "zero fill" {
+				want = 5
+			}
+			require.Len(t, entries, want)
+
+			// The damaged tail is gone and appends follow the last good record
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			assert.Equal(t, l.Size(), info.Size())
+			lsn, err := l.Append(&WALEntry{Op: OpSet, Key: []byte("next")})
+			require.NoError(t, err)
+			assert.Equal(t, uint64(want+1), lsn)
+			require.NoError(t, l.Close())
+
+			_, entries, err = Open(path)
+			require.NoError(t, err)
+			require.Len(t, entries, want+1)
+			assert.Equal(t, []byte("next"), entries[want].Key)
+		})
+	}
+}
+
+func TestLog_Transaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+	writeLog(t, path, 2)
+
+	l, _, err := Open(path)
+	require.NoError(t, err)
+	txStart := l.Size()
+	entries := []*WALEntry{
+		{Op: OpSet, Key: []byte("a"), Value: []byte("1")},
+		{Op: OpDel, Key: []byte("key0")},
+	}
+	lsn, err := l.AppendTx(entries)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(6), lsn)
+	assert.Equal(t, uint64(4), entries[0].LSN)
+	assert.Equal(t, uint64(5), entries[1].LSN)
+	txEnd := l.Size()
+	require.NoError(t, l.Close())
+
+	// The markers are not returned
+	l, got, err := Open(path)
+	require.NoError(t, err)
+	require.Len(t, got, 4)
+	assert.Equal(t, uint64(4), got[2].LSN)
+	assert.Equal(t, []byte("key0"), got[3].Key)
+	assert.Equal(t, uint64(7), l.NextLSN())
+	require.NoError(t, l.Close())
+
+	// Without its commit record the transaction is dropped whole, even
+	// when the records before the cut are intact
+	for _, cut := range []int64{txStart + 3, txEnd - RECORD_HEADER_SIZE - PAYLOAD_FIXED_SIZE, txEnd - 1} {
+		require.NoError(t, os.Truncate(path, cut))
+		l, got, err := Open(path)
+		require.NoError(t, err)
+		require.Len(t, got, 2, "cut at %d", cut)
+		assert.Equal(t, txStart, l.Size())
+		assert.Equal(t, uint64(3), l.NextLSN())
+
+		lsn, err := l.AppendTx(entries)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(6), lsn)
+		require.NoError(t, l.Close())
+	}
+}
+
+func TestLog_CorruptMiddle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+	offsets := writeLog(t, path, 5)
+	flipByte(t, path, offsets[2]+RECORD_HEADER_SIZE+3)
+
+	_, _, err := Open(path)
+	assert.ErrorIs(t, err, ErrCorrupt)
+	assert.Contains(t, err.Error(), "record 3")
+
+	// The log is left as it was
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Greater(t, info.Size(), offsets[4])
+}
+
+func TestLog_WriteError(t *testing.T) {
+	l, _, err := Open(filepath.Join(t.TempDir(), "test.wal"))
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	_, err = l.Append(&WALEntry{Op: OpSet, Key: []byte("key")})
+	assert.Error(t, err)
+	assert.Equal(t, uint64(1), l.NextLSN())
+}
+
+func flipByte(t *testing.T, path string, off int64) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	require.NoError(t, err)
+	defer f.Close()
+	b := make([]byte, 1)
+	_, err = f.ReadAt(b, off)
+	require.NoError(t, err)
+	b[0] ^= 0xFF
+	_, err = f.WriteAt(b, off)
+	require.NoError(t, err)
+}
+
+func TestLog_Segments(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "wal")
+	archive := filepath.Join(t.TempDir(), "archive")
+	opts := Options{SegmentSize: 256, Archive: CopyToDir(archive)}
+	l, entries, err := OpenDir(dir, opts)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	for i := 0; i < 20; i++ {
+		_, err := l.Append(&WALEntry{Op: OpSet, Key: []byte(fmt.Sprintf("key%02d", i)), Value: make([]byte, 50)})
+		require.NoError(t, err)
+	}
+	lsns, err := ListSegments(dir)
+	require.NoError(t, err)
+	require.Greater(t, len(lsns), 3)
+	assert.Equal(t, uint64(1), lsns[0])
+
+	// Finished segments are archived in the background, not the current one
+	require.Eventually(t, func() bool {
+		return l.Stats().Archived == uint64(len(lsns)-1)
+	}, time.Second, time.Millisecond)
+	require.NoError(t, l.ArchiveErr())
+	archived, err := ListSegments(archive)
+	require.NoError(t, err)
+	assert.Equal(t, lsns[:len(lsns)-1], archived)
+
+	// Only segments wholly before the LSN go
+	require.NoError(t, l.Discard(lsns[2]+1))
+	assert.Equal(t, lsns[2], l.FirstLSN())
+	require.NoError(t, l.Close())
+
+	l, entries, err = OpenDir(dir, opts)
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+	assert.Equal(t, lsns[2], entries[0].LSN)
+	assert.Equal(t, []byte("key19"), entries[len(entries)-1].Key)
+	assert.Equal(t, uint64(21), l.NextLSN())
+
+	// Rotate finishes a segment early so it can be archived
+	require.NoError(t, l.Rotate())
+	require.Eventually(t, func() bool {
+		return l.Stats().Archived == 1
+	}, time.Second, time.Millisecond)
+	archived, err = ListSegments(archive)
+	require.NoError(t, err)
+	assert.Equal(t, lsns, archived)
+	require.NoError(t, l.Close())
+}
+
+func TestLog_SegmentsNotArchived(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "wal")
+	fail := errors.New("archive is down")
+	l, _, err := OpenDir(dir, Options{SegmentSize: 64, Archive: func(string) error { return fail }})
+	require.NoError(t, err)
+	defer l.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err := l.Append(&WALEntry{Op: OpSet, Key: []byte("key"), Value: make([]byte, 50)})
+		require.NoError(t, err)
+	}
+	require.Eventually(t, func() bool {
+		return l.ArchiveErr() != nil
+	}, time.Second, time.Millisecond)
+
+	// Retention keeps what the archive has not taken
+	require.NoError(t, l.Discard(l.NextLSN()))
+	assert.Equal(t, uint64(1), l.FirstLSN())
+}
+
+func TestLog_SegmentGap(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "wal")
+	l, _, err := OpenDir(dir, Options{SegmentSize: 64})
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err := l.Append(&WALEntry{Op: OpSet, Key: []byte("key"), Value: make([]byte, 50)})
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	lsns, err := ListSegments(dir)
+	require.NoError(t, err)
+	require.Len(t, lsns, 5)
+	require.NoError(t, os.Remove(filepath.Join(dir, SegmentName(lsns[2]))))
+
+	_, _, err = OpenDir(dir, Options{SegmentSize: 64})
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
+
+func TestLog_GroupCommit(t *testing.T) {
+	l, _, err := Open(filepath.Join(t.TempDir(), "test.wal"))
+	require.NoError(t, err)
+	defer l.Close()
+
+	// One fsync covers every record written before it
+	for i := 0; i < 10; i++ {
+		_, err := l.Append(&WALEntry{Op: OpSet, Key: []byte("key")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.SyncTo(5))
+	require.NoError(t, l.SyncTo(10))
+	require.NoError(t, l.Sync())
+	assert.Equal(t, uint64(10), l.Stats().Records)
+	assert.Equal(t, uint64(1), l.Stats().Syncs)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				lsn, err := l.Append(&WALEntry{Op: OpSet, Key: []byte("key")})
+				assert.NoError(t, err)
+				assert.NoError(t, l.SyncTo(lsn))
+			}
+		}()
+	}
+	wg.Wait()
+	stats := l.Stats()
+	assert.Equal(t, uint64(210), stats.Records)
+	assert.LessOrEqual(t, stats.Syncs, uint64(201))
+}
+
+func TestLog_SyncError(t *testing.T) {
+	l, _, err := Open(filepath.Join(t.TempDir(), "test.wal"))
+	require.NoError(t, err)
+	_, err = l.Append(&WALEntry{Op: OpSet, Key: []byte("key")})
+	require.NoError(t, err)
+	require.NoError(t, l.f.Close())
+
+	// The failed fsync sticks
+	assert.Error(t, l.Sync())
+	_, err = l.Append(&WALEntry{Op: OpSet, Key: []byte("key")})
+	assert.Error(t, err)
+	assert.Error(t, l.SyncTo(1))
+}
+
+func TestLog_ReplayArchive(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "wal")
+	l, _, err := OpenDir(dir, Options{SegmentSize: 256})
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ { // LSN 1-5
+		_, err := l.Append(&WALEntry{Op: OpSet, Key: []byte(fmt.Sprintf("key%d", i)), Value: make([]byte, 50)})
+		require.NoError(t, err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	beforeTx := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	_, err = l.AppendTx([]*WALEntry{ // LSN 6-10
+		{Op: OpDel, Key: []byte("key0")},
+		{Op: OpDel, Key: []byte("key1")},
+		{Op: OpSet, Key: []byte("key5")},
+	})
+	require.NoError(t, err)
+	lsn, err := l.RestorePoint("mark") // LSN 11
+	require.NoError(t, err)
+	assert.Equal(t, uint64(11), lsn)
+	_, err = l.Append(&WALEntry{Op: OpSet, Key: []byte("key6")}) // LSN 12
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	// Restore points are not writes
+	l, entries, err := OpenDir(dir, Options{SegmentSize: 256})
+	require.NoError(t, err)
+	assert.Len(t, entries, 9)
+	require.NoError(t, l.Close())
+	lsns, err := ListSegments(dir)
+	require.NoError(t, err)
+	require.Greater(t, len(lsns), 2)
+
+	replay := func(from uint64, target Target) ([]uint64, uint64, error) {
+		var got []uint64
+		next, err := ReplayArchive(dir, from, target, func(writes []WALEntry, next uint64) error {
+			for _, entry := range writes {
+				got = append(got, entry.LSN)
+			}
+			// A unit is one record or ends with a commit record
+			last := writes[len(writes)-1].LSN
+			assert.Contains(t, []uint64{last + 1, last + 2}, next)
+			return nil
+		})
+		return got, next, err
+	}
+
+	got, next, err := replay(1, Target{})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5, 7, 8, 9, 12}, got)
+	assert.Equal(t, uint64(13), next)
+
+	// A transaction that commits past the target is left out whole
+	got, next, err = replay(1, Target{LSN: 8})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5}, got)
+	assert.Equal(t, uint64(6), next)
+
+	got, next, err = replay(1, Target{Time: beforeTx})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5}, got)
+	assert.Equal(t, uint64(6), next)
+
+	got, next, err = replay(3, Target{RestorePoint: "mark"})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{3, 4, 5, 7, 8, 9}, got)
+	assert.Equal(t, uint64(11), next)
+
+	got, next, err = replay(1, Target{LSN: 12})
+	require.NoError(t, err)
+	assert.Len(t, got, 9)
+	assert.Equal(t, uint64(13), next)
+
+	_, _, err = replay(1, Target{LSN: 13})
+	assert.ErrorIs(t, err, ErrTargetNotReached)
+	_, _, err = replay(1, Target{RestorePoint: "other"})
+	assert.ErrorIs(t, err, ErrTargetNotReached)
+	_, _, err = replay(20, Target{})
+	assert.ErrorIs(t, err, ErrCorrupt)
+
+	// A missing segment is a gap
+	require.NoError(t, os.Remove(filepath.Join(dir, SegmentName(lsns[1]))))
+	_, _, err = replay(1, Target{})
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
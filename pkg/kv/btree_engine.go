@@ -16,6 +16,16 @@ func NewBPTreeEngine(file string) (*BPTreeEngine, error) {
 	return &BPTreeEngine{Tree: tree}, nil
 }
 
+// NewBPTreeEngineWithOptions opens the tree with a caller-chosen cache size
+// and, for new files, page size
+func NewBPTreeEngineWithOptions(file string, opts bptree_disk.Options) (*BPTreeEngine, error) {
+	tree, err := bptree_disk.OpenWithOptions(file, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &BPTreeEngine{Tree: tree}, nil
+}
+
 func (e *BPTreeEngine) Get(key []byte) ([]byte, bool) {
 	kv, err := e.Tree.Find(key)
 	if err != nil {
@@ -36,6 +46,81 @@ func (e *BPTreeEngine) Scan(startKey, endKey []byte, fn func(key, val []byte) bo
 	return e.Tree.Scan(startKey, endKey, fn)
 }
 
+// KeyOrder is the order of the tree's comparator
+func (e *BPTreeEngine) KeyOrder() func(a, b []byte) int {
+	return e.Tree.Comparator().Compare
+}
+
+// BulkLoad builds the tree bottom-up from pairs sorted by key
+func (e *BPTreeEngine) BulkLoad(it bptree_disk.BulkIterator, opts bptree_disk.BulkOptions) error {
+	return e.Tree.BulkLoad(it, opts)
+}
+
+// DeleteRange removes the keys in [start, end), dropping whole subtrees
+func (e *BPTreeEngine) DeleteRange(start, end []byte) (int, error) {
+	return e.Tree.DeleteRange(start, end)
+}
+
+// Stats reports the shape of the tree and the pager counters
+func (e *BPTreeEngine) Stats() (*bptree_disk.Stats, error) {
+	return e.Tree.Stats()
+}
+
+// EstimateRange estimates the keys in [start, end) from a sample of leaves
+func (e *BPTreeEngine) EstimateRange(start, end []byte) (bptree_disk.RangeEstimate, error) {
+	return e.Tree.EstimateRange(start, end)
+}
+
+// NewIterator walks the tree leaf by leaf
+func (e *BPTreeEngine) NewIterator(reverse bool) Iterator {
+	return &bptreeIterator{tree: e.Tree, reverse: reverse}
+}
+
+type bptreeIterator struct {
+	tree    *bptree_disk.BPlusTree
+	iter    *bptree_disk.BIter
+	reverse bool
+}
+
+func (it *bptreeIterator) Seek(key []byte) {
+	switch {
+	case !it.reverse:
+		it.iter = it.tree.SeekGE(key)
+	case key == nil:
+		it.iter = it.tree.SeekLast()
+	default:
+		it.iter = it.tree.SeekLE(key)
+	}
+}
+
+func (it *bptreeIterator) Valid() bool {
+	return it.iter.Valid()
+}
+
+func (it *bptreeIterator) Key() []byte {
+	return it.iter.Deref().Key
+}
+
+func (it *bptreeIterator) Value() []byte {
+	return it.iter.Deref().Val
+}
+
+func (it *bptreeIterator) Next() {
+	if it.reverse {
+		it.iter.Prev()
+		return
+	}
+	it.iter.Next()
+}
+
+func (it *bptreeIterator) Err() error {
+	return it.iter.Err()
+}
+
+func (it *bptreeIterator) Close() error {
+	return nil
+}
+
 func (e *BPTreeEngine) Close() error {
 	return e.Tree.Close()
 }
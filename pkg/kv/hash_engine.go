@@ -0,0 +1,153 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/index/bptree_disk"
+	"github.com/spaghetti-lover/go-db/internal/storage/index/hash_disk"
+)
+
+var ErrUnorderedEngine = errors.New("unordered engine: range scans need an ordered engine")
+
+// HashEngine stores the pairs in an on-disk extendible hash table. Point
+// operations read one bucket page; keys are kept in no particular order.
+type HashEngine struct {
+	Index *hash_disk.HashIndex
+}
+
+func NewHashEngine(file string) (*HashEngine, error) {
+	index, err := hash_disk.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	return &HashEngine{Index: index}, nil
+}
+
+func (e *HashEngine) Get(key []byte) ([]byte, bool) {
+	val, err := e.Index.Get(key)
+	if err != nil {
+		return nil, false
+	}
+	return val, true
+}
+
+func (e *HashEngine) Set(key, val []byte) error {
+	return e.Index.Set(key, val)
+}
+
+func (e *HashEngine) Del(key []byte) (bool, error) {
+	return e.Index.Del(key)
+}
+
+// KeyOrder is nil: the keys are in no order
+func (e *HashEngine) KeyOrder() func(a, b []byte) int {
+	return nil
+}
+
+// Scan with both bounds nil visits every pair in no particular order;
+// a bounded scan returns ErrUnorderedEngine
+func (e *HashEngine) Scan(startKey, endKey []byte, fn func(key, val []byte) bool) error {
+	if startKey != nil || endKey != nil {
+		return ErrUnorderedEngine
+	}
+	return e.Index.ForEach(fn)
+}
+
+// DeleteRange removes the keys in [start, end) with one pass over every
+// bucket
+func (e *HashEngine) DeleteRange(start, end []byte) (int, error) {
+	var keys [][]byte
+	err := e.Index.ForEach(func(key, val []byte) bool {
+		if inRange(key, start, end) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, key := range keys {
+		ok, err := e.Index.Del(key)
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// EstimateRange counts the keys in [start, end) with one pass over every
+// bucket; the result is exact
+func (e *HashEngine) EstimateRange(start, end []byte) (bptree_disk.RangeEstimate, error) {
+	est := bptree_disk.RangeEstimate{Exact: true}
+	err := e.Index.ForEach(func(key, val []byte) bool {
+		if inRange(key, start, end) {
+			est.Keys++
+			est.Bytes += int64(len(key) + len(val))
+		}
+		return true
+	})
+	return est, err
+}
+
+// inRange reports whether key is in [start, end); nil bounds are unbounded
+func inRange(key, start, end []byte) bool {
+	return (start == nil || bytes.Compare(key, start) >= 0) && (end == nil || bytes.Compare(key, end) < 0)
+}
+
+// NewIterator walks every pair in no particular order, the same for both
+// directions. Seek only accepts nil; any other key stops the iterator
+// with ErrUnorderedEngine.
+func (e *HashEngine) NewIterator(reverse bool) Iterator {
+	return &hashIterator{iter: e.Index.NewIterator()}
+}
+
+type hashIterator struct {
+	iter *hash_disk.Iterator
+	err  error
+}
+
+func (it *hashIterator) Seek(key []byte) {
+	if key != nil {
+		it.err = ErrUnorderedEngine
+		return
+	}
+	it.err = nil
+	it.iter.First()
+}
+
+func (it *hashIterator) Valid() bool {
+	return it.err == nil && it.iter.Valid()
+}
+
+func (it *hashIterator) Key() []byte {
+	return it.iter.Key()
+}
+
+func (it *hashIterator) Value() []byte {
+	return it.iter.Value()
+}
+
+func (it *hashIterator) Next() {
+	it.iter.Next()
+}
+
+func (it *hashIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.iter.Err()
+}
+
+func (it *hashIterator) Close() error {
+	return nil
+}
+
+func (e *HashEngine) Close() error {
+	return e.Index.Close()
+}
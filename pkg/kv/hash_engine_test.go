@@ -0,0 +1,52 @@
+package kv
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashEngine(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.hash")
+	kv := &KV{}
+	require.NoError(t, kv.Open("hash", file))
+
+	for i := 0; i < 500; i++ {
+		require.NoError(t, kv.Set([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("val%d", i))))
+	}
+	ok, err := kv.Del([]byte("key010"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// Only an unbounded scan is supported
+	err = kv.Scan([]byte("key008"), []byte("key012"), func(key, val []byte) bool { return true })
+	assert.ErrorIs(t, err, ErrUnorderedEngine)
+	n := 0
+	require.NoError(t, kv.Scan(nil, nil, func(key, val []byte) bool {
+		n++
+		return true
+	}))
+	assert.Equal(t, 499, n)
+
+	deleted, err := kv.DeleteRange([]byte("key100"), []byte("key200"))
+	require.NoError(t, err)
+	assert.Equal(t, 100, deleted)
+
+	require.NoError(t, kv.Close())
+	require.NoError(t, kv.Open("hash", file))
+	defer kv.Close()
+
+	val, ok := kv.Get([]byte("key042"))
+	require.True(t, ok)
+	assert.Equal(t, []byte("val42"), val)
+	_, ok = kv.Get([]byte("key150"))
+	assert.False(t, ok)
+
+	est, err := kv.EstimateRange(nil, nil)
+	require.NoError(t, err)
+	assert.True(t, est.Exact)
+	assert.Equal(t, int64(399), est.Keys)
+}
@@ -0,0 +1,89 @@
+package kv
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/lsm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// collect seeks to key and returns up to n keys from there
+func collect(t *testing.T, it Iterator, key []byte, n int) []string {
+	var keys []string
+	for it.Seek(key); it.Valid() && len(keys) < n; it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	require.NoError(t, it.Err())
+	return keys
+}
+
+func TestIterator_OrderedEngines(t *testing.T) {
+	dir := t.TempDir()
+	bptree, err := NewBPTreeEngine(filepath.Join(dir, "iter.db"))
+	require.NoError(t, err)
+	lsmEngine, err := NewLSMEngine(filepath.Join(dir, "lsm"), lsm.Options{MemtableSize: 4 << 10})
+	require.NoError(t, err)
+
+	engines := map[string]KVEngine{
+		"ram":    NewRAMEngine(4),
+		"bptree": bptree,
+		"lsm":    lsmEngine,
+	}
+	for name, engine := range engines {
+		t.Run(name, func(t *testing.T) {
+			defer engine.Close()
+			for i := 0; i < 300; i += 2 {
+				require.NoError(t, engine.Set([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("val%d", i))))
+			}
+
+			it := engine.NewIterator(false)
+			assert.Equal(t, []string{"key000", "key002"}, collect(t, it, nil, 2))
+			assert.Equal(t, []string{"key102", "key104", "key106"}, collect(t, it, []byte("key101"), 3))
+			assert.Empty(t, collect(t, it, []byte("key999"), 3))
+			assert.Len(t, collect(t, it, nil, 1000), 150)
+			require.NoError(t, it.Close())
+
+			rev := engine.NewIterator(true)
+			assert.Equal(t, []string{"key298", "key296"}, collect(t, rev, nil, 2))
+			assert.Equal(t, []string{"key100", "key098"}, collect(t, rev, []byte("key101"), 2))
+			assert.Empty(t, collect(t, rev, []byte("a"), 3))
+			require.NoError(t, rev.Close())
+
+			// Values match the keys
+			it = engine.NewIterator(false)
+			defer it.Close()
+			it.Seek([]byte("key042"))
+			require.True(t, it.Valid())
+			assert.Equal(t, []byte("val42"), it.Value())
+		})
+	}
+}
+
+func TestIterator_HashEngine(t *testing.T) {
+	engine, err := NewHashEngine(filepath.Join(t.TempDir(), "iter.hash"))
+	require.NoError(t, err)
+	defer engine.Close()
+
+	want := map[string]string{}
+	for i := 0; i < 500; i++ {
+		key, val := fmt.Sprintf("key%03d", i), fmt.Sprintf("val%d", i)
+		require.NoError(t, engine.Set([]byte(key), []byte(val)))
+		want[key] = val
+	}
+
+	it := engine.NewIterator(false)
+	defer it.Close()
+	got := map[string]string{}
+	for it.Seek(nil); it.Valid(); it.Next() {
+		got[string(it.Key())] = string(it.Value())
+	}
+	require.NoError(t, it.Err())
+	assert.Equal(t, want, got)
+
+	it.Seek([]byte("key042"))
+	assert.False(t, it.Valid())
+	assert.ErrorIs(t, it.Err(), ErrUnorderedEngine)
+}
@@ -1,8 +1,13 @@
 package kv
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"sync"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/index/bptree_disk"
+	"github.com/spaghetti-lover/go-db/internal/storage/lsm"
 )
 
 type KVEngine interface {
@@ -11,8 +16,80 @@ type KVEngine interface {
 	Del(key []byte) (bool, error)
 	Close() error
 	Scan(startKey, endKey []byte, fn func(key, val []byte) bool) error
+	NewIterator(reverse bool) Iterator
+}
+
+// BulkLoader is implemented by engines that can load a sorted stream of
+// pairs faster than one Set per key
+type BulkLoader interface {
+	BulkLoad(it bptree_disk.BulkIterator, opts bptree_disk.BulkOptions) error
+}
+
+// RangeDeleter is implemented by engines that can remove a key range
+// without deleting the keys one by one
+type RangeDeleter interface {
+	DeleteRange(start, end []byte) (int, error)
+}
+
+// StatsReporter is implemented by engines that can describe how they use
+// their storage
+type StatsReporter interface {
+	Stats() (*bptree_disk.Stats, error)
+}
+
+// RangeEstimator is implemented by engines that can size a key range
+// without reading all of it
+type RangeEstimator interface {
+	EstimateRange(start, end []byte) (bptree_disk.RangeEstimate, error)
+}
+
+// BatchWriter is implemented by engines that can apply several writes as
+// one atomic unit: after a crash either all of them are there or none.
+// durability says when the batch must be on disk.
+type BatchWriter interface {
+	WriteBatch(ops []BatchOp, durability Durability) error
+}
+
+// Comparer is implemented by engines that do not keep their keys in
+// bytes.Compare order. KeyOrder returns their order, or nil if they keep
+// keys in no order at all.
+type Comparer interface {
+	KeyOrder() func(a, b []byte) int
+}
+
+// Durability says when a logged write reaches the disk
+type Durability int
+
+const (
+	DurabilityDefault Durability = iota // the engine's setting
+	SyncCommit                          // before the write returns
+	SyncPeriodic                        // within the engine's sync interval
+	SyncOS                              // when the OS writes it back, or at a checkpoint
+)
+
+// BatchOp is one write of a batch; a delete has no Value
+type BatchOp struct {
+	Key    []byte
+	Value  []byte
+	Delete bool
 }
 
+// Iterator walks the keys of an engine in ascending order, or descending
+// for a reverse iterator. It is not positioned until Seek is called.
+type Iterator interface {
+	// Seek moves to the first key >= key, or the last key <= key when
+	// reversed. A nil key is the first key, or the last one.
+	Seek(key []byte)
+	Valid() bool
+	Key() []byte
+	Value() []byte
+	Next()
+	Err() error
+	Close() error
+}
+
+var ErrNoStats = errors.New("engine does not report storage stats")
+
 type KV struct {
 	Filename string
 	Engine   KVEngine
@@ -27,6 +104,14 @@ func NewKV(engine KVEngine) *KV {
 	return &KV{Engine: engine}
 }
 
+// KeyOrder returns the key order of the engine, nil for an unordered one
+func (kv *KV) KeyOrder() func(a, b []byte) int {
+	if c, ok := kv.Engine.(Comparer); ok {
+		return c.KeyOrder()
+	}
+	return bytes.Compare
+}
+
 func (kv *KV) Get(key []byte) ([]byte, bool) {
 	return kv.Engine.Get(key)
 }
@@ -43,6 +128,89 @@ func (kv *KV) Scan(startKey, endKey []byte, fn func(key, val []byte) bool) error
 	return kv.Engine.Scan(startKey, endKey, fn)
 }
 
+// BulkLoad stores pairs given in ascending key order. Engines without a
+// bulk path get one Set per pair.
+func (kv *KV) BulkLoad(it bptree_disk.BulkIterator, opts bptree_disk.BulkOptions) error {
+	if loader, ok := kv.Engine.(BulkLoader); ok {
+		return loader.BulkLoad(it, opts)
+	}
+	for ; it.Valid(); it.Next() {
+		if err := kv.Engine.Set(it.Key(), it.Value()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteRange removes every key in [start, end) and returns how many were
+// removed; nil bounds are unbounded. Engines without a range path get one
+// Del per key.
+func (kv *KV) DeleteRange(start, end []byte) (int, error) {
+	if deleter, ok := kv.Engine.(RangeDeleter); ok {
+		return deleter.DeleteRange(start, end)
+	}
+
+	var keys [][]byte
+	err := kv.Engine.Scan(start, end, func(key, val []byte) bool {
+		if end != nil && bytes.Equal(key, end) {
+			return false
+		}
+		keys = append(keys, append([]byte(nil), key...))
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, key := range keys {
+		ok, err := kv.Engine.Del(key)
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Stats reports the storage statistics of the engine, or ErrNoStats
+func (kv *KV) Stats() (*bptree_disk.Stats, error) {
+	if reporter, ok := kv.Engine.(StatsReporter); ok {
+		return reporter.Stats()
+	}
+	return nil, ErrNoStats
+}
+
+// EstimateRange estimates the number of keys in [start, end) and their size;
+// nil bounds are unbounded. Engines that cannot estimate are scanned, and
+// the result is exact.
+func (kv *KV) EstimateRange(start, end []byte) (bptree_disk.RangeEstimate, error) {
+	if estimator, ok := kv.Engine.(RangeEstimator); ok {
+		return estimator.EstimateRange(start, end)
+	}
+
+	est := bptree_disk.RangeEstimate{Exact: true}
+	err := kv.Engine.Scan(start, end, func(key, val []byte) bool {
+		if end != nil && bytes.Equal(key, end) {
+			return false
+		}
+		est.Keys++
+		est.Bytes += int64(len(key) + len(val))
+		return true
+	})
+	return est, err
+}
+
+// NewIterator returns an iterator over the engine
+func (kv *KV) NewIterator(reverse bool) Iterator {
+	return kv.Engine.NewIterator(reverse)
+}
+
+// Open opens an engine by type. For "lsm" fileName is a directory;
+// "wal-bptree" keeps its log segments next to the tree in the directory
+// fileName + ".wal".
 func (kv *KV) Open(engineType, fileName string) error {
 	var engine KVEngine
 	var err error
@@ -50,6 +218,14 @@ func (kv *KV) Open(engineType, fileName string) error {
 	switch engineType {
 	case "bptree":
 		engine, err = NewBPTreeEngine(fileName)
+	case "wal-bptree":
+		engine, err = NewWALBPTreeEngine(fileName, fileName+".wal")
+	case "ram":
+		engine = NewRAMEngine(0)
+	case "lsm":
+		engine, err = NewLSMEngine(fileName, lsm.Options{})
+	case "hash":
+		engine, err = NewHashEngine(fileName)
 	default:
 		return fmt.Errorf("unknown engine type: %s", engineType)
 	}
@@ -0,0 +1,70 @@
+package kv
+
+import (
+	"github.com/spaghetti-lover/go-db/internal/storage/lsm"
+)
+
+// LSMEngine stores the pairs in a log-structured merge tree kept in a
+// directory
+type LSMEngine struct {
+	Tree *lsm.Tree
+}
+
+func NewLSMEngine(dir string, opts lsm.Options) (*LSMEngine, error) {
+	tree, err := lsm.Open(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &LSMEngine{Tree: tree}, nil
+}
+
+func (e *LSMEngine) Get(key []byte) ([]byte, bool) {
+	val, ok, err := e.Tree.Get(key)
+	if err != nil {
+		return nil, false
+	}
+	return val, ok
+}
+
+func (e *LSMEngine) Set(key, val []byte) error {
+	return e.Tree.Set(key, val)
+}
+
+func (e *LSMEngine) Del(key []byte) (bool, error) {
+	return e.Tree.Del(key)
+}
+
+// WriteBatch applies ops as one transaction of the tree's WAL
+func (e *LSMEngine) WriteBatch(ops []BatchOp, durability Durability) error {
+	batch := make([]lsm.BatchOp, len(ops))
+	for i, op := range ops {
+		batch[i] = lsm.BatchOp{Key: op.Key, Value: op.Value, Delete: op.Delete}
+	}
+	return e.Tree.WriteBatch(batch, lsmDurability(durability))
+}
+
+// lsmDurability maps a durability level to the tree's
+func lsmDurability(durability Durability) lsm.Durability {
+	switch durability {
+	case SyncCommit:
+		return lsm.SyncCommit
+	case SyncPeriodic:
+		return lsm.SyncPeriodic
+	case SyncOS:
+		return lsm.SyncOS
+	}
+	return lsm.DurabilityDefault
+}
+
+func (e *LSMEngine) Scan(startKey, endKey []byte, fn func(key, val []byte) bool) error {
+	return e.Tree.Scan(startKey, endKey, fn)
+}
+
+// NewIterator merges the memtables and the tables of every level
+func (e *LSMEngine) NewIterator(reverse bool) Iterator {
+	return e.Tree.NewIterator(reverse)
+}
+
+func (e *LSMEngine) Close() error {
+	return e.Tree.Close()
+}
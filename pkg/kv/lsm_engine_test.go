@@ -0,0 +1,80 @@
+package kv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLSMEngine(t *testing.T) {
+	dir := t.TempDir()
+	kv := &KV{}
+	require.NoError(t, kv.Open("lsm", dir))
+
+	for i := 0; i < 200; i++ {
+		require.NoError(t, kv.Set([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("val%d", i))))
+	}
+	ok, err := kv.Del([]byte("key010"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	var keys []string
+	require.NoError(t, kv.Scan([]byte("key008"), []byte("key012"), func(key, val []byte) bool {
+		keys = append(keys, string(key))
+		return true
+	}))
+	assert.Equal(t, []string{"key008", "key009", "key011", "key012"}, keys)
+
+	// Reopening replays the WAL
+	require.NoError(t, kv.Close())
+	require.NoError(t, kv.Open("lsm", dir))
+	defer kv.Close()
+
+	val, ok := kv.Get([]byte("key042"))
+	require.True(t, ok)
+	assert.Equal(t, []byte("val42"), val)
+	_, ok = kv.Get([]byte("key010"))
+	assert.False(t, ok)
+
+	it := kv.NewIterator(true)
+	defer it.Close()
+	it.Seek([]byte("key011x"))
+	require.True(t, it.Valid())
+	assert.Equal(t, []byte("key011"), it.Key())
+	it.Next()
+	assert.Equal(t, []byte("key009"), it.Key())
+}
+
+func TestLSMEngine_CommitIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	kv := &KV{}
+	require.NoError(t, kv.Open("lsm", dir))
+	require.NoError(t, kv.Set([]byte("key1"), []byte("old")))
+
+	tx := &KVTX{}
+	kv.Begin(tx)
+	require.NoError(t, tx.Set([]byte("key2"), []byte("value2")))
+	require.NoError(t, tx.Del([]byte("key1")))
+	require.NoError(t, kv.Commit(tx))
+	require.NoError(t, kv.Close())
+
+	// A crash before the commit record is durable loses the whole commit
+	wals, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	require.NoError(t, err)
+	require.Len(t, wals, 1)
+	info, err := os.Stat(wals[0])
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(wals[0], info.Size()-1))
+
+	require.NoError(t, kv.Open("lsm", dir))
+	defer kv.Close()
+	val, ok := kv.Get([]byte("key1"))
+	require.True(t, ok)
+	assert.Equal(t, []byte("old"), val)
+	_, ok = kv.Get([]byte("key2"))
+	assert.False(t, ok)
+}
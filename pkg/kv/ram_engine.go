@@ -0,0 +1,158 @@
+package kv
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/index/bptree_ram"
+)
+
+// RAM_ITER_BATCH is the number of pairs an iterator copies out each time
+// it takes the engine lock
+const RAM_ITER_BATCH = 64
+
+// RAMEngine keeps the pairs in an in-memory B+tree. Nothing is persisted.
+type RAMEngine struct {
+	mu   sync.RWMutex
+	Tree *bptree_ram.BPlusTree[[]byte, []byte]
+}
+
+// NewRAMEngine creates an empty engine; an order of 0 selects the default
+func NewRAMEngine(order int) *RAMEngine {
+	return &RAMEngine{Tree: bptree_ram.NewBPlusTree[[]byte, []byte](bytes.Compare, order)}
+}
+
+func (e *RAMEngine) Get(key []byte) ([]byte, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	val, ok := e.Tree.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return append([]byte{}, val...), true
+}
+
+func (e *RAMEngine) Set(key, val []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.Tree.Insert(append([]byte{}, key...), append([]byte{}, val...))
+	return nil
+}
+
+func (e *RAMEngine) Del(key []byte) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.Tree.Delete(key), nil
+}
+
+// Scan visits the pairs from startKey to endKey, both included. fn must
+// not modify the engine.
+func (e *RAMEngine) Scan(startKey, endKey []byte, fn func(key, val []byte) bool) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for it := e.Tree.SeekGE(startKey); it.Valid(); it.Next() {
+		if endKey != nil && bytes.Compare(it.Key(), endKey) > 0 {
+			break
+		}
+		if !fn(it.Key(), it.Value()) {
+			break
+		}
+	}
+	return nil
+}
+
+// NewIterator copies a batch of pairs at a time under the read lock, so
+// writers are not held up between calls. Stored keys and values are never
+// changed in place, so the batch shares them with the tree.
+func (e *RAMEngine) NewIterator(reverse bool) Iterator {
+	return &ramIterator{e: e, reverse: reverse}
+}
+
+type ramIterator struct {
+	e       *RAMEngine
+	reverse bool
+	keys    [][]byte
+	vals    [][]byte
+	i       int
+	more    bool // the tree may hold pairs after the batch
+}
+
+func (it *ramIterator) Seek(key []byte) {
+	it.fill(key, true)
+}
+
+// fill copies the pairs from key on; the pair at key is skipped when
+// inclusive is false
+func (it *ramIterator) fill(key []byte, inclusive bool) {
+	it.e.mu.RLock()
+	defer it.e.mu.RUnlock()
+
+	tree := it.e.Tree
+	var cur *bptree_ram.Iter[[]byte, []byte]
+	switch {
+	case key == nil && !it.reverse:
+		cur = tree.First()
+	case key == nil:
+		cur = tree.Last()
+	case !it.reverse:
+		cur = tree.SeekGE(key)
+	default:
+		cur = tree.SeekLE(key)
+	}
+	if !inclusive && cur.Valid() && bytes.Equal(cur.Key(), key) {
+		it.step(cur)
+	}
+
+	it.keys, it.vals, it.i = nil, nil, 0
+	for ; cur.Valid() && len(it.keys) < RAM_ITER_BATCH; it.step(cur) {
+		it.keys = append(it.keys, cur.Key())
+		it.vals = append(it.vals, cur.Value())
+	}
+	it.more = cur.Valid()
+}
+
+func (it *ramIterator) step(cur *bptree_ram.Iter[[]byte, []byte]) {
+	if it.reverse {
+		cur.Prev()
+	} else {
+		cur.Next()
+	}
+}
+
+func (it *ramIterator) Valid() bool {
+	return it.i < len(it.keys)
+}
+
+func (it *ramIterator) Key() []byte {
+	return it.keys[it.i]
+}
+
+func (it *ramIterator) Value() []byte {
+	return it.vals[it.i]
+}
+
+func (it *ramIterator) Next() {
+	it.i++
+	if it.i == len(it.keys) && it.more {
+		it.fill(it.keys[it.i-1], false)
+	}
+}
+
+func (it *ramIterator) Err() error {
+	return nil
+}
+
+func (it *ramIterator) Close() error {
+	return nil
+}
+
+// Len returns the number of keys stored
+func (e *RAMEngine) Len() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.Tree.Len()
+}
+
+func (e *RAMEngine) Close() error {
+	return nil
+}
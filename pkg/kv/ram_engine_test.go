@@ -0,0 +1,54 @@
+package kv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRAMEngine(t *testing.T) {
+	kv := &KV{}
+	require.NoError(t, kv.Open("ram", ""))
+	defer kv.Close()
+
+	for i := 0; i < 200; i++ {
+		require.NoError(t, kv.Set([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("val%d", i))))
+	}
+
+	val, ok := kv.Get([]byte("key042"))
+	require.True(t, ok)
+	assert.Equal(t, []byte("val42"), val)
+
+	// Stored keys and values are copies
+	key := []byte("key042")
+	require.NoError(t, kv.Set(key, val))
+	key[0], val[0] = 'x', 'x'
+	val, ok = kv.Get([]byte("key042"))
+	require.True(t, ok)
+	assert.Equal(t, []byte("val42"), val)
+
+	ok, err := kv.Del([]byte("key010"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = kv.Del([]byte("key010"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 199, kv.Engine.(*RAMEngine).Len())
+
+	// Both bounds are included, as with the disk engine
+	var keys []string
+	require.NoError(t, kv.Scan([]byte("key008"), []byte("key012"), func(key, val []byte) bool {
+		keys = append(keys, string(key))
+		return true
+	}))
+	assert.Equal(t, []string{"key008", "key009", "key011", "key012"}, keys)
+
+	n, err := kv.DeleteRange([]byte("key100"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 100, n)
+	est, err := kv.EstimateRange(nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(99), est.Keys)
+}
@@ -3,6 +3,7 @@ package kv
 import (
 	"bytes"
 	"errors"
+	"sort"
 )
 
 // Flags for pending operations
@@ -41,6 +42,8 @@ type KVTX struct {
 	pending map[string]pendingOp // pending writes in this TX
 	reads   []StoreKey           // keys read (for conflict detection)
 	aborted bool                 // whether TX was aborted
+
+	durability Durability // when the commit must be on disk
 }
 
 // Begin starts a new transaction
@@ -53,9 +56,16 @@ func (kv *KV) Begin(tx *KVTX) {
 	tx.pending = make(map[string]pendingOp)
 	tx.reads = nil
 	tx.aborted = false
+	tx.durability = DurabilityDefault
 	tx.meta = loadMetaFromDisk(kv)
 }
 
+// SetDurability overrides the engine's durability for this transaction's
+// commit. Engines without a log ignore it.
+func (tx *KVTX) SetDurability(d Durability) {
+	tx.durability = d
+}
+
 // Commit ends a transaction: commit updates; rollback on error
 func (kv *KV) Commit(tx *KVTX) error {
 	if tx.aborted {
@@ -72,24 +82,15 @@ func (kv *KV) Commit(tx *KVTX) error {
 		return ErrTxConflict
 	}
 
-	// Apply pending writes to storage
-	var writes []StoreKey
-	for keyStr, op := range tx.pending {
-		key := []byte(keyStr)
-		writes = append(writes, StoreKey{key: key})
-
-		switch op.flag {
-		case FLAG_UPDATED:
-			if err := kv.Engine.Set(key, op.value); err != nil {
-				writeMetaToDisk(kv, tx.meta)
-				return err
-			}
-		case FLAG_DELETED:
-			if _, err := kv.Engine.Del(key); err != nil {
-				writeMetaToDisk(kv, tx.meta)
-				return err
-			}
-		}
+	// Apply pending writes to storage, in one batch if the engine can
+	ops := tx.writeSet()
+	if err := kv.apply(ops, tx.durability); err != nil {
+		writeMetaToDisk(kv, tx.meta)
+		return err
+	}
+	writes := make([]StoreKey, len(ops))
+	for i, op := range ops {
+		writes[i] = StoreKey{key: op.Key}
 	}
 
 	// Update version and history
@@ -107,6 +108,43 @@ func (kv *KV) Commit(tx *KVTX) error {
 	return nil
 }
 
+// writeSet returns the pending writes in the engine's key order, or in
+// bytes order if the engine has none
+func (tx *KVTX) writeSet() []BatchOp {
+	ops := make([]BatchOp, 0, len(tx.pending))
+	for keyStr, op := range tx.pending {
+		ops = append(ops, BatchOp{Key: []byte(keyStr), Value: op.value, Delete: op.flag == FLAG_DELETED})
+	}
+	compare := tx.kv.KeyOrder()
+	if compare == nil {
+		compare = bytes.Compare
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return compare(ops[i].Key, ops[j].Key) < 0
+	})
+	return ops
+}
+
+// apply writes ops to the engine. Engines that cannot write a batch
+// atomically, the in-memory and hash engines and the plain B+tree, get one
+// call per key: a commit on them is not atomic across a crash or a
+// failed write.
+func (kv *KV) apply(ops []BatchOp, durability Durability) error {
+	if writer, ok := kv.Engine.(BatchWriter); ok {
+		return writer.WriteBatch(ops, durability)
+	}
+	for _, op := range ops {
+		if op.Delete {
+			if _, err := kv.Engine.Del(op.Key); err != nil {
+				return err
+			}
+		} else if err := kv.Engine.Set(op.Key, op.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Abort ends a transaction: rollback all changes
 func (kv *KV) Abort(tx *KVTX) {
 	kv.mu.Lock()
@@ -198,6 +236,122 @@ func (tx *KVTX) Scan(startKey, endKey []byte, fn func(key, val []byte) bool) err
 	})
 }
 
+// NewIterator walks the store with the transaction's pending writes laid
+// over it, in the engine's key order: updated keys show their new value,
+// deleted keys are skipped. The pending writes are taken when Seek is
+// called. Keys read from the store are tracked for conflict detection. On
+// an unordered engine the writes cannot be merged in, so Seek fails with
+// ErrUnorderedEngine.
+func (tx *KVTX) NewIterator(reverse bool) Iterator {
+	return &txIterator{tx: tx, base: tx.kv.NewIterator(reverse), reverse: reverse, compare: tx.kv.KeyOrder()}
+}
+
+type txIterator struct {
+	tx      *KVTX
+	base    Iterator
+	reverse bool
+	compare func(a, b []byte) int
+
+	pending []string // pending keys in iteration order
+	pi      int
+
+	key, val []byte
+	ok       bool
+	err      error
+}
+
+func (it *txIterator) Seek(key []byte) {
+	it.ok, it.err = false, nil
+	if it.tx.aborted {
+		it.err = ErrTxAborted
+		return
+	}
+	if it.compare == nil {
+		it.err = ErrUnorderedEngine
+		return
+	}
+	it.base.Seek(key)
+
+	it.pending = it.pending[:0]
+	for k := range it.tx.pending {
+		it.pending = append(it.pending, k)
+	}
+	sort.Slice(it.pending, func(i, j int) bool {
+		return it.before([]byte(it.pending[i]), []byte(it.pending[j]))
+	})
+	it.pi = sort.Search(len(it.pending), func(i int) bool {
+		return key == nil || !it.before([]byte(it.pending[i]), key)
+	})
+	it.find()
+}
+
+// before reports whether a comes before b in the direction of iteration
+func (it *txIterator) before(a, b []byte) bool {
+	if it.reverse {
+		return it.compare(a, b) > 0
+	}
+	return it.compare(a, b) < 0
+}
+
+// find moves to the next visible key, consuming what it passes. A pending
+// write hides the stored value of the same key.
+func (it *txIterator) find() {
+	for {
+		baseOK := it.base.Valid()
+		pendOK := it.pi < len(it.pending)
+		if !baseOK && !pendOK {
+			it.ok, it.err = false, it.base.Err()
+			return
+		}
+
+		if baseOK && (!pendOK || !it.before([]byte(it.pending[it.pi]), it.base.Key())) {
+			key := append([]byte{}, it.base.Key()...)
+			val := append([]byte{}, it.base.Value()...)
+			it.base.Next()
+			it.tx.reads = append(it.tx.reads, StoreKey{key: key})
+			if pendOK && it.pending[it.pi] == string(key) {
+				// the pending write below takes its place
+				continue
+			}
+			it.key, it.val, it.ok = key, val, true
+			return
+		}
+
+		k := it.pending[it.pi]
+		it.pi++
+		op := it.tx.pending[k]
+		if op.flag == FLAG_DELETED {
+			continue
+		}
+		it.key, it.val, it.ok = []byte(k), op.value, true
+		return
+	}
+}
+
+func (it *txIterator) Valid() bool {
+	return it.ok
+}
+
+func (it *txIterator) Key() []byte {
+	return it.key
+}
+
+func (it *txIterator) Value() []byte {
+	return it.val
+}
+
+func (it *txIterator) Next() {
+	it.find()
+}
+
+func (it *txIterator) Err() error {
+	return it.err
+}
+
+func (it *txIterator) Close() error {
+	return it.base.Close()
+}
+
 // detectConflicts checks if any read keys were modified by other transactions
 func detectConflicts(kv *KV, tx *KVTX) bool {
 	for i := len(kv.history) - 1; i >= 0; i-- {
@@ -1,10 +1,13 @@
 package kv
 
 import (
+	"bytes"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 
+	"github.com/spaghetti-lover/go-db/internal/storage/index/bptree_ram"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -258,3 +261,149 @@ func TestTransaction_AbortedTxOperations(t *testing.T) {
 	err = kv.Commit(tx)
 	assert.ErrorIs(t, err, ErrTxAborted)
 }
+
+func TestTransaction_Iterator(t *testing.T) {
+	kv := setupTestKV(t)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, kv.Set([]byte(k), []byte("old-"+k)))
+	}
+
+	tx := &KVTX{}
+	kv.Begin(tx)
+	require.NoError(t, tx.Set([]byte("b"), []byte("new-b")))
+	require.NoError(t, tx.Del([]byte("c")))
+	require.NoError(t, tx.Set([]byte("bb"), []byte("new-bb")))
+	require.NoError(t, tx.Set([]byte("e"), []byte("new-e")))
+
+	it := tx.NewIterator(false)
+	var pairs []string
+	for it.Seek([]byte("b")); it.Valid(); it.Next() {
+		pairs = append(pairs, string(it.Key())+"="+string(it.Value()))
+	}
+	require.NoError(t, it.Err())
+	require.NoError(t, it.Close())
+	assert.Equal(t, []string{"b=new-b", "bb=new-bb", "d=old-d", "e=new-e"}, pairs)
+
+	rev := tx.NewIterator(true)
+	defer rev.Close()
+	var keys []string
+	for rev.Seek(nil); rev.Valid(); rev.Next() {
+		keys = append(keys, string(rev.Key()))
+	}
+	assert.Equal(t, []string{"e", "d", "bb", "b", "a"}, keys)
+
+	// Keys read through the iterator conflict with later commits
+	tx2 := &KVTX{}
+	kv.Begin(tx2)
+	require.NoError(t, tx2.Set([]byte("d"), []byte("modified_by_tx2")))
+	require.NoError(t, kv.Commit(tx2))
+	assert.ErrorIs(t, kv.Commit(tx), ErrTxConflict)
+}
+
+// reverseEngine keeps its keys in descending bytes order
+type reverseEngine struct {
+	*RAMEngine
+}
+
+func reverseCompare(a, b []byte) int {
+	return bytes.Compare(b, a)
+}
+
+func (e reverseEngine) KeyOrder() func(a, b []byte) int {
+	return reverseCompare
+}
+
+func TestTransaction_IteratorKeyOrder(t *testing.T) {
+	engine := reverseEngine{&RAMEngine{Tree: bptree_ram.NewBPlusTree[[]byte, []byte](reverseCompare, 0)}}
+	kv := NewKV(engine)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, kv.Set([]byte(k), []byte("old-"+k)))
+	}
+
+	tx := &KVTX{}
+	kv.Begin(tx)
+	require.NoError(t, tx.Set([]byte("bb"), []byte("new-bb")))
+	require.NoError(t, tx.Del([]byte("b")))
+	require.NoError(t, tx.Set([]byte("e"), []byte("new-e")))
+
+	// The pending writes follow the engine's order, not bytes order
+	it := tx.NewIterator(false)
+	var keys []string
+	for it.Seek([]byte("c")); it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	require.NoError(t, it.Err())
+	require.NoError(t, it.Close())
+	assert.Equal(t, []string{"c", "bb", "a"}, keys)
+
+	ops := tx.writeSet()
+	assert.Equal(t, []string{"e", "bb", "b"}, []string{string(ops[0].Key), string(ops[1].Key), string(ops[2].Key)})
+}
+
+func TestTransaction_IteratorUnorderedEngine(t *testing.T) {
+	engine, err := NewHashEngine(filepath.Join(t.TempDir(), "tx.hash"))
+	require.NoError(t, err)
+	kv := NewKV(engine)
+	defer kv.Close()
+	require.NoError(t, kv.Set([]byte("a"), []byte("1")))
+
+	tx := &KVTX{}
+	kv.Begin(tx)
+	require.NoError(t, tx.Set([]byte("b"), []byte("2")))
+	it := tx.NewIterator(false)
+	defer it.Close()
+	it.Seek(nil)
+	assert.False(t, it.Valid())
+	assert.ErrorIs(t, it.Err(), ErrUnorderedEngine)
+
+	// Commit still works
+	require.NoError(t, kv.Commit(tx))
+	val, ok := kv.Get([]byte("b"))
+	require.True(t, ok)
+	assert.Equal(t, []byte("2"), val)
+}
+
+func TestTransaction_CommitLogsOneBatch(t *testing.T) {
+	kv := &KV{}
+	require.NoError(t, kv.Open("wal-bptree", filepath.Join(t.TempDir(), "tx.db")))
+	defer kv.Close()
+	log := kv.Engine.(*WALBPTreeEngine).WAL
+
+	require.NoError(t, kv.Set([]byte("key1"), []byte("old")))
+	next := log.NextLSN()
+
+	tx := &KVTX{}
+	kv.Begin(tx)
+	require.NoError(t, tx.Set([]byte("key2"), []byte("value2")))
+	require.NoError(t, tx.Set([]byte("key3"), []byte("value3")))
+	require.NoError(t, tx.Del([]byte("key1")))
+	require.NoError(t, kv.Commit(tx))
+
+	// Begin, three writes and commit
+	assert.Equal(t, next+5, log.NextLSN())
+	_, ok := kv.Get([]byte("key1"))
+	assert.False(t, ok)
+	val, ok := kv.Get([]byte("key3"))
+	require.True(t, ok)
+	assert.Equal(t, []byte("value3"), val)
+}
+
+func TestTransaction_Durability(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tx.db")
+	engine, err := NewWALBPTreeEngineWithOptions(file, file+".wal", WALOptions{Durability: SyncOS})
+	require.NoError(t, err)
+	kv := NewKV(engine)
+	defer kv.Close()
+
+	tx := &KVTX{}
+	kv.Begin(tx)
+	require.NoError(t, tx.Set([]byte("key1"), []byte("value1")))
+	require.NoError(t, kv.Commit(tx))
+	assert.Zero(t, engine.WAL.Stats().Syncs)
+
+	kv.Begin(tx)
+	tx.SetDurability(SyncCommit)
+	require.NoError(t, tx.Set([]byte("key2"), []byte("value2")))
+	require.NoError(t, kv.Commit(tx))
+	assert.Equal(t, uint64(1), engine.WAL.Stats().Syncs)
+}
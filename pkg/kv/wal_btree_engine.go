@@ -1,72 +1,527 @@
 package kv
 
 import (
-	"os"
+	"fmt"
+	"sync"
+	"time"
 
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+	"github.com/spaghetti-lover/go-db/internal/storage/index/bptree_disk"
 	"github.com/spaghetti-lover/go-db/internal/wal"
 )
 
+// Defaults of WALOptions
+const (
+	DEFAULT_WAL_CACHE_PAGES  = 1024
+	DEFAULT_CHECKPOINT_BYTES = 4 << 20
+	DEFAULT_SYNC_INTERVAL    = 10 * time.Millisecond
+)
+
+// WALOptions configures a WALBPTreeEngine
+type WALOptions struct {
+	// CachePages is the size of the tree's page cache, which holds every
+	// page changed since the last checkpoint. Zero means
+	// DEFAULT_WAL_CACHE_PAGES.
+	CachePages int
+
+	// CheckpointBytes starts a checkpoint once this much has been logged
+	// since the last one.
+	// Zero means DEFAULT_CHECKPOINT_BYTES.
+	CheckpointBytes int64
+
+	// CheckpointInterval also starts one this often; zero means only by
+	// log size
+	CheckpointInterval time.Duration
+
+	// Durability applies to writes that do not ask for their own. Zero
+	// means SyncCommit.
+	Durability Durability
+
+	// SyncInterval is how often the log is synced for SyncPeriodic
+	// writes. Zero means DEFAULT_SYNC_INTERVAL.
+	SyncInterval time.Duration
+
+	// SegmentSize and Archive are passed to the log, see wal.Options
+	SegmentSize int64
+	Archive     func(segment string) error
+}
+
+// WALBPTreeEngine logs every write before applying it to the tree.
+// Reads are served by the tree, whose pager is the only page cache.
+//
+// Changed pages stay in the cache until a checkpoint. A checkpoint notes
+// the next LSN, copies the changed pages and the meta page, which records
+// that LSN, while writers wait, then writes and syncs the copies while
+// writers carry on, and finally drops the log segments before that LSN.
+// Pages are stamped with the LSN of their last change, so recovery
+// replays the log from the checkpoint LSN and skips the records whose
+// page already has them.
+//
+// WriteBatch logs its writes as one transaction, so recovery redoes all of
+// them or none.
+//
+// Writers append to the log under the engine lock but wait for the fsync
+// outside it, so writers that commit together share one fsync. A write is
+// visible to readers once applied, which can be just before it is on disk.
+// Data pages are only synced by checkpoints, which sync the log first.
 type WALBPTreeEngine struct {
-	Tree       *BPTreeEngine
-	WALFile    *os.File
-	BufferPool *BufferPool
+	Tree *BPTreeEngine
+	WAL  *wal.Log
+	opts WALOptions
+
+	dataFile string // the tree file, copied by Backup
+
+	mu        sync.Mutex // serializes logged writes: LSN order is apply order
+	ckptMu    sync.Mutex // one checkpoint at a time
+	failed    error      // the tree may lag the log; writes fail until reopened
+	periodic  uint64     // last LSN logged by a SyncPeriodic write, 0 once synced
+	ckptBytes int64      // log bytes written when the last checkpoint began
+	kick      chan struct{}
+	done      chan struct{}
+	wg        sync.WaitGroup
+
+	replayed, skipped int // records handled by recovery
+
+	// afterLog runs between logging a write and applying it; tests use it
+	// to stop the process there
+	afterLog func()
 }
 
-func NewWALBPTreeEngine(dataFile, walFile string) (*WALBPTreeEngine, error) {
-	tree, err := NewBPTreeEngine(dataFile)
+func NewWALBPTreeEngine(dataFile, walDir string) (*WALBPTreeEngine, error) {
+	return NewWALBPTreeEngineWithOptions(dataFile, walDir, WALOptions{})
+}
+
+// NewWALBPTreeEngineWithOptions opens the tree and the segmented log in
+// walDir and replays the log into the tree from the last checkpoint
+func NewWALBPTreeEngineWithOptions(dataFile, walDir string, opts WALOptions) (*WALBPTreeEngine, error) {
+	e, entries, err := openWALBPTree(dataFile, walDir, opts)
 	if err != nil {
 		return nil, err
 	}
-	f, err := os.OpenFile(walFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
-	if err != nil {
+	if err := e.recover(entries); err != nil {
+		e.abort()
 		return nil, err
 	}
-	// Replay WAL
-	entries, _ := wal.ReadAllWAL(f)
-	for _, e := range entries {
-		if e.Op == 0 {
-			tree.Set(e.Key, e.Value)
-		} else {
-			tree.Del(e.Key)
+	e.start()
+	return e, nil
+}
+
+// openWALBPTree opens the tree and the log without replaying anything or
+// starting the background goroutine
+func openWALBPTree(dataFile, walDir string, opts WALOptions) (*WALBPTreeEngine, []wal.WALEntry, error) {
+	if opts.CachePages == 0 {
+		opts.CachePages = DEFAULT_WAL_CACHE_PAGES
+	}
+	if opts.CheckpointBytes == 0 {
+		opts.CheckpointBytes = DEFAULT_CHECKPOINT_BYTES
+	}
+	if opts.Durability == DurabilityDefault {
+		opts.Durability = SyncCommit
+	}
+	if opts.SyncInterval == 0 {
+		opts.SyncInterval = DEFAULT_SYNC_INTERVAL
+	}
+
+	tree, err := NewBPTreeEngineWithOptions(dataFile, bptree_disk.Options{CachePages: opts.CachePages, Logged: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	log, entries, err := wal.OpenDir(walDir, wal.Options{SegmentSize: opts.SegmentSize, Archive: opts.Archive})
+	if err != nil {
+		tree.Close()
+		return nil, nil, err
+	}
+
+	return &WALBPTreeEngine{
+		Tree:     tree,
+		WAL:      log,
+		opts:     opts,
+		dataFile: dataFile,
+		kick:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}, entries, nil
+}
+
+func (e *WALBPTreeEngine) start() {
+	e.wg.Add(1)
+	go e.background()
+}
+
+// abort closes an engine that failed to open, leaving its files as they
+// are
+func (e *WALBPTreeEngine) abort() {
+	e.WAL.Close()
+	e.Tree.Tree.Discard()
+}
+
+// recover redoes the logged writes from the checkpoint LSN on. A record
+// whose leaf carries its LSN or a later one is already in the tree.
+func (e *WALBPTreeEngine) recover(entries []wal.WALEntry) error {
+	tree := e.Tree.Tree
+	start := max(tree.CheckpointLSN(), 1)
+
+	if e.WAL.NextLSN() < start {
+		// A new log next to an existing tree carries on its LSNs
+		if len(entries) > 0 {
+			return fmt.Errorf("%w: log ends at %d, before checkpoint %d", wal.ErrCorrupt, e.WAL.NextLSN(), start)
+		}
+		return e.WAL.Restart(start)
+	}
+	if e.WAL.FirstLSN() > start {
+		return fmt.Errorf("%w: log starts at %d, after checkpoint %d", wal.ErrCorrupt, e.WAL.FirstLSN(), start)
+	}
+
+	for _, entry := range entries {
+		if entry.LSN < start {
+			continue
+		}
+		pageLSN, err := tree.PageLSN(entry.Key)
+		if err != nil {
+			return err
+		}
+		if pageLSN >= entry.LSN {
+			e.skipped++
+			continue
+		}
+
+		if _, err := e.apply(&entry); err != nil {
+			return err
+		}
+		e.replayed++
+
+		// Redo dirties pages without ever evicting them, so a long log
+		// or a large transaction needs checkpoints on the way
+		if tree.DirtyPages() > tree.CacheCapacity()/2 {
+			if err := e.checkpointLocked(entry.LSN + 1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// apply makes a logged write in the tree, stamping the pages it changes
+// with its LSN
+func (e *WALBPTreeEngine) apply(entry *wal.WALEntry) (bool, error) {
+	e.Tree.Tree.SetLSN(entry.LSN)
+	if entry.Op == wal.OpSet {
+		return true, e.Tree.Set(entry.Key, entry.Value)
+	}
+	return e.Tree.Del(entry.Key)
+}
+
+// write logs entries, as one transaction if there are several, applies
+// them and waits for the log as durability asks. It returns what applying
+// the last one returned.
+func (e *WALBPTreeEngine) write(entries []*wal.WALEntry, durability Durability) (bool, error) {
+	// A write the tree rejects must not reach the log, or every replay
+	// would fail on it
+	for _, entry := range entries {
+		if len(entry.Key) > disk.MAX_KEY_SIZE {
+			return false, disk.ErrKeyTooLarge
+		}
+	}
+
+	// A batch may dirty more pages than the cache holds, so it
+	// checkpoints while it is applied; checkpoints take ckptMu first
+	if len(entries) > 1 {
+		e.ckptMu.Lock()
+	}
+	e.mu.Lock()
+	unlock := func() {
+		e.mu.Unlock()
+		if len(entries) > 1 {
+			e.ckptMu.Unlock()
+		}
+	}
+	if e.failed != nil {
+		unlock()
+		return false, e.failed
+	}
+	var lsn uint64
+	var err error
+	if len(entries) == 1 {
+		lsn, err = e.WAL.Append(entries[0])
+	} else {
+		lsn, err = e.WAL.AppendTx(entries)
+	}
+	if err != nil {
+		unlock()
+		return false, err
+	}
+	if e.afterLog != nil {
+		e.afterLog()
+	}
+
+	var ok bool
+	for i, entry := range entries {
+		if ok, err = e.apply(entry); err != nil {
+			// The tree may hold part of what the log holds; reopening
+			// redoes the rest
+			e.failed = err
+			break
+		}
+		// The checkpoint covers the entries applied so far; recovery
+		// redoes the rest of the transaction from the next one
+		if i < len(entries)-1 && e.Tree.Tree.DirtyPages() > e.Tree.Tree.CacheCapacity()/2 {
+			if err = e.checkpointLocked(entry.LSN + 1); err != nil {
+				e.failed = err
+				break
+			}
+		}
+	}
+	logged := e.WAL.Stats().Bytes - e.ckptBytes
+	unlock()
+	if err != nil {
+		return false, err
+	}
+
+	if durability == DurabilityDefault {
+		durability = e.opts.Durability
+	}
+	switch durability {
+	case SyncCommit:
+		if err := e.WAL.SyncTo(lsn); err != nil {
+			return false, err
 		}
+	case SyncPeriodic:
+		e.mu.Lock()
+		e.periodic = max(e.periodic, lsn)
+		e.mu.Unlock()
 	}
-	// Truncate WAL sau khi replay (optional)
-	f.Truncate(0)
-	f.Seek(0, 0)
-	engine := &WALBPTreeEngine{Tree: tree, WALFile: f, BufferPool: NewBufferPool(128)}
-	return engine, nil
+
+	// The cache must keep room for the pages of the next writes, so a
+	// writer that finds it half dirty checkpoints itself. This write is
+	// done whatever happens: a failed checkpoint stops the next ones.
+	if e.Tree.Tree.DirtyPages() > e.Tree.Tree.CacheCapacity()/2 {
+		e.Checkpoint()
+		return ok, nil
+	}
+	if logged >= e.opts.CheckpointBytes {
+		select {
+		case e.kick <- struct{}{}:
+		default:
+		}
+	}
+	return ok, nil
 }
 
 func (e *WALBPTreeEngine) Set(key, val []byte) error {
-	k := string(key)
-	e.BufferPool.Set(k, val)
-	err := wal.WriteWAL(e.WALFile, &wal.WALEntry{Op: 0, Key: key, Value: val})
+	_, err := e.write([]*wal.WALEntry{{Op: wal.OpSet, Key: key, Value: val}}, DurabilityDefault)
+	return err
+}
+
+func (e *WALBPTreeEngine) Get(key []byte) ([]byte, bool) {
+	return e.Tree.Get(key)
+}
+
+func (e *WALBPTreeEngine) Del(key []byte) (bool, error) {
+	return e.write([]*wal.WALEntry{{Op: wal.OpDel, Key: key}}, DurabilityDefault)
+}
+
+// WriteBatch logs ops as one transaction, applies them in order and waits
+// for the log as durability asks
+func (e *WALBPTreeEngine) WriteBatch(ops []BatchOp, durability Durability) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	entries := make([]*wal.WALEntry, len(ops))
+	for i, op := range ops {
+		entries[i] = &wal.WALEntry{Op: wal.OpSet, Key: op.Key, Value: op.Value}
+		if op.Delete {
+			entries[i] = &wal.WALEntry{Op: wal.OpDel, Key: op.Key}
+		}
+	}
+	_, err := e.write(entries, durability)
+	return err
+}
+
+// Checkpoint makes every write logged so far durable in the tree file and
+// drops it from the log. Writers wait only while the changed pages are
+// copied, not while they are written and synced.
+func (e *WALBPTreeEngine) Checkpoint() error {
+	e.ckptMu.Lock()
+	defer e.ckptMu.Unlock()
+	_, err := e.checkpoint()
+	return err
+}
+
+// checkpoint runs a checkpoint under ckptMu and returns its LSN. A
+// failure stops writes until the engine is reopened.
+func (e *WALBPTreeEngine) checkpoint() (uint64, error) {
+	lsn, err := e.writeCheckpoint()
+	if err != nil {
+		e.fail(err)
+	}
+	return lsn, err
+}
+
+func (e *WALBPTreeEngine) writeCheckpoint() (uint64, error) {
+	e.mu.Lock()
+	lsn := e.WAL.NextLSN()
+	e.ckptBytes = e.WAL.Stats().Bytes
+	ckpt, err := e.Tree.Tree.BeginCheckpoint(lsn)
+	e.mu.Unlock()
 	if err != nil {
+		return 0, err
+	}
+	if err := e.writeCheckpointPages(ckpt, lsn); err != nil {
+		return 0, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return lsn, e.WAL.Discard(lsn)
+}
+
+// checkpointLocked checkpoints the tree at lsn without letting writers
+// in: the caller holds e.mu, and ckptMu once the engine is running. It
+// serves checkpoints in the middle of a transaction, whose LSN is that of
+// its first write not yet applied.
+func (e *WALBPTreeEngine) checkpointLocked(lsn uint64) error {
+	e.ckptBytes = e.WAL.Stats().Bytes
+	ckpt, err := e.Tree.Tree.BeginCheckpoint(lsn)
+	if err != nil {
+		return err
+	}
+	if err := e.writeCheckpointPages(ckpt, lsn); err != nil {
 		return err
 	}
-	return e.Tree.Set(key, val)
+	return e.WAL.Discard(lsn)
 }
 
-func (e *WALBPTreeEngine) Get(key []byte) ([]byte, bool) {
-	k := string(key)
-	if val, ok := e.BufferPool.Get(k); ok {
-		return val, true
+// writeCheckpointPages syncs the log up to lsn, then writes the pages of
+// ckpt. The checkpoint is aborted if either fails.
+func (e *WALBPTreeEngine) writeCheckpointPages(ckpt *bptree_disk.Checkpoint, lsn uint64) error {
+	// The log goes first: the pages may hold writes not yet synced
+	if err := e.WAL.SyncTo(lsn - 1); err != nil {
+		ckpt.Abort()
+		return err
 	}
-	val, ok := e.Tree.Get(key)
-	if ok {
-		e.BufferPool.Set(k, val)
+	if err := ckpt.Write(); err != nil {
+		ckpt.Abort()
+		return err
 	}
-	return val, ok
+	return nil
 }
 
-func (e *WALBPTreeEngine) Del(key []byte) (bool, error) {
-	k := string(key)
-	e.BufferPool.Del(k)
-	err := wal.WriteWAL(e.WALFile, &wal.WALEntry{Op: 1, Key: key})
+// Backup copies the tree file to a new file at path as a base backup for
+// RestoreWALBPTreeEngine and returns the checkpoint LSN it is consistent
+// with. The copy is taken right after a checkpoint, which is the only time
+// pages are written, and the next checkpoint waits for it; writers carry
+// on meanwhile. Restoring needs the archived segments from that LSN on.
+func (e *WALBPTreeEngine) Backup(path string) (uint64, error) {
+	e.ckptMu.Lock()
+	defer e.ckptMu.Unlock()
+
+	lsn, err := e.checkpoint()
 	if err != nil {
-		return false, err
+		return 0, err
+	}
+	return lsn, copyFile(e.dataFile, path)
+}
+
+// CreateRestorePoint logs a restore point named name, which
+// RestoreWALBPTreeEngine can stop at, and returns its LSN once it is on
+// disk
+func (e *WALBPTreeEngine) CreateRestorePoint(name string) (uint64, error) {
+	e.mu.Lock()
+	if e.failed != nil {
+		e.mu.Unlock()
+		return 0, e.failed
+	}
+	lsn, err := e.WAL.RestorePoint(name)
+	e.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return lsn, e.WAL.SyncTo(lsn)
+}
+
+// background syncs the log for SyncPeriodic writes and runs the
+// checkpoints started by log size or interval
+func (e *WALBPTreeEngine) background() {
+	defer e.wg.Done()
+
+	syncTicker := time.NewTicker(e.opts.SyncInterval)
+	defer syncTicker.Stop()
+	var ckptTick <-chan time.Time
+	if e.opts.CheckpointInterval > 0 {
+		ticker := time.NewTicker(e.opts.CheckpointInterval)
+		defer ticker.Stop()
+		ckptTick = ticker.C
+	}
+
+	for {
+		var err error
+		select {
+		case <-e.done:
+			return
+		case <-syncTicker.C:
+			e.mu.Lock()
+			lsn := e.periodic
+			e.periodic = 0
+			e.mu.Unlock()
+			if lsn > 0 {
+				err = e.WAL.SyncTo(lsn)
+			}
+		case <-e.kick:
+			err = e.Checkpoint()
+		case <-ckptTick:
+			err = e.Checkpoint()
+		}
+		if err != nil {
+			e.fail(err)
+		}
+	}
+}
+
+// fail stops writes after an error that may leave the tree behind the
+// log, keeping the first such error
+func (e *WALBPTreeEngine) fail(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.failed == nil {
+		e.failed = err
 	}
-	return e.Tree.Del(key)
 }
 
-// TODO: Scan, Close implementations
+// KeyOrder is the order of the underlying tree
+func (e *WALBPTreeEngine) KeyOrder() func(a, b []byte) int {
+	return e.Tree.KeyOrder()
+}
+
+// Scan reads the tree, which already holds every logged write
+func (e *WALBPTreeEngine) Scan(startKey, endKey []byte, fn func(key, val []byte) bool) error {
+	return e.Tree.Scan(startKey, endKey, fn)
+}
+
+// NewIterator reads the tree, which already holds every logged write
+func (e *WALBPTreeEngine) NewIterator(reverse bool) Iterator {
+	return e.Tree.NewIterator(reverse)
+}
+
+// Close takes a last checkpoint, then closes the tree and the log. An
+// engine that failed, or whose last checkpoint fails, drops the pages it
+// has not written and returns the error; reopening redoes them from the
+// log.
+func (e *WALBPTreeEngine) Close() error {
+	close(e.done)
+	e.wg.Wait()
+
+	e.mu.Lock()
+	err := e.failed
+	e.mu.Unlock()
+	if err == nil {
+		err = e.Checkpoint()
+	}
+	if err != nil {
+		e.Tree.Tree.Discard()
+	} else {
+		err = e.Tree.Close()
+	}
+	if cerr := e.WAL.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
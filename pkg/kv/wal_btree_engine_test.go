@@ -0,0 +1,581 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+	"github.com/spaghetti-lover/go-db/internal/wal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWALBPTreeEngine(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	kv := &KV{}
+	require.NoError(t, kv.Open("wal-bptree", file))
+
+	for i := 0; i < 200; i++ {
+		require.NoError(t, kv.Set([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("val%d", i))))
+	}
+	ok, err := kv.Del([]byte("key010"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	var keys []string
+	require.NoError(t, kv.Scan([]byte("key008"), []byte("key012"), func(key, val []byte) bool {
+		keys = append(keys, string(key))
+		return true
+	}))
+	assert.Equal(t, []string{"key008", "key009", "key011", "key012"}, keys)
+
+	require.NoError(t, kv.Close())
+	require.NoError(t, kv.Open("wal-bptree", file))
+	defer kv.Close()
+
+	val, ok := kv.Get([]byte("key042"))
+	require.True(t, ok)
+	assert.Equal(t, []byte("val42"), val)
+	_, ok = kv.Get([]byte("key010"))
+	assert.False(t, ok)
+
+	it := kv.NewIterator(true)
+	defer it.Close()
+	it.Seek(nil)
+	require.True(t, it.Valid())
+	assert.Equal(t, []byte("key199"), it.Key())
+}
+
+// walCrashChild runs in a child process started by the crash tests. It
+// writes some keys, then exits between logging the last write and
+// applying it.
+func walCrashChild(file, op string) {
+	var opts WALOptions
+	if op == "large" {
+		opts.CachePages = 64
+	}
+	e, err := NewWALBPTreeEngineWithOptions(file, file+".wal", opts)
+	if err != nil {
+		os.Exit(1)
+	}
+	for i := 0; i < 50; i++ {
+		if err := e.Set([]byte(fmt.Sprintf("key%03d", i)), []byte("before")); err != nil {
+			os.Exit(1)
+		}
+	}
+
+	e.afterLog = func() { os.Exit(3) }
+	switch op {
+	case "set":
+		e.Set([]byte("key010"), []byte("after"))
+	case "del":
+		e.Del([]byte("key010"))
+	case "batch":
+		e.WriteBatch([]BatchOp{
+			{Key: []byte("key010"), Value: []byte("after")},
+			{Key: []byte("key011"), Delete: true},
+			{Key: []byte("key100"), Value: []byte("after")},
+		}, DurabilityDefault)
+	case "large":
+		e.WriteBatch(largeBatch(5000), DurabilityDefault)
+	case "checkpoint":
+		// Exit with writes after a checkpoint in the log only
+		if e.Checkpoint() != nil {
+			os.Exit(1)
+		}
+		e.afterLog = nil
+		e.Del([]byte("key010"))
+		e.Set([]byte("key011"), []byte("after"))
+		os.Exit(3)
+	case "torn":
+		// Exit as if the last checkpoint wrote its pages but not its meta
+		// page, which still has the first LSN
+		ckpt, err := e.Tree.Tree.BeginCheckpoint(1)
+		if err != nil || ckpt.Write() != nil {
+			os.Exit(1)
+		}
+		os.Exit(3)
+	}
+	os.Exit(1)
+}
+
+// runWALCrashChild runs walCrashChild in a child process and checks it
+// stopped where it meant to
+func runWALCrashChild(t *testing.T, file, op string) {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestWALBPTreeEngine_CrashBeforeApply$")
+	cmd.Env = append(os.Environ(), "WAL_CRASH_FILE="+file, "WAL_CRASH_OP="+op)
+	err := cmd.Run()
+	var exit *exec.ExitError
+	require.True(t, errors.As(err, &exit), "child: %v", err)
+	require.Equal(t, 3, exit.ExitCode())
+}
+
+func TestWALBPTreeEngine_CrashBeforeApply(t *testing.T) {
+	if file := os.Getenv("WAL_CRASH_FILE"); file != "" {
+		walCrashChild(file, os.Getenv("WAL_CRASH_OP"))
+	}
+
+	for _, op := range []string{"set", "del"} {
+		t.Run(op, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "crash.db")
+			runWALCrashChild(t, file, op)
+
+			// The write reached the log only
+			info, err := os.Stat(filepath.Join(file+".wal", wal.SegmentName(1)))
+			require.NoError(t, err)
+			assert.Greater(t, info.Size(), int64(wal.FILE_HEADER_SIZE))
+
+			e, err := NewWALBPTreeEngine(file, file+".wal")
+			require.NoError(t, err)
+			defer e.Close()
+
+			val, ok := e.Get([]byte("key010"))
+			if op == "set" {
+				require.True(t, ok)
+				assert.Equal(t, []byte("after"), val)
+			} else {
+				assert.False(t, ok)
+			}
+			val, ok = e.Get([]byte("key049"))
+			require.True(t, ok)
+			assert.Equal(t, []byte("before"), val)
+
+			// No checkpoint was taken, so the whole log was replayed and
+			// stays until the next one
+			assert.Equal(t, 51, e.replayed)
+			assert.Equal(t, 0, e.skipped)
+			assert.Equal(t, uint64(1), e.WAL.FirstLSN())
+			assert.Equal(t, uint64(52), e.WAL.NextLSN())
+
+			n := 0
+			require.NoError(t, e.Scan(nil, nil, func(key, val []byte) bool {
+				n++
+				return true
+			}))
+			if op == "set" {
+				assert.Equal(t, 50, n)
+			} else {
+				assert.Equal(t, 49, n)
+			}
+		})
+	}
+}
+
+// largeBatch returns n writes of 200-byte values, more than a cache of
+// 64 pages holds
+func largeBatch(n int) []BatchOp {
+	ops := make([]BatchOp, n)
+	for i := range ops {
+		ops[i] = BatchOp{Key: []byte(fmt.Sprintf("big%05d", i)), Value: bytes.Repeat([]byte{'v'}, 200)}
+	}
+	return ops
+}
+
+func TestWALBPTreeEngine_LargeTransaction(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "large.db")
+	opts := WALOptions{CachePages: 64}
+	e, err := NewWALBPTreeEngineWithOptions(file, file+".wal", opts)
+	require.NoError(t, err)
+	kv := NewKV(e)
+
+	// The transaction checkpoints while it is applied, and the engine
+	// keeps working after it
+	tx := &KVTX{}
+	kv.Begin(tx)
+	for _, op := range largeBatch(5000) {
+		require.NoError(t, tx.Set(op.Key, op.Value))
+	}
+	require.NoError(t, kv.Commit(tx))
+	assert.LessOrEqual(t, e.Tree.Tree.DirtyPages(), opts.CachePages/2)
+	require.NoError(t, kv.Set([]byte("after"), []byte("1")))
+	require.NoError(t, kv.Close())
+
+	e, err = NewWALBPTreeEngineWithOptions(file, file+".wal", opts)
+	require.NoError(t, err)
+	defer e.Close()
+	n := 0
+	require.NoError(t, e.Scan(nil, nil, func(key, val []byte) bool {
+		n++
+		return true
+	}))
+	assert.Equal(t, 5001, n)
+	val, ok := e.Get([]byte("big04999"))
+	require.True(t, ok)
+	assert.Len(t, val, 200)
+}
+
+func TestWALBPTreeEngine_RecoverLargeTransaction(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "large.db")
+	runWALCrashChild(t, file, "large")
+
+	// Redo checkpoints on the way, so a transaction larger than the cache
+	// can be replayed
+	e, err := NewWALBPTreeEngineWithOptions(file, file+".wal", WALOptions{CachePages: 64})
+	require.NoError(t, err)
+	defer e.Close()
+	assert.Greater(t, e.Tree.Tree.CheckpointLSN(), uint64(51))
+	assert.Equal(t, 5050, e.replayed)
+	n := 0
+	require.NoError(t, e.Scan([]byte("big"), []byte("big~"), func(key, val []byte) bool {
+		n++
+		return true
+	}))
+	assert.Equal(t, 5000, n)
+}
+
+func TestWALBPTreeEngine_Checkpoint(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ckpt.db")
+	opts := WALOptions{CachePages: 64, CheckpointBytes: 8 << 10, SegmentSize: 16 << 10}
+	e, err := NewWALBPTreeEngineWithOptions(file, file+".wal", opts)
+	require.NoError(t, err)
+
+	// Checkpoints keep both the log and the dirty pages bounded
+	val := make([]byte, 100)
+	maxSize := int64(0)
+	for i := 0; i < 2000; i++ {
+		require.NoError(t, e.Set([]byte(fmt.Sprintf("key%05d", i)), val))
+		maxSize = max(maxSize, e.WAL.Size())
+		require.LessOrEqual(t, e.Tree.Tree.DirtyPages(), opts.CachePages/2)
+	}
+	assert.Less(t, maxSize, int64(2000*100))
+	assert.Greater(t, e.Tree.Tree.CheckpointLSN(), uint64(1))
+
+	// Only the segment the checkpoint falls in is left
+	require.NoError(t, e.Checkpoint())
+	assert.LessOrEqual(t, e.WAL.Size(), opts.SegmentSize)
+	assert.Greater(t, e.WAL.FirstLSN(), uint64(1))
+	assert.Equal(t, uint64(2001), e.Tree.Tree.CheckpointLSN())
+	assert.Zero(t, e.Tree.Tree.DirtyPages())
+	require.NoError(t, e.Close())
+
+	e, err = NewWALBPTreeEngineWithOptions(file, file+".wal", opts)
+	require.NoError(t, err)
+	defer e.Close()
+	assert.Zero(t, e.replayed)
+	assert.Equal(t, uint64(2001), e.WAL.NextLSN())
+
+	got, ok := e.Get([]byte("key01999"))
+	require.True(t, ok)
+	assert.Equal(t, val, got)
+}
+
+func TestWALBPTreeEngine_CrashAfterCheckpoint(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "crash.db")
+	runWALCrashChild(t, file, "checkpoint")
+
+	e, err := NewWALBPTreeEngine(file, file+".wal")
+	require.NoError(t, err)
+	defer e.Close()
+
+	// Only the writes after the checkpoint are redone
+	assert.Equal(t, uint64(51), e.Tree.Tree.CheckpointLSN())
+	assert.Equal(t, 2, e.replayed)
+
+	_, ok := e.Get([]byte("key010"))
+	assert.False(t, ok)
+	val, ok := e.Get([]byte("key011"))
+	require.True(t, ok)
+	assert.Equal(t, []byte("after"), val)
+	val, ok = e.Get([]byte("key049"))
+	require.True(t, ok)
+	assert.Equal(t, []byte("before"), val)
+}
+
+func TestWALBPTreeEngine_SkipAppliedRecords(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "crash.db")
+	runWALCrashChild(t, file, "torn")
+
+	e, err := NewWALBPTreeEngine(file, file+".wal")
+	require.NoError(t, err)
+	defer e.Close()
+
+	// Redo starts at LSN 1, but the page LSNs show every write is there
+	assert.Equal(t, 50, e.skipped)
+	assert.Zero(t, e.replayed)
+
+	val, ok := e.Get([]byte("key049"))
+	require.True(t, ok)
+	assert.Equal(t, []byte("before"), val)
+}
+
+func TestWALBPTreeEngine_CrashDuringBatch(t *testing.T) {
+	for _, torn := range []bool{false, true} {
+		t.Run(fmt.Sprintf("torn=%v", torn), func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "crash.db")
+			runWALCrashChild(t, file, "batch")
+
+			// A crash in the middle of the log write loses the commit record
+			if torn {
+				segment := filepath.Join(file+".wal", wal.SegmentName(1))
+				info, err := os.Stat(segment)
+				require.NoError(t, err)
+				require.NoError(t, os.Truncate(segment, info.Size()-3))
+			}
+
+			e, err := NewWALBPTreeEngine(file, file+".wal")
+			require.NoError(t, err)
+			defer e.Close()
+
+			val10, _ := e.Get([]byte("key010"))
+			_, ok11 := e.Get([]byte("key011"))
+			val100, ok100 := e.Get([]byte("key100"))
+			if torn {
+				assert.Equal(t, []byte("before"), val10)
+				assert.True(t, ok11)
+				assert.False(t, ok100)
+				assert.Equal(t, uint64(51), e.WAL.NextLSN())
+			} else {
+				assert.Equal(t, []byte("after"), val10)
+				assert.False(t, ok11)
+				require.True(t, ok100)
+				assert.Equal(t, []byte("after"), val100)
+				assert.Equal(t, uint64(56), e.WAL.NextLSN())
+			}
+		})
+	}
+}
+
+func TestWALBPTreeEngine_Durability(t *testing.T) {
+	open := func(t *testing.T, opts WALOptions) *WALBPTreeEngine {
+		file := filepath.Join(t.TempDir(), "sync.db")
+		e, err := NewWALBPTreeEngineWithOptions(file, file+".wal", opts)
+		require.NoError(t, err)
+		t.Cleanup(func() { e.Close() })
+		return e
+	}
+	set := func(t *testing.T, e *WALBPTreeEngine, n int) {
+		for i := 0; i < n; i++ {
+			require.NoError(t, e.Set([]byte(fmt.Sprintf("key%03d", i)), []byte("val")))
+		}
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		e := open(t, WALOptions{})
+		set(t, e, 20)
+		assert.Equal(t, uint64(20), e.WAL.Stats().Syncs)
+
+		// Concurrent writers share fsyncs
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					assert.NoError(t, e.Set([]byte(fmt.Sprintf("w%d-%d", i, j)), []byte("val")))
+				}
+			}(i)
+		}
+		wg.Wait()
+		assert.LessOrEqual(t, e.WAL.Stats().Syncs, uint64(20+160))
+		assert.Equal(t, uint64(180), e.WAL.Stats().Records)
+	})
+
+	t.Run("periodic", func(t *testing.T) {
+		e := open(t, WALOptions{Durability: SyncPeriodic, SyncInterval: 50 * time.Millisecond})
+		set(t, e, 20)
+		assert.Eventually(t, func() bool {
+			return e.WAL.Stats().Syncs > 0
+		}, time.Second, time.Millisecond)
+		assert.Less(t, e.WAL.Stats().Syncs, uint64(20))
+	})
+
+	t.Run("os", func(t *testing.T) {
+		e := open(t, WALOptions{Durability: SyncOS, SyncInterval: time.Millisecond})
+		set(t, e, 20)
+		time.Sleep(5 * time.Millisecond)
+		assert.Zero(t, e.WAL.Stats().Syncs)
+
+		// A batch can ask for more than the engine's setting, and a
+		// checkpoint syncs the log before the pages
+		require.NoError(t, e.WriteBatch([]BatchOp{{Key: []byte("a"), Value: []byte("b")}}, SyncCommit))
+		assert.Equal(t, uint64(1), e.WAL.Stats().Syncs)
+		require.NoError(t, e.Set([]byte("c"), []byte("d")))
+		require.NoError(t, e.Checkpoint())
+		assert.Equal(t, uint64(2), e.WAL.Stats().Syncs)
+	})
+}
+
+func TestWALBPTreeEngine_Archive(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "archive.db")
+	archive := filepath.Join(t.TempDir(), "archive")
+	opts := WALOptions{SegmentSize: 4 << 10, Archive: wal.CopyToDir(archive)}
+	e, err := NewWALBPTreeEngineWithOptions(file, file+".wal", opts)
+	require.NoError(t, err)
+	defer e.Close()
+
+	for i := 0; i < 500; i++ {
+		require.NoError(t, e.Set([]byte(fmt.Sprintf("key%03d", i)), make([]byte, 50)))
+	}
+	require.Eventually(t, func() bool {
+		segments, err := wal.ListSegments(file + ".wal")
+		return err == nil && e.WAL.Stats().Archived == uint64(len(segments)-1)
+	}, time.Second, time.Millisecond)
+
+	// A checkpoint removes the archived segments it covers, and the
+	// archive keeps them
+	require.NoError(t, e.Checkpoint())
+	segments, err := wal.ListSegments(file + ".wal")
+	require.NoError(t, err)
+	assert.Len(t, segments, 1)
+	archived, err := wal.ListSegments(archive)
+	require.NoError(t, err)
+	assert.Greater(t, len(archived), 5)
+	assert.Equal(t, uint64(1), archived[0])
+	assert.Equal(t, segments[0], e.WAL.FirstLSN())
+}
+
+func TestWALBPTreeEngine_Restore(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "restore.db")
+	archive := filepath.Join(dir, "archive")
+	e, err := NewWALBPTreeEngineWithOptions(file, file+".wal", WALOptions{SegmentSize: 4 << 10, Archive: wal.CopyToDir(archive)})
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, e.Set([]byte(fmt.Sprintf("key%03d", i)), make([]byte, 50)))
+	}
+	backup := filepath.Join(dir, "base.db")
+	lsn, err := e.Backup(backup)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(101), lsn)
+
+	for i := 100; i < 200; i++ {
+		require.NoError(t, e.Set([]byte(fmt.Sprintf("key%03d", i)), make([]byte, 50)))
+	}
+	_, err = e.CreateRestorePoint("before-delete")
+	require.NoError(t, err)
+	time.Sleep(2 * time.Millisecond)
+	beforeDelete := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	ops := make([]BatchOp, 50)
+	for i := range ops {
+		ops[i] = BatchOp{Key: []byte(fmt.Sprintf("key%03d", i)), Delete: true}
+	}
+	require.NoError(t, e.WriteBatch(ops, DurabilityDefault))
+
+	// Everything logged is archived once the last segment is finished;
+	// segments are archived in order
+	require.NoError(t, e.WAL.Rotate())
+	require.Eventually(t, func() bool {
+		segments, err := wal.ListSegments(file + ".wal")
+		require.NoError(t, err)
+		archived, err := wal.ListSegments(archive)
+		require.NoError(t, err)
+		return len(archived) > 0 && archived[len(archived)-1] == segments[len(segments)-2]
+	}, time.Second, time.Millisecond)
+	require.NoError(t, e.Close())
+
+	count := func(e *WALBPTreeEngine) int {
+		n := 0
+		require.NoError(t, e.Scan(nil, nil, func(key, val []byte) bool {
+			n++
+			return true
+		}))
+		return n
+	}
+	targets := map[string]wal.Target{
+		"point": {RestorePoint: "before-delete"},
+		"time":  {Time: beforeDelete},
+		"all":   {},
+	}
+	for name, target := range targets {
+		restored := filepath.Join(dir, name+".db")
+		// What an interrupted restore left is replaced, and a small cache
+		// makes replay checkpoint between transactions
+		require.NoError(t, os.WriteFile(restored+RESTORE_SUFFIX, []byte("torn"), 0644))
+		r, err := RestoreWALBPTreeEngine(restored, restored+".wal", RestoreOptions{Backup: backup, Archive: archive, Target: target}, WALOptions{CachePages: 4})
+		require.NoError(t, err, name)
+		_, err = os.Stat(restored + RESTORE_SUFFIX)
+		assert.True(t, os.IsNotExist(err), name)
+		_, ok := r.Get([]byte("key000"))
+		assert.Equal(t, name != "all", ok, name)
+		if name == "all" {
+			assert.Equal(t, 150, count(r))
+		} else {
+			assert.Equal(t, 200, count(r), name)
+		}
+		require.NoError(t, r.Close())
+	}
+
+	// The restored engine opens as usual and its log carries on after the
+	// restore point
+	r, err := NewWALBPTreeEngine(filepath.Join(dir, "point.db"), filepath.Join(dir, "point.db.wal"))
+	require.NoError(t, err)
+	assert.Equal(t, 200, count(r))
+	assert.Equal(t, uint64(201), r.WAL.NextLSN())
+	require.NoError(t, r.Set([]byte("new"), []byte("v")))
+	require.NoError(t, r.Close())
+
+	// A restore never overwrites, and cleans up when the target is missing
+	_, err = RestoreWALBPTreeEngine(file, file+".wal", RestoreOptions{Backup: backup, Archive: archive}, WALOptions{})
+	assert.ErrorIs(t, err, os.ErrExist)
+	missing := filepath.Join(dir, "missing.db")
+	_, err = RestoreWALBPTreeEngine(missing, missing+".wal", RestoreOptions{Backup: backup, Archive: archive, Target: wal.Target{RestorePoint: "none"}}, WALOptions{})
+	assert.ErrorIs(t, err, wal.ErrTargetNotReached)
+	for _, path := range []string{missing, missing + RESTORE_SUFFIX, missing + ".wal" + RESTORE_SUFFIX} {
+		_, err = os.Stat(path)
+		assert.True(t, os.IsNotExist(err), path)
+	}
+}
+
+func TestWALBPTreeEngine_CheckpointError(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ckpt.db")
+	e, err := NewWALBPTreeEngine(file, file+".wal")
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, e.Set([]byte(fmt.Sprintf("key%03d", i)), []byte("val")))
+	}
+
+	// The double-write file cannot be created, so nothing is written and
+	// the engine takes no more writes
+	lsn := e.Tree.Tree.CheckpointLSN()
+	require.NoError(t, os.Mkdir(file+disk.DOUBLE_WRITE_SUFFIX, 0755))
+	require.Error(t, e.Checkpoint())
+	assert.Error(t, e.Set([]byte("key100"), []byte("val")))
+	assert.Equal(t, lsn, e.Tree.Tree.CheckpointLSN())
+	assert.NotZero(t, e.Tree.Tree.DirtyPages())
+
+	// Close writes nothing more and reports the failure; reopening redoes
+	// the log, so no change is lost
+	require.NoError(t, os.Remove(file+disk.DOUBLE_WRITE_SUFFIX))
+	assert.Error(t, e.Close())
+	e, err = NewWALBPTreeEngine(file, file+".wal")
+	require.NoError(t, err)
+	defer e.Close()
+	for i := 0; i < 100; i++ {
+		_, ok := e.Get([]byte(fmt.Sprintf("key%03d", i)))
+		require.True(t, ok, i)
+	}
+	_, ok := e.Get([]byte("key100"))
+	assert.False(t, ok)
+}
+
+func TestWALBPTreeEngine_WriterCheckpointError(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ckpt.db")
+	e, err := NewWALBPTreeEngineWithOptions(file, file+".wal", WALOptions{CachePages: 16})
+	require.NoError(t, err)
+	defer e.Close()
+	require.NoError(t, os.Mkdir(file+disk.DOUBLE_WRITE_SUFFIX, 0755))
+
+	// The write whose checkpoint fails still succeeds; the next one fails
+	i := 0
+	for ; i < 1000; i++ {
+		if err = e.Set([]byte(fmt.Sprintf("key%03d", i)), make([]byte, 100)); err != nil {
+			break
+		}
+	}
+	require.Error(t, err)
+	require.Greater(t, i, 0)
+	_, ok := e.Get([]byte(fmt.Sprintf("key%03d", i-1)))
+	assert.True(t, ok)
+	_, ok = e.Get([]byte(fmt.Sprintf("key%03d", i)))
+	assert.False(t, ok)
+	require.NoError(t, os.Remove(file+disk.DOUBLE_WRITE_SUFFIX))
+}
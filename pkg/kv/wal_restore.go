@@ -0,0 +1,143 @@
+package kv
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spaghetti-lover/go-db/internal/storage/disk"
+	"github.com/spaghetti-lover/go-db/internal/wal"
+)
+
+// RestoreOptions says what RestoreWALBPTreeEngine restores from
+type RestoreOptions struct {
+	Backup  string     // base backup written by Backup
+	Archive string     // directory the log segments were archived to
+	Target  wal.Target // where replay stops; the zero Target replays the whole archive
+}
+
+// RESTORE_SUFFIX marks the files of a restore in progress
+const RESTORE_SUFFIX = ".restoring"
+
+// RestoreWALBPTreeEngine builds a new engine at dataFile and walDir, which
+// must not exist yet, from a base backup and the archived log: it copies
+// the backup, replays the archive from the backup's checkpoint up to the
+// target and checkpoints. The work is done under names ending in
+// RESTORE_SUFFIX, which are renamed into place at the end, so a restore cut
+// short leaves nothing that opens as a database. The new log carries on
+// from the LSN after the last replayed record, so it must be archived
+// somewhere other than the archive restored from.
+func RestoreWALBPTreeEngine(dataFile, walDir string, restore RestoreOptions, opts WALOptions) (*WALBPTreeEngine, error) {
+	for _, path := range []string{dataFile, walDir} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			return nil, fmt.Errorf("kv: restore into %s: %w", path, os.ErrExist)
+		}
+	}
+
+	// Whatever an earlier restore left behind is of no use
+	tmpFile, tmpDir := dataFile+RESTORE_SUFFIX, walDir+RESTORE_SUFFIX
+	removeTmp := func() {
+		os.Remove(tmpFile)
+		os.Remove(tmpFile + disk.DOUBLE_WRITE_SUFFIX)
+		os.RemoveAll(tmpDir)
+	}
+	removeTmp()
+
+	err := restoreWALBPTree(tmpFile, tmpDir, restore, opts)
+	// The tree goes first: on its own it opens with an empty log
+	if err == nil {
+		err = os.Rename(tmpFile, dataFile)
+	}
+	if err == nil {
+		err = os.Rename(tmpDir, walDir)
+	}
+	if err == nil {
+		err = syncDir(filepath.Dir(dataFile))
+	}
+	if err == nil {
+		err = syncDir(filepath.Dir(walDir))
+	}
+	if err != nil {
+		removeTmp()
+		return nil, err
+	}
+	return NewWALBPTreeEngineWithOptions(dataFile, walDir, opts)
+}
+
+// restoreWALBPTree restores into dataFile and walDir and closes the engine
+func restoreWALBPTree(dataFile, walDir string, restore RestoreOptions, opts WALOptions) error {
+	if err := copyFile(restore.Backup, dataFile); err != nil {
+		return err
+	}
+	opts.Archive = nil
+	e, _, err := openWALBPTree(dataFile, walDir, opts)
+	if err != nil {
+		return err
+	}
+
+	tree := e.Tree.Tree
+	next, err := wal.ReplayArchive(restore.Archive, max(tree.CheckpointLSN(), 1), restore.Target, func(writes []wal.WALEntry, next uint64) error {
+		for _, entry := range writes {
+			if _, err := e.apply(&entry); err != nil {
+				return err
+			}
+			e.replayed++
+		}
+
+		// Nothing is logged, so the cache is emptied by checkpointing the
+		// tree alone, between units so a transaction is never split
+		if tree.DirtyPages() > tree.CacheCapacity()/2 {
+			ckpt, err := tree.BeginCheckpoint(next)
+			if err != nil {
+				return err
+			}
+			if err := ckpt.Write(); err != nil {
+				ckpt.Abort()
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = e.WAL.Restart(next)
+	}
+	if err != nil {
+		e.abort()
+		return err
+	}
+	return e.Close()
+}
+
+// copyFile copies src to a new file dst and syncs it
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// syncDir makes the files created or renamed in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}